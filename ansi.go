@@ -0,0 +1,147 @@
+// Rendering of the screen to terminals, with ANSI escape sequences.
+
+package vnc
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+)
+
+// DefaultANSIColumns is the width of the rendering of RenderANSI, if not
+// given.
+const DefaultANSIColumns = 80
+
+// RenderANSI draws img to a terminal supporting 256 colors, downscaled to
+// cols characters wide, e.g. for a quick look at the screen of a headless
+// session over SSH. Each character is the upper half block, colored with the
+// average color of two square blocks of pixels, one above the other. If cols
+// is zero, DefaultANSIColumns is used; images narrower than cols aren't
+// scaled up.
+func RenderANSI(w io.Writer, img image.Image, cols int) error {
+	b := img.Bounds()
+	if b.Empty() {
+		return nil
+	}
+	if cols <= 0 {
+		cols = DefaultANSIColumns
+	}
+	if cols > b.Dx() {
+		cols = b.Dx()
+	}
+	// The rows of blocks, keeping the aspect ratio, rounded up to a whole
+	// number of characters.
+	rows := (b.Dy()*cols + b.Dx() - 1) / b.Dx()
+	rows += rows % 2
+
+	bw := bufio.NewWriter(w)
+	for row := 0; row < rows; row += 2 {
+		fg, bg := -1, -1
+		for col := 0; col < cols; col++ {
+			top := ansiColor(averageColor(img, blockRect(b, cols, rows, col, row)))
+			bottom := ansiColor(averageColor(img, blockRect(b, cols, rows, col, row+1)))
+			if top != fg {
+				fmt.Fprintf(bw, "\x1b[38;5;%dm", top)
+				fg = top
+			}
+			if bottom != bg {
+				fmt.Fprintf(bw, "\x1b[48;5;%dm", bottom)
+				bg = bottom
+			}
+			bw.WriteString("▀")
+		}
+		bw.WriteString("\x1b[0m\n")
+	}
+	return bw.Flush()
+}
+
+// RenderANSI draws the screen to a terminal. See RenderANSI.
+func (s *Screen) RenderANSI(w io.Writer, cols int) error {
+	return RenderANSI(w, s.Image(), cols)
+}
+
+// blockRect returns the pixels of img, within b, of the block at (col, row)
+// of cols x rows blocks.
+func blockRect(b image.Rectangle, cols, rows, col, row int) image.Rectangle {
+	return image.Rect(
+		b.Min.X+col*b.Dx()/cols, b.Min.Y+row*b.Dy()/rows,
+		b.Min.X+(col+1)*b.Dx()/cols, b.Min.Y+(row+1)*b.Dy()/rows,
+	)
+}
+
+// averageColor returns the average 8-bit red, green and blue of the pixels of
+// r, which is black if r is empty, e.g. below the image.
+func averageColor(img image.Image, r image.Rectangle) [3]int {
+	var sum [3]int
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			sum[0] += int(cr >> 8)
+			sum[1] += int(cg >> 8)
+			sum[2] += int(cb >> 8)
+			n++
+		}
+	}
+	if n == 0 {
+		return [3]int{}
+	}
+	return [3]int{sum[0] / n, sum[1] / n, sum[2] / n}
+}
+
+// ansiLevels are the levels of each channel of the 6x6x6 color cube of the
+// 256 color palette of terminals, at 16-231.
+var ansiLevels = [6]int{0, 0x5f, 0x87, 0xaf, 0xd7, 0xff}
+
+// ansiColor returns the closest color of the color cube, or of the grays at
+// 232-255, of the 256 color palette.
+func ansiColor(c [3]int) int {
+	var idx [3]int
+	var cube [3]int
+	for i, v := range c {
+		idx[i] = nearestLevel(v)
+		cube[i] = ansiLevels[idx[i]]
+	}
+	best := 16 + 36*idx[0] + 6*idx[1] + idx[2]
+	// The grays are 8, 18, ..., 238.
+	avg := (c[0] + c[1] + c[2]) / 3
+	g := (avg - 8 + 5) / 10
+	if g < 0 {
+		g = 0
+	} else if g > 23 {
+		g = 23
+	}
+	gray := 8 + 10*g
+	if colorDist(c, [3]int{gray, gray, gray}) < colorDist(c, cube) {
+		best = 232 + g
+	}
+	return best
+}
+
+// nearestLevel returns the index of the level of the color cube nearest v.
+func nearestLevel(v int) int {
+	best := 0
+	for i, l := range ansiLevels {
+		if abs(v-l) < abs(v-ansiLevels[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// colorDist returns the squared distance of the colors.
+func colorDist(a, b [3]int) int {
+	d := 0
+	for i := range a {
+		d += (a[i] - b[i]) * (a[i] - b[i])
+	}
+	return d
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
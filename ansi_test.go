@@ -0,0 +1,60 @@
+package vnc
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestRenderANSI(t *testing.T) {
+	// Red on the left, blue on the right, over black.
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 8; x++ {
+			c := color.RGBA{0xff, 0, 0, 0xff}
+			if x >= 4 {
+				c = color.RGBA{0, 0, 0xff, 0xff}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := RenderANSI(&buf, img, 2); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b[38;5;196m\x1b[48;5;16m▀\x1b[38;5;21m▀\x1b[0m\n"
+	if got := buf.String(); got != want {
+		t.Errorf("RenderANSI() = %q, want %q", got, want)
+	}
+
+	// Not scaled up, and the rows round up to whole characters.
+	buf.Reset()
+	if err := RenderANSI(&buf, image.NewRGBA(image.Rect(0, 0, 3, 3)), 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(buf.String(), "\n"), 2; got != want {
+		t.Errorf("lines = %d, want %d", got, want)
+	}
+	if got, want := strings.Count(buf.String(), "▀"), 6; got != want {
+		t.Errorf("characters = %d, want %d", got, want)
+	}
+}
+
+func TestANSIColor(t *testing.T) {
+	for _, tt := range []struct {
+		c    [3]int
+		want int
+	}{
+		{[3]int{0, 0, 0}, 16},
+		{[3]int{0xff, 0xff, 0xff}, 231},
+		{[3]int{0xff, 0, 0}, 196},
+		{[3]int{0x80, 0x80, 0x80}, 244},
+		{[3]int{0x5f, 0x87, 0xaf}, 67},
+	} {
+		if got := ansiColor(tt.c); got != tt.want {
+			t.Errorf("ansiColor(%v) = %d, want %d", tt.c, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Choice of the order of encodings for the link to the server.
+
+package vnc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// LinkProfile is the kind of network link to a server, which determines the
+// preferred order of encodings.
+type LinkProfile int
+
+const (
+	// LinkLAN is a fast, low latency link, where the CPU time to decode
+	// compressed encodings costs more than the bandwidth they save.
+	LinkLAN LinkProfile = iota
+	// LinkWAN is a link where bandwidth is worth saving, at modest CPU cost.
+	LinkWAN
+	// LinkConstrained is a slow or high latency link, where the most
+	// compact encodings are preferred, whatever their CPU cost.
+	LinkConstrained
+)
+
+var linkProfileNames = map[LinkProfile]string{
+	LinkLAN:         "LAN",
+	LinkWAN:         "WAN",
+	LinkConstrained: "Constrained",
+}
+
+func (p LinkProfile) String() string {
+	if name, ok := linkProfileNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("LinkProfile(%d)", int(p))
+}
+
+// The preferred order of the pixel encodings of each link profile. CopyRect
+// is always first, as it is the cheapest encoding for both ends. The private
+// ZSTD encoding is only preferred on a LAN, where it costs less CPU time than
+// the standard compressed encodings.
+var linkEncodings = map[LinkProfile][]encodings.Encoding{
+	LinkLAN:         {encodings.CopyRect, encodings.ZstdPrivate, encodings.Raw, encodings.Hextile, encodings.TRLE, encodings.ZRLE, encodings.RRE},
+	LinkWAN:         {encodings.CopyRect, encodings.ZRLE, encodings.TRLE, encodings.Hextile, encodings.RRE, encodings.Raw},
+	LinkConstrained: {encodings.CopyRect, encodings.ZRLE, encodings.TRLE, encodings.RRE, encodings.Hextile, encodings.Raw},
+}
+
+// The thresholds of the link characteristics between link profiles.
+const (
+	LANMinBandwidth         = 10 << 20 // 10 MiB/s
+	LANMaxRTT               = 5 * time.Millisecond
+	ConstrainedMaxBandwidth = 256 << 10 // 256 KiB/s
+	ConstrainedMinRTT       = 150 * time.Millisecond
+)
+
+// LinkStats are the measured characteristics of a link.
+type LinkStats struct {
+	Bandwidth float64       // Bytes per second. Zero if unknown.
+	RTT       time.Duration // Round-trip time. Zero if unknown.
+}
+
+// Profile returns the link profile of the link. Unknown characteristics
+// don't count against a profile, so a link of which nothing is known is a
+// LAN.
+func (s LinkStats) Profile() LinkProfile {
+	if (s.Bandwidth > 0 && s.Bandwidth < ConstrainedMaxBandwidth) || s.RTT > ConstrainedMinRTT {
+		return LinkConstrained
+	}
+	if (s.Bandwidth > 0 && s.Bandwidth < LANMinBandwidth) || s.RTT > LANMaxRTT {
+		return LinkWAN
+	}
+	return LinkLAN
+}
+
+// PreferredEncodings returns encs in the order preferred for the link
+// profile, for SetEncodings. The encodings of the profile come first, in the
+// order of the profile, followed by other pixel encodings, and then the
+// pseudo-encodings, both in their order in encs.
+func PreferredEncodings(p LinkProfile, encs Encodings) Encodings {
+	rank := map[encodings.Encoding]int{}
+	for i, e := range linkEncodings[p] {
+		rank[e] = i
+	}
+	class := func(e Encoding) int {
+		switch _, ok := rank[e.Type()]; {
+		case ok:
+			return 0
+		case e.Type() >= 0:
+			return 1
+		}
+		return 2
+	}
+
+	sorted := append(Encodings{}, encs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ci, cj := class(sorted[i]), class(sorted[j])
+		if ci != cj {
+			return ci < cj
+		}
+		return ci == 0 && rank[sorted[i].Type()] < rank[sorted[j].Type()]
+	})
+	return sorted
+}
+
+// SetPreferredEncodings sets the encodings supported by the client, in the
+// order preferred for the link profile. See PreferredEncodings.
+func (c *ClientConn) SetPreferredEncodings(p LinkProfile, encs Encodings) error {
+	return c.SetEncodings(PreferredEncodings(p, encs))
+}
+
+//-----------------------------------------------------------------------------
+// Pixel format fallback
+
+// PixelFormatRGB565 is a true color pixel format of 16 bits-per-pixel, the
+// first fallback of PixelFormatFallback.
+var PixelFormatRGB565 = PixelFormat{
+	BPP:        16,
+	Depth:      16,
+	BigEndian:  rfbflags.RFBFalse,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     0x1f,
+	GreenMax:   0x3f,
+	BlueMax:    0x1f,
+	RedShift:   11,
+	GreenShift: 5,
+	BlueShift:  0,
+}
+
+// The defaults of PixelFormatFallback.
+const (
+	DefaultFallbackLowBandwidth  = ConstrainedMaxBandwidth
+	DefaultFallbackHighBandwidth = 1 << 20 // 1 MiB/s
+	DefaultFallbackMinInterval   = 5 * time.Second
+	DefaultBandwidthMinSample    = 16 << 10 // 16 KiB
+)
+
+// bandwidthWeight is the weight of each sample in the estimated bandwidth.
+const bandwidthWeight = 0.25
+
+// PixelFormatFallback configures the automatic fallback of the pixel format
+// to fewer bits-per-pixel while the estimated bandwidth of the link is low,
+// and its restoration once the bandwidth recovers. See ClientConn.Bandwidth.
+//
+// The pixel format steps down one fallback at a time, while the bandwidth is
+// below LowBandwidth, and back up one at a time, while it is above
+// HighBandwidth. The pixel format is switched with SetPixelFormat between
+// FramebufferUpdates, by the goroutine reading from the server, and the
+// pixel format in use when first stepping down is the one restored.
+//
+// As the server may encode the updates of requests already sent in either
+// pixel format (see RFC 6143 Section 7.5.1), the pixel format is only switched
+// once every FramebufferUpdateRequest sent has been answered, and the switch
+// is followed by a non-incremental request of the whole framebuffer. The
+// pixels already decoded keep the pixel format they were decoded with.
+type PixelFormatFallback struct {
+	// LowBandwidth is the bandwidth, in bytes per second, below which the
+	// pixel format steps down. If zero, DefaultFallbackLowBandwidth is used.
+	LowBandwidth float64
+
+	// HighBandwidth is the bandwidth, in bytes per second, above which the
+	// pixel format steps back up. If zero, DefaultFallbackHighBandwidth is
+	// used.
+	HighBandwidth float64
+
+	// Formats are the pixel formats stepped down to, in order. If nil,
+	// PixelFormatRGB565 and then PixelFormat8bit (i.e. indexed color) are
+	// used.
+	Formats []PixelFormat
+
+	// MinInterval is the minimum interval between switches of the pixel
+	// format, which gives the estimated bandwidth time to settle. If zero,
+	// DefaultFallbackMinInterval is used.
+	MinInterval time.Duration
+}
+
+func (f *PixelFormatFallback) lowBandwidth() float64 {
+	if f.LowBandwidth <= 0 {
+		return DefaultFallbackLowBandwidth
+	}
+	return f.LowBandwidth
+}
+
+func (f *PixelFormatFallback) highBandwidth() float64 {
+	if f.HighBandwidth <= 0 {
+		return DefaultFallbackHighBandwidth
+	}
+	return f.HighBandwidth
+}
+
+func (f *PixelFormatFallback) formats() []PixelFormat {
+	if f.Formats == nil {
+		return []PixelFormat{PixelFormatRGB565, PixelFormat8bit}
+	}
+	return f.Formats
+}
+
+func (f *PixelFormatFallback) minInterval() time.Duration {
+	if f.MinInterval <= 0 {
+		return DefaultFallbackMinInterval
+	}
+	return f.MinInterval
+}
+
+// Bandwidth returns the estimated bandwidth of the link, in bytes per second,
+// or zero if unknown. It is estimated from the time taken to receive each
+// FramebufferUpdate of at least DefaultBandwidthMinSample bytes, as smaller
+// updates are dominated by latency rather than bandwidth.
+func (c *ClientConn) Bandwidth() float64 {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.bandwidth
+}
+
+// observeUpdate records a FramebufferUpdate of n bytes, received over d, in
+// the estimated bandwidth, and then switches the pixel format if the
+// PixelFormatFallback of the connection calls for it, and no request is
+// outstanding.
+func (c *ClientConn) observeUpdate(n int, d time.Duration, now time.Time) error {
+	if !c.notifies() || n < DefaultBandwidthMinSample || d <= 0 {
+		return nil
+	}
+	sample := float64(n) / d.Seconds()
+	c.metaMu.Lock()
+	if c.bandwidth == 0 {
+		c.bandwidth = sample
+	} else {
+		c.bandwidth += bandwidthWeight * (sample - c.bandwidth)
+	}
+	bw := c.bandwidth
+	c.metaMu.Unlock()
+
+	f := c.config.PixelFormatFallback
+	if f == nil || now.Sub(c.lastFallback) < f.minInterval() {
+		return nil
+	}
+	formats := f.formats()
+	level := c.fallback
+	switch {
+	case bw < f.lowBandwidth() && level < len(formats):
+		level++
+	case bw > f.highBandwidth() && level > 0:
+		level--
+	default:
+		return nil
+	}
+	if c.outstanding() {
+		return nil
+	}
+
+	if c.fallback == 0 {
+		c.fullPixelFormat = c.PixelFormat()
+	}
+	pf := c.fullPixelFormat
+	if level > 0 {
+		pf = formats[level-1]
+	}
+	if err := c.SetPixelFormat(pf); err != nil {
+		return err
+	}
+	c.fallback, c.lastFallback = level, now
+	return c.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, c.FramebufferWidth(), c.FramebufferHeight())
+}
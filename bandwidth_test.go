@@ -0,0 +1,193 @@
+package vnc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+func TestLinkStats_Profile(t *testing.T) {
+	for _, tt := range []struct {
+		desc  string
+		stats LinkStats
+		want  LinkProfile
+	}{
+		{"unknown", LinkStats{}, LinkLAN},
+		{"lan", LinkStats{100 << 20, time.Millisecond}, LinkLAN},
+		{"slow lan", LinkStats{1 << 20, time.Millisecond}, LinkWAN},
+		{"wan", LinkStats{1 << 20, 40 * time.Millisecond}, LinkWAN},
+		{"latency only", LinkStats{RTT: 40 * time.Millisecond}, LinkWAN},
+		{"slow", LinkStats{64 << 10, 40 * time.Millisecond}, LinkConstrained},
+		{"satellite", LinkStats{10 << 20, 600 * time.Millisecond}, LinkConstrained},
+	} {
+		if got, want := tt.stats.Profile(), tt.want; got != want {
+			t.Errorf("%s: Profile() = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestPreferredEncodings(t *testing.T) {
+	encs := Encodings{
+		&DesktopSizePseudoEncoding{},
+		&RawEncoding{},
+		&advertisedEncoding{encodings.ZRLE},
+		&advertisedEncoding{7}, // Tight, which no profile ranks.
+		&CursorPseudoEncoding{},
+		&CopyRectEncoding{},
+		&advertisedEncoding{encodings.Hextile},
+	}
+	for _, tt := range []struct {
+		profile LinkProfile
+		want    []encodings.Encoding
+	}{
+		{LinkLAN, []encodings.Encoding{encodings.CopyRect, encodings.Raw, encodings.Hextile, encodings.ZRLE, 7, encodings.DesktopSizePseudo, encodings.ColorPseudo}},
+		{LinkWAN, []encodings.Encoding{encodings.CopyRect, encodings.ZRLE, encodings.Hextile, encodings.Raw, 7, encodings.DesktopSizePseudo, encodings.ColorPseudo}},
+		{LinkConstrained, []encodings.Encoding{encodings.CopyRect, encodings.ZRLE, encodings.Hextile, encodings.Raw, 7, encodings.DesktopSizePseudo, encodings.ColorPseudo}},
+	} {
+		var got []encodings.Encoding
+		for _, e := range PreferredEncodings(tt.profile, encs) {
+			got = append(got, e.Type())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: PreferredEncodings() = %v, want %v", tt.profile, got, tt.want)
+		}
+	}
+	if got, want := encs[0].Type(), encodings.DesktopSizePseudo; got != want {
+		t.Errorf("PreferredEncodings() modified encs; encs[0] = %v, want %v", got, want)
+	}
+}
+
+func TestClientConn_Bandwidth(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	now := time.Now()
+
+	for _, tt := range []struct {
+		desc string
+		n    int
+		d    time.Duration
+		want float64
+	}{
+		{"small update ignored", 1 << 10, time.Millisecond, 0},
+		{"first sample", 64 << 10, time.Second, 64 << 10},
+		{"weighted sample", 128 << 10, time.Second, 80 << 10},
+	} {
+		if err := conn.observeUpdate(tt.n, tt.d, now); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if got := conn.Bandwidth(); got != tt.want {
+			t.Errorf("%s: incorrect bandwidth; got = %v, want = %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestFramebufferUpdate_Bandwidth(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 640, 480
+
+	if err := conn.send([]byte{0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(rectangleMessage{0, 0, 128, 64, encodings.Raw}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(make([]byte, 128*64*4)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := conn.Bandwidth(); got <= 0 {
+		t.Errorf("incorrect bandwidth; got = %v, want > 0", got)
+	}
+}
+
+func TestPixelFormatFallback(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{
+		PixelFormatFallback: &PixelFormatFallback{
+			LowBandwidth:  100 << 10,
+			HighBandwidth: 400 << 10,
+			MinInterval:   time.Second,
+		},
+	})
+	conn.fbWidth, conn.fbHeight = 640, 480
+	now := time.Now()
+
+	for _, tt := range []struct {
+		desc        string
+		elapsed     time.Duration // Since the start.
+		bw          float64       // The bandwidth of each sample.
+		outstanding bool          // The last request sent is unanswered.
+		want        *PixelFormat  // The pixel format sent, if any.
+	}{
+		{"low bandwidth", 2 * time.Second, 10 << 10, false, &PixelFormatRGB565},
+		{"too soon", 2500 * time.Millisecond, 10 << 10, true, nil},
+		{"request outstanding", 4 * time.Second, 10 << 10, true, nil},
+		{"still low", 4 * time.Second, 10 << 10, false, &PixelFormat8bit},
+		{"lowest", 6 * time.Second, 10 << 10, false, nil},
+		{"moderate", 8 * time.Second, 200 << 10, false, nil},
+		{"recovered", 10 * time.Second, 10 << 20, false, &PixelFormatRGB565},
+		{"restored", 12 * time.Second, 10 << 20, false, &PixelFormat32bit},
+		{"full", 14 * time.Second, 10 << 20, false, nil},
+	} {
+		mockConn.Reset()
+		if !tt.outstanding {
+			conn.answerRequest()
+		}
+		// Reset the estimate, so each sample sets the bandwidth.
+		conn.bandwidth = 0
+		d := time.Duration(float64(64<<10) / tt.bw * float64(time.Second))
+		if err := conn.observeUpdate(64<<10, d, now.Add(tt.elapsed)); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if tt.want == nil {
+			if got, want := mockConn.b.Len(), 0; got != want {
+				t.Errorf("%s: unexpected message sent; got %d bytes", tt.desc, got)
+			}
+			continue
+		}
+		var msg SetPixelFormatMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatalf("%s: %s", tt.desc, err)
+		}
+		if got, want := msg.PF, *tt.want; got != want {
+			t.Errorf("%s: incorrect pixel format; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := conn.PixelFormat(), *tt.want; got != want {
+			t.Errorf("%s: incorrect pixel format of connection; got = %v, want = %v", tt.desc, got, want)
+		}
+		// The whole framebuffer is requested in the new pixel format.
+		var req FramebufferUpdateRequestMessage
+		if err := conn.receive(&req); err != nil {
+			t.Fatalf("%s: %s", tt.desc, err)
+		}
+		if req.Inc != rfbflags.RFBFalse || req.Width != 640 || req.Height != 480 {
+			t.Errorf("%s: incorrect request; got = %+v, want the whole framebuffer", tt.desc, req)
+		}
+	}
+}
+
+func TestPixelFormatFallback_DecodedColors(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat32bit
+
+	colors := make([]Color, 1)
+	if err := conn.readPixelFormat().decodePixels(nil, []byte{0x80, 0x80, 0x80, 0}, colors); err != nil {
+		t.Fatal(err)
+	}
+	r, g, b, _ := colors[0].RGBA()
+	if err := conn.SetPixelFormat(PixelFormatRGB565); err != nil {
+		t.Fatal(err)
+	}
+	conn.readPixelFormat()
+
+	// The colors decoded keep the pixel format they were decoded with.
+	if r2, g2, b2, _ := colors[0].RGBA(); r2 != r || g2 != g || b2 != b {
+		t.Errorf("RGBA() after switching = %#x, %#x, %#x, want %#x, %#x, %#x", r2, g2, b2, r, g, b)
+	}
+}
@@ -0,0 +1,132 @@
+package vnc
+
+// Decoder benchmarks, replaying the update stream corpora found in
+// testdata/corpus. See testdata/corpus/gen.go for the corpus format.
+//
+//   $ go test -run=NONE -bench=Corpus
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/kward/go-vnc/messages"
+)
+
+// corpus holds a parsed update stream corpus.
+type corpus struct {
+	name        string
+	fbW, fbH    uint16
+	pixelFormat PixelFormat
+	stream      []byte // Server messages, sans the ServerInit header.
+}
+
+// loadCorpora reads all the corpora found in testdata/corpus.
+func loadCorpora(tb testing.TB) []corpus {
+	files, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.rfb"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if len(files) == 0 {
+		tb.Fatal("no corpora found")
+	}
+
+	var corpora []corpus
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		if _, err := mockConn.Write(data); err != nil {
+			tb.Fatal(err)
+		}
+		if err := conn.serverInit(); err != nil {
+			tb.Fatalf("%s: invalid ServerInit header; %s", f, err)
+		}
+		corpora = append(corpora, corpus{
+			name:        filepath.Base(f),
+			fbW:         conn.FramebufferWidth(),
+			fbH:         conn.FramebufferHeight(),
+			pixelFormat: conn.pixelFormat,
+			stream:      mockConn.b.Bytes(),
+		})
+	}
+	return corpora
+}
+
+// newCorpusConn returns a ClientConn configured to decode corpus c.
+func newCorpusConn(c corpus) (*ClientConn, *MockConn) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, NewClientConfig(""))
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}
+	conn.pixelFormat = c.pixelFormat
+	conn.setFramebufferSize(c.fbW, c.fbH)
+	return conn, mockConn
+}
+
+// replay decodes all the server messages found in the stream of corpus c,
+// returning the number of messages decoded.
+func replay(conn *ClientConn, mockConn *MockConn, c corpus) (int, error) {
+	serverMessages := make(map[messages.ServerMessage]ServerMessage)
+	for _, m := range conn.config.ServerMessages {
+		serverMessages[m.Type()] = m
+	}
+
+	mockConn.Reset()
+	mockConn.Write(c.stream)
+
+	n := 0
+	for {
+		var messageType messages.ServerMessage
+		if err := conn.receive(&messageType); err != nil {
+			if errors.Is(err, io.EOF) {
+				return n, nil
+			}
+			return n, err
+		}
+		msg, ok := serverMessages[messageType]
+		if !ok {
+			return n, Errorf("unsupported message-type: %v", messageType)
+		}
+		if _, err := msg.Read(conn); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func TestCorpora(t *testing.T) {
+	for _, c := range loadCorpora(t) {
+		conn, mockConn := newCorpusConn(c)
+		n, err := replay(conn, mockConn, c)
+		if err != nil {
+			t.Errorf("%s: error decoding corpus; %s", c.name, err)
+			continue
+		}
+		if n == 0 {
+			t.Errorf("%s: no messages decoded", c.name)
+		}
+	}
+}
+
+func BenchmarkCorpus(b *testing.B) {
+	for _, c := range loadCorpora(b) {
+		c := c
+		b.Run(c.name, func(b *testing.B) {
+			conn, mockConn := newCorpusConn(c)
+			b.SetBytes(int64(len(c.stream)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := replay(conn, mockConn, c); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
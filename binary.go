@@ -0,0 +1,329 @@
+// Implementations of the encoding.BinaryMarshaler and BinaryUnmarshaler
+// interfaces, so that messages can be constructed, stored, and tested without
+// a connection.
+
+package vnc
+
+import (
+	"encoding"
+	"encoding/binary"
+
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// Verify that interfaces are honored.
+var (
+	_ encoding.BinaryMarshaler   = (*SetPixelFormatMessage)(nil)
+	_ encoding.BinaryUnmarshaler = (*SetPixelFormatMessage)(nil)
+	_ encoding.BinaryMarshaler   = (*FramebufferUpdateRequestMessage)(nil)
+	_ encoding.BinaryUnmarshaler = (*FramebufferUpdateRequestMessage)(nil)
+	_ encoding.BinaryMarshaler   = (*KeyEventMessage)(nil)
+	_ encoding.BinaryUnmarshaler = (*KeyEventMessage)(nil)
+	_ encoding.BinaryMarshaler   = (*PointerEventMessage)(nil)
+	_ encoding.BinaryUnmarshaler = (*PointerEventMessage)(nil)
+
+	_ encoding.BinaryMarshaler   = (*FramebufferUpdate)(nil)
+	_ encoding.BinaryUnmarshaler = (*FramebufferUpdate)(nil)
+	_ encoding.BinaryMarshaler   = (*SetColorMapEntries)(nil)
+	_ encoding.BinaryUnmarshaler = (*SetColorMapEntries)(nil)
+	_ encoding.BinaryMarshaler   = (*Bell)(nil)
+	_ encoding.BinaryUnmarshaler = (*Bell)(nil)
+	_ encoding.BinaryMarshaler   = (*ServerCutText)(nil)
+	_ encoding.BinaryUnmarshaler = (*ServerCutText)(nil)
+	_ encoding.BinaryMarshaler   = (*UltraResizeFrameBuffer)(nil)
+	_ encoding.BinaryUnmarshaler = (*UltraResizeFrameBuffer)(nil)
+	_ encoding.BinaryMarshaler   = (*UltraFileTransfer)(nil)
+	_ encoding.BinaryUnmarshaler = (*UltraFileTransfer)(nil)
+	_ encoding.BinaryMarshaler   = (*UltraTextChat)(nil)
+	_ encoding.BinaryUnmarshaler = (*UltraTextChat)(nil)
+	_ encoding.BinaryMarshaler   = (*UltraKeepAlive)(nil)
+	_ encoding.BinaryUnmarshaler = (*UltraKeepAlive)(nil)
+	_ encoding.BinaryMarshaler   = (*UltraServerState)(nil)
+	_ encoding.BinaryUnmarshaler = (*UltraServerState)(nil)
+)
+
+//-----------------------------------------------------------------------------
+// Client-to-Server messages
+//
+// The messages are encoded exactly as sent to the server. SetEncodingsMessage
+// and ClientCutTextMessage are excluded: they hold only the fixed-length part
+// of their messages, read and written with the encodings and text which
+// follow, so they can't be encoded on their own.
+
+// checkClientMessage returns an error unless data holds a message of type t,
+// with length n.
+func checkClientMessage(data []byte, t messages.ClientMessage, n int) error {
+	if len(data) != n {
+		return Errorf("%v message length %d, want %d", t, len(data), n)
+	}
+	if got := messages.ClientMessage(data[0]); got != t {
+		return Errorf("message-type %v, want %v", got, t)
+	}
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *SetPixelFormatMessage) MarshalBinary() ([]byte, error) { return m.Marshal() }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *SetPixelFormatMessage) UnmarshalBinary(data []byte) error {
+	if err := checkClientMessage(data, messages.SetPixelFormat, setPixelFormatMessageLen); err != nil {
+		return err
+	}
+	m.Msg = messages.SetPixelFormat
+	m.PF.get(data[4:])
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *FramebufferUpdateRequestMessage) MarshalBinary() ([]byte, error) { return m.Marshal() }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *FramebufferUpdateRequestMessage) UnmarshalBinary(data []byte) error {
+	if err := checkClientMessage(data, messages.FramebufferUpdateRequest, framebufferUpdateRequestMessageLen); err != nil {
+		return err
+	}
+	m.Msg = messages.FramebufferUpdateRequest
+	m.Inc = rfbflags.RFBFlag(data[1])
+	m.X = binary.BigEndian.Uint16(data[2:])
+	m.Y = binary.BigEndian.Uint16(data[4:])
+	m.Width = binary.BigEndian.Uint16(data[6:])
+	m.Height = binary.BigEndian.Uint16(data[8:])
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *KeyEventMessage) MarshalBinary() ([]byte, error) { return m.Marshal() }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *KeyEventMessage) UnmarshalBinary(data []byte) error {
+	if err := checkClientMessage(data, messages.KeyEvent, keyEventMessageLen); err != nil {
+		return err
+	}
+	m.Msg = messages.KeyEvent
+	m.DownFlag = rfbflags.RFBFlag(data[1])
+	m.Key = keys.Key(binary.BigEndian.Uint32(data[4:]))
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *PointerEventMessage) MarshalBinary() ([]byte, error) { return m.Marshal() }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *PointerEventMessage) UnmarshalBinary(data []byte) error {
+	if err := checkClientMessage(data, messages.PointerEvent, pointerEventMessageLen); err != nil {
+		return err
+	}
+	m.Msg = messages.PointerEvent
+	m.Mask = data[1]
+	m.X = binary.BigEndian.Uint16(data[2:])
+	m.Y = binary.BigEndian.Uint16(data[4:])
+	return nil
+}
+
+//-----------------------------------------------------------------------------
+// Server-to-Client messages
+//
+// The messages are encoded exactly as received from the server, including the
+// message-type. Without a connection, they are decoded as though received by
+// a new connection: pixel data is in the PixelFormat32bit pixel format, all
+// the built-in encodings are supported, and the framebuffer size is
+// unbounded. Use ClientConn.UnmarshalServerMessage to decode messages with
+// the state of a connection.
+
+// builtinEncodings returns the encodings supported by detached connections.
+func builtinEncodings() Encodings {
+	return Encodings{
+		&RawEncoding{},
+		&CopyRectEncoding{},
+		&CursorPseudoEncoding{},
+		&DesktopSizePseudoEncoding{},
+		&DesktopNamePseudoEncoding{},
+		&ExtendedDesktopSizePseudoEncoding{},
+		&LastRectPseudoEncoding{},
+		&PointerPosPseudoEncoding{},
+	}
+}
+
+// unmarshalServerMessage decodes data, which must hold a message of the same
+// type as m, without a connection.
+func unmarshalServerMessage(m ServerMessage, data []byte) (ServerMessage, error) {
+	c := newClientConn(newByteConn(nil), &ClientConfig{TolerateUnknownEncodings: true})
+	c.encodings = builtinEncodings()
+	c.fbWidth, c.fbHeight = 0xffff, 0xffff
+
+	var msg ServerMessage
+	err := c.withData(data, func(c *ClientConn) error {
+		hdr, err := c.readHeader(1)
+		if err != nil {
+			return err
+		}
+		if got := messages.ServerMessage(hdr[0]); got != m.Type() {
+			return Errorf("message-type %v, want %v", got, m.Type())
+		}
+		msg, err = m.Read(c)
+		return err
+	})
+	return msg, err
+}
+
+// serverMessageHeader returns a message header of length n, holding the
+// message-type of m.
+func serverMessageHeader(m ServerMessage, n int) []byte {
+	b := make([]byte, n)
+	b[0] = uint8(m.Type())
+	return b
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *FramebufferUpdate) MarshalBinary() ([]byte, error) { return m.Marshal() }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *FramebufferUpdate) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*FramebufferUpdate)
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *SetColorMapEntries) MarshalBinary() ([]byte, error) {
+	b := serverMessageHeader(m, 6+6*len(m.Colors))
+	binary.BigEndian.PutUint16(b[2:], m.FirstColor)
+	binary.BigEndian.PutUint16(b[4:], uint16(len(m.Colors)))
+	for i, c := range m.Colors {
+		binary.BigEndian.PutUint16(b[6+6*i:], c.R)
+		binary.BigEndian.PutUint16(b[8+6*i:], c.G)
+		binary.BigEndian.PutUint16(b[10+6*i:], c.B)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *SetColorMapEntries) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*SetColorMapEntries)
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *Bell) MarshalBinary() ([]byte, error) { return serverMessageHeader(m, 1), nil }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *Bell) UnmarshalBinary(data []byte) error {
+	_, err := unmarshalServerMessage(m, data)
+	return err
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *ServerCutText) MarshalBinary() ([]byte, error) {
+	text, err := latin1Bytes(m.Text)
+	if err != nil {
+		return nil, err
+	}
+	b := serverMessageHeader(m, 8)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(text)))
+	return append(b, text...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *ServerCutText) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*ServerCutText)
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UltraResizeFrameBuffer) MarshalBinary() ([]byte, error) {
+	b := serverMessageHeader(m, 6)
+	binary.BigEndian.PutUint16(b[2:], m.Width)
+	binary.BigEndian.PutUint16(b[4:], m.Height)
+	return b, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *UltraResizeFrameBuffer) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*UltraResizeFrameBuffer)
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UltraFileTransfer) MarshalBinary() ([]byte, error) {
+	b := serverMessageHeader(m, 12)
+	b[1], b[2] = m.ContentType, m.ContentParam
+	binary.BigEndian.PutUint32(b[4:], m.Size)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(m.Data)))
+	return append(b, m.Data...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *UltraFileTransfer) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*UltraFileTransfer)
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UltraTextChat) MarshalBinary() ([]byte, error) {
+	b := serverMessageHeader(m, 8)
+	if m.Control != 0 {
+		binary.BigEndian.PutUint32(b[4:], m.Control)
+		return b, nil
+	}
+	binary.BigEndian.PutUint32(b[4:], uint32(len(m.Text)))
+	return append(b, m.Text...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *UltraTextChat) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*UltraTextChat)
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UltraKeepAlive) MarshalBinary() ([]byte, error) { return serverMessageHeader(m, 1), nil }
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *UltraKeepAlive) UnmarshalBinary(data []byte) error {
+	_, err := unmarshalServerMessage(m, data)
+	return err
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (m *UltraServerState) MarshalBinary() ([]byte, error) {
+	b := serverMessageHeader(m, 12)
+	binary.BigEndian.PutUint32(b[4:], m.State)
+	binary.BigEndian.PutUint32(b[8:], m.Value)
+	return b, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (m *UltraServerState) UnmarshalBinary(data []byte) error {
+	msg, err := unmarshalServerMessage(m, data)
+	if err != nil {
+		return err
+	}
+	*m = *msg.(*UltraServerState)
+	return nil
+}
@@ -0,0 +1,95 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding"
+	"reflect"
+	"testing"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	type message interface {
+		encoding.BinaryMarshaler
+		encoding.BinaryUnmarshaler
+	}
+	for _, tt := range []struct {
+		desc string
+		data []byte
+		msg  message
+	}{
+		// Client-to-Server messages.
+		{"SetPixelFormat",
+			append([]byte{0, 0, 0, 0}, 32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0),
+			&SetPixelFormatMessage{}},
+		{"FramebufferUpdateRequest", []byte{3, 1, 0, 1, 0, 2, 0, 3, 0, 4}, &FramebufferUpdateRequestMessage{}},
+		{"KeyEvent", []byte{4, 1, 0, 0, 0, 0, 0xff, 0x0d}, &KeyEventMessage{}},
+		{"PointerEvent", []byte{5, 1, 0, 10, 0, 20}, &PointerEventMessage{}},
+		// Server-to-Client messages.
+		{"FramebufferUpdate",
+			[]byte{0, 0, 0, 1, 0, 1, 0, 2, 0, 1, 0, 1, 0, 0, 0, 0, 0x11, 0x22, 0x33, 0},
+			&FramebufferUpdate{}},
+		{"SetColorMapEntries", []byte{1, 0, 0, 7, 0, 1, 0, 1, 0, 2, 0, 3}, &SetColorMapEntries{}},
+		{"Bell", []byte{2}, &Bell{}},
+		{"ServerCutText", []byte{3, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'}, &ServerCutText{}},
+		{"UltraResizeFrameBuffer", []byte{4, 0, 0x03, 0x20, 0x02, 0x58}, &UltraResizeFrameBuffer{}},
+		{"UltraFileTransfer", []byte{7, 1, 2, 0, 0, 0, 0, 9, 0, 0, 0, 2, 'a', 'b'}, &UltraFileTransfer{}},
+		{"UltraTextChat", []byte{11, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'}, &UltraTextChat{}},
+		{"UltraTextChat control", []byte{11, 0, 0, 0, 0xff, 0xff, 0xff, 0xfe}, &UltraTextChat{}},
+		{"UltraKeepAlive", []byte{13}, &UltraKeepAlive{}},
+		{"UltraServerState", []byte{173, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2}, &UltraServerState{}},
+	} {
+		if err := tt.msg.UnmarshalBinary(tt.data); err != nil {
+			t.Errorf("%s: UnmarshalBinary() unexpected error: %s", tt.desc, err)
+			continue
+		}
+		got, err := tt.msg.MarshalBinary()
+		if err != nil {
+			t.Errorf("%s: MarshalBinary() unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.data) {
+			t.Errorf("%s: MarshalBinary() = %v, want = %v", tt.desc, got, tt.data)
+		}
+	}
+}
+
+func TestUnmarshalBinary_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		data []byte
+		msg  encoding.BinaryUnmarshaler
+	}{
+		{"short client message", []byte{4, 1, 0}, &KeyEventMessage{}},
+		{"long client message", []byte{5, 1, 0, 10, 0, 20, 0}, &PointerEventMessage{}},
+		{"wrong client message-type", []byte{5, 1, 0, 0, 0, 0, 0xff, 0x0d}, &KeyEventMessage{}},
+		{"empty server message", nil, &Bell{}},
+		{"wrong server message-type", []byte{2}, &ServerCutText{}},
+		{"truncated server message", []byte{3, 0, 0, 0, 0, 0, 0, 3, 'a'}, &ServerCutText{}},
+		{"trailing data", []byte{2, 2}, &Bell{}},
+		{"oversized raw rectangle", []byte{0, 0, 0, 1, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, &FramebufferUpdate{}},
+		{"oversized cursor rectangle", []byte{0, 0, 0, 1, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x11}, &FramebufferUpdate{}},
+	} {
+		if err := tt.msg.UnmarshalBinary(tt.data); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+}
+
+func TestFramebufferUpdate_UnmarshalBinary(t *testing.T) {
+	var m FramebufferUpdate
+	if err := m.UnmarshalBinary([]byte{0, 0, 0, 1, 0, 1, 0, 2, 0, 1, 0, 1, 0, 0, 0, 0, 0x11, 0x22, 0x33, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(m.Rects), 1; got != want {
+		t.Fatalf("got %d rectangles, want %d", got, want)
+	}
+	raw, ok := m.Rects[0].Enc.(*RawEncoding)
+	if !ok {
+		t.Fatalf("encoding = %T, want *RawEncoding", m.Rects[0].Enc)
+	}
+	// Decoded with PixelFormat32bit: big-endian, shifts of 0, 8 and 16 bits.
+	c := raw.Colors[0]
+	if got, want := []uint16{c.R, c.G, c.B}, []uint16{0x3300, 0x2233, 0x1122}; !reflect.DeepEqual(got, want) {
+		t.Errorf("color = %v, want = %v", got, want)
+	}
+}
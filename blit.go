@@ -0,0 +1,94 @@
+// Drawing of parts of the framebuffer into images, e.g. by viewers.
+
+package vnc
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Blit draws the rectangle sr of the framebuffer into the rectangle dr of
+// dst, scaling it, with nearest-neighbour sampling, if their sizes differ, and
+// converting its pixels to the color model of dst. Only the parts of dr within
+// dst, and mapped from within the framebuffer, are drawn. Drawing into an
+// *image.RGBA writes its pixels directly; other images are drawn with Set.
+func (fb *Framebuffer) Blit(dst draw.Image, dr, sr image.Rectangle) {
+	fb.blit(dst, dr, sr, dr)
+}
+
+// BlitScaled draws the framebuffer scaled to the bounds of dst, e.g. the
+// window of a viewer, but only the parts covering the rectangles damaged of
+// the framebuffer, e.g. those of Frame.Changed, so viewers repaint only what
+// changed. If damaged is empty, the whole framebuffer is drawn.
+func (fb *Framebuffer) BlitScaled(dst draw.Image, damaged ...image.Rectangle) {
+	sr, dr := image.Rect(0, 0, fb.Width, fb.Height), dst.Bounds()
+	if len(damaged) == 0 {
+		fb.blit(dst, dr, sr, dr)
+		return
+	}
+	for _, r := range damaged {
+		fb.blit(dst, dr, sr, scaleRect(r, sr, dr))
+	}
+}
+
+// blit draws the part clip of dr, mapped from sr of the framebuffer.
+func (fb *Framebuffer) blit(dst draw.Image, dr, sr, clip image.Rectangle) {
+	if dr.Empty() || sr.Empty() {
+		return
+	}
+	clip = clip.Intersect(dr).Intersect(dst.Bounds())
+	bounds := image.Rect(0, 0, fb.Width, fb.Height)
+	rgba, _ := dst.(*image.RGBA)
+	for y := clip.Min.Y; y < clip.Max.Y; y++ {
+		sy := sr.Min.Y + (y-dr.Min.Y)*sr.Dy()/dr.Dy()
+		for x := clip.Min.X; x < clip.Max.X; x++ {
+			sx := sr.Min.X + (x-dr.Min.X)*sr.Dx()/dr.Dx()
+			if !image.Pt(sx, sy).In(bounds) {
+				continue
+			}
+			c := fb.Pixels[sy*fb.Width+sx]
+			if rgba == nil {
+				dst.Set(x, y, c)
+				continue
+			}
+			r, g, b, _ := c.RGBA()
+			p := rgba.Pix[rgba.PixOffset(x, y):]
+			p[0], p[1], p[2], p[3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), 0xff
+		}
+	}
+}
+
+// scaleRect returns the rectangle r of from, scaled to to, rounded outwards
+// so that it covers every pixel sampled from r.
+func scaleRect(r, from, to image.Rectangle) image.Rectangle {
+	scale := func(v, fmin, flen, tmin, tlen int, up bool) int {
+		n := (v - fmin) * tlen
+		q := n / flen
+		if up && n%flen != 0 {
+			q++
+		}
+		return tmin + q
+	}
+	return image.Rect(
+		scale(r.Min.X, from.Min.X, from.Dx(), to.Min.X, to.Dx(), false),
+		scale(r.Min.Y, from.Min.Y, from.Dy(), to.Min.Y, to.Dy(), false),
+		scale(r.Max.X, from.Min.X, from.Dx(), to.Min.X, to.Dx(), true),
+		scale(r.Max.Y, from.Min.Y, from.Dy(), to.Min.Y, to.Dy(), true),
+	)
+}
+
+// Blit draws the rectangle sr of the screen into the rectangle dr of dst. See
+// Framebuffer.Blit.
+func (s *Screen) Blit(dst draw.Image, dr, sr image.Rectangle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fb.Blit(dst, dr, sr)
+}
+
+// BlitScaled draws the parts of the screen damaged, scaled to the bounds of
+// dst. See Framebuffer.BlitScaled.
+func (s *Screen) BlitScaled(dst draw.Image, damaged ...image.Rectangle) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fb.BlitScaled(dst, damaged...)
+}
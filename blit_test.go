@@ -0,0 +1,80 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testFramebuffer returns a 4x3 framebuffer, with red at (1, 1).
+func testFramebuffer() *Framebuffer {
+	fb := NewFramebuffer(4, 3)
+	fb.Pixels[1*4+1] = Color{R: 0xffff}
+	return fb
+}
+
+func TestFramebuffer_Blit(t *testing.T) {
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+	none := color.RGBA{}
+
+	for _, tt := range []struct {
+		desc   string
+		dst    image.Rectangle
+		dr, sr image.Rectangle
+		want   map[image.Point]color.RGBA
+	}{
+		{"copy", image.Rect(0, 0, 4, 3), image.Rect(0, 0, 4, 3), image.Rect(0, 0, 4, 3),
+			map[image.Point]color.RGBA{{1, 1}: red, {0, 0}: black}},
+		{"sub-rectangle", image.Rect(0, 0, 4, 3), image.Rect(2, 2, 3, 3), image.Rect(1, 1, 2, 2),
+			map[image.Point]color.RGBA{{2, 2}: red, {1, 1}: none}},
+		{"scaled up", image.Rect(0, 0, 8, 6), image.Rect(0, 0, 8, 6), image.Rect(0, 0, 4, 3),
+			map[image.Point]color.RGBA{{2, 2}: red, {3, 3}: red, {4, 4}: black, {1, 1}: black}},
+		{"scaled down", image.Rect(0, 0, 2, 3), image.Rect(0, 0, 2, 3), image.Rect(0, 0, 4, 3),
+			map[image.Point]color.RGBA{{0, 1}: black, {1, 1}: black}},
+		{"clipped", image.Rect(0, 0, 2, 2), image.Rect(0, 0, 4, 3), image.Rect(0, 0, 4, 3),
+			map[image.Point]color.RGBA{{1, 1}: red}},
+	} {
+		dst := image.NewRGBA(tt.dst)
+		testFramebuffer().Blit(dst, tt.dr, tt.sr)
+		for p, want := range tt.want {
+			if got := dst.RGBAAt(p.X, p.Y); got != want {
+				t.Errorf("%s: pixel %v = %v, want %v", tt.desc, p, got, want)
+			}
+		}
+	}
+}
+
+func TestFramebuffer_Blit_Convert(t *testing.T) {
+	dst := image.NewGray(image.Rect(0, 0, 4, 3))
+	testFramebuffer().Blit(dst, dst.Bounds(), dst.Bounds())
+	if got, want := dst.GrayAt(1, 1).Y, color.GrayModel.Convert(color.RGBA{0xff, 0, 0, 0xff}).(color.Gray).Y; got != want {
+		t.Errorf("gray = %d, want %d", got, want)
+	}
+}
+
+func TestFramebuffer_BlitScaled(t *testing.T) {
+	sentinel := color.RGBA{0, 0xff, 0, 0xff}
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 6))
+	for i := range dst.Pix {
+		dst.Pix[i] = []uint8{sentinel.R, sentinel.G, sentinel.B, sentinel.A}[i%4]
+	}
+	testFramebuffer().BlitScaled(dst, image.Rect(1, 1, 2, 2))
+	for _, tt := range []struct {
+		p    image.Point
+		want color.RGBA
+	}{
+		{image.Pt(2, 2), color.RGBA{0xff, 0, 0, 0xff}},
+		{image.Pt(3, 3), color.RGBA{0xff, 0, 0, 0xff}},
+		{image.Pt(1, 1), sentinel}, // Not damaged.
+		{image.Pt(4, 4), sentinel},
+	} {
+		if got := dst.RGBAAt(tt.p.X, tt.p.Y); got != tt.want {
+			t.Errorf("pixel %v = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	if got, want := scaleRect(image.Rect(1, 1, 2, 2), image.Rect(0, 0, 3, 3), image.Rect(0, 0, 4, 4)), image.Rect(1, 1, 3, 3); got != want {
+		t.Errorf("scaleRect() = %v, want %v", got, want)
+	}
+}
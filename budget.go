@@ -0,0 +1,130 @@
+// A cap on the memory used by connections.
+
+package vnc
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// colorSize is the memory used by each pixel of a Framebuffer.
+const colorSize = int64(unsafe.Sizeof(Color{}))
+
+// MemoryBudget caps the memory used by the framebuffers of screens, their
+// frame histories, snapshots, the buffers pixel data is decoded from, and the
+// rectangles of RectCaches, of the connections sharing it, e.g. all those of a
+// process managing hundreds of sessions. Rather than use memory beyond the
+// budget, connections degrade:
+//
+//   - the frame history of a screen is dropped, and resumes once there is room;
+//   - the framebuffer is left out of snapshots made with State;
+//   - the buffer pixel data is decoded from isn't kept between rectangles;
+//   - rectangles aren't added to the RectCache.
+//
+// Framebuffers themselves are always counted, but never refused, as screens
+// can't work without them. The memory used by a screen is returned to the
+// budget when the screen, or its connection, is closed, and the memory used
+// by the rest of a connection when it is closed. As a RectCache may outlive
+// the connections using it, the memory of its rectangles is returned when
+// they are evicted.
+type MemoryBudget struct {
+	max int64
+
+	mu     sync.Mutex
+	used   int64
+	denied uint64
+}
+
+// NewMemoryBudget returns a budget of max bytes.
+func NewMemoryBudget(max int64) *MemoryBudget {
+	return &MemoryBudget{max: max}
+}
+
+// Max returns the size of the budget, in bytes.
+func (b *MemoryBudget) Max() int64 {
+	return b.max
+}
+
+// Used returns the bytes of the budget in use.
+func (b *MemoryBudget) Used() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// Denied returns the number of times memory was refused, and a connection
+// degraded.
+func (b *MemoryBudget) Denied() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.denied
+}
+
+// reserve reserves n bytes, unless that would exceed the budget, or force is
+// set. A nil budget reserves everything.
+func (b *MemoryBudget) reserve(n int64, force bool) bool {
+	if b == nil || n <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !force && b.used+n > b.max {
+		b.denied++
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// release returns n bytes to the budget.
+func (b *MemoryBudget) release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+}
+
+// connBudget is the share of the MemoryBudget of a connection, which returns
+// what it holds to the budget when the connection is closed.
+type connBudget struct {
+	budget *MemoryBudget
+
+	mu     sync.Mutex
+	held   int64
+	closed bool // Once closed, nothing more is reserved from the budget.
+}
+
+// reserve reserves n bytes for the connection. See MemoryBudget.reserve.
+func (cb *connBudget) reserve(n int64, force bool) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.closed {
+		return force
+	}
+	if !cb.budget.reserve(n, force) {
+		return false
+	}
+	cb.held += n
+	return true
+}
+
+// release returns n bytes of the connection to the budget.
+func (cb *connBudget) release(n int64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if n > cb.held {
+		n = cb.held
+	}
+	cb.budget.release(n)
+	cb.held -= n
+}
+
+// close returns all the bytes held by the connection to the budget.
+func (cb *connBudget) close() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.budget.release(cb.held)
+	cb.held, cb.closed = 0, true
+}
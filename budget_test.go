@@ -0,0 +1,242 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	var nilBudget *MemoryBudget
+	if !nilBudget.reserve(1<<40, false) {
+		t.Error("nil budget refused memory")
+	}
+
+	b := NewMemoryBudget(100)
+	for _, tt := range []struct {
+		desc  string
+		n     int64
+		force bool
+		ok    bool
+		used  int64
+	}{
+		{"within", 60, false, true, 60},
+		{"over", 50, false, false, 60},
+		{"exactly", 40, false, true, 100},
+		{"forced", 10, true, true, 110},
+	} {
+		if got, want := b.reserve(tt.n, tt.force), tt.ok; got != want {
+			t.Errorf("%s: reserve(%d) = %v, want %v", tt.desc, tt.n, got, want)
+		}
+		if got, want := b.Used(), tt.used; got != want {
+			t.Errorf("%s: Used() = %d, want %d", tt.desc, got, want)
+		}
+	}
+	if got, want := b.Denied(), uint64(1); got != want {
+		t.Errorf("Denied() = %d, want %d", got, want)
+	}
+}
+
+func TestConnBudget_Close(t *testing.T) {
+	b := NewMemoryBudget(100)
+	cb := &connBudget{budget: b}
+	cb.reserve(30, false)
+	cb.release(10)
+	if got, want := b.Used(), int64(20); got != want {
+		t.Errorf("Used() = %d, want %d", got, want)
+	}
+	cb.close()
+	if got, want := b.Used(), int64(0); got != want {
+		t.Errorf("Used() = %d, want %d once closed", got, want)
+	}
+	// Nothing is reserved, or released, once closed.
+	cb.reserve(30, true)
+	cb.release(30)
+	if got, want := b.Used(), int64(0); got != want {
+		t.Errorf("Used() = %d, want %d after closing", got, want)
+	}
+}
+
+func TestMemoryBudget_Screen(t *testing.T) {
+	fb := 12 * colorSize // The 4x3 framebuffer of the test screen.
+	b := NewMemoryBudget(fb + colorSize)
+	sc := &screenConn{}
+	conn := NewClientConn(sc, &ClientConfig{MemoryBudget: b})
+	conn.fbWidth, conn.fbHeight = 4, 3
+	s := NewScreen(conn)
+	if got, want := b.Used(), fb; got != want {
+		t.Fatalf("Used() = %d, want %d", got, want)
+	}
+
+	// The history has room for a single pixel.
+	s.SetHistory(3)
+	for _, fu := range []*FramebufferUpdate{
+		rawUpdate(0, 0, Color{R: 0xffff}),
+		rawUpdate(1, 0, Color{R: 0xffff}), // Drops the history.
+	} {
+		if err := s.Handle(fu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := s.HistoryLen(), 1; got != want {
+		t.Errorf("HistoryLen() = %d, want %d", got, want)
+	}
+	if got, want := b.Used(), fb; got != want {
+		t.Errorf("Used() = %d, want %d once the history is dropped", got, want)
+	}
+	// The history restarts from the update which dropped it.
+	if err := s.Handle(rawUpdate(2, 0, Color{R: 0xffff})); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := s.HistoryLen(), 2; got != want {
+		t.Errorf("HistoryLen() = %d, want %d", got, want)
+	}
+
+	// The snapshot has no room for the framebuffer.
+	if st := s.State(); len(st.Framebuffer) != 0 {
+		t.Errorf("snapshot holds the framebuffer, over the budget")
+	}
+
+	conn.Close()
+	if got, want := b.Used(), int64(0); got != want {
+		t.Errorf("Used() = %d, want %d once closed", got, want)
+	}
+}
+
+func TestMemoryBudget_ScreenClose(t *testing.T) {
+	fb := 12 * colorSize // The 4x3 framebuffer of the test screen.
+	b := NewMemoryBudget(100 * colorSize)
+	conn := NewClientConn(&screenConn{}, &ClientConfig{MemoryBudget: b})
+	conn.fbWidth, conn.fbHeight = 4, 3
+
+	// Screens replaced on a connection return their memory once closed.
+	for i := 0; i < 3; i++ {
+		s := NewScreen(conn)
+		s.SetHistory(2)
+		if err := s.Handle(rawUpdate(0, 0, Color{R: 0xffff})); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		s.Close()
+		if err := s.Handle(resizeUpdate(8, 6)); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := b.Used(), int64(0); got != want {
+			t.Fatalf("Used() = %d, want %d once screen %d is closed", got, want, i)
+		}
+	}
+
+	// A resumed screen is charged for the framebuffer of the state.
+	st := &State{Width: 2, Height: 2, PixelFormat: conn.PixelFormat(), Framebuffer: make([]byte, 2*2*6)}
+	s := ResumeScreen(conn, st)
+	if got, want := b.Used(), 4*colorSize; got != want {
+		t.Errorf("Used() = %d, want %d for the resumed framebuffer (not %d)", got, want, fb)
+	}
+	s.Close()
+	if got, want := b.Used(), int64(0); got != want {
+		t.Errorf("Used() = %d, want %d once the resumed screen is closed", got, want)
+	}
+}
+
+func TestMemoryBudget_RectCache(t *testing.T) {
+	entry := 4 * colorSize // A 2x2 raw rectangle.
+	b := NewMemoryBudget(entry + colorSize)
+	rc := NewRectCache(2)
+
+	rc.put(RectHash{1}, &RawEncoding{make([]Color, 4)}, b)
+	if got, want := b.Used(), entry; got != want {
+		t.Errorf("Used() = %d, want %d", got, want)
+	}
+	// Over the budget, rectangles aren't cached.
+	rc.put(RectHash{2}, &RawEncoding{make([]Color, 4)}, b)
+	if got, want := rc.Len(), 1; got != want {
+		t.Errorf("Len() = %d, want %d over the budget", got, want)
+	}
+	// Evicted rectangles return their memory.
+	rc.put(RectHash{3}, &RawEncoding{make([]Color, 1)}, b)
+	rc.put(RectHash{4}, &RawEncoding{}, b)
+	if got, want := b.Used(), colorSize; got != want {
+		t.Errorf("Used() = %d, want %d once evicted", got, want)
+	}
+}
+
+func TestMemoryBudget_Unmarshal(t *testing.T) {
+	const size = 64
+	entry := size * size * colorSize // A rectangle of the RectCache.
+	b := NewMemoryBudget(1 << 30)
+	rc := NewRectCache(1)
+	conn := NewClientConn(&MockConn{}, &ClientConfig{MemoryBudget: b, RectCache: rc})
+	conn.pixelFormat = PixelFormat32bit
+	conn.fbWidth, conn.fbHeight = size, size
+
+	// update returns a FramebufferUpdate of a raw rectangle of pixels of the
+	// value v.
+	update := func(v byte) []byte {
+		var buf bytes.Buffer
+		buf.Write([]byte{0, 0, 0, 1})
+		binary.Write(&buf, binary.BigEndian, rectangleMessage{0, 0, size, size, encodings.Raw})
+		buf.Write(bytes.Repeat([]byte{v}, size*size*4))
+		return buf.Bytes()
+	}
+
+	// Each update misses the cache, and only the rectangle cached last is
+	// charged to the budget.
+	for i := 0; i < 5; i++ {
+		if _, err := conn.UnmarshalServerMessage(update(byte(i))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got, want := b.Used(), entry; got != want {
+			t.Fatalf("update %d: Used() = %d, want %d", i, got, want)
+		}
+	}
+	if _, misses := rc.Stats(); misses != 5 {
+		t.Errorf("misses = %d, want 5", misses)
+	}
+
+	// Without a cache, nothing is charged once unmarshaled.
+	conn = NewClientConn(&MockConn{}, &ClientConfig{MemoryBudget: b})
+	conn.pixelFormat = PixelFormat32bit
+	conn.fbWidth, conn.fbHeight = size, size
+	for i := 0; i < 5; i++ {
+		if _, err := conn.UnmarshalServerMessage(update(byte(i))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	conn.Close()
+	if got, want := b.Used(), entry; got != want {
+		t.Errorf("Used() = %d, want %d", got, want)
+	}
+}
+
+func TestMemoryBudget_ReadPixels(t *testing.T) {
+	b := NewMemoryBudget(4)
+	conn := NewClientConn(NewStreamConn(&repeatReader{}, nil), &ClientConfig{MemoryBudget: b})
+	if _, err := conn.readPixels(4); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cap(conn.pixBuf), 4; got != want {
+		t.Errorf("cap(pixBuf) = %d, want %d", got, want)
+	}
+	// The larger buffer isn't kept.
+	if _, err := conn.readPixels(8); err != nil {
+		t.Fatal(err)
+	}
+	if conn.pixBuf != nil {
+		t.Errorf("pixBuf kept over the budget")
+	}
+	if got, want := b.Used(), int64(0); got != want {
+		t.Errorf("Used() = %d, want %d", got, want)
+	}
+}
+
+// repeatReader reads zeros forever.
+type repeatReader struct{}
+
+func (*repeatReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
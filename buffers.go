@@ -0,0 +1,61 @@
+// Vectored writes of the parts of messages.
+
+package vnc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"unsafe"
+)
+
+// stringBytes returns the bytes of s, without copying them. They must not be
+// modified.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// buffersLen returns the total length of the buffers.
+func buffersLen(bufs net.Buffers) int {
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	return n
+}
+
+// writeBuffers writes the buffers to w as a single message: with one vectored
+// write to TCP and Unix domain sockets, which write them atomically, and
+// otherwise with one write of them concatenated, so that messages written
+// concurrently aren't interleaved.
+func writeBuffers(w io.Writer, bufs net.Buffers) error {
+	switch w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		_, err := bufs.WriteTo(w)
+		return err
+	}
+	_, err := w.Write(bytes.Join(bufs, nil))
+	return err
+}
+
+// sendBuffers sends a message made of the buffers to the network, without
+// concatenating them where it can, e.g. the header and text of a
+// ClientCutText message.
+func (c *ClientConn) sendBuffers(bufs net.Buffers) error {
+	n := buffersLen(bufs)
+	var err error
+	switch {
+	case c.sendQ != nil:
+		err = c.write(bytes.Join(bufs, nil))
+	case c.coalescer != nil:
+		err = c.coalescer.writeBuffers(bufs)
+	default:
+		err = writeBuffers(c.c, bufs)
+	}
+	if err != nil {
+		return connError(err)
+	}
+	c.metrics["bytes-sent"].Adjust(int64(n))
+	c.stats.sent(n)
+	return nil
+}
@@ -0,0 +1,61 @@
+package vnc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestWriteBuffers(t *testing.T) {
+	bufs := func() net.Buffers { return net.Buffers{[]byte("head"), []byte("payload")} }
+
+	// Written concatenated to other writers.
+	w := &recordingWriter{}
+	if err := writeBuffers(w, bufs()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.get(), [][]byte{[]byte("headpayload")}; len(got) != 1 || !bytes.Equal(got[0], want[0]) {
+		t.Errorf("writes = %q, want = %q", got, want)
+	}
+
+	// Written with a vectored write to TCP connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	if err := writeBuffers(c, bufs()); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	got, err := ioutil.ReadAll(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("headpayload"); !bytes.Equal(got, want) {
+		t.Errorf("read = %q, want = %q", got, want)
+	}
+}
+
+func TestClientCutText_SingleWrite(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	w := &recordingWriter{}
+	conn := NewClientConn(NewStreamConn(eofReader{}, w), &ClientConfig{})
+	if err := conn.ClientCutText("hi"); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{6, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'}
+	if got := w.get(); len(got) != 1 || !bytes.Equal(got[0], want) {
+		t.Errorf("writes = %v, want = [%v]", got, want)
+	}
+}
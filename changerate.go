@@ -0,0 +1,88 @@
+// The rate at which the pixels of a screen change.
+
+package vnc
+
+import (
+	"math"
+	"time"
+)
+
+// DefaultChangeRateWindow is the default window of Screen.ChangeRate.
+const DefaultChangeRateWindow = time.Second
+
+// changeRate is a rolling rate of the fraction of the pixels of a screen
+// changed per second, decaying exponentially with the time constant window.
+type changeRate struct {
+	window time.Duration // Zero is DefaultChangeRateWindow.
+	rate   float64       // The rate at time last.
+	last   time.Time
+}
+
+// seconds returns the window, in seconds.
+func (r *changeRate) seconds() float64 {
+	if r.window <= 0 {
+		return DefaultChangeRateWindow.Seconds()
+	}
+	return r.window.Seconds()
+}
+
+// observe records that the fraction f of the pixels changed at now.
+func (r *changeRate) observe(f float64, now time.Time) {
+	r.rate = r.at(now) + f/r.seconds()
+	r.last = now
+}
+
+// at returns the rate at now.
+func (r *changeRate) at(now time.Time) float64 {
+	if r.last.IsZero() {
+		return 0
+	}
+	d := now.Sub(r.last).Seconds()
+	if d <= 0 {
+		return r.rate
+	}
+	return r.rate * math.Exp(-d/r.seconds())
+}
+
+// changedArea returns the number of pixels of the screen changed by the
+// rectangle once applied, all of them if it resized the screen.
+func (s *Screen) changedArea(rect *Rectangle) int {
+	if rect.IsResize() {
+		return len(s.fb.Pixels)
+	}
+	if rect.Enc == nil || rect.Enc.Type() < 0 {
+		return 0
+	}
+	return rect.Area()
+}
+
+// observeChanges records the pixels changed by an update at now.
+func (s *Screen) observeChanges(area int, now time.Time) {
+	f := 1.0
+	if n := len(s.fb.Pixels); area < n {
+		f = float64(area) / float64(n)
+	}
+	s.changes.observe(f, now)
+}
+
+// ChangeRate returns the rolling rate at which the pixels of the screen
+// change: the fraction of the pixels changed per second, from the rectangles
+// applied, weighted over the window set by SetChangeRateWindow. A screen
+// updated in full 30 times a second, e.g. playing a video, changes at about
+// 30, and an idle screen at about 0. Rectangles which overlap, or don't
+// change the pixels they hold, count in full.
+func (s *Screen) ChangeRate() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.changes.at(time.Now())
+}
+
+// SetChangeRateWindow sets the window of ChangeRate, the time constant of its
+// exponential decay. If zero, DefaultChangeRateWindow is used.
+func (s *Screen) SetChangeRateWindow(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.changes.rate, s.changes.last = s.changes.at(now), now
+	s.changes.window = d
+}
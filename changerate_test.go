@@ -0,0 +1,54 @@
+package vnc
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestChangeRate(t *testing.T) {
+	var r changeRate
+	start := time.Unix(1000, 0)
+	if got := r.at(start); got != 0 {
+		t.Errorf("at() = %v, want 0 before any change", got)
+	}
+
+	// Half of the screen changes 10 times a second.
+	now := start
+	for i := 0; i < 100; i++ {
+		now = now.Add(100 * time.Millisecond)
+		r.observe(0.5, now)
+	}
+	if got, want := r.at(now), 5.0; math.Abs(got-want) > 0.5 {
+		t.Errorf("at() = %v, want about %v", got, want)
+	}
+	// The rate decays once the screen stops changing.
+	got := r.at(now.Add(DefaultChangeRateWindow))
+	if want := r.at(now) / math.E; math.Abs(got-want) > 1e-9 {
+		t.Errorf("at() = %v after the window, want %v", got, want)
+	}
+}
+
+func TestScreen_ChangeRate(t *testing.T) {
+	s, _ := newTestScreen()
+	s.SetChangeRateWindow(time.Hour) // Hardly decays during the test.
+	if got := s.ChangeRate(); got != 0 {
+		t.Errorf("ChangeRate() = %v, want 0", got)
+	}
+	// A pixel of the 12 of the screen.
+	if err := s.Handle(rawUpdate(0, 0, Color{R: 0xffff})); err != nil {
+		t.Fatal(err)
+	}
+	want := 1.0 / 12 / time.Hour.Seconds()
+	if got := s.ChangeRate(); math.Abs(got-want) > want/100 {
+		t.Errorf("ChangeRate() = %v, want %v", got, want)
+	}
+	// Resizes change the whole screen.
+	if err := s.Handle(resizeUpdate(2, 2)); err != nil {
+		t.Fatal(err)
+	}
+	want += 1 / time.Hour.Seconds()
+	if got := s.ChangeRate(); math.Abs(got-want) > want/100 {
+		t.Errorf("ChangeRate() = %v, want %v", got, want)
+	}
+}
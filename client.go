@@ -3,9 +3,12 @@
 package vnc
 
 import (
+	"encoding/binary"
 	"fmt"
+	"net"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/golang/glog"
 	"github.com/kward/go-vnc/buttons"
@@ -23,6 +26,19 @@ type SetPixelFormatMessage struct {
 	PF  PixelFormat            // pixel-format
 }
 
+const setPixelFormatMessageLen = 20
+
+// Verify that interfaces are honored.
+var _ Marshaler = (*SetPixelFormatMessage)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *SetPixelFormatMessage) Marshal() ([]byte, error) {
+	b := make([]byte, setPixelFormatMessageLen)
+	b[0] = uint8(m.Msg)
+	m.PF.put(b[4:])
+	return b, nil
+}
+
 // SetPixelFormat sets the format in which pixel values should be sent
 // in FramebufferUpdate messages from the server.
 //
@@ -36,16 +52,10 @@ func (c *ClientConn) SetPixelFormat(pf PixelFormat) error {
 		Msg: messages.SetPixelFormat,
 		PF:  pf,
 	}
-	if err := c.send(msg); err != nil {
+	if err := c.sendMessage(&msg); err != nil {
 		return err
 	}
-
-	// Invalidate the color map.
-	if !rfbflags.IsTrueColor(pf.TrueColor) {
-		c.colorMap = [256]Color{}
-	}
-
-	c.pixelFormat = pf
+	c.setPixelFormat(pf)
 	return nil
 }
 
@@ -56,10 +66,31 @@ type SetEncodingsMessage struct {
 	NumEncs uint16                 // number-of-encodings
 }
 
+const setEncodingsMessageLen = 4
+
+// Verify that interfaces are honored.
+var _ Marshaler = (*SetEncodingsMessage)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *SetEncodingsMessage) Marshal() ([]byte, error) {
+	b := make([]byte, setEncodingsMessageLen)
+	b[0] = uint8(m.Msg)
+	binary.BigEndian.PutUint16(b[2:], m.NumEncs)
+	return b, nil
+}
+
 // SetEncodings sets the encoding types in which the pixel data can be sent
 // from the server. After calling this method, the encs slice given should not
 // be modified.
 //
+// SetEncodings may be called again at any time, e.g. to switch to Raw for a
+// pixel-perfect capture. The encodings are replaced before the message is
+// sent, so the updates using them can be decoded as soon as the server sends
+// them. The encodings replaced remain decodable, as updates using them may
+// still be in flight, until an update is read answering a
+// FramebufferUpdateRequest sent after the change. Each FramebufferUpdate is
+// decoded with the encodings supported when it started to be read.
+//
 // TODO(kward:20170306) Fix bad practice of mixing of protocol and internal
 // state here.
 //
@@ -80,31 +111,28 @@ func (c *ClientConn) SetEncodings(encs Encodings) error {
 	if !haveRaw {
 		encs = append(encs, &RawEncoding{})
 	}
-
-	buf := NewBuffer(nil)
+	encs = c.profileEncodings(encs)
 
 	// Prepare message.
 	msg := SetEncodingsMessage{
 		Msg:     messages.SetEncodings,
 		NumEncs: uint16(len(encs)),
 	}
-	if err := buf.Write(msg); err != nil {
+	b, err := msg.Marshal()
+	if err != nil {
 		return err
 	}
 	bytes, err := encs.Marshal()
 	if err != nil {
 		return err
 	}
-	if err := buf.Write(bytes); err != nil {
-		return err
-	}
 
 	// Send message.
-	if err := c.send(buf.Bytes()); err != nil {
+	c.setEncodings(encs)
+	if err := c.send(append(b, bytes...)); err != nil {
 		return err
 	}
-
-	c.encodings = encs
+	c.sentEncodings()
 	return nil
 }
 
@@ -116,13 +144,31 @@ type FramebufferUpdateRequestMessage struct {
 	Width, Height uint16                 // width, height
 }
 
+const framebufferUpdateRequestMessageLen = 10
+
+// Verify that interfaces are honored.
+var _ Marshaler = (*FramebufferUpdateRequestMessage)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *FramebufferUpdateRequestMessage) Marshal() ([]byte, error) {
+	b := make([]byte, framebufferUpdateRequestMessageLen)
+	b[0] = uint8(m.Msg)
+	b[1] = uint8(m.Inc)
+	binary.BigEndian.PutUint16(b[2:], m.X)
+	binary.BigEndian.PutUint16(b[4:], m.Y)
+	binary.BigEndian.PutUint16(b[6:], m.Width)
+	binary.BigEndian.PutUint16(b[8:], m.Height)
+	return b, nil
+}
+
 // Requests a framebuffer update from the server. There may be an indefinite
 // time between the request and the actual framebuffer update being received.
 //
 // See RFC 6143 Section 7.5.3
 func (c *ClientConn) FramebufferUpdateRequest(inc rfbflags.RFBFlag, x, y, w, h uint16) error {
 	msg := FramebufferUpdateRequestMessage{messages.FramebufferUpdateRequest, inc, x, y, w, h}
-	return c.send(&msg)
+	c.countRequest()
+	return c.sendMessage(&msg)
 }
 
 // KeyEventMessage holds the wire format message.
@@ -133,6 +179,20 @@ type KeyEventMessage struct {
 	Key      keys.Key               // key
 }
 
+const keyEventMessageLen = 8
+
+// Verify that interfaces are honored.
+var _ Marshaler = (*KeyEventMessage)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *KeyEventMessage) Marshal() ([]byte, error) {
+	b := make([]byte, keyEventMessageLen)
+	b[0] = uint8(m.Msg)
+	b[1] = uint8(m.DownFlag)
+	binary.BigEndian.PutUint32(b[4:], uint32(m.Key))
+	return b, nil
+}
+
 const (
 	PressKey   = true
 	ReleaseKey = false
@@ -149,10 +209,14 @@ func (c *ClientConn) KeyEvent(key keys.Key, down bool) error {
 		glog.Infof("ClientConnt.%s", logging.FnNameWithArgs("%s, %t", key, down))
 	}
 
+	if err := c.checkInput(messages.KeyEvent); err != nil {
+		return err
+	}
 	msg := KeyEventMessage{messages.KeyEvent, rfbflags.BoolToRFBFlag(down), [2]byte{}, key}
-	if err := c.send(msg); err != nil {
+	if err := c.sendMessage(&msg); err != nil {
 		return err
 	}
+	c.record(MacroEvent{Kind: KeyMacroEvent, Key: key, Down: down})
 
 	settleUI()
 	return nil
@@ -165,22 +229,50 @@ type PointerEventMessage struct {
 	X, Y uint16                 // x-, y-position
 }
 
+const pointerEventMessageLen = 6
+
+// Verify that interfaces are honored.
+var _ Marshaler = (*PointerEventMessage)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *PointerEventMessage) Marshal() ([]byte, error) {
+	b := make([]byte, pointerEventMessageLen)
+	b[0] = uint8(m.Msg)
+	b[1] = m.Mask
+	binary.BigEndian.PutUint16(b[2:], m.X)
+	binary.BigEndian.PutUint16(b[4:], m.Y)
+	return b, nil
+}
+
 // PointerEvent indicates that pointer movement or a pointer button
 // press or release.
 //
 // The `button` is a bitwise mask of various Button values. When a button
 // is set, it is pressed, when it is unset, it is released.
 //
+// The position is transformed as set by SetPointerTransform.
+//
 // See RFC 6143 Section 7.5.5
 func (c *ClientConn) PointerEvent(button buttons.Button, x, y uint16) error {
 	if logging.V(logging.FnDeclLevel) {
 		glog.Info(logging.FnNameWithArgs("%s, %d, %d", button, x, y))
 	}
 
+	x, y = c.transformPointer(x, y)
+	return c.pointerEvent(button, x, y)
+}
+
+// pointerEvent sends a PointerEvent at the position (x, y) of the
+// framebuffer.
+func (c *ClientConn) pointerEvent(button buttons.Button, x, y uint16) error {
+	if err := c.checkInput(messages.PointerEvent); err != nil {
+		return err
+	}
 	msg := PointerEventMessage{messages.PointerEvent, uint8(button), x, y}
-	if err := c.send(msg); err != nil {
+	if err := c.sendMessage(&msg); err != nil {
 		return err
 	}
+	c.record(MacroEvent{Kind: PointerMacroEvent, Buttons: button, X: x, Y: y})
 
 	settleUI()
 	return nil
@@ -193,10 +285,22 @@ type ClientCutTextMessage struct {
 	Length uint32                 // length
 }
 
+const clientCutTextMessageLen = 8
+
+// Verify that interfaces are honored.
+var _ Marshaler = (*ClientCutTextMessage)(nil)
+
+// Marshal implements the Marshaler interface.
+func (m *ClientCutTextMessage) Marshal() ([]byte, error) {
+	b := make([]byte, clientCutTextMessageLen)
+	b[0] = uint8(m.Msg)
+	binary.BigEndian.PutUint32(b[4:], m.Length)
+	return b, nil
+}
+
 // ClientCutText tells the server that the client has new text in its cut buffer.
-// The text string MUST only contain Latin-1 characters. This encoding
-// is compatible with Go's native string format, but can only use up to
-// unicode.MaxLatin1 values.
+// The text string MUST only contain Latin-1 characters, i.e. up to
+// unicode.MaxLatin1, which are sent encoded as Latin-1, one byte each.
 //
 // See RFC 6143 Section 7.5.6
 func (c *ClientConn) ClientCutText(text string) error {
@@ -204,28 +308,65 @@ func (c *ClientConn) ClientCutText(text string) error {
 		glog.Info(logging.FnNameWithArgs("%s", text))
 	}
 
-	for _, char := range text {
-		if char > unicode.MaxLatin1 {
-			return NewVNCError(fmt.Sprintf("Character %q is not valid Latin-1", char))
-		}
+	if err := c.checkInput(messages.ClientCutText); err != nil {
+		return err
 	}
 
 	// Strip carriage-return (0x0d) chars.
 	// From RFC: "Ends of lines are represented by the newline character (0x0a)
 	// alone. No carriage-return (0x0d) is used."
 	text = strings.Join(strings.Split(text, "\r"), "")
+	latin1, err := latin1Bytes(text)
+	if err != nil {
+		return err
+	}
 
 	msg := ClientCutTextMessage{
 		Msg:    messages.ClientCutText,
-		Length: uint32(len(text)),
+		Length: uint32(len(latin1)),
 	}
-	if err := c.send(msg); err != nil {
+	b, err := msg.Marshal()
+	if err != nil {
 		return err
 	}
-	if err := c.send([]byte(text)); err != nil {
+	if err := c.sendBuffers(net.Buffers{b, latin1}); err != nil {
 		return err
 	}
 
 	settleUI()
 	return nil
 }
+
+// latin1Bytes returns text encoded as Latin-1. Text which is ASCII, as is
+// most cut text, isn't copied.
+func latin1Bytes(text string) ([]byte, error) {
+	ascii := true
+	for _, char := range text {
+		if char > unicode.MaxLatin1 {
+			return nil, NewVNCError(fmt.Sprintf("Character %q is not valid Latin-1", char))
+		}
+		ascii = ascii && char < utf8.RuneSelf
+	}
+	if ascii {
+		return stringBytes(text), nil
+	}
+	b := make([]byte, 0, utf8.RuneCountInString(text))
+	for _, char := range text {
+		b = append(b, byte(char))
+	}
+	return b, nil
+}
+
+// latin1String returns the Latin-1 text b as a string.
+func latin1String(b []byte) string {
+	for _, c := range b {
+		if c >= utf8.RuneSelf {
+			runes := make([]rune, len(b))
+			for i, c := range b {
+				runes[i] = rune(c)
+			}
+			return string(runes)
+		}
+	}
+	return string(b)
+}
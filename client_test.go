@@ -327,6 +327,7 @@ func TestClientCutText(t *testing.T) {
 		{"abc123", []byte("abc123"), true},
 		{"foo\r\nbar", []byte("foo\nbar"), true},
 		{"", []byte{}, true},
+		{"café", []byte{'c', 'a', 'f', 0xe9}, true},
 		{"ɹɐqooɟ", []byte{}, false},
 	}
 
@@ -375,3 +376,123 @@ func TestClientCutText(t *testing.T) {
 		}
 	}
 }
+
+func TestClientMessage_Marshal(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		msg  Marshaler
+	}{
+		{"SetPixelFormat", &SetPixelFormatMessage{Msg: messages.SetPixelFormat, PF: NewPixelFormat(16)}},
+		{"SetEncodings", &SetEncodingsMessage{Msg: messages.SetEncodings, NumEncs: 258}},
+		{"FramebufferUpdateRequest", &FramebufferUpdateRequestMessage{messages.FramebufferUpdateRequest, rfbflags.RFBTrue, 1, 2, 300, 400}},
+		{"KeyEvent", &KeyEventMessage{messages.KeyEvent, rfbflags.RFBTrue, [2]byte{}, keys.Return}},
+		{"PointerEvent", &PointerEventMessage{messages.PointerEvent, 5, 600, 700}},
+		{"ClientCutText", &ClientCutTextMessage{Msg: messages.ClientCutText, Length: 70000}},
+	} {
+		got, err := tt.msg.Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// The hand-rolled codecs must match the reflection-based encoding.
+		buf := NewBuffer(nil)
+		if err := buf.Write(tt.msg); err != nil {
+			t.Fatalf("%s: %v", tt.desc, err)
+		}
+		if want := buf.Bytes(); !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect result; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestSetEncodings_Renegotiation(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	if err := conn.SetEncodings(Encodings{&CopyRectEncoding{}, &RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	before := conn.encodable()
+	if err := conn.SetEncodings(Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		fn   EncodableFunc
+		enc  encodings.Encoding
+		ok   bool
+	}{
+		{"current", conn.Encodable, encodings.DesktopSizePseudo, true},
+		{"retired", conn.Encodable, encodings.CopyRect, true},
+		{"unsupported", conn.Encodable, encodings.Hextile, false},
+		{"snapshot before", before, encodings.CopyRect, true},
+		{"snapshot before, added later", before, encodings.DesktopSizePseudo, false},
+	} {
+		if _, ok := tt.fn(tt.enc); ok != tt.ok {
+			t.Errorf("%s: %v encodable = %v, want %v", tt.desc, tt.enc, ok, tt.ok)
+		}
+	}
+	if got, want := len(conn.Encodings()), 2; got != want {
+		t.Errorf("len(Encodings()) = %d, want %d", got, want)
+	}
+
+	// A CopyRect update in flight is decoded.
+	data := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 1, 0, 1, 0, 1}
+	if _, err := conn.UnmarshalServerMessage(data); err != nil {
+		t.Errorf("unexpected error decoding retired encoding: %s", err)
+	}
+
+	// Re-adding an encoding un-retires it.
+	if err := conn.SetEncodings(Encodings{&CopyRectEncoding{}, &RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(conn.retired), 1; got != want {
+		t.Errorf("len(retired) = %d, want %d (%v)", got, want, conn.retired)
+	}
+}
+
+func TestSetEncodings_PruneRetired(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	// readUpdate reads an empty FramebufferUpdate.
+	readUpdate := func() {
+		mockConn.Reset()
+		if err := conn.send([]byte{0, 0, 0}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := conn.SetEncodings(Encodings{&CopyRectEncoding{}, &RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetEncodings(Encodings{&RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 10, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		desc    string
+		retired int
+	}{
+		{"answers request before SetEncodings", 1},
+		{"answers request after SetEncodings", 0},
+		{"unsolicited", 0},
+	} {
+		readUpdate()
+		if got := len(conn.retired); got != tt.retired {
+			t.Errorf("%s: len(retired) = %d, want %d", tt.desc, got, tt.retired)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+// Helpers for the clipboard, i.e. cut text.
+
+package vnc
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+// DefaultMaxClientCutText is the default limit on the length of the cut text
+// sent with SendCutText. It is the default MaxCutText of TigerVNC servers,
+// which drop longer cut text; other servers have similar limits.
+const DefaultMaxClientCutText = 256 << 10 // 256 KiB
+
+// CutTextAction is the action taken with cut text exceeding the limit.
+type CutTextAction int
+
+// The actions taken with cut text exceeding the limit.
+const (
+	// CutTextReject sends nothing, and returns an ErrLimitExceeded error.
+	CutTextReject CutTextAction = iota
+	// CutTextTruncate sends the longest prefix within the limit.
+	CutTextTruncate
+	// CutTextSplit sends the text in successive chunks within the limit. As
+	// each replaces the clipboard of the server, CutTextOptions.ChunkFunc
+	// should consume each chunk (e.g. by pasting it) before the next is sent.
+	CutTextSplit
+)
+
+// CutTextPolicy returns the action taken with cut text of length n, exceeding
+// the limit.
+type CutTextPolicy func(n, limit int) CutTextAction
+
+// CutTextOptions configure SendCutText.
+type CutTextOptions struct {
+	// Limit is the maximum length of each ClientCutText message. If zero,
+	// DefaultMaxClientCutText is used.
+	Limit int
+
+	// Policy decides the action taken with cut text exceeding the limit. If
+	// nil, the cut text is rejected.
+	Policy CutTextPolicy
+
+	// ChunkFunc, if set, is called after each chunk i of n is sent with
+	// CutTextSplit. An error stops the remaining chunks being sent.
+	ChunkFunc func(i, n int) error
+}
+
+func (o *CutTextOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultMaxClientCutText
+	}
+	return o.Limit
+}
+
+// SendCutText sends the text with ClientCutText, within the limit of the
+// options, and returns the length of the text delivered. Lengths are those of
+// the Latin-1 encoding of the text sent, i.e. its number of characters.
+// Carriage-returns are stripped, as by ClientCutText, before the length is
+// checked.
+func (c *ClientConn) SendCutText(text string, opts CutTextOptions) (int, error) {
+	text = strings.Join(strings.Split(text, "\r"), "")
+	n := utf8.RuneCountInString(text)
+	limit := opts.limit()
+	if n <= limit {
+		if err := c.ClientCutText(text); err != nil {
+			return 0, err
+		}
+		return n, nil
+	}
+
+	action := CutTextReject
+	if opts.Policy != nil {
+		action = opts.Policy(n, limit)
+	}
+	switch action {
+	case CutTextTruncate:
+		chunk := cutTextChunk(text, limit)
+		if err := c.ClientCutText(chunk); err != nil {
+			return 0, err
+		}
+		return utf8.RuneCountInString(chunk), nil
+	case CutTextSplit:
+		var chunks []string
+		for rest := text; rest != ""; {
+			chunk := cutTextChunk(rest, limit)
+			chunks = append(chunks, chunk)
+			rest = rest[len(chunk):]
+		}
+		sent := 0
+		for i, chunk := range chunks {
+			if err := c.ClientCutText(chunk); err != nil {
+				return sent, err
+			}
+			sent += utf8.RuneCountInString(chunk)
+			if fn := opts.ChunkFunc; fn != nil {
+				if err := fn(i, len(chunks)); err != nil {
+					return sent, err
+				}
+			}
+		}
+		return sent, nil
+	}
+	return 0, wrapErrorf(ErrLimitExceeded, "cut text length %d exceeds limit of %d", n, limit)
+}
+
+// cutTextChunk returns the longest prefix of text of at most limit
+// characters, the length of its Latin-1 encoding. At least one character is
+// returned.
+func cutTextChunk(text string, limit int) string {
+	i := 0
+	for n := 0; i < len(text) && (n < limit || n == 0); n++ {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+	}
+	return text[:i]
+}
+
+//-----------------------------------------------------------------------------
+// Clipboard synchronization
+
+// LocalClipboard is the clipboard of the local system.
+type LocalClipboard interface {
+	// ReadClipboard returns the text of the clipboard.
+	ReadClipboard() (string, error)
+	// WriteClipboard replaces the text of the clipboard.
+	WriteClipboard(text string) error
+}
+
+// MemoryClipboard is a LocalClipboard held in memory, e.g. for tests, or to
+// share the clipboard of the server within the process.
+type MemoryClipboard struct {
+	mu   sync.Mutex
+	text string
+}
+
+// Verify that interfaces are honored.
+var _ LocalClipboard = (*MemoryClipboard)(nil)
+
+// ReadClipboard implements the LocalClipboard interface.
+func (m *MemoryClipboard) ReadClipboard() (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.text, nil
+}
+
+// WriteClipboard implements the LocalClipboard interface.
+func (m *MemoryClipboard) WriteClipboard(text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.text = text
+	return nil
+}
+
+// CommandClipboard is a LocalClipboard accessed by running commands, e.g.
+// xclip on X11, or pbpaste and pbcopy on macOS.
+type CommandClipboard struct {
+	Read  []string // The command writing the text of the clipboard to stdout.
+	Write []string // The command replacing the clipboard with stdin.
+}
+
+// Verify that interfaces are honored.
+var _ LocalClipboard = (*CommandClipboard)(nil)
+
+// XclipClipboard returns the X11 clipboard, accessed with xclip.
+func XclipClipboard() *CommandClipboard {
+	return &CommandClipboard{
+		Read:  []string{"xclip", "-selection", "clipboard", "-out"},
+		Write: []string{"xclip", "-selection", "clipboard", "-in"},
+	}
+}
+
+// PasteboardClipboard returns the macOS clipboard, accessed with pbpaste and
+// pbcopy.
+func PasteboardClipboard() *CommandClipboard {
+	return &CommandClipboard{
+		Read:  []string{"pbpaste"},
+		Write: []string{"pbcopy"},
+	}
+}
+
+// ReadClipboard implements the LocalClipboard interface.
+func (cc *CommandClipboard) ReadClipboard() (string, error) {
+	out, err := exec.Command(cc.Read[0], cc.Read[1:]...).Output()
+	if err != nil {
+		return "", wrapErrorf(err, "reading clipboard with %s: %s", cc.Read[0], err)
+	}
+	return string(out), nil
+}
+
+// WriteClipboard implements the LocalClipboard interface.
+func (cc *CommandClipboard) WriteClipboard(text string) error {
+	cmd := exec.Command(cc.Write[0], cc.Write[1:]...)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return wrapErrorf(err, "writing clipboard with %s: %s: %s", cc.Write[0], err, stderr.String())
+	}
+	return nil
+}
+
+// The defaults of ClipboardSync.
+const (
+	DefaultClipboardPollInterval = 500 * time.Millisecond
+	DefaultClipboardMinInterval  = time.Second
+)
+
+// ClipboardSync mirrors the cut text of the server into a local clipboard,
+// and changes to the local clipboard to the server.
+//
+// The text last synchronized in either direction is remembered, so text is
+// never echoed back to where it came from, e.g. when a server echoes the cut
+// text of the client. Characters which aren't Latin-1, the only characters
+// of cut text, are sent as '?'.
+//
+// The ClipboardSync must be given every server message, either with Handle
+// or by Listen, and Run polls the local clipboard.
+type ClipboardSync struct {
+	c     *ClientConn
+	local LocalClipboard
+
+	// PollInterval is the interval at which the local clipboard is polled.
+	// If zero, DefaultClipboardPollInterval is used.
+	PollInterval time.Duration
+
+	// MinInterval is the minimum interval between sending local changes to
+	// the server, which limits the rate of cut text sent while the local
+	// clipboard changes rapidly. Changes are delayed, not dropped. If zero,
+	// DefaultClipboardMinInterval is used.
+	MinInterval time.Duration
+
+	// Options configure the sending of cut text. See SendCutText.
+	Options CutTextOptions
+
+	mu       sync.Mutex
+	last     string    // The text of the local clipboard last synchronized.
+	sent     string    // The text last sent to the server, as sent.
+	lastSent time.Time // When text was last sent to the server.
+}
+
+// NewClipboardSync returns a ClipboardSync between the connection and the
+// local clipboard.
+func NewClipboardSync(c *ClientConn, local LocalClipboard) *ClipboardSync {
+	return &ClipboardSync{c: c, local: local}
+}
+
+// Handle writes the text of a ServerCutText message to the local clipboard.
+// All other messages are ignored.
+func (s *ClipboardSync) Handle(msg ServerMessage) error {
+	m, ok := msg.(*ServerCutText)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.Text == s.last || m.Text == s.sent {
+		return nil
+	}
+	if err := s.local.WriteClipboard(m.Text); err != nil {
+		return err
+	}
+	s.last = m.Text
+	return nil
+}
+
+// Listen handles the messages received on msgs, until the context is done or
+// the channel is closed.
+func (s *ClipboardSync) Listen(ctx context.Context, msgs <-chan ServerMessage) error {
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if err := s.Handle(msg); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Run polls the local clipboard, and sends its changes to the server, until
+// the context is done, or the local clipboard or connection fails.
+func (s *ClipboardSync) Run(ctx context.Context) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = DefaultClipboardPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.poll(time.Now()); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// poll sends the text of the local clipboard to the server, if it changed,
+// and the rate limit allows it.
+func (s *ClipboardSync) poll(now time.Time) error {
+	text, err := s.local.ReadClipboard()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	minInterval := s.MinInterval
+	if minInterval <= 0 {
+		minInterval = DefaultClipboardMinInterval
+	}
+	if text == s.last || now.Sub(s.lastSent) < minInterval {
+		return nil
+	}
+	sent := strings.Join(strings.Split(toLatin1(text), "\r"), "")
+	if _, err := s.c.SendCutText(sent, s.Options); err != nil {
+		return err
+	}
+	s.last, s.sent, s.lastSent = text, sent, now
+	return nil
+}
+
+// toLatin1 replaces the characters of text which aren't Latin-1 with '?'.
+func toLatin1(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r > unicode.MaxLatin1 {
+			return '?'
+		}
+		return r
+	}, text)
+}
+
+//-----------------------------------------------------------------------------
+// Clipboard history
+
+// ClipboardEntry is cut text received from the server.
+type ClipboardEntry struct {
+	Time time.Time // When the cut text was received.
+	Text string
+}
+
+// clipboardHistory is a ring of the latest cut text received.
+type clipboardHistory struct {
+	mu      sync.Mutex
+	entries []ClipboardEntry // The ring, of length the maximum retained.
+	next    int              // The index of the next entry in the ring.
+	n       int              // The number of entries retained.
+}
+
+// setMax sets the maximum number of entries retained, keeping the latest.
+func (h *clipboardHistory) setMax(max int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	latest := h.latest(func(ClipboardEntry) bool { return true })
+	if max < 0 {
+		max = 0
+	}
+	if len(latest) > max {
+		latest = latest[:max]
+	}
+	h.entries, h.next, h.n = make([]ClipboardEntry, max), 0, 0
+	for i := len(latest) - 1; i >= 0; i-- {
+		h.addLocked(latest[i])
+	}
+}
+
+// add retains the entry, discarding the oldest if the ring is full.
+func (h *clipboardHistory) add(e ClipboardEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.addLocked(e)
+}
+
+func (h *clipboardHistory) addLocked(e ClipboardEntry) {
+	if len(h.entries) == 0 {
+		return
+	}
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.n < len(h.entries) {
+		h.n++
+	}
+}
+
+// latest returns the entries retained, newest first, while want returns true.
+func (h *clipboardHistory) latest(want func(ClipboardEntry) bool) []ClipboardEntry {
+	var entries []ClipboardEntry
+	for i := 1; i <= h.n; i++ {
+		e := h.entries[(h.next-i+len(h.entries))%len(h.entries)]
+		if !want(e) {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// SetClipboardHistory retains the last n cut texts received from the server,
+// overriding ClientConfig.ClipboardHistory. Zero disables the history, and
+// discards the cut text retained.
+func (c *ClientConn) SetClipboardHistory(n int) {
+	c.clipHistory.setMax(n)
+}
+
+// ClipboardHistory returns the cut text retained, newest first.
+func (c *ClientConn) ClipboardHistory() []ClipboardEntry {
+	c.clipHistory.mu.Lock()
+	defer c.clipHistory.mu.Unlock()
+	return c.clipHistory.latest(func(ClipboardEntry) bool { return true })
+}
+
+// ClipboardSince returns the cut text retained which was received after t,
+// newest first, e.g. to check what was copied by a step of automation.
+func (c *ClientConn) ClipboardSince(t time.Time) []ClipboardEntry {
+	c.clipHistory.mu.Lock()
+	defer c.clipHistory.mu.Unlock()
+	return c.clipHistory.latest(func(e ClipboardEntry) bool { return e.Time.After(t) })
+}
+
+// recordCutText adds cut text received from the server to the history.
+func (c *ClientConn) recordCutText(text string) {
+	if c.eventConn != nil {
+		c = c.eventConn
+	}
+	c.clipHistory.add(ClipboardEntry{Time: time.Now(), Text: text})
+}
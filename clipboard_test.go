@@ -0,0 +1,266 @@
+package vnc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// receiveCutTexts returns the texts of the ClientCutText messages sent,
+// decoded from Latin-1.
+func receiveCutTexts(t *testing.T, conn *ClientConn, mockConn *MockConn) []string {
+	var texts []string
+	for mockConn.b.Len() > 0 {
+		var msg ClientCutTextMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		text := make([]byte, msg.Length)
+		if err := conn.receive(&text); err != nil {
+			t.Fatal(err)
+		}
+		runes := make([]rune, len(text))
+		for i, b := range text {
+			runes[i] = rune(b)
+		}
+		texts = append(texts, string(runes))
+	}
+	return texts
+}
+
+func TestClientConn_SendCutText(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	policy := func(action CutTextAction) CutTextPolicy {
+		return func(int, int) CutTextAction { return action }
+	}
+	for _, tt := range []struct {
+		desc   string
+		text   string
+		policy CutTextPolicy
+		sent   int
+		texts  []string
+		err    error
+	}{
+		{"within limit", "abc\r\n", nil, 4, []string{"abc\n"}, nil},
+		{"reject", "abcdef", nil, 0, nil, ErrLimitExceeded},
+		{"truncate", "abcdef", policy(CutTextTruncate), 4, []string{"abcd"}, nil},
+		{"split", "abcdefghij", policy(CutTextSplit), 10, []string{"abcd", "efgh", "ij"}, nil},
+		{"latin-1 length", "ééé\r\n", nil, 4, []string{"ééé\n"}, nil},
+		{"split between characters", "abcdé", policy(CutTextSplit), 5, []string{"abcd", "é"}, nil},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		var chunks int
+		sent, err := conn.SendCutText(tt.text, CutTextOptions{
+			Limit:     4,
+			Policy:    tt.policy,
+			ChunkFunc: func(i, n int) error { chunks++; return nil },
+		})
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, want %v", tt.desc, err, tt.err)
+		}
+		if got, want := sent, tt.sent; got != want {
+			t.Errorf("%s: sent = %d, want = %d", tt.desc, got, want)
+		}
+		if got, want := receiveCutTexts(t, conn, mockConn), tt.texts; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: texts = %q, want = %q", tt.desc, got, want)
+		}
+		if tt.policy != nil && len(tt.texts) > 1 && chunks != len(tt.texts) {
+			t.Errorf("%s: ChunkFunc called %d times, want %d", tt.desc, chunks, len(tt.texts))
+		}
+	}
+}
+
+func TestClientConn_SendCutText_ChunkFuncError(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	stop := errors.New("stop")
+	sent, err := conn.SendCutText("abcdefghij", CutTextOptions{
+		Limit:     4,
+		Policy:    func(int, int) CutTextAction { return CutTextSplit },
+		ChunkFunc: func(i, n int) error { return stop },
+	})
+	if err != stop {
+		t.Errorf("error = %v, want %v", err, stop)
+	}
+	if got, want := sent, 4; got != want {
+		t.Errorf("sent = %d, want = %d", got, want)
+	}
+}
+
+func TestClipboardSync(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	local := &MemoryClipboard{}
+	s := NewClipboardSync(conn, local)
+	now := time.Now()
+
+	// Server to local.
+	if err := s.Handle(&ServerCutText{Text: "server"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := local.ReadClipboard(); got != "server" {
+		t.Errorf("local clipboard = %q, want %q", got, "server")
+	}
+	// The text from the server isn't echoed back.
+	if err := s.poll(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveCutTexts(t, conn, mockConn); got != nil {
+		t.Errorf("sent %q, want nothing", got)
+	}
+
+	// Local to server.
+	local.WriteClipboard("local ✓")
+	if err := s.poll(now); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := receiveCutTexts(t, conn, mockConn), []string{"local ?"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+	// The echo of the server isn't written back.
+	local.WriteClipboard("changed")
+	if err := s.Handle(&ServerCutText{Text: "local ?"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := local.ReadClipboard(); got != "changed" {
+		t.Errorf("local clipboard = %q, want %q", got, "changed")
+	}
+
+	// Rate limiting delays the change.
+	if err := s.poll(now.Add(DefaultClipboardMinInterval / 2)); err != nil {
+		t.Fatal(err)
+	}
+	if got := receiveCutTexts(t, conn, mockConn); got != nil {
+		t.Errorf("sent %q within the minimum interval, want nothing", got)
+	}
+	if err := s.poll(now.Add(DefaultClipboardMinInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := receiveCutTexts(t, conn, mockConn), []string{"changed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sent %q, want %q", got, want)
+	}
+}
+
+// writeCounter counts the writes to the clipboard.
+type writeCounter struct {
+	MemoryClipboard
+	writes int
+}
+
+func (c *writeCounter) WriteClipboard(text string) error {
+	c.writes++
+	return c.MemoryClipboard.WriteClipboard(text)
+}
+
+func TestClipboardSync_Latin1Echo(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	local := &writeCounter{}
+	s := NewClipboardSync(conn, local)
+
+	local.MemoryClipboard.WriteClipboard("café")
+	if err := s.poll(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// The server echoes the bytes of the ClientCutText message back, sans
+	// message-type.
+	sent := append([]byte(nil), mockConn.b.Bytes()...)
+	if want := len("caf") + 1 + 8; len(sent) != want {
+		t.Fatalf("sent %d bytes, want %d", len(sent), want)
+	}
+	mockConn.Reset()
+	if err := conn.send(sent[1:]); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := (&ServerCutText{}).Read(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := msg.(*ServerCutText).Text, "café"; got != want {
+		t.Errorf("received %q, want %q", got, want)
+	}
+	if err := s.Handle(msg); err != nil {
+		t.Fatal(err)
+	}
+	if local.writes != 0 {
+		t.Errorf("local clipboard written %d times, want 0", local.writes)
+	}
+}
+
+// receiveServerCutText makes the connection receive cut text from the server.
+func receiveServerCutText(t *testing.T, conn *ClientConn, text string) {
+	if err := conn.send([]byte{0, 0, 0, 0, 0, 0, byte(len(text))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// clipboardTexts returns the texts of the entries.
+func clipboardTexts(entries []ClipboardEntry) []string {
+	var texts []string
+	for _, e := range entries {
+		texts = append(texts, e.Text)
+	}
+	return texts
+}
+
+func TestClientConn_ClipboardHistory(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{ClipboardHistory: 3})
+	for _, text := range []string{"a", "b"} {
+		receiveServerCutText(t, conn, text)
+	}
+	mid := time.Now()
+	for _, text := range []string{"c", "d"} {
+		receiveServerCutText(t, conn, text)
+	}
+
+	if got, want := clipboardTexts(conn.ClipboardHistory()), []string{"d", "c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClipboardHistory() = %q, want %q", got, want)
+	}
+	if got, want := clipboardTexts(conn.ClipboardSince(mid)), []string{"d", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClipboardSince() = %q, want %q", got, want)
+	}
+	if e := conn.ClipboardHistory()[0]; e.Time.Before(mid) {
+		t.Errorf("incorrect time; got = %v, want after %v", e.Time, mid)
+	}
+
+	// Shrinking the history keeps the latest.
+	conn.SetClipboardHistory(2)
+	if got, want := clipboardTexts(conn.ClipboardHistory()), []string{"d", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClipboardHistory() = %q, want %q", got, want)
+	}
+	receiveServerCutText(t, conn, "e")
+	if got, want := clipboardTexts(conn.ClipboardHistory()), []string{"e", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClipboardHistory() = %q, want %q", got, want)
+	}
+
+	// Disabling the history discards it.
+	conn.SetClipboardHistory(0)
+	receiveServerCutText(t, conn, "f")
+	if got := conn.ClipboardHistory(); got != nil {
+		t.Errorf("ClipboardHistory() = %q, want nothing", clipboardTexts(got))
+	}
+}
+
+func TestClientConn_ClipboardHistory_Unmarshal(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{
+		ClipboardHistory: 1,
+		ServerMessages:   []ServerMessage{&ServerCutText{}},
+	})
+	if _, err := conn.UnmarshalServerMessage([]byte{3, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := clipboardTexts(conn.ClipboardHistory()), []string{"hi"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ClipboardHistory() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,114 @@
+// Package cmdutil provides functionality shared by the commands.
+package cmdutil
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/npipe"
+	"golang.org/x/net/context"
+)
+
+// PasswordEnv is the environment variable holding the VNC password.
+const PasswordEnv = "VNC_PASSWORD"
+
+// ReadPassword returns the VNC password from a file or, if path is empty, the
+// environment.
+func ReadPassword(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(PasswordEnv), nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// WritePNG writes the image to path as a PNG image.
+func WritePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// NewClientConfig returns a ClientConfig which offers VNC authentication
+// only if a password is given.
+func NewClientConfig(password string) *vnc.ClientConfig {
+	cfg := vnc.NewClientConfig(password)
+	if password == "" {
+		cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	}
+	cfg.ServerMessageCh = make(chan vnc.ServerMessage, 1)
+	return cfg
+}
+
+// Dial connects to addr, which is either a TCP host:port, or the path of a
+// Windows named pipe, e.g. `\\.\pipe\vnc`.
+func Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if npipe.IsPath(addr) {
+		return npipe.Dial(ctx, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// Listen listens on addr, which is either a TCP host:port, or the path of a
+// Windows named pipe.
+func Listen(addr string) (net.Listener, error) {
+	if npipe.IsPath(addr) {
+		return npipe.Listen(addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// IsConnectionFile returns whether addr is the path of the .vnc file of a
+// saved connection, rather than an address.
+func IsConnectionFile(addr string) bool {
+	return strings.EqualFold(filepath.Ext(addr), ".vnc")
+}
+
+// ReadConnectionFile reads the .vnc file of a saved connection.
+func ReadConnectionFile(path string) (*vnc.ConnectionFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return vnc.ReadConnectionFile(f)
+}
+
+// Connect dials the server at addr, wrapping the network connection with
+// wrap (if non-nil), and negotiates a VNC session. See Dial. If addr is the
+// path of a .vnc file, the saved connection is dialed, and applied to cfg
+// (see ConnectionFile.ApplyTo).
+func Connect(ctx context.Context, addr string, cfg *vnc.ClientConfig, wrap func(net.Conn) net.Conn) (*vnc.ClientConn, error) {
+	if IsConnectionFile(addr) {
+		f, err := ReadConnectionFile(addr)
+		if err != nil {
+			return nil, err
+		}
+		f.ApplyTo(cfg)
+		addr = f.Addr
+	}
+	nc, err := Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if wrap != nil {
+		nc = wrap(nc)
+	}
+	return vnc.Connect(ctx, nc, cfg)
+}
@@ -0,0 +1,86 @@
+package cmdutil
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPassword(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cmdutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "passwd")
+	if err := ioutil.WriteFile(path, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ReadPassword(path); err != nil || got != "secret" {
+		t.Errorf("ReadPassword() = %q, %v; want = %q", got, err, "secret")
+	}
+	os.Setenv(PasswordEnv, "env")
+	defer os.Unsetenv(PasswordEnv)
+	if got, err := ReadPassword(""); err != nil || got != "env" {
+		t.Errorf("ReadPassword() = %q, %v; want = %q", got, err, "env")
+	}
+	if _, err := ReadPassword(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestWritePNG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cmdutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "img.png")
+	if err := WritePNG(path, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := img.Bounds(), image.Rect(0, 0, 4, 3); got != want {
+		t.Errorf("bounds = %v, want %v", got, want)
+	}
+	if err := WritePNG(filepath.Join(dir, "missing", "img.png"), img); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNewClientConfig(t *testing.T) {
+	if got, want := len(NewClientConfig("").Auth), 1; got != want {
+		t.Errorf("without password, len(Auth) = %d, want = %d", got, want)
+	}
+	if got, want := len(NewClientConfig("secret").Auth), 2; got != want {
+		t.Errorf("with password, len(Auth) = %d, want = %d", got, want)
+	}
+}
+
+func TestIsConnectionFile(t *testing.T) {
+	for _, tt := range []struct {
+		addr string
+		want bool
+	}{
+		{"localhost:5900", false},
+		{"home.vnc", true},
+		{"/tmp/HOME.VNC", true},
+		{"/tmp/vnc.sock", false},
+	} {
+		if got := IsConnectionFile(tt.addr); got != tt.want {
+			t.Errorf("IsConnectionFile(%q) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+/*
+The vncbench command benchmarks a VNC server. For each encoding in turn, it
+asks the server to prefer that encoding, requests framebuffer updates
+continuously, and reports the update and rectangle rates, the bandwidth used,
+and the time spent decoding each encoding.
+
+Usage:
+
+	vncbench [flags] host:port
+
+Decode time is the time spent reading rectangles, less the time spent waiting
+for data from the network.
+
+The password is taken from the -password_file flag, or else the VNC_PASSWORD
+environment variable.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+var (
+	encodingList = flag.String("encodings", "raw,copyrect", "Comma separated list of encodings to benchmark.")
+	duration     = flag.Duration("duration", 10*time.Second, "Duration of the benchmark of each encoding.")
+	incremental  = flag.Bool("incremental", false, "Request incremental updates, rather than the full framebuffer.")
+	passwordFile = flag.String("password_file", "", "File containing the VNC password.")
+	timeout      = flag.Duration("timeout", 10*time.Second, "Timeout for connecting to the server.")
+)
+
+// supported holds the encodings which can be benchmarked.
+var supported = []vnc.Encoding{
+	&vnc.RawEncoding{},
+	&vnc.CopyRectEncoding{},
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host:port\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	encs, err := parseEncodings(*encodingList)
+	if err != nil {
+		log.Fatal(err)
+	}
+	password, err := cmdutil.ReadPassword(*passwordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	results, err := bench(flag.Arg(0), password, encs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report(os.Stdout, results)
+}
+
+// parseEncodings parses a comma separated list of encoding names, ignoring
+// case.
+func parseEncodings(list string) ([]vnc.Encoding, error) {
+	var encs []vnc.Encoding
+	for _, name := range strings.Split(list, ",") {
+		enc, ok := lookupEncoding(strings.TrimSpace(name))
+		if !ok {
+			var names []string
+			for _, e := range supported {
+				names = append(names, strings.ToLower(e.Type().String()))
+			}
+			return nil, fmt.Errorf("unsupported encoding %q; supported encodings are %s", name, strings.Join(names, ", "))
+		}
+		encs = append(encs, enc)
+	}
+	return encs, nil
+}
+
+func lookupEncoding(name string) (vnc.Encoding, bool) {
+	for _, e := range supported {
+		if strings.EqualFold(e.Type().String(), name) {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// bench connects to the server at addr, and benchmarks each encoding.
+func bench(addr, password string, encs []vnc.Encoding) ([]*result, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	var m *meter
+	t := &tracker{}
+	cfg := cmdutil.NewClientConfig(password)
+	cfg.RectFunc = t.rect
+	vc, err := cmdutil.Connect(ctx, addr, cfg, func(nc net.Conn) net.Conn {
+		m = &meter{Conn: nc}
+		return m
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer vc.Close()
+	t.m = m
+	log.Printf("connected to %q (%dx%d)", vc.DesktopName(), vc.FramebufferWidth(), vc.FramebufferHeight())
+
+	done := make(chan error, 1)
+	go func() { done <- vc.ListenAndHandle() }()
+
+	var results []*result
+	for _, enc := range encs {
+		res, err := run(vc, enc, t, cfg.ServerMessageCh, done)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// run benchmarks a single encoding.
+func run(vc *vnc.ClientConn, enc vnc.Encoding, t *tracker, msgs <-chan vnc.ServerMessage, done <-chan error) (*result, error) {
+	encs := vnc.Encodings{enc}
+	if enc.Type() != encodings.Raw {
+		encs = append(encs, &vnc.RawEncoding{}) // Servers may always fall back to Raw.
+	}
+	if err := vc.SetEncodings(encs); err != nil {
+		return nil, err
+	}
+
+	res := &result{enc: enc.Type()}
+	t.reset(res)
+	bytes := t.m.received()
+	start := time.Now()
+	end := start.Add(*duration)
+	// The first request is always for the full framebuffer, so that
+	// incremental requests have something to compare with.
+	inc := rfbflags.RFBFalse
+	for {
+		t.mark()
+		if err := vc.FramebufferUpdateRequest(inc, 0, 0, vc.FramebufferWidth(), vc.FramebufferHeight()); err != nil {
+			return nil, err
+		}
+		if err := waitUpdate(msgs, done); err != nil {
+			return nil, err
+		}
+		res.updates++
+		if time.Now().After(end) {
+			break
+		}
+		inc = rfbflags.BoolToRFBFlag(*incremental)
+	}
+	res.elapsed = time.Since(start)
+	res.bytes = t.m.received() - bytes
+	return res, nil
+}
+
+// waitUpdate waits for the next FramebufferUpdate.
+func waitUpdate(msgs <-chan vnc.ServerMessage, done <-chan error) error {
+	for {
+		select {
+		case msg := <-msgs:
+			if _, ok := msg.(*vnc.FramebufferUpdate); ok {
+				return nil
+			}
+		case err := <-done:
+			if err == nil {
+				err = vnc.ErrClosed
+			}
+			return fmt.Errorf("connection ended: %w", err)
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+
+// meter counts the bytes read from a connection, and the time spent waiting
+// for them.
+type meter struct {
+	net.Conn
+	mu      sync.Mutex
+	bytes   int64
+	blocked time.Duration
+}
+
+func (m *meter) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := m.Conn.Read(b)
+	m.mu.Lock()
+	m.bytes += int64(n)
+	m.blocked += time.Since(start)
+	m.mu.Unlock()
+	return n, err
+}
+
+// received returns the number of bytes read.
+func (m *meter) received() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes
+}
+
+// waited returns the time spent waiting in Read.
+func (m *meter) waited() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.blocked
+}
+
+// result holds the results of benchmarking an encoding.
+type result struct {
+	enc     encodings.Encoding // The preferred encoding.
+	updates int
+	bytes   int64
+	elapsed time.Duration
+	rects   map[encodings.Encoding]*rectStats // By the encoding actually used.
+}
+
+// rectStats holds statistics for the rectangles of a single encoding.
+type rectStats struct {
+	rects  int
+	pixels int64
+	decode time.Duration
+}
+
+// tracker measures the decode time of rectangles, as they are read.
+type tracker struct {
+	m *meter
+
+	mu         sync.Mutex
+	res        *result
+	last       time.Time     // When the last rectangle was read.
+	lastWaited time.Duration // The time waited for data when it was read.
+}
+
+// reset starts tracking rectangles into res.
+func (t *tracker) reset(res *result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res.rects = map[encodings.Encoding]*rectStats{}
+	t.res = res
+}
+
+// mark marks the start of reading of the next rectangle.
+func (t *tracker) mark() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.last, t.lastWaited = time.Now(), t.m.waited()
+}
+
+// rect implements vnc.RectFunc.
+func (t *tracker) rect(rect *vnc.Rectangle, i, n int) error {
+	now, waited := time.Now(), t.m.waited()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	decode := now.Sub(t.last) - (waited - t.lastWaited)
+	t.last, t.lastWaited = now, waited
+	if t.res == nil || rect.Enc == nil {
+		return nil
+	}
+	enc := rect.Enc.Type()
+	st, ok := t.res.rects[enc]
+	if !ok {
+		st = &rectStats{}
+		t.res.rects[enc] = st
+	}
+	st.rects++
+	if enc >= 0 {
+		st.pixels += int64(rect.Width) * int64(rect.Height)
+	}
+	if decode > 0 {
+		st.decode += decode
+	}
+	return nil
+}
+
+// report writes the results as a table.
+func report(w io.Writer, results []*result) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "encoding\tupdates/s\trects/s\tMpixels/s\tMB/s\tdecode\tdecode/rect")
+	for _, res := range results {
+		secs := res.elapsed.Seconds()
+		if secs <= 0 {
+			continue
+		}
+		var total rectStats
+		for _, st := range res.rects {
+			total.rects += st.rects
+			total.pixels += st.pixels
+			total.decode += st.decode
+		}
+		fmt.Fprintf(tw, "%v\t%.1f\t%.1f\t%.2f\t%.2f\t%v\t%v\n", res.enc,
+			float64(res.updates)/secs, float64(total.rects)/secs,
+			float64(total.pixels)/secs/1e6, float64(res.bytes)/secs/1e6,
+			round(total.decode), round(perRect(total)))
+
+		// Break down the rectangles by the encoding actually used.
+		var encs []int
+		for enc := range res.rects {
+			encs = append(encs, int(enc))
+		}
+		sort.Ints(encs)
+		for _, enc := range encs {
+			st := res.rects[encodings.Encoding(enc)]
+			fmt.Fprintf(tw, "  %v\t\t%.1f\t%.2f\t\t%v\t%v\n", encodings.Encoding(enc),
+				float64(st.rects)/secs, float64(st.pixels)/secs/1e6,
+				round(st.decode), round(perRect(*st)))
+		}
+	}
+	tw.Flush()
+}
+
+// perRect returns the mean decode time of the rectangles.
+func perRect(st rectStats) time.Duration {
+	if st.rects == 0 {
+		return 0
+	}
+	return st.decode / time.Duration(st.rects)
+}
+
+// round rounds the duration for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/encodings"
+)
+
+func TestParseEncodings(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		list string
+		encs []encodings.Encoding
+		ok   bool
+	}{
+		{"single", "raw", []encodings.Encoding{encodings.Raw}, true},
+		{"multiple", "CopyRect, raw", []encodings.Encoding{encodings.CopyRect, encodings.Raw}, true},
+		{"unsupported", "raw,zrle", nil, false},
+	} {
+		encs, err := parseEncodings(tt.list)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.desc, err, tt.ok)
+			continue
+		}
+		if got, want := len(encs), len(tt.encs); got != want {
+			t.Errorf("%s: got %d encodings, want %d", tt.desc, got, want)
+			continue
+		}
+		for i, enc := range encs {
+			if got, want := enc.Type(), tt.encs[i]; got != want {
+				t.Errorf("%s: encoding %d = %v, want %v", tt.desc, i, got, want)
+			}
+		}
+	}
+}
+
+func TestMeter(t *testing.T) {
+	server, client := net.Pipe()
+	m := &meter{Conn: client}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		server.Write([]byte("hello"))
+		server.Close()
+	}()
+	b, err := ioutil.ReadAll(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.received(), int64(len(b)); got != want {
+		t.Errorf("received() = %d, want %d", got, want)
+	}
+	if got, want := m.waited(), 10*time.Millisecond; got < want {
+		t.Errorf("waited() = %s, want >= %s", got, want)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	m := &meter{}
+	tr := &tracker{m: m}
+	res := &result{enc: encodings.Raw}
+	tr.reset(res)
+
+	tr.mark()
+	// Time spent waiting for the network isn't counted as decode time.
+	m.blocked = time.Hour
+	for i := 0; i < 3; i++ {
+		rect := &vnc.Rectangle{Width: 2, Height: 3, Enc: &vnc.RawEncoding{}}
+		if err := tr.rect(rect, i, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tr.rect(&vnc.Rectangle{Width: 10, Height: 10, Enc: &vnc.DesktopSizePseudoEncoding{}}, 0, 1)
+
+	st := res.rects[encodings.Raw]
+	if st == nil {
+		t.Fatal("no Raw rectangles tracked")
+	}
+	if got, want := st.rects, 3; got != want {
+		t.Errorf("rects = %d, want %d", got, want)
+	}
+	if got, want := st.pixels, int64(18); got != want {
+		t.Errorf("pixels = %d, want %d", got, want)
+	}
+	if st.decode >= time.Hour {
+		t.Errorf("decode = %s, includes network wait", st.decode)
+	}
+	if got, want := res.rects[encodings.DesktopSizePseudo].pixels, int64(0); got != want {
+		t.Errorf("pseudo-encoding pixels = %d, want %d", got, want)
+	}
+}
+
+func TestReport(t *testing.T) {
+	results := []*result{{
+		enc:     encodings.CopyRect,
+		updates: 10,
+		bytes:   2e6,
+		elapsed: time.Second,
+		rects: map[encodings.Encoding]*rectStats{
+			encodings.Raw:      {rects: 4, pixels: 1e6, decode: 4 * time.Millisecond},
+			encodings.CopyRect: {rects: 6, pixels: 5e5},
+		},
+	}}
+	var buf bytes.Buffer
+	report(&buf, results)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := len(lines), 4; got != want {
+		t.Fatalf("got %d lines, want %d:\n%s", got, want, buf.String())
+	}
+	for i, want := range [][]string{
+		{"encoding", "updates/s", "rects/s"},
+		{"CopyRect", "10.0", "10.0", "1.50", "2.00", "4ms", "400µs"},
+		{"Raw", "4.0", "1.00", "4ms", "1ms"},
+		{"CopyRect", "6.0", "0.50", "0s", "0s"},
+	} {
+		fields := strings.Fields(lines[i])
+		for j, w := range want {
+			if j >= len(fields) || fields[j] != w {
+				t.Errorf("line %d = %q, want fields %v", i, lines[i], want)
+				break
+			}
+		}
+	}
+}
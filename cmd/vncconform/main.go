@@ -0,0 +1,85 @@
+/*
+The vncconform command checks the behavior of a VNC server against the RFB
+protocol, e.g. that the encodings requested are honored, and reports the
+result of each check. See the conformance package for the checks.
+
+Usage:
+
+	vncconform [flags] host:port
+
+The exit status is 1 if any check fails. The password is taken from the
+-password_file flag, or else the VNC_PASSWORD environment variable.
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/conformance"
+	"golang.org/x/net/context"
+)
+
+var (
+	jsonOutput   = flag.Bool("json", false, "Write the report as JSON.")
+	passwordFile = flag.String("password_file", "", "File containing the VNC password.")
+	timeout      = flag.Duration("timeout", conformance.DefaultTimeout, "Timeout of each check.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host:port\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	password, err := cmdutil.ReadPassword(*passwordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addr := flag.Arg(0)
+	s := &conformance.Suite{
+		Dial: func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return cmdutil.Dial(ctx, addr)
+		},
+		Password: password,
+		Timeout:  *timeout,
+	}
+	r := s.Run(context.Background())
+	if *jsonOutput {
+		err = json.NewEncoder(os.Stdout).Encode(r)
+	} else {
+		err = report(os.Stdout, r)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if r.Failed() {
+		os.Exit(1)
+	}
+}
+
+// report writes the report as a table.
+func report(w io.Writer, r *conformance.Report) error {
+	if r.ProtocolVersion != "" {
+		fmt.Fprintf(w, "%q (%dx%d), %s\n\n", r.DesktopName, r.Width, r.Height, r.ProtocolVersion)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tTIME\tDETAIL")
+	for _, res := range r.Results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Check, res.Status, res.Duration.Round(time.Millisecond), res.Detail)
+	}
+	return tw.Flush()
+}
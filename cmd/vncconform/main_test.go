@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/conformance"
+)
+
+func TestReport(t *testing.T) {
+	r := &conformance.Report{
+		ProtocolVersion: "RFB 3.8",
+		DesktopName:     "desktop",
+		Width:           640,
+		Height:          480,
+		Results: []conformance.Result{
+			{Check: "full-update", Status: conformance.Pass, Duration: 12 * time.Millisecond},
+			{Check: "resize", Status: conformance.Skip, Detail: "ExtendedDesktopSize isn't supported"},
+		},
+	}
+	var buf bytes.Buffer
+	if err := report(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	want := `"desktop" (640x480), RFB 3.8
+
+CHECK        STATUS  TIME  DETAIL
+full-update  pass    12ms  
+resize       skip    0s    ExtendedDesktopSize isn't supported
+`
+	if got := buf.String(); got != want {
+		t.Errorf("incorrect report; got =\n%s\nwant =\n%s", got, want)
+	}
+}
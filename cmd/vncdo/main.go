@@ -0,0 +1,255 @@
+/*
+The vncdo command automates a VNC session, by running a script of commands
+which type text, press keys, click the pointer, wait for the screen, and
+capture screenshots.
+
+Usage:
+
+	vncdo [flags] host:port command...
+	vncdo [flags] -f script host:port
+
+The commands are:
+
+	type TEXT           type the text
+	key KEYS            press a key combination, e.g. "enter" or "ctrl-alt-del"
+	move X Y            move the pointer
+	click X Y           click the left button at (X, Y)
+	rclick X Y          click the right button at (X, Y)
+	dclick X Y          double-click the left button at (X, Y)
+	pause DURATION      pause, e.g. "500ms" or "2" (seconds)
+	capture FILE        capture the screen as a PNG image
+	waitchange X Y W H  wait until the region of the screen changes
+	expect FILE X Y     wait until the screen at (X, Y) matches the PNG image
+	play FILE           replay an input macro, as written by vnc.Macro.Write
+
+A script holds one command per line. Blank lines, and lines starting with '#',
+are ignored.
+
+The password is taken from the -password_file flag, or else the VNC_PASSWORD
+environment variable.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/keys"
+	"golang.org/x/net/context"
+)
+
+var (
+	scriptFile   = flag.String("f", "", "Script file to run, instead of the command-line arguments.")
+	passwordFile = flag.String("password_file", "", "File containing the VNC password.")
+	timeout      = flag.Duration("timeout", 10*time.Second, "Timeout for connecting to the server.")
+	waitTimeout  = flag.Duration("wait_timeout", 30*time.Second, "Timeout for the waitchange and expect commands.")
+	settle       = flag.Duration("settle", vnc.Settle(), "Time to let the UI settle after each input event.")
+	fuzz         = flag.Int("fuzz", 0, "Maximum difference per color channel (0-255) for expect to match.")
+	layout       = flag.String("layout", "", "Keyboard layout of the server for the type command, for servers mapping keysyms to US keys ("+strings.Join(keys.LayoutNames(), ", ")+").")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host:port command...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 || (*scriptFile == "") == (flag.NArg() == 1) {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var kl *keys.Layout
+	if *layout != "" {
+		var ok bool
+		if kl, ok = keys.LookupLayout(*layout); !ok {
+			log.Fatalf("unknown keyboard layout %q", *layout)
+		}
+	}
+
+	cmds, err := loadCommands(*scriptFile, flag.Args()[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	password, err := cmdutil.ReadPassword(*passwordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := do(flag.Arg(0), password, kl, cmds); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadCommands returns the commands from the script file, if given, or else
+// from the arguments.
+func loadCommands(path string, args []string) ([]command, error) {
+	if path == "" {
+		return parseArgs(args)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseScript(f)
+}
+
+// do connects to the server at addr, and runs the commands.
+func do(addr, password string, kl *keys.Layout, cmds []command) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	cfg := cmdutil.NewClientConfig(password)
+	cfg.KeyboardLayout = kl
+	vc, err := cmdutil.Connect(ctx, addr, cfg, nil)
+	if err != nil {
+		return err
+	}
+	defer vc.Close()
+	vnc.SetSettle(*settle)
+
+	encs := vnc.Encodings{&vnc.RawEncoding{}, &vnc.CopyRectEncoding{}, &vnc.DesktopSizePseudoEncoding{}}
+	if err := vc.SetEncodings(encs); err != nil {
+		return err
+	}
+
+	// The context is cancelled if the connection ends.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	r := &runner{ctx: ctx, vc: vc, screen: vnc.NewScreen(vc)}
+	go func() {
+		r.err = vc.ListenAndHandle()
+		if r.err == nil {
+			r.err = vnc.ErrClosed
+		}
+		cancel()
+	}()
+	go r.screen.Listen(ctx, cfg.ServerMessageCh)
+
+	for i, c := range cmds {
+		if err := r.run(c); err != nil {
+			return fmt.Errorf("command %d (%s): %s", i+1, c, err)
+		}
+	}
+	return nil
+}
+
+// runner runs commands against a connection.
+type runner struct {
+	ctx    context.Context
+	vc     *vnc.ClientConn
+	screen *vnc.Screen
+	err    error // Why the connection ended. Valid once ctx is done.
+}
+
+// run runs a single command.
+func (r *runner) run(c command) error {
+	switch c.name {
+	case "type":
+		return r.vc.Type(c.args[0])
+	case "key":
+		ks, err := parseKeys(c.args[0])
+		if err != nil {
+			return err
+		}
+		return r.vc.KeyCombo(ks...)
+	case "move", "click", "rclick", "dclick":
+		xy, err := parseCoords(c.args...)
+		if err != nil {
+			return err
+		}
+		switch c.name {
+		case "move":
+			return r.vc.PointerEvent(buttons.None, xy[0], xy[1])
+		case "rclick":
+			return r.vc.Click(buttons.Right, xy[0], xy[1])
+		case "dclick":
+			if err := r.vc.Click(buttons.Left, xy[0], xy[1]); err != nil {
+				return err
+			}
+		}
+		return r.vc.Click(buttons.Left, xy[0], xy[1])
+	case "pause":
+		d, err := parsePause(c.args[0])
+		if err != nil {
+			return err
+		}
+		time.Sleep(d)
+		return nil
+	case "play":
+		f, err := os.Open(c.args[0])
+		if err != nil {
+			return err
+		}
+		m, err := vnc.ReadMacro(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		return m.Play(r.ctx, r.vc, 1)
+	case "capture":
+		if err := r.wait(r.screen.Refresh); err != nil {
+			return err
+		}
+		return cmdutil.WritePNG(c.args[0], r.screen.Image())
+	case "waitchange":
+		v, err := parseCoords(c.args...)
+		if err != nil {
+			return err
+		}
+		region := image.Rect(int(v[0]), int(v[1]), int(v[0])+int(v[2]), int(v[1])+int(v[3]))
+		return r.wait(func(ctx context.Context) error {
+			return r.screen.WaitForChange(ctx, region)
+		})
+	case "expect":
+		xy, err := parseCoords(c.args[1:]...)
+		if err != nil {
+			return err
+		}
+		img, err := readPNG(c.args[0])
+		if err != nil {
+			return err
+		}
+		region := image.Rectangle{Max: img.Bounds().Size()}.Add(image.Pt(int(xy[0]), int(xy[1])))
+		return r.wait(func(ctx context.Context) error {
+			return r.screen.WaitForMatch(ctx, region, img, *fuzz)
+		})
+	}
+	return fmt.Errorf("unknown command %q", c.name)
+}
+
+// wait calls fn with a context which times out after the wait timeout, or
+// is cancelled when the connection ends.
+func (r *runner) wait(fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(r.ctx, *waitTimeout)
+	defer cancel()
+	err := fn(ctx)
+	switch {
+	case err == nil:
+		return nil
+	case r.ctx.Err() != nil:
+		return fmt.Errorf("connection ended: %w", r.err)
+	case ctx.Err() == context.DeadlineExceeded:
+		return fmt.Errorf("timed out after %s", *waitTimeout)
+	}
+	return err
+}
+
+// readPNG reads a PNG image.
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc/keys"
+)
+
+// command is a single step of a script.
+type command struct {
+	name string
+	args []string
+}
+
+func (c command) String() string {
+	return strings.TrimSpace(c.name + " " + strings.Join(c.args, " "))
+}
+
+// arity holds the number of arguments taken by each command.
+var arity = map[string]int{
+	"capture":    1, // capture FILE.png
+	"click":      2, // click X Y
+	"dclick":     2, // dclick X Y
+	"expect":     3, // expect FILE.png X Y
+	"key":        1, // key ctrl-alt-del
+	"move":       2, // move X Y
+	"pause":      1, // pause DURATION
+	"play":       1, // play FILE.json
+	"rclick":     2, // rclick X Y
+	"type":       1, // type TEXT
+	"waitchange": 4, // waitchange X Y W H
+}
+
+// parseArgs parses commands given as command-line arguments, e.g.
+// ["move", "10", "20", "type", "hello"].
+func parseArgs(args []string) ([]command, error) {
+	var cmds []command
+	for len(args) > 0 {
+		name := args[0]
+		n, ok := arity[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown command %q", name)
+		}
+		if len(args) < n+1 {
+			return nil, fmt.Errorf("%s: expected %d arguments, got %d", name, n, len(args)-1)
+		}
+		cmds = append(cmds, command{name, args[1 : n+1]})
+		args = args[n+1:]
+	}
+	return cmds, nil
+}
+
+// parseScript parses a script holding one command per line. Blank lines, and
+// lines starting with '#', are ignored. The argument of type is the remainder
+// of the line, so that text containing spaces can be typed.
+func parseScript(r io.Reader) ([]command, error) {
+	var cmds []command
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if fields[0] == "type" {
+			fields = []string{"type", strings.TrimSpace(strings.TrimPrefix(text, "type"))}
+		}
+		c, err := parseArgs(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if len(c) != 1 {
+			return nil, fmt.Errorf("line %d: expected a single command", line)
+		}
+		cmds = append(cmds, c[0])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// parseCoords parses the arguments as unsigned 16-bit coordinates.
+func parseCoords(args ...string) ([]uint16, error) {
+	var v []uint16
+	for _, a := range args {
+		n, err := strconv.ParseUint(a, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid coordinate %q", a)
+		}
+		v = append(v, uint16(n))
+	}
+	return v, nil
+}
+
+// parsePause parses a duration (e.g. "500ms"), or a number of seconds.
+func parsePause(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// parseKeys parses a key combination of key names joined by '-', e.g.
+// "ctrl-alt-del". A single character is typed as is, so "-" is the minus key.
+func parseKeys(spec string) ([]keys.Key, error) {
+	if k, ok := keys.Lookup(spec); ok {
+		return []keys.Key{k}, nil
+	}
+	var ks []keys.Key
+	for _, name := range strings.Split(spec, "-") {
+		k, ok := keys.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", name)
+		}
+		ks = append(ks, k)
+	}
+	return ks, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/keys"
+)
+
+func TestParseArgs(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		args []string
+		cmds []command
+		ok   bool
+	}{
+		{"empty", nil, nil, true},
+		{"single", []string{"type", "hello"}, []command{{"type", []string{"hello"}}}, true},
+		{"multiple",
+			[]string{"move", "1", "2", "key", "enter", "capture", "a.png"},
+			[]command{{"move", []string{"1", "2"}}, {"key", []string{"enter"}}, {"capture", []string{"a.png"}}},
+			true},
+		{"unknown command", []string{"jump"}, nil, false},
+		{"missing arguments", []string{"click", "1"}, nil, false},
+	} {
+		cmds, err := parseArgs(tt.args)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			}
+			continue
+		}
+		if got, want := cmds, tt.cmds; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: commands = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	script := `# Log in.
+click 10 20
+type  hello world
+
+key ctrl-alt-del
+waitchange 0 0 100 50
+`
+	cmds, err := parseScript(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []command{
+		{"click", []string{"10", "20"}},
+		{"type", []string{"hello world"}},
+		{"key", []string{"ctrl-alt-del"}},
+		{"waitchange", []string{"0", "0", "100", "50"}},
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("commands = %v, want %v", cmds, want)
+	}
+
+	for _, script := range []string{"click 1 2 3", "move 1", "jump"} {
+		if _, err := parseScript(strings.NewReader(script)); err == nil {
+			t.Errorf("%q: expected error", script)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		keys []keys.Key
+		ok   bool
+	}{
+		{"a", []keys.Key{keys.SmallA}, true},
+		{"-", []keys.Key{keys.Minus}, true},
+		{"Enter", []keys.Key{keys.Return}, true},
+		{"ctrl-alt-del", []keys.Key{keys.ControlLeft, keys.AltLeft, keys.Delete}, true},
+		{"shift-a", []keys.Key{keys.ShiftLeft, keys.SmallA}, true},
+		{"ctrl-bogus", nil, false},
+	} {
+		ks, err := parseKeys(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: err = %v, want ok = %v", tt.spec, err, tt.ok)
+			continue
+		}
+		if got, want := ks, tt.keys; !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: keys = %v, want %v", tt.spec, got, want)
+		}
+	}
+}
+
+func TestParsePause(t *testing.T) {
+	for _, tt := range []struct {
+		s  string
+		d  time.Duration
+		ok bool
+	}{
+		{"500ms", 500 * time.Millisecond, true},
+		{"2", 2 * time.Second, true},
+		{"0.25", 250 * time.Millisecond, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+	} {
+		d, err := parsePause(tt.s)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: err = %v, want ok = %v", tt.s, err, tt.ok)
+			continue
+		}
+		if got, want := d, tt.d; got != want {
+			t.Errorf("%q: duration = %s, want %s", tt.s, got, want)
+		}
+	}
+}
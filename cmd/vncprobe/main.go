@@ -0,0 +1,312 @@
+/*
+The vncprobe command reports the capabilities of VNC servers as JSON, for
+inventory and security assessment. For each host, only the ProtocolVersion and
+Security handshakes are performed, reporting the protocol version and the
+security types offered by the server. No authentication is attempted.
+
+With the -init flag, a full connection is also made to each host, reporting
+the desktop name and size from the ServerInit message. This requires the
+None security type, or the password.
+
+Usage:
+
+	vncprobe [flags] host[:port]...
+	vncprobe [flags] -f hosts
+
+One JSON object is written per line for each host, in the order given. The
+port defaults to 5900.
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/npipe"
+	"golang.org/x/net/context"
+)
+
+var (
+	hostsFile    = flag.String("f", "", "File of hosts to probe, one per line.")
+	initialize   = flag.Bool("init", false, "Also connect fully, to report the ServerInit desktop metadata.")
+	passwordFile = flag.String("password_file", "", "File containing the VNC password, for -init.")
+	parallel     = flag.Int("parallel", 16, "Number of hosts probed in parallel.")
+	timeout      = flag.Duration("timeout", 5*time.Second, "Timeout for probing each host.")
+)
+
+const defaultPort = "5900"
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host[:port]...\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	hosts := flag.Args()
+	if *hostsFile != "" {
+		f, err := os.Open(*hostsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		hosts, err = readHosts(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(hosts) == 0 || *parallel < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var password string
+	if *initialize {
+		var err error
+		if password, err = cmdutil.ReadPassword(*passwordFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, r := range probeAll(hosts, password) {
+		if err := enc.Encode(r); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// readHosts reads hosts, one per line. Blank lines, and lines starting with
+// '#', are ignored.
+func readHosts(r io.Reader) ([]string, error) {
+	var hosts []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hosts = append(hosts, line)
+	}
+	return hosts, s.Err()
+}
+
+// hostAddr returns the address of the host, adding the default port if the
+// host has none. The paths of named pipes are returned unchanged.
+func hostAddr(host string) string {
+	if npipe.IsPath(host) {
+		return host
+	}
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+}
+
+// probeAll probes the hosts in parallel, returning the results in the same
+// order as the hosts.
+func probeAll(hosts []string, password string) []*result {
+	results := make([]*result, len(hosts))
+	sem := make(chan struct{}, *parallel)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, addr string) {
+			defer func() { <-sem; wg.Done() }()
+			results[i] = probeHost(addr, password)
+		}(i, hostAddr(host))
+	}
+	wg.Wait()
+	return results
+}
+
+// probeHost probes the server at addr.
+func probeHost(addr, password string) *result {
+	r := &result{Address: addr}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	c, err := cmdutil.Dial(ctx, addr)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	}
+	err = probe(c, r)
+	c.Close()
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	if *initialize {
+		if err := describe(ctx, addr, password, r); err != nil {
+			r.Error = err.Error()
+		}
+	}
+	return r
+}
+
+//-----------------------------------------------------------------------------
+
+// result holds what was learned about a server.
+type result struct {
+	Address         string         `json:"address"`
+	ProtocolVersion string         `json:"protocol_version,omitempty"`
+	Negotiated      string         `json:"negotiated_version,omitempty"`
+	SecurityTypes   []securityType `json:"security_types,omitempty"`
+	FailureReason   string         `json:"failure_reason,omitempty"`
+	Desktop         *desktop       `json:"desktop,omitempty"`
+	Error           string         `json:"error,omitempty"`
+}
+
+// securityType is a security type offered by a server.
+type securityType struct {
+	ID   uint8  `json:"id"`
+	Name string `json:"name"`
+}
+
+// desktop holds the desktop metadata of the ServerInit message.
+type desktop struct {
+	Name   string `json:"name"`
+	Width  uint16 `json:"width"`
+	Height uint16 `json:"height"`
+}
+
+// securityTypeNames holds the names of the registered security types.
+// https://www.iana.org/assignments/rfb/rfb.xhtml#rfb-1
+var securityTypeNames = map[uint8]string{
+	0:   "Invalid",
+	1:   "None",
+	2:   "VNC Authentication",
+	5:   "RA2",
+	6:   "RA2ne",
+	16:  "Tight",
+	17:  "Ultra",
+	18:  "TLS",
+	19:  "VeNCrypt",
+	20:  "SASL",
+	21:  "MD5 hash authentication",
+	22:  "xvp",
+	30:  "Apple Remote Desktop",
+	113: "MS-Logon II",
+}
+
+func newSecurityType(id uint8) securityType {
+	name, ok := securityTypeNames[id]
+	if !ok {
+		name = fmt.Sprintf("Unknown (%d)", id)
+	}
+	return securityType{id, name}
+}
+
+// probe performs the ProtocolVersion and Security handshakes on the
+// connection, recording the results in r. The connection is left before any
+// security type is selected.
+func probe(c io.ReadWriter, r *result) error {
+	var pv [12]byte
+	if _, err := io.ReadFull(c, pv[:]); err != nil {
+		return fmt.Errorf("reading ProtocolVersion: %s", err)
+	}
+	var v vnc.ProtocolVersion
+	if _, err := fmt.Sscanf(string(pv[:]), "RFB %03d.%03d\n", &v.Major, &v.Minor); err != nil {
+		return fmt.Errorf("invalid ProtocolVersion %q", pv)
+	}
+	r.ProtocolVersion = v.String()
+
+	// Speak the latest version supported by both sides.
+	n := vnc.ProtocolVersion38
+	switch {
+	case v.Major < 3:
+		return fmt.Errorf("unsupported protocol version %s", v)
+	case v.Major > 3 || v.Minor >= 8:
+	case v.Minor == 7:
+		n = vnc.ProtocolVersion37
+	default:
+		n = vnc.ProtocolVersion33
+	}
+	r.Negotiated = n.String()
+	if _, err := fmt.Fprintf(c, "RFB %03d.%03d\n", n.Major, n.Minor); err != nil {
+		return err
+	}
+
+	if n == vnc.ProtocolVersion33 {
+		// The server decides the security type.
+		var id uint32
+		if err := binary.Read(c, binary.BigEndian, &id); err != nil {
+			return fmt.Errorf("reading security type: %s", err)
+		}
+		if id == 0 {
+			return readFailure(c, r)
+		}
+		r.SecurityTypes = []securityType{newSecurityType(uint8(id))}
+		return nil
+	}
+
+	var count [1]byte
+	if _, err := io.ReadFull(c, count[:]); err != nil {
+		return fmt.Errorf("reading security types: %s", err)
+	}
+	if count[0] == 0 {
+		return readFailure(c, r)
+	}
+	ids := make([]byte, count[0])
+	if _, err := io.ReadFull(c, ids); err != nil {
+		return fmt.Errorf("reading security types: %s", err)
+	}
+	for _, id := range ids {
+		r.SecurityTypes = append(r.SecurityTypes, newSecurityType(id))
+	}
+	return nil
+}
+
+// maxReasonLen limits the length of the failure reason read from a server.
+const maxReasonLen = 4096
+
+// readFailure reads the reason the server refused the connection.
+func readFailure(c io.Reader, r *result) error {
+	var n uint32
+	if err := binary.Read(c, binary.BigEndian, &n); err != nil {
+		return fmt.Errorf("reading failure reason: %s", err)
+	}
+	if n > maxReasonLen {
+		return fmt.Errorf("failure reason too long (%d bytes)", n)
+	}
+	reason := make([]byte, n)
+	if _, err := io.ReadFull(c, reason); err != nil {
+		return fmt.Errorf("reading failure reason: %s", err)
+	}
+	r.FailureReason = string(reason)
+	return nil
+}
+
+// describe connects to the server at addr, recording the desktop metadata of
+// the ServerInit message in r.
+func describe(ctx context.Context, addr, password string, r *result) error {
+	vc, err := cmdutil.Connect(ctx, addr, cmdutil.NewClientConfig(password), nil)
+	if err != nil {
+		return err
+	}
+	defer vc.Close()
+	r.Desktop = &desktop{
+		Name:   vc.DesktopName(),
+		Width:  vc.FramebufferWidth(),
+		Height: vc.FramebufferHeight(),
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHostAddr(t *testing.T) {
+	for _, tt := range []struct {
+		host, addr string
+	}{
+		{"10.0.0.1", "10.0.0.1:5900"},
+		{"10.0.0.1:5901", "10.0.0.1:5901"},
+		{"example.com", "example.com:5900"},
+		{"::1", "[::1]:5900"},
+		{"[::1]", "[::1]:5900"},
+		{`\\.\pipe\vnc`, `\\.\pipe\vnc`},
+		{"[::1]:5902", "[::1]:5902"},
+	} {
+		if got, want := hostAddr(tt.host), tt.addr; got != want {
+			t.Errorf("hostAddr(%q) = %q, want %q", tt.host, got, want)
+		}
+	}
+}
+
+func TestReadHosts(t *testing.T) {
+	hosts, err := readHosts(strings.NewReader("# Lab.\n10.0.0.1\n\n  10.0.0.2:5901  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hosts, []string{"10.0.0.1", "10.0.0.2:5901"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("readHosts() = %v, want %v", got, want)
+	}
+}
+
+// u32 returns the big-endian encoding of v.
+func u32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func cat(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+func TestProbe(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		server []byte // Sent by the server.
+		client string // Sent by the client.
+		want   result
+		ok     bool
+	}{
+		{"3.8",
+			cat([]byte("RFB 003.008\n"), []byte{2, 1, 2}),
+			"RFB 003.008\n",
+			result{ProtocolVersion: "3.8", Negotiated: "3.8", SecurityTypes: []securityType{{1, "None"}, {2, "VNC Authentication"}}},
+			true},
+		{"3.7",
+			cat([]byte("RFB 003.007\n"), []byte{1, 19}),
+			"RFB 003.007\n",
+			result{ProtocolVersion: "3.7", Negotiated: "3.7", SecurityTypes: []securityType{{19, "VeNCrypt"}}},
+			true},
+		{"Apple",
+			cat([]byte("RFB 003.889\n"), []byte{2, 30, 99}),
+			"RFB 003.008\n",
+			result{ProtocolVersion: "3.889", Negotiated: "3.8", SecurityTypes: []securityType{{30, "Apple Remote Desktop"}, {99, "Unknown (99)"}}},
+			true},
+		{"3.3",
+			cat([]byte("RFB 003.003\n"), u32(2)),
+			"RFB 003.003\n",
+			result{ProtocolVersion: "3.3", Negotiated: "3.3", SecurityTypes: []securityType{{2, "VNC Authentication"}}},
+			true},
+		{"3.3 failure",
+			cat([]byte("RFB 003.003\n"), u32(0), u32(8), []byte("too many")),
+			"RFB 003.003\n",
+			result{ProtocolVersion: "3.3", Negotiated: "3.3", FailureReason: "too many"},
+			true},
+		{"3.8 failure",
+			cat([]byte("RFB 003.008\n"), []byte{0}, u32(6), []byte("banned")),
+			"RFB 003.008\n",
+			result{ProtocolVersion: "3.8", Negotiated: "3.8", FailureReason: "banned"},
+			true},
+		{"invalid version", []byte("SSH-2.0-OpenSSH\n"), "", result{}, false},
+		{"truncated", cat([]byte("RFB 003.008\n"), []byte{2, 1}), "RFB 003.008\n", result{}, false},
+	} {
+		var sent bytes.Buffer
+		conn := struct {
+			io.Reader
+			io.Writer
+		}{bytes.NewReader(tt.server), &sent}
+		var r result
+		err := probe(conn, &r)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.desc, err, tt.ok)
+			continue
+		}
+		if got, want := sent.String(), tt.client; got != want {
+			t.Errorf("%s: client sent %q, want %q", tt.desc, got, want)
+		}
+		if !tt.ok {
+			continue
+		}
+		if !reflect.DeepEqual(r, tt.want) {
+			t.Errorf("%s: result = %+v, want %+v", tt.desc, r, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+/*
+The vncproxy command is an auditing gateway for VNC servers. It accepts VNC
+client connections, relays each to an upstream server, logs the sessions, and
+optionally records the server-to-client stream of each session as an FBS
+file, which can be replayed later.
+
+Usage:
+
+	vncproxy [flags] -server host:port
+
+The proxy relays the RFB protocol unmodified, so clients authenticate
+directly with the upstream server.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/fbs"
+	"golang.org/x/net/context"
+)
+
+var (
+	listen    = flag.String("listen", "127.0.0.1:5900", "Address to accept client connections on, or the path of a Windows named pipe.")
+	server    = flag.String("server", "", "Address of the upstream VNC server, or the path of a Windows named pipe.")
+	recordDir = flag.String("record_dir", "", "Directory to record sessions into as FBS files. Recording is disabled if unset.")
+	logFile   = flag.String("log_file", "", "File to append the session log to. Defaults to stderr.")
+	dialTO    = flag.Duration("dial_timeout", 10*time.Second, "Timeout for connecting to the upstream server.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] -server host:port\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *server == "" || flag.NArg() != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("error opening log file: %s", err)
+		}
+		defer f.Close()
+		logger.SetOutput(f)
+	}
+	if *recordDir != "" {
+		if err := os.MkdirAll(*recordDir, 0755); err != nil {
+			log.Fatalf("error creating record directory: %s", err)
+		}
+	}
+
+	ln, err := cmdutil.Listen(*listen)
+	if err != nil {
+		log.Fatalf("error listening: %s", err)
+	}
+	logger.Printf("proxying %s to %s", ln.Addr(), *server)
+
+	p := &proxy{
+		server:      *server,
+		recordDir:   *recordDir,
+		dialTimeout: *dialTO,
+		log:         logger,
+	}
+	if err := p.serve(ln); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// proxy relays VNC client connections to an upstream server.
+type proxy struct {
+	server      string
+	recordDir   string
+	dialTimeout time.Duration
+	log         *log.Logger
+	now         func() time.Time // For testing.
+}
+
+// serve accepts connections from ln until it is closed.
+func (p *proxy) serve(ln net.Listener) error {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go p.handle(c)
+	}
+}
+
+// handle relays a single client connection.
+func (p *proxy) handle(client net.Conn) {
+	defer client.Close()
+	start := p.timeNow()
+	id := fmt.Sprintf("%s %s", start.Format("20060102-150405.000"), client.RemoteAddr())
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.dialTimeout)
+	upstream, err := cmdutil.Dial(ctx, p.server)
+	cancel()
+	if err != nil {
+		p.log.Printf("[%s] error connecting to %s: %s", id, p.server, err)
+		return
+	}
+	defer upstream.Close()
+	p.log.Printf("[%s] session opened to %s", id, p.server)
+
+	// Record the server-to-client stream.
+	var toClient io.Writer = client
+	if p.recordDir != "" {
+		path := filepath.Join(p.recordDir, recordingName(start, client.RemoteAddr()))
+		f, err := os.Create(path)
+		if err != nil {
+			p.log.Printf("[%s] error creating recording: %s", id, err)
+			return
+		}
+		defer f.Close()
+		w, err := fbs.NewWriter(f)
+		if err != nil {
+			p.log.Printf("[%s] error writing recording: %s", id, err)
+			return
+		}
+		toClient = io.MultiWriter(client, w)
+		p.log.Printf("[%s] recording to %s", id, path)
+	}
+
+	var wg sync.WaitGroup
+	var sent, received int64
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sent, _ = io.Copy(upstream, client)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer wg.Done()
+		received, _ = io.Copy(toClient, upstream)
+		closeWrite(client)
+	}()
+	wg.Wait()
+
+	p.log.Printf("[%s] session closed after %v; client sent %d bytes, server sent %d bytes",
+		id, p.timeNow().Sub(start).Round(time.Millisecond), sent, received)
+}
+
+func (p *proxy) timeNow() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// closeWrite signals the end of the stream to the peer, so that the relay in
+// the opposite direction can drain.
+func closeWrite(c net.Conn) {
+	if tc, ok := c.(interface{ CloseWrite() error }); ok {
+		tc.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// recordingName returns the file name of a recording.
+func recordingName(start time.Time, addr net.Addr) string {
+	host := strings.NewReplacer(":", "_", `\`, "_", "[", "", "]", "").Replace(addr.String())
+	return fmt.Sprintf("%s-%s.fbs", start.Format("20060102-150405.000"), host)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/fbs"
+	"github.com/kward/go-vnc/npipe"
+)
+
+// newUpstream returns the address of a server which sends greeting, then
+// echoes everything it receives.
+func newUpstream(t *testing.T, greeting string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	go func() {
+		defer ln.Close()
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.Write([]byte(greeting))
+		io.Copy(c, c)
+	}()
+	return ln.Addr().String()
+}
+
+func TestProxy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vncproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := &proxy{
+		server:      newUpstream(t, "RFB 003.008\n"),
+		recordDir:   dir,
+		dialTimeout: time.Second,
+		log:         log.New(ioutil.Discard, "", 0),
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go p.serve(ln)
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Write([]byte("RFB 003.008\n"))
+	c.(*net.TCPConn).CloseWrite()
+	got, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if want := "RFB 003.008\nRFB 003.008\n"; string(got) != want {
+		t.Errorf("relayed data = %q, want = %q", got, want)
+	}
+
+	// The recording is complete once the client has been sent EOF.
+	files, err := filepath.Glob(filepath.Join(dir, "*.fbs"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one recording; got %v, %v", files, err)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := fbs.NewReader(f)
+	if err != nil {
+		t.Fatalf("invalid recording: %s", err)
+	}
+	var recorded []byte
+	for {
+		b, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid recording: %s", err)
+		}
+		recorded = append(recorded, b.Data...)
+	}
+	if !bytes.Equal(recorded, got) {
+		t.Errorf("recorded data = %q, want = %q", recorded, got)
+	}
+}
+
+func TestRecordingName(t *testing.T) {
+	start := time.Date(2017, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	for _, tt := range []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, "20170102-030405.006-10.0.0.1_1234.fbs"},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234}, "20170102-030405.006-__1_1234.fbs"},
+		{npipe.Addr(`\\.\pipe\vnc`), "20170102-030405.006-__._pipe_vnc.fbs"},
+	} {
+		if got := recordingName(start, tt.addr); got != tt.want {
+			t.Errorf("recordingName(%v) = %q, want = %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+/*
+The vncrecord command attaches to a VNC server, and records the session as an
+FBS file until interrupted. Optionally, the framebuffer is also written
+periodically as PNG keyframes.
+
+Usage:
+
+	vncrecord [flags] host:port
+
+The password is taken from the -password_file flag, or else the VNC_PASSWORD
+environment variable.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/fbs"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+var (
+	output           = flag.String("o", "session.fbs", "Output FBS file.")
+	passwordFile     = flag.String("password_file", "", "File containing the VNC password.")
+	interval         = flag.Duration("interval", 100*time.Millisecond, "Minimum interval between framebuffer update requests.")
+	duration         = flag.Duration("duration", 0, "Stop recording after this long. Records until interrupted if zero.")
+	keyframeDir      = flag.String("keyframe_dir", "", "Directory to write PNG keyframes into. Keyframes are disabled if unset.")
+	keyframeInterval = flag.Duration("keyframe_interval", 10*time.Second, "Interval between keyframes.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host:port\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	password, err := cmdutil.ReadPassword(*passwordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *keyframeDir != "" {
+		if err := os.MkdirAll(*keyframeDir, 0755); err != nil {
+			log.Fatalf("error creating keyframe directory: %s", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), *duration)
+	}
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	f, err := os.Create(*output)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = record(ctx, flag.Arg(0), password, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// record records the session with the server at addr to w, until the context
+// is done.
+func record(ctx context.Context, addr, password string, w io.Writer) error {
+	rec, err := fbs.NewWriter(w)
+	if err != nil {
+		return err
+	}
+
+	cfg := cmdutil.NewClientConfig(password)
+	cfg.ViewOnly = true // Recording never disturbs the session.
+	vc, err := cmdutil.Connect(ctx, addr, cfg, func(nc net.Conn) net.Conn {
+		return &recordingConn{nc, rec}
+	})
+	if err != nil {
+		return err
+	}
+	defer vc.Close()
+	log.Printf("recording %q (%dx%d) to %s", vc.DesktopName(), vc.FramebufferWidth(), vc.FramebufferHeight(), *output)
+
+	encs := vnc.Encodings{&vnc.RawEncoding{}, &vnc.CopyRectEncoding{}, &vnc.DesktopSizePseudoEncoding{}}
+	if err := vc.SetEncodings(encs); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- vc.ListenAndHandle() }()
+
+	return loop(ctx, vc, cfg.ServerMessageCh, done)
+}
+
+// loop requests framebuffer updates, and writes keyframes, until the context
+// is done.
+func loop(ctx context.Context, vc *vnc.ClientConn, msgs <-chan vnc.ServerMessage, done <-chan error) error {
+	fb := vnc.NewFramebuffer(int(vc.FramebufferWidth()), int(vc.FramebufferHeight()))
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, vc.FramebufferWidth(), vc.FramebufferHeight()); err != nil {
+		return err
+	}
+
+	var keyframes <-chan time.Time
+	if *keyframeDir != "" {
+		t := time.NewTicker(*keyframeInterval)
+		defer t.Stop()
+		keyframes = t.C
+	}
+	var request <-chan time.Time
+	start := time.Now()
+
+	for {
+		select {
+		case msg := <-msgs:
+			fu, ok := msg.(*vnc.FramebufferUpdate)
+			if !ok {
+				continue
+			}
+			for i := range fu.Rects {
+				if err := fb.Apply(&fu.Rects[i]); err != nil {
+					return err
+				}
+			}
+			request = time.After(*interval)
+		case <-request:
+			request = nil
+			if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, uint16(fb.Width), uint16(fb.Height)); err != nil {
+				return err
+			}
+		case now := <-keyframes:
+			if err := cmdutil.WritePNG(keyframePath(*keyframeDir, now.Sub(start)), fb.Image()); err != nil {
+				return err
+			}
+		case err := <-done:
+			if err == nil {
+				err = vnc.ErrClosed
+			}
+			return fmt.Errorf("connection ended: %w", err)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// recordingConn records everything read from the connection.
+type recordingConn struct {
+	net.Conn
+	rec io.Writer
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if _, werr := c.rec.Write(b[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// keyframePath returns the path of the keyframe taken at offset into the
+// recording.
+func keyframePath(dir string, offset time.Duration) string {
+	return filepath.Join(dir, fmt.Sprintf("keyframe-%09d.png", offset/time.Millisecond))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/fbs"
+)
+
+func TestRecordingConn(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+
+	var buf bytes.Buffer
+	rec, err := fbs.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &recordingConn{client, rec}
+
+	go func() {
+		server.Write([]byte("RFB 003.008\n"))
+		server.Write([]byte{1, 2})
+		server.Close()
+	}()
+	got, err := ioutil.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := fbs.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recorded []byte
+	for {
+		b, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorded = append(recorded, b.Data...)
+	}
+	if !bytes.Equal(recorded, got) {
+		t.Errorf("recorded = %q, want = %q", recorded, got)
+	}
+}
+
+func TestKeyframePath(t *testing.T) {
+	path := keyframePath("keyframes", 12345*time.Millisecond)
+	if got, want := filepath.Base(path), "keyframe-000012345.png"; got != want {
+		t.Errorf("keyframePath() = %q, want = %q", got, want)
+	}
+}
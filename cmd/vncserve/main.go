@@ -0,0 +1,131 @@
+/*
+The vncserve command is a VNC server for testing viewers and clients. It
+serves a static image, a directory of PNG images shown in turn, or an animated
+test pattern. Input from clients is ignored.
+
+Usage:
+
+	vncserve [flags] [image]
+
+With no image or -dir flag, the test pattern is served. If the VNC_PASSWORD
+environment variable (or -password_file flag) is set, clients must use VNC
+authentication. With the -chaos flag, faults are injected into the updates
+sent, to exercise the robustness of clients. With the -inetd flag, a single client is served on stdin and
+stdout, e.g. when started by inetd, or over an SSH session.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/server"
+)
+
+var (
+	listen       = flag.String("listen", ":5900", "Address to listen on, or the path of a Windows named pipe.")
+	dir          = flag.String("dir", "", "Directory of PNG images to serve in turn, sorted by name.")
+	size         = flag.String("size", "640x480", "Size of the test pattern.")
+	interval     = flag.Duration("interval", time.Second/25, "Interval between frames of the test pattern, or images of -dir.")
+	name         = flag.String("name", "vncserve", "Desktop name.")
+	passwordFile = flag.String("password_file", "", "File containing the VNC password.")
+	inetd        = flag.Bool("inetd", false, "Serve a single client on stdin and stdout, rather than listening.")
+	chaosFaults  = flag.String("chaos", "", "Comma separated faults to inject into updates, or all: truncate, invalid-length, slow-write, disconnect, bogus-encoding.")
+	chaosRate    = flag.Float64("chaos_rate", 0.1, "Fraction of updates with a fault, with -chaos.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [image]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 || (flag.NArg() == 1 && *dir != "") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	src, err := newSource(flag.Arg(0), *dir, *size, *interval)
+	if err != nil {
+		log.Fatal(err)
+	}
+	password, err := cmdutil.ReadPassword(*passwordFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chaos, err := newChaos(*chaosFaults, *chaosRate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := &server.Server{Source: animate(src), Name: *name, Password: password, Chaos: chaos}
+	if *inetd {
+		if err := s.ServeConn(vnc.NewStreamConn(os.Stdin, os.Stdout)); err != nil && err != io.EOF {
+			log.Fatal(err)
+		}
+		return
+	}
+	l, err := cmdutil.Listen(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := src.Bounds()
+	log.Printf("serving %dx%d on %s", b.Dx(), b.Dy(), l.Addr())
+	log.Fatal(s.Serve(l))
+}
+
+// newChaos returns the fault injection selected by the flags, or nil if
+// faults is empty.
+func newChaos(faults string, rate float64) (*server.Chaos, error) {
+	if faults == "" {
+		return nil, nil
+	}
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid chaos rate %v", rate)
+	}
+	chaos := &server.Chaos{Rate: rate}
+	if faults == "all" {
+		return chaos, nil
+	}
+	for _, name := range strings.Split(faults, ",") {
+		f, err := server.ParseFault(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		chaos.Faults = append(chaos.Faults, f)
+	}
+	return chaos, nil
+}
+
+// newSource returns the source of frames selected by the flags.
+func newSource(path, dir, size string, interval time.Duration) (source, error) {
+	switch {
+	case path != "":
+		img, err := readImage(path)
+		if err != nil {
+			return nil, err
+		}
+		return newImageSource([]image.Image{img}, 0)
+	case dir != "":
+		imgs, err := readDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		return newImageSource(imgs, interval)
+	}
+	var w, h int
+	if _, err := fmt.Sscanf(size, "%dx%d", &w, &h); err != nil || w <= 0 || h <= 0 || w > 0xffff || h > 0xffff {
+		return nil, fmt.Errorf("invalid size %q", size)
+	}
+	return newPatternSource(w, h, interval), nil
+}
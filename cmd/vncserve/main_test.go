@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/server"
+)
+
+func TestNewChaos(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		faults string
+		rate   float64
+		want   *server.Chaos
+		ok     bool
+	}{
+		{"disabled", "", 0.5, nil, true},
+		{"all", "all", 0.5, &server.Chaos{Rate: 0.5}, true},
+		{"some", "truncate, slow-write", 1, &server.Chaos{
+			Faults: []server.Fault{server.FaultTruncate, server.FaultSlowWrite}, Rate: 1}, true},
+		{"unknown fault", "truncate,bogus", 0.5, nil, false},
+		{"invalid rate", "all", 2, nil, false},
+	} {
+		got, err := newChaos(tt.faults, tt.rate)
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			}
+			continue
+		}
+		if !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: newChaos() = %+v, want %+v", tt.desc, got, tt.want)
+		}
+	}
+}
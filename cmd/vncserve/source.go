@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // Register the JPEG decoder.
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc/server"
+)
+
+// source provides the frames served to clients.
+type source interface {
+	// Bounds returns the bounds of every frame.
+	Bounds() image.Rectangle
+	// Frame returns frame n.
+	Frame(n int) *image.RGBA
+	// Interval returns the interval between frames, or zero if the source
+	// has only a single frame.
+	Interval() time.Duration
+}
+
+// animate returns a framebuffer source serving the frames of src, changing
+// frame every interval of src.
+func animate(src source) *server.ImageSource {
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src.Frame(0), image.Point{}, draw.Src)
+	fs := server.NewImageSource(img)
+	if iv := src.Interval(); iv > 0 {
+		go func() {
+			t := time.NewTicker(iv)
+			defer t.Stop()
+			for n := 1; ; n++ {
+				<-t.C
+				draw.Draw(img, img.Bounds(), src.Frame(n), image.Point{}, draw.Src)
+				fs.Damage(img.Bounds())
+			}
+		}()
+	}
+	return fs
+}
+
+// imageSource serves a fixed sequence of images, cycling through them.
+type imageSource struct {
+	frames   []*image.RGBA
+	interval time.Duration
+}
+
+// Verify that interfaces are honored.
+var _ source = (*imageSource)(nil)
+var _ source = (*patternSource)(nil)
+
+// newImageSource returns a source which serves the images, changing image
+// every interval. All images must have the same size.
+func newImageSource(imgs []image.Image, interval time.Duration) (*imageSource, error) {
+	if len(imgs) == 0 {
+		return nil, fmt.Errorf("no images")
+	}
+	s := &imageSource{interval: interval}
+	if len(imgs) == 1 {
+		s.interval = 0
+	}
+	size := imgs[0].Bounds().Size()
+	for i, img := range imgs {
+		if got := img.Bounds().Size(); got != size {
+			return nil, fmt.Errorf("image %d is %v, want %v", i, got, size)
+		}
+		s.frames = append(s.frames, toRGBA(img))
+	}
+	return s, nil
+}
+
+func (s *imageSource) Bounds() image.Rectangle { return s.frames[0].Bounds() }
+func (s *imageSource) Frame(n int) *image.RGBA { return s.frames[n%len(s.frames)] }
+func (s *imageSource) Interval() time.Duration { return s.interval }
+
+// toRGBA returns the image as an RGBA image, with its origin at (0, 0).
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// readImage reads a PNG or JPEG image.
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return img, nil
+}
+
+// readDir reads the PNG images of a directory, sorted by name.
+func readDir(dir string) ([]image.Image, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		if !fi.IsDir() && strings.EqualFold(filepath.Ext(fi.Name()), ".png") {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no PNG images in %s", dir)
+	}
+	var imgs []image.Image
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// patternColors are the colors of the test pattern bars.
+var patternColors = []color.RGBA{
+	{0xff, 0xff, 0xff, 0xff}, // white
+	{0xff, 0xff, 0x00, 0xff}, // yellow
+	{0x00, 0xff, 0xff, 0xff}, // cyan
+	{0x00, 0xff, 0x00, 0xff}, // green
+	{0xff, 0x00, 0xff, 0xff}, // magenta
+	{0xff, 0x00, 0x00, 0xff}, // red
+	{0x00, 0x00, 0xff, 0xff}, // blue
+	{0x00, 0x00, 0x00, 0xff}, // black
+}
+
+// patternSource serves an animated test pattern of vertical color bars,
+// which scroll one pixel to the left every frame, over a gray gradient.
+type patternSource struct {
+	width, height int
+	interval      time.Duration
+}
+
+func newPatternSource(width, height int, interval time.Duration) *patternSource {
+	return &patternSource{width, height, interval}
+}
+
+func (s *patternSource) Bounds() image.Rectangle { return image.Rect(0, 0, s.width, s.height) }
+func (s *patternSource) Interval() time.Duration { return s.interval }
+
+func (s *patternSource) Frame(n int) *image.RGBA {
+	img := image.NewRGBA(s.Bounds())
+	barWidth := (s.width + len(patternColors) - 1) / len(patternColors)
+	barHeight := s.height * 3 / 4
+	for y := 0; y < s.height; y++ {
+		for x := 0; x < s.width; x++ {
+			if y < barHeight {
+				img.SetRGBA(x, y, patternColors[(x+n)%s.width/barWidth])
+				continue
+			}
+			v := uint8(x * 0xff / s.width)
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+	return img
+}
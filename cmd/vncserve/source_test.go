@@ -0,0 +1,116 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestImageSource(t *testing.T) {
+	a := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	b := image.NewRGBA(image.Rect(5, 5, 7, 7)) // Different origin, same size.
+	src, err := newImageSource([]image.Image{a, b}, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := src.Bounds(), image.Rect(0, 0, 2, 2); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+	if src.Frame(0) != src.Frame(2) || src.Frame(0) == src.Frame(1) {
+		t.Errorf("frames do not cycle")
+	}
+	if got, want := src.Interval(), time.Second; got != want {
+		t.Errorf("Interval() = %s, want %s", got, want)
+	}
+
+	single, err := newImageSource([]image.Image{a}, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := single.Interval(), time.Duration(0); got != want {
+		t.Errorf("single image Interval() = %s, want %s", got, want)
+	}
+
+	if _, err := newImageSource([]image.Image{a, image.NewRGBA(image.Rect(0, 0, 3, 2))}, 0); err == nil {
+		t.Errorf("expected error for mismatched sizes")
+	}
+	if _, err := newImageSource(nil, 0); err == nil {
+		t.Errorf("expected error for no images")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vncserve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, name := range []string{"b.png", "a.png"} {
+		img := image.NewRGBA(image.Rect(0, 0, i+1, 1))
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	imgs, err := readDir(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := len(imgs), 2; got != want {
+		t.Fatalf("len(imgs) = %d, want %d", got, want)
+	}
+	// Sorted by name, so a.png (2 pixels wide) comes first.
+	if got, want := imgs[0].Bounds().Dx(), 2; got != want {
+		t.Errorf("first image width = %d, want %d", got, want)
+	}
+}
+
+func TestPatternSource(t *testing.T) {
+	src := newPatternSource(16, 8, time.Second)
+	f0, f1 := src.Frame(0), src.Frame(1)
+	if got, want := f0.Bounds(), image.Rect(0, 0, 16, 8); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+	// The bars scroll left by a pixel each frame.
+	if got, want := f1.RGBAAt(0, 0), f0.RGBAAt(1, 0); got != want {
+		t.Errorf("frame 1 (0, 0) = %v, want %v", got, want)
+	}
+	if f0.RGBAAt(1, 0) == f0.RGBAAt(2, 0) {
+		t.Errorf("expected a bar boundary between (1, 0) and (2, 0)")
+	}
+}
+
+func TestAnimate(t *testing.T) {
+	src := newPatternSource(16, 8, time.Millisecond)
+	fs := animate(src)
+	f0, seq := fs.Frame()
+	if got, want := f0.RGBAAt(0, 0), src.Frame(0).RGBAAt(0, 0); got != want {
+		t.Errorf("incorrect first frame pixel; got = %v, want = %v", got, want)
+	}
+	select {
+	case <-fs.Changed(seq):
+	case <-time.After(5 * time.Second):
+		t.Fatal("frame never changed")
+	}
+
+	static := animate(newPatternSource(16, 8, 0))
+	_, seq = static.Frame()
+	select {
+	case <-static.Changed(seq):
+		t.Error("static source changed")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
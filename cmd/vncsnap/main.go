@@ -0,0 +1,293 @@
+/*
+The vncsnap command connects to a VNC server, captures one full framebuffer,
+and writes it as a PNG or JPEG image, or draws it to a terminal with ANSI
+escape sequences, e.g. for a quick look over SSH:
+
+	vncsnap -format ansi -o - host:port
+
+Usage:
+
+	vncsnap [flags] host:port
+
+The password is taken from the -password_file flag, or else the VNC_PASSWORD
+environment variable. Without a password, only the None security type is
+offered.
+
+The exit status is 0 on success, 1 for usage errors, 2 if the connection
+failed, 3 if authentication failed, 4 on timeout, and 5 if the image couldn't
+be written.
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/cmd/internal/cmdutil"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// Exit statuses.
+const (
+	exitOK = iota
+	exitUsage
+	exitConnect
+	exitAuth
+	exitTimeout
+	exitWrite
+)
+
+var (
+	output       = flag.String("o", "snapshot.png", "Output file, or - for stdout.")
+	format       = flag.String("format", "", "Image format (png, jpeg or ansi). Inferred from the output file name if unset.")
+	quality      = flag.Int("quality", jpeg.DefaultQuality, "JPEG quality (1-100).")
+	columns      = flag.Int("columns", vnc.DefaultANSIColumns, "Width of the ansi format, in characters.")
+	passwordFile = flag.String("password_file", "", "File containing the VNC password.")
+	exclusive    = flag.Bool("exclusive", false, "Request exclusive access to the desktop.")
+	timeout      = flag.Duration("timeout", 30*time.Second, "Timeout for the whole capture.")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] host:port\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	imgFormat, err := imageFormat(*output, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vncsnap: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	password, err := cmdutil.ReadPassword(*passwordFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vncsnap: %s\n", err)
+		os.Exit(exitUsage)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	img, err := snap(ctx, flag.Arg(0), password)
+	if err == nil {
+		err = save(*output, img, imgFormat, *quality)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vncsnap: %s\n", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// snap connects to the server at addr, and captures the framebuffer.
+func snap(ctx context.Context, addr, password string) (image.Image, error) {
+	cfg := cmdutil.NewClientConfig(password)
+	cfg.Exclusive = *exclusive
+	vc, err := cmdutil.Connect(ctx, addr, cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer vc.Close()
+
+	if err := vc.SetEncodings(vnc.Encodings{&vnc.RawEncoding{}, &vnc.DesktopSizePseudoEncoding{}}); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() { done <- vc.ListenAndHandle() }()
+
+	fb, err := capture(ctx, vc, cfg.ServerMessageCh, done)
+	if err != nil {
+		return nil, err
+	}
+	return fb.Image(), nil
+}
+
+// capture requests the full framebuffer, and waits until the updates received
+// cover all of it. Servers may answer with several updates, or only part of
+// the framebuffer, so the pixels still missing are requested again after each
+// update. A resize restarts the capture.
+func capture(ctx context.Context, vc *vnc.ClientConn, msgs <-chan vnc.ServerMessage, done <-chan error) (*vnc.Framebuffer, error) {
+	w, h := vc.FramebufferWidth(), vc.FramebufferHeight()
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, w, h); err != nil {
+		return nil, err
+	}
+
+	fb := vnc.NewFramebuffer(int(w), int(h))
+	cov := newCoverage(fb.Width, fb.Height)
+	for {
+		select {
+		case msg := <-msgs:
+			fu, ok := msg.(*vnc.FramebufferUpdate)
+			if !ok {
+				continue
+			}
+			for i := range fu.Rects {
+				rect := &fu.Rects[i]
+				if err := fb.Apply(rect); err != nil {
+					return nil, err
+				}
+				if rect.IsResize() {
+					cov = newCoverage(fb.Width, fb.Height)
+					continue
+				}
+				cov.add(rect)
+			}
+			missing := cov.missing()
+			if missing.Empty() {
+				return fb, nil
+			}
+			if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse,
+				uint16(missing.Min.X), uint16(missing.Min.Y), uint16(missing.Dx()), uint16(missing.Dy())); err != nil {
+				return nil, err
+			}
+		case err := <-done:
+			if err == nil {
+				err = vnc.ErrClosed
+			}
+			return nil, fmt.Errorf("connection ended before the framebuffer was received: %w", err)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// coverage tracks the pixels of a framebuffer received.
+type coverage struct {
+	width, height int
+	covered       []bool
+	n             int // The number of pixels covered.
+}
+
+func newCoverage(width, height int) *coverage {
+	return &coverage{width: width, height: height, covered: make([]bool, width*height)}
+}
+
+// add marks the pixels of the rectangle as received. Pseudo-encodings hold
+// no pixels.
+func (c *coverage) add(rect *vnc.Rectangle) {
+	if rect.Enc == nil || rect.Enc.Type() < 0 {
+		return
+	}
+	r := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+	r = r.Intersect(image.Rect(0, 0, c.width, c.height))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if i := y*c.width + x; !c.covered[i] {
+				c.covered[i] = true
+				c.n++
+			}
+		}
+	}
+}
+
+// missing returns the bounds of the pixels not yet received.
+func (c *coverage) missing() image.Rectangle {
+	var r image.Rectangle
+	if c.n == len(c.covered) {
+		return r
+	}
+	for i, ok := range c.covered {
+		if !ok {
+			x, y := i%c.width, i/c.width
+			r = r.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	return r
+}
+
+// writeError is returned when the image couldn't be written.
+type writeError struct {
+	err error
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+// save writes the image to path, or stdout if path is "-".
+func save(path string, img image.Image, format string, quality int) error {
+	if path == "-" {
+		if err := writeImage(os.Stdout, img, format, quality); err != nil {
+			return &writeError{err}
+		}
+		return nil
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return &writeError{err}
+	}
+	if err := writeImage(f, img, format, quality); err != nil {
+		f.Close()
+		os.Remove(path)
+		return &writeError{err}
+	}
+	if err := f.Close(); err != nil {
+		return &writeError{err}
+	}
+	return nil
+}
+
+// writeImage encodes the image in the given format.
+func writeImage(w io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(w, img)
+	case "jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "ansi":
+		return vnc.RenderANSI(w, img, *columns)
+	}
+	return fmt.Errorf("unsupported image format %q", format)
+}
+
+// imageFormat returns the image format to write, which is either given
+// explicitly, or inferred from the extension of the output path.
+func imageFormat(path, format string) (string, error) {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if path == "-" {
+			format = "png"
+		}
+	}
+	switch format {
+	case "png":
+		return "png", nil
+	case "jpg", "jpeg":
+		return "jpeg", nil
+	case "ansi":
+		return "ansi", nil
+	}
+	return "", fmt.Errorf("unsupported image format %q; must be png, jpeg or ansi", format)
+}
+
+// exitCode returns the exit status for an error.
+func exitCode(err error) int {
+	var werr *writeError
+	var nerr net.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &werr):
+		return exitWrite
+	case errors.Is(err, vnc.ErrAuthFailed):
+		return exitAuth
+	case errors.Is(err, vnc.ErrTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &nerr) && nerr.Timeout():
+		return exitTimeout
+	}
+	return exitConnect
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/kward/go-vnc"
+	"golang.org/x/net/context"
+)
+
+func TestImageFormat(t *testing.T) {
+	for _, tt := range []struct {
+		path, format string
+		want         string
+		ok           bool
+	}{
+		{"out.png", "", "png", true},
+		{"out.PNG", "", "png", true},
+		{"out.jpg", "", "jpeg", true},
+		{"out.jpeg", "", "jpeg", true},
+		{"out.png", "jpeg", "jpeg", true},
+		{"-", "", "png", true},
+		{"-", "jpg", "jpeg", true},
+		{"-", "ansi", "ansi", true},
+		{"out.gif", "", "", false},
+		{"out", "", "", false},
+		{"out.png", "bmp", "", false},
+	} {
+		got, err := imageFormat(tt.path, tt.format)
+		if err == nil && !tt.ok {
+			t.Errorf("imageFormat(%q, %q) expected error", tt.path, tt.format)
+			continue
+		}
+		if err != nil && tt.ok {
+			t.Errorf("imageFormat(%q, %q) unexpected error: %s", tt.path, tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("imageFormat(%q, %q) = %q, want = %q", tt.path, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestWriteImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(1, 1, color.RGBA{0xff, 0, 0, 0xff})
+
+	var buf bytes.Buffer
+	if err := writeImage(&buf, img, "png", 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dec, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := color.RGBAModel.Convert(dec.At(1, 1)), (color.RGBA{0xff, 0, 0, 0xff}); got != want {
+		t.Errorf("pixel = %v, want = %v", got, want)
+	}
+
+	buf.Reset()
+	if err := writeImage(&buf, img, "jpeg", 90); err != nil || buf.Len() == 0 {
+		t.Errorf("jpeg: unexpected error: %v", err)
+	}
+	buf.Reset()
+	if err := writeImage(&buf, img, "ansi", 0); err != nil || buf.Len() == 0 {
+		t.Errorf("ansi: unexpected error: %v", err)
+	}
+	if err := writeImage(&buf, img, "gif", 0); err == nil {
+		t.Error("gif: expected error")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{errors.New("connection refused"), exitConnect},
+		{fmt.Errorf("handshake: %w", vnc.ErrAuthFailed), exitAuth},
+		{fmt.Errorf("handshake: %w", vnc.ErrTimeout), exitTimeout},
+		{context.DeadlineExceeded, exitTimeout},
+		{&writeError{errors.New("disk full")}, exitWrite},
+	} {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %d, want = %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	c := newCoverage(4, 3)
+	if got, want := c.missing(), image.Rect(0, 0, 4, 3); got != want {
+		t.Errorf("missing() = %v, want %v", got, want)
+	}
+	for _, tt := range []struct {
+		desc string
+		rect vnc.Rectangle
+		want image.Rectangle
+	}{
+		{"pseudo-encoding", vnc.Rectangle{Width: 4, Height: 3, Enc: &vnc.CursorPseudoEncoding{}}, image.Rect(0, 0, 4, 3)},
+		{"top rows", vnc.Rectangle{Width: 4, Height: 2, Enc: &vnc.RawEncoding{}}, image.Rect(0, 2, 4, 3)},
+		{"overlapping", vnc.Rectangle{X: 1, Y: 1, Width: 2, Height: 2, Enc: &vnc.RawEncoding{}}, image.Rect(0, 2, 4, 3)},
+		{"partial row", vnc.Rectangle{Y: 2, Width: 3, Height: 1, Enc: &vnc.RawEncoding{}}, image.Rect(3, 2, 4, 3)},
+		{"complete", vnc.Rectangle{X: 3, Y: 2, Width: 1, Height: 1, Enc: &vnc.CopyRectEncoding{}}, image.Rectangle{}},
+	} {
+		c.add(&tt.rect)
+		if got := c.missing(); got != tt.want {
+			t.Errorf("%s: missing() = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Coalescing of the messages sent to the server.
+
+package vnc
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// coalesceLimit is the number of bytes buffered by a writeCoalescer, beyond
+// which they are written without waiting for the delay.
+const coalesceLimit = 16 << 10 // 16 KiB
+
+// writeCoalescer buffers the small writes made close together, e.g. the key
+// and pointer events of bursts of input, and writes them with a single write
+// once the delay since the first has passed. This reduces the syscalls and
+// packets sent, at the cost of the delay.
+//
+// As writes return before the data is written, the error of a delayed write
+// is returned by the next write, or by flush.
+type writeCoalescer struct {
+	w     io.Writer
+	delay time.Duration
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	err   error // The error of the last delayed write.
+}
+
+func newWriteCoalescer(w io.Writer, delay time.Duration) *writeCoalescer {
+	return &writeCoalescer{w: w, delay: delay}
+}
+
+// Write implements the io.Writer interface.
+func (wc *writeCoalescer) Write(b []byte) (int, error) {
+	if err := wc.writeBuffers(net.Buffers{b}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// writeBuffers buffers the buffers of a message, in one go, so the message
+// isn't interleaved with others written concurrently.
+func (wc *writeCoalescer) writeBuffers(bufs net.Buffers) error {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if err := wc.err; err != nil {
+		wc.err = nil
+		return err
+	}
+	for _, b := range bufs {
+		wc.buf = append(wc.buf, b...)
+	}
+	if len(wc.buf) >= coalesceLimit {
+		return wc.flushLocked()
+	}
+	if wc.timer == nil {
+		wc.timer = time.AfterFunc(wc.delay, wc.flushDelayed)
+	}
+	return nil
+}
+
+// flush writes the buffered data now, and returns the error of the write, or
+// of an earlier delayed write.
+func (wc *writeCoalescer) flush() error {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if err := wc.err; err != nil {
+		wc.err = nil
+		return err
+	}
+	return wc.flushLocked()
+}
+
+// flushDelayed writes the buffered data once the delay has passed.
+func (wc *writeCoalescer) flushDelayed() {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	if err := wc.flushLocked(); err != nil {
+		wc.err = err
+	}
+}
+
+func (wc *writeCoalescer) flushLocked() error {
+	if wc.timer != nil {
+		wc.timer.Stop()
+		wc.timer = nil
+	}
+	if len(wc.buf) == 0 {
+		return nil
+	}
+	_, err := wc.w.Write(wc.buf)
+	wc.buf = wc.buf[:0]
+	return err
+}
+
+// writer returns the writer of the messages sent to the server.
+func (c *ClientConn) writer() io.Writer {
+	if c.coalescer != nil {
+		return c.coalescer
+	}
+	return c.c
+}
+
+// Flush writes the messages held back by ClientConfig.CoalesceWrites now,
+// e.g. before waiting for the response of the server to them. It returns the
+// error of writing them, or of an earlier write of the held back messages.
+func (c *ClientConn) Flush() error {
+	if c.coalescer == nil {
+		return nil
+	}
+	if err := c.coalescer.flush(); err != nil {
+		return connError(err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package vnc
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// recordingWriter records each write made to it.
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes [][]byte
+	err    error
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (w *recordingWriter) get() [][]byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writes
+}
+
+func TestWriteCoalescer(t *testing.T) {
+	w := &recordingWriter{}
+	wc := newWriteCoalescer(w, 10*time.Millisecond)
+	for _, b := range []string{"ab", "cd", "ef"} {
+		if _, err := wc.Write([]byte(b)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if got := len(w.get()); got != 0 {
+		t.Fatalf("incorrect number of writes before the delay; got = %v, want = 0", got)
+	}
+	for start := time.Now(); len(w.get()) == 0; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("writes never flushed")
+		}
+	}
+	if got, want := w.get(), [][]byte{[]byte("abcdef")}; len(got) != 1 || !bytes.Equal(got[0], want[0]) {
+		t.Errorf("incorrect writes; got = %q, want = %q", got, want)
+	}
+}
+
+func TestWriteCoalescer_Limit(t *testing.T) {
+	w := &recordingWriter{}
+	wc := newWriteCoalescer(w, time.Hour)
+	if _, err := wc.Write([]byte("ab")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := wc.Write(make([]byte, coalesceLimit)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got := w.get()
+	if len(got) != 1 {
+		t.Fatalf("incorrect number of writes; got = %v, want = 1", len(got))
+	}
+	if got, want := len(got[0]), 2+coalesceLimit; got != want {
+		t.Errorf("incorrect length of write; got = %v, want = %v", got, want)
+	}
+}
+
+func TestWriteCoalescer_Flush(t *testing.T) {
+	w := &recordingWriter{}
+	wc := newWriteCoalescer(w, time.Hour)
+	if err := wc.flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := wc.Write([]byte("ab")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := wc.flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := w.get(), [][]byte{[]byte("ab")}; len(got) != 1 || !bytes.Equal(got[0], want[0]) {
+		t.Errorf("incorrect writes; got = %q, want = %q", got, want)
+	}
+}
+
+func TestWriteCoalescer_Error(t *testing.T) {
+	errWrite := errors.New("write failed")
+	w := &recordingWriter{err: errWrite}
+
+	wc := newWriteCoalescer(w, time.Hour)
+	if _, err := wc.Write([]byte("ab")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := wc.flush(); err != errWrite {
+		t.Errorf("incorrect flush error; got = %v, want = %v", err, errWrite)
+	}
+
+	// The error of a delayed write is returned by the next write.
+	wc = newWriteCoalescer(w, time.Millisecond)
+	if _, err := wc.Write([]byte("ab")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var err error
+	for start := time.Now(); err == nil; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("delayed write error never returned")
+		}
+		wc.mu.Lock()
+		pending := wc.timer != nil
+		wc.mu.Unlock()
+		if !pending {
+			_, err = wc.Write([]byte("cd"))
+		}
+	}
+	if err != errWrite {
+		t.Errorf("incorrect write error; got = %v, want = %v", err, errWrite)
+	}
+}
+
+func TestClientConn_CoalesceWrites(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.coalescer = newWriteCoalescer(mockConn, time.Hour)
+
+	SetSettle(0) // Disable UI settling for tests.
+	for _, down := range []bool{PressKey, ReleaseKey} {
+		if err := conn.KeyEvent(keys.Digit0, down); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if got, want := mockConn.b.Len(), 0; got != want {
+		t.Fatalf("messages written before flush; got %v bytes", got)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, down := range []bool{PressKey, ReleaseKey} {
+		var msg KeyEventMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := msg.Key, keys.Digit0; got != want {
+			t.Errorf("incorrect key; got = %v, want = %v", got, want)
+		}
+		if got, want := rfbflags.ToBool(msg.DownFlag), down; got != want {
+			t.Errorf("incorrect down-flag; got = %v, want = %v", got, want)
+		}
+	}
+}
@@ -5,18 +5,52 @@ package vnc
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"time"
 )
 
+// Errors returned by the client. They are usually wrapped with additional
+// context, so test for them with errors.Is.
+var (
+	// ErrAuthFailed indicates that the server rejected authentication.
+	ErrAuthFailed = errors.New("authentication failed")
+	// ErrClosed indicates that the connection was closed.
+	ErrClosed = errors.New("connection closed")
+	// ErrLimitExceeded indicates that a message exceeded a configured Limit.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrProtocol indicates that the server violated the RFB protocol.
+	ErrProtocol = errors.New("protocol violation")
+	// ErrTimeout indicates that a network operation timed out.
+	ErrTimeout = errors.New("timeout")
+	// ErrUnsupportedEncoding indicates that a rectangle used an encoding which
+	// the client doesn't support.
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+	// ErrUnsupportedMessage indicates that the server sent a message-type
+	// which the client doesn't support.
+	ErrUnsupportedMessage = errors.New("unsupported message-type")
+	// ErrUnsupportedSecurity indicates that no security type supported by
+	// both the client and server was found.
+	ErrUnsupportedSecurity = errors.New("unsupported security type")
+	// ErrUnsupportedVersion indicates that the server protocol version is not
+	// supported.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	// ErrViewOnly indicates that input wasn't sent to the server, as the
+	// connection is view-only.
+	ErrViewOnly = errors.New("view-only")
+)
+
 // VNCError implements error interface.
 type VNCError struct {
 	desc string
+	err  error // Wrapped error, if any.
 }
 
 // NewVNCError returns a custom VNCError error.
 func NewVNCError(desc string) error {
-	return &VNCError{desc}
+	return &VNCError{desc: desc}
 }
 
 // Error returns an VNCError as a string.
@@ -24,12 +58,91 @@ func (e VNCError) Error() string {
 	return e.desc
 }
 
+// Unwrap returns the error wrapped by a VNCError, if any.
+func (e *VNCError) Unwrap() error {
+	return e.err
+}
+
 func Errorf(format string, a ...interface{}) error {
 	return &VNCError{
 		desc: fmt.Sprintf(format, a...),
 	}
 }
 
+// wrapErrorf returns a VNCError which wraps err.
+func wrapErrorf(err error, format string, a ...interface{}) error {
+	return &VNCError{
+		desc: fmt.Sprintf(format, a...),
+		err:  err,
+	}
+}
+
+// ProtocolError indicates that the server violated the RFB protocol.
+type ProtocolError struct {
+	desc string
+}
+
+// Error implements the error interface.
+func (e *ProtocolError) Error() string {
+	return "protocol violation: " + e.desc
+}
+
+// Unwrap returns ErrProtocol.
+func (e *ProtocolError) Unwrap() error {
+	return ErrProtocol
+}
+
+func protocolErrorf(format string, a ...interface{}) error {
+	return &ProtocolError{
+		desc: fmt.Sprintf(format, a...),
+	}
+}
+
+// ConnError is returned for errors of the underlying network connection.
+type ConnError struct {
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ConnError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the network error.
+func (e *ConnError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is ErrClosed or ErrTimeout.
+func (e *ConnError) Is(target error) bool {
+	switch target {
+	case ErrClosed:
+		return e.Err == io.EOF || e.Err == io.ErrUnexpectedEOF || errors.Is(e.Err, net.ErrClosed)
+	case ErrTimeout:
+		var nerr net.Error
+		return errors.As(e.Err, &nerr) && nerr.Timeout()
+	}
+	return false
+}
+
+// connError wraps a non-nil error of the underlying network connection.
+func connError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ConnError{err}
+}
+
+// isZero returns true if all the bytes are zero.
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 var settleDuration = 25 * time.Millisecond
 
 // Settle returns the UI settle duration.
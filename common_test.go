@@ -2,13 +2,83 @@ package vnc
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/kward/go-vnc/encodings"
 	"github.com/kward/go-vnc/go/operators"
 )
 
+func TestErrors(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{Strict: true})
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	for _, tt := range []struct {
+		desc string
+		msg  ServerMessage
+		data []interface{}
+		want error
+	}{
+		{"unsupported encoding", &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(1), rectangleMessage{0, 0, 1, 1, encodings.Hextile}}, ErrUnsupportedEncoding},
+		{"limit exceeded", &ServerCutText{},
+			[]interface{}{[3]byte{}, uint32(DefaultMaxCutTextLength + 1)}, ErrLimitExceeded},
+		{"protocol violation", &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(1), rectangleMessage{5, 5, 10, 10, encodings.Raw}}, ErrProtocol},
+		{"connection closed", &ServerCutText{},
+			[]interface{}{[3]byte{}, uint32(10)}, ErrClosed},
+	} {
+		mockConn.Reset()
+		for _, d := range tt.data {
+			if err := conn.send(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := tt.msg.Read(conn)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want errors.Is(%v)", tt.desc, err, tt.want)
+		}
+	}
+}
+
+func TestConnError(t *testing.T) {
+	for _, tt := range []struct {
+		err     error
+		closed  bool
+		timeout bool
+	}{
+		{io.EOF, true, false},
+		{io.ErrUnexpectedEOF, true, false},
+		{net.ErrClosed, true, false},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, false, true},
+		{errors.New("other"), false, false},
+	} {
+		err := connError(tt.err)
+		if got, want := errors.Is(err, ErrClosed), tt.closed; got != want {
+			t.Errorf("%v: errors.Is(ErrClosed) = %v, want = %v", tt.err, got, want)
+		}
+		if got, want := errors.Is(err, ErrTimeout), tt.timeout; got != want {
+			t.Errorf("%v: errors.Is(ErrTimeout) = %v, want = %v", tt.err, got, want)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: wrapped error lost", tt.err)
+		}
+	}
+	if connError(nil) != nil {
+		t.Error("connError(nil) != nil")
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
 func TestBuffer_Read(t *testing.T) {
 	var (
 		buf    *Buffer
@@ -0,0 +1,226 @@
+// Workarounds for the quirks of VNC server implementations.
+
+package vnc
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/logging"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// Profile selects the workarounds used for the quirks of a VNC server
+// implementation.
+type Profile int
+
+// Known profiles.
+const (
+	// ProfileStandard uses no workarounds.
+	ProfileStandard Profile = iota
+	// ProfileAuto detects the server implementation where it can, from its
+	// ProtocolVersion, and otherwise uses ProfileStandard.
+	ProfileAuto
+	// ProfileApple is for macOS Screen Sharing and Apple Remote Desktop,
+	// which announce protocol version 3.889. The 3.889 version is echoed
+	// back, as the servers expect, rather than 3.8; and the conventional
+	// 32-bit little-endian true-color pixel format (PixelFormatApple) is
+	// requested, rather than echoing the pixel format announced by the
+	// server, which doesn't reliably honor it. The Apple pseudo-encodings
+	// are undocumented, and aren't advertised, so the servers only send the
+	// standard encodings.
+	ProfileApple
+	// ProfileUltraVNC is for UltraVNC servers. The UltraVNC server messages
+	// (see ultravnc.go) are read, rather than aborting the connection, and
+	// the ServerState and EnableKeepAlive pseudo-encodings are advertised,
+	// so the server reports its state and keeps idle connections alive.
+	// The UltraVNC cache and Ultra encodings are never advertised, as they
+	// aren't supported.
+	ProfileUltraVNC
+	// ProfileRealVNC is for RealVNC Server 4 and later, which announce
+	// protocol versions 4.1 and 5.0. RealVNC servers list their proprietary
+	// security types (e.g. RA2) first, so the security type is chosen in the
+	// order of ClientConfig.Auth rather than in the order listed by the
+	// server. RealVNC servers also strictly use the first listed encoding
+	// which they support, so Raw is advertised last.
+	ProfileRealVNC
+)
+
+var profileNames = map[Profile]string{
+	ProfileStandard: "Standard",
+	ProfileAuto:     "Auto",
+	ProfileApple:    "Apple",
+	ProfileUltraVNC: "UltraVNC",
+	ProfileRealVNC:  "RealVNC",
+}
+
+func (p Profile) String() string {
+	if name, ok := profileNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Profile(%d)", int(p))
+}
+
+// PixelFormatApple is the pixel format requested by ProfileApple.
+var PixelFormatApple = PixelFormat{
+	BPP:        32,
+	Depth:      24,
+	BigEndian:  rfbflags.RFBFalse,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     0xff,
+	GreenMax:   0xff,
+	BlueMax:    0xff,
+	RedShift:   16,
+	GreenShift: 8,
+	BlueShift:  0,
+}
+
+// Profile returns the profile in use, once the ProtocolVersion handshake has
+// detected the server implementation.
+func (c *ClientConn) Profile() Profile {
+	return c.profile
+}
+
+// detectProfile sets the profile of the connection, from the configured
+// profile and the protocol version announced by the server.
+func (c *ClientConn) detectProfile() {
+	c.profile = c.config.Profile
+	if c.profile == ProfileAuto {
+		switch {
+		case c.serverVersion == ProtocolVersionApple:
+			c.profile = ProfileApple
+		case c.serverVersion == ProtocolVersionRealVNC4, c.serverVersion == ProtocolVersionRealVNC5:
+			c.profile = ProfileRealVNC
+		default:
+			c.profile = ProfileStandard
+		}
+	}
+	if logging.V(logging.ResultLevel) {
+		glog.Infof("profile: %v", c.profile)
+	}
+}
+
+// protocolVersionReply returns the ProtocolVersion message sent to the
+// server, for the negotiated version pv.
+func (c *ClientConn) protocolVersionReply(pv string) string {
+	if c.profile == ProfileApple && c.serverVersion == ProtocolVersionApple && pv == PROTO_VERS_3_8 {
+		return fmt.Sprintf("RFB %03d.%03d\n", ProtocolVersionApple.Major, ProtocolVersionApple.Minor)
+	}
+	return pv
+}
+
+// chooseAuth returns the ClientAuth used with the security types supported by
+// the server, or nil if none is configured.
+func (c *ClientConn) chooseAuth(securityTypes []uint8) ClientAuth {
+	if c.profile == ProfileRealVNC {
+		for _, a := range c.config.Auth {
+			for _, securityType := range securityTypes {
+				if a.SecurityType() == securityType {
+					return a
+				}
+			}
+		}
+		return nil
+	}
+	// Use the first configured security type listed by the server.
+	for _, securityType := range securityTypes {
+		for _, a := range c.config.Auth {
+			if a.SecurityType() == securityType {
+				return a
+			}
+		}
+	}
+	return nil
+}
+
+// realVNCSecurityOnly returns true if the security types only include the
+// proprietary RealVNC security types: RA2, RA2ne, and types 128 to 255.
+func realVNCSecurityOnly(securityTypes []uint8) bool {
+	for _, t := range securityTypes {
+		if t != secTypeRA2 && t != secTypeRA2ne && t < 128 {
+			return false
+		}
+	}
+	return true
+}
+
+// initialPixelFormat returns the pixel format requested once connected.
+func (c *ClientConn) initialPixelFormat() PixelFormat {
+	if c.profile == ProfileApple {
+		return PixelFormatApple
+	}
+	return c.pixelFormat
+}
+
+// profileServerMessages returns the server messages read for the profile, in
+// addition to the configured ServerMessages.
+func (c *ClientConn) profileServerMessages() []ServerMessage {
+	if c.profile == ProfileUltraVNC {
+		return ultraServerMessages
+	}
+	return nil
+}
+
+// profileEncodings returns the encodings to advertise, given those requested.
+func (c *ClientConn) profileEncodings(encs Encodings) Encodings {
+	if c.profile == ProfileUltraVNC {
+		encs = withEncodings(encs, encodings.UltraServerStatePseudo, encodings.UltraEnableKeepAlivePseudo)
+	}
+	if c.profile == ProfileRealVNC {
+		encs = rawLast(encs)
+	}
+	return encs
+}
+
+// rawLast returns a copy of encs with the Raw encoding moved to the end.
+func rawLast(encs Encodings) Encodings {
+	var raw Encodings
+	out := make(Encodings, 0, len(encs))
+	for _, e := range encs {
+		if e.Type() == encodings.Raw {
+			raw = append(raw, e)
+			continue
+		}
+		out = append(out, e)
+	}
+	return append(out, raw...)
+}
+
+// withEncodings returns encs, with advertisements of the pseudo-encodings
+// appended where encs lacks them.
+func withEncodings(encs Encodings, pseudos ...encodings.Encoding) Encodings {
+	have := map[encodings.Encoding]bool{}
+	for _, e := range encs {
+		have[e.Type()] = true
+	}
+	for _, p := range pseudos {
+		if !have[p] {
+			encs = append(encs, &advertisedEncoding{p})
+		}
+	}
+	return encs
+}
+
+// advertisedEncoding advertises support for a pseudo-encoding which the server
+// never sends as a rectangle.
+type advertisedEncoding struct {
+	t encodings.Encoding
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*advertisedEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*advertisedEncoding) Marshal() ([]byte, error) { return []byte{}, nil }
+
+// Read implements the Encoding interface.
+func (e *advertisedEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, protocolErrorf("unexpected rectangle with %v", e.t)
+}
+
+// String implements the fmt.Stringer interface.
+func (e *advertisedEncoding) String() string { return e.t.String() }
+
+// Type implements the Encoding interface.
+func (e *advertisedEncoding) Type() encodings.Encoding { return e.t }
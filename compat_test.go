@@ -0,0 +1,109 @@
+package vnc
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+func TestClientConn_InitialPixelFormat(t *testing.T) {
+	server := NewPixelFormat(16)
+	for _, tt := range []struct {
+		profile Profile
+		want    PixelFormat
+	}{
+		{ProfileStandard, server},
+		{ProfileApple, PixelFormatApple},
+	} {
+		conn := NewClientConn(&MockConn{}, &ClientConfig{})
+		conn.pixelFormat, conn.profile = server, tt.profile
+		if got, want := conn.initialPixelFormat(), tt.want; got != want {
+			t.Errorf("%v: initialPixelFormat() = %v, want = %v", tt.profile, got, want)
+		}
+	}
+}
+
+func TestProfile_String(t *testing.T) {
+	for _, tt := range []struct {
+		profile Profile
+		want    string
+	}{
+		{ProfileStandard, "Standard"},
+		{ProfileApple, "Apple"},
+		{ProfileUltraVNC, "UltraVNC"},
+		{ProfileRealVNC, "RealVNC"},
+		{Profile(99), "Profile(99)"},
+	} {
+		if got, want := tt.profile.String(), tt.want; got != want {
+			t.Errorf("String() = %q, want = %q", got, want)
+		}
+	}
+}
+
+func TestClientConn_ChooseAuth(t *testing.T) {
+	none, vncAuth := &ClientAuthNone{}, &ClientAuthVNC{"."}
+	for _, tt := range []struct {
+		desc     string
+		profile  Profile
+		secTypes []uint8
+		auth     []ClientAuth
+		want     ClientAuth
+	}{
+		{"standard", ProfileStandard, []uint8{secTypeNone, secTypeVNCAuth}, []ClientAuth{vncAuth, none}, none},
+		{"realvnc", ProfileRealVNC, []uint8{secTypeNone, secTypeVNCAuth}, []ClientAuth{vncAuth, none}, vncAuth},
+		{"realvnc proprietary first", ProfileRealVNC, []uint8{secTypeRA2, secTypeRA2ne, secTypeVNCAuth}, []ClientAuth{vncAuth}, vncAuth},
+		{"none matching", ProfileRealVNC, []uint8{secTypeRA2}, []ClientAuth{vncAuth}, nil},
+	} {
+		conn := NewClientConn(&MockConn{}, &ClientConfig{Auth: tt.auth})
+		conn.profile = tt.profile
+		if got, want := conn.chooseAuth(tt.secTypes), tt.want; got != want {
+			t.Errorf("%s: chooseAuth() = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestSecurityHandshake38_RealVNC(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{Auth: []ClientAuth{&ClientAuthNone{}}})
+	conn.protocolVersion, conn.profile = PROTO_VERS_3_8, ProfileRealVNC
+	if err := conn.send([]byte{2, secTypeRA2, 130}); err != nil {
+		t.Fatal(err)
+	}
+	err := conn.securityHandshake38()
+	if !errors.Is(err, ErrUnsupportedSecurity) {
+		t.Fatalf("error = %v, want %v", err, ErrUnsupportedSecurity)
+	}
+	if !strings.Contains(err.Error(), "RealVNC") {
+		t.Errorf("error %q doesn't mention RealVNC", err)
+	}
+}
+
+func TestRealVNCSecurityOnly(t *testing.T) {
+	for _, tt := range []struct {
+		secTypes []uint8
+		want     bool
+	}{
+		{[]uint8{secTypeRA2, secTypeRA2ne, 128, 255}, true},
+		{[]uint8{secTypeRA2, secTypeVNCAuth}, false},
+		{[]uint8{secTypeNone}, false},
+	} {
+		if got, want := realVNCSecurityOnly(tt.secTypes), tt.want; got != want {
+			t.Errorf("realVNCSecurityOnly(%v) = %v, want = %v", tt.secTypes, got, want)
+		}
+	}
+}
+
+func TestClientConn_ProfileEncodings_RealVNC(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.profile = ProfileRealVNC
+	encs := conn.profileEncodings(Encodings{&RawEncoding{}, &CopyRectEncoding{}, &DesktopSizePseudoEncoding{}})
+	var got []encodings.Encoding
+	for _, e := range encs {
+		got = append(got, e.Type())
+	}
+	if want := []encodings.Encoding{encodings.CopyRect, encodings.DesktopSizePseudo, encodings.Raw}; !reflect.DeepEqual(got, want) {
+		t.Errorf("encodings = %v, want = %v", got, want)
+	}
+}
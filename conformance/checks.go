@@ -0,0 +1,180 @@
+// The checks of the behavior of servers.
+
+package conformance
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// DefaultChecks returns the checks run by default:
+//
+//   - full-update: a non-incremental update covers the whole framebuffer.
+//   - encodings: the rectangles of updates are only of the encodings
+//     requested.
+//   - resize: the server supporting ExtendedDesktopSize announces the size of
+//     the framebuffer, and then sends rectangles within it.
+//   - clipboard: cut text sent to the server comes back unchanged, if the
+//     server sends it back at all.
+//   - color-map: the server sends the color map of a color map pixel format
+//     before the pixels of an update.
+func (s *Suite) DefaultChecks() []Check {
+	return []Check{
+		{"full-update", checkFullUpdate},
+		{"encodings", checkEncodings},
+		{"resize", checkResize},
+		{"clipboard", s.checkClipboard},
+		{"color-map", checkColorMap},
+	}
+}
+
+// bounds returns the bounds of the rectangle, which must be within the
+// framebuffer of size w x h.
+func bounds(i int, r *vnc.Rectangle, w, h uint16) error {
+	if int(r.X)+int(r.Width) > int(w) || int(r.Y)+int(r.Height) > int(h) {
+		return fmt.Errorf("rectangle %d at (%d, %d) of %dx%d exceeds the %dx%d framebuffer",
+			i, r.X, r.Y, r.Width, r.Height, w, h)
+	}
+	return nil
+}
+
+func checkFullUpdate(ctx context.Context, c *Conn) error {
+	c.Start()
+	fu, err := c.Update(ctx, false)
+	if err != nil {
+		return err
+	}
+	w, h := c.FramebufferWidth(), c.FramebufferHeight()
+	covered := make([]bool, int(w)*int(h))
+	n := 0
+	for i := range fu.Rects {
+		r := &fu.Rects[i]
+		if r.Enc == nil || r.Enc.Type() < 0 {
+			continue // Pseudo-encodings hold no pixels.
+		}
+		if err := bounds(i, r, w, h); err != nil {
+			return err
+		}
+		for y := int(r.Y); y < int(r.Y)+int(r.Height); y++ {
+			for x := int(r.X); x < int(r.X)+int(r.Width); x++ {
+				if !covered[y*int(w)+x] {
+					covered[y*int(w)+x] = true
+					n++
+				}
+			}
+		}
+	}
+	if n != len(covered) {
+		return fmt.Errorf("update covers %d of the %d pixels", n, len(covered))
+	}
+	return nil
+}
+
+func checkEncodings(ctx context.Context, c *Conn) error {
+	if err := c.SetEncodings(vnc.Encodings{&vnc.RawEncoding{}}); err != nil {
+		return err
+	}
+	c.Start()
+	fu, err := c.Update(ctx, false)
+	if err != nil {
+		return err
+	}
+	for i, r := range fu.Rects {
+		if r.Enc == nil || r.Enc.Type() != encodings.Raw {
+			return fmt.Errorf("rectangle %d is %v encoded, but only Raw was requested", i, r.Enc)
+		}
+	}
+	return nil
+}
+
+func checkResize(ctx context.Context, c *Conn) error {
+	encs := vnc.Encodings{&vnc.RawEncoding{}, &vnc.DesktopSizePseudoEncoding{}, &vnc.ExtendedDesktopSizePseudoEncoding{}}
+	if err := c.SetEncodings(encs); err != nil {
+		return err
+	}
+	c.Start()
+	// The server must answer the first non-incremental update request with
+	// an ExtendedDesktopSize rectangle, to show it supports it.
+	fu, err := c.Update(ctx, false)
+	if err != nil {
+		return err
+	}
+	var size *vnc.Rectangle
+	for i := range fu.Rects {
+		if _, ok := fu.Rects[i].Enc.(*vnc.ExtendedDesktopSizePseudoEncoding); ok {
+			size = &fu.Rects[i]
+		}
+	}
+	if size == nil {
+		return Skipf("ExtendedDesktopSize isn't supported")
+	}
+	if w, h := c.FramebufferWidth(), c.FramebufferHeight(); size.Width != w || size.Height != h {
+		return fmt.Errorf("ExtendedDesktopSize of %dx%d, but the framebuffer is %dx%d", size.Width, size.Height, w, h)
+	}
+	for i := range fu.Rects {
+		if r := &fu.Rects[i]; r.Enc != nil && r.Enc.Type() >= 0 {
+			if err := bounds(i, r, size.Width, size.Height); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Suite) checkClipboard(ctx context.Context, c *Conn) error {
+	c.Start()
+	text := fmt.Sprintf("go-vnc conformance %d", time.Now().UnixNano())
+	if err := c.ClientCutText(text); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.clipboardWait())
+	defer cancel()
+	for {
+		msg, err := c.Next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return Skipf("cut text wasn't sent back; servers may only send cut text when the clipboard of the desktop changes")
+			}
+			return err
+		}
+		cut, ok := msg.(*vnc.ServerCutText)
+		if !ok {
+			continue
+		}
+		if cut.Text != text {
+			return fmt.Errorf("cut text %q sent back as %q", text, cut.Text)
+		}
+		return nil
+	}
+}
+
+func checkColorMap(ctx context.Context, c *Conn) error {
+	if err := c.SetPixelFormat(vnc.PixelFormat8bit); err != nil {
+		return err
+	}
+	c.Start()
+	if err := c.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, c.FramebufferWidth(), c.FramebufferHeight()); err != nil {
+		return err
+	}
+	colors := 0
+	for {
+		msg, err := c.Next(ctx)
+		if err != nil {
+			return err
+		}
+		switch msg := msg.(type) {
+		case *vnc.SetColorMapEntries:
+			colors += len(msg.Colors)
+		case *vnc.FramebufferUpdate:
+			if colors == 0 {
+				return fmt.Errorf("update in a color map pixel format before SetColorMapEntries")
+			}
+			return nil
+		}
+	}
+}
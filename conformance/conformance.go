@@ -0,0 +1,301 @@
+/*
+Package conformance checks the behavior of a live VNC server against the RFB
+protocol, e.g. that the encodings requested are honored, and reports the
+results, for the interoperability matrix of this library, or for server
+authors.
+
+Each check is run over its own connection to the server:
+
+	s := &conformance.Suite{
+		Dial: func(ctx context.Context) (io.ReadWriteCloser, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "tcp", "127.0.0.1:5900")
+		},
+	}
+	report := s.Run(ctx)
+	json.NewEncoder(os.Stdout).Encode(report)
+*/
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// Status is the outcome of a check.
+type Status int
+
+// The outcomes of checks.
+const (
+	// Pass is the status of checks which the server passed.
+	Pass Status = iota
+	// Fail is the status of checks which the server failed, or which
+	// couldn't connect to the server.
+	Fail
+	// Skip is the status of checks which don't apply to the server, e.g.
+	// of extensions it doesn't support.
+	Skip
+)
+
+var statusNames = map[Status]string{
+	Pass: "pass",
+	Fail: "fail",
+	Skip: "skip",
+}
+
+func (s Status) String() string {
+	if name, ok := statusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (s *Status) UnmarshalText(b []byte) error {
+	for status, name := range statusNames {
+		if string(b) == name {
+			*s = status
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status %q", b)
+}
+
+// skipError is returned by checks which don't apply to the server.
+type skipError struct {
+	reason string
+}
+
+func (e *skipError) Error() string { return e.reason }
+
+// Skipf returns the error of a check which doesn't apply to the server.
+func Skipf(format string, a ...interface{}) error {
+	return &skipError{fmt.Sprintf(format, a...)}
+}
+
+// Check is a check of the behavior of the server.
+type Check struct {
+	Name string
+	// Run runs the check over the connection c, which it configures, e.g.
+	// with SetEncodings, before calling c.Start. It returns nil if the
+	// server passes, or an error made with Skipf if the check doesn't apply
+	// to it.
+	Run func(ctx context.Context, c *Conn) error
+}
+
+// Result is the result of a check.
+type Result struct {
+	Check    string        `json:"check"`
+	Status   Status        `json:"status"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Report is the report of a run of the checks.
+type Report struct {
+	Start           time.Time `json:"start"`
+	ProtocolVersion string    `json:"protocol_version,omitempty"`
+	DesktopName     string    `json:"desktop_name,omitempty"`
+	Width           uint16    `json:"width,omitempty"`
+	Height          uint16    `json:"height,omitempty"`
+	Results         []Result  `json:"results"`
+}
+
+// Failed returns whether any check failed.
+func (r *Report) Failed() bool {
+	for _, res := range r.Results {
+		if res.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// The defaults of Suite.
+const (
+	DefaultTimeout       = 10 * time.Second
+	DefaultClipboardWait = 2 * time.Second
+)
+
+// Suite is a suite of checks of a server.
+type Suite struct {
+	// Dial connects to the server. It is called for each check.
+	Dial func(ctx context.Context) (io.ReadWriteCloser, error)
+	// Password is the password of VNC authentication. If empty, only the
+	// None security type is offered.
+	Password string
+	// Timeout is the timeout of each check, including connecting. If zero,
+	// DefaultTimeout is used.
+	Timeout time.Duration
+	// ClipboardWait is how long the clipboard check waits for the cut text
+	// sent to come back. If zero, DefaultClipboardWait is used.
+	ClipboardWait time.Duration
+	// Checks are the checks run, in order. If nil, DefaultChecks are run.
+	Checks []Check
+}
+
+func (s *Suite) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return s.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (s *Suite) clipboardWait() time.Duration {
+	if s.ClipboardWait > 0 {
+		return s.ClipboardWait
+	}
+	return DefaultClipboardWait
+}
+
+// Run runs the checks, and returns the report of their results.
+func (s *Suite) Run(ctx context.Context) *Report {
+	r := &Report{Start: time.Now()}
+	checks := s.Checks
+	if checks == nil {
+		checks = s.DefaultChecks()
+	}
+	for _, check := range checks {
+		start := time.Now()
+		res := Result{Check: check.Name, Status: Pass}
+		if err := s.run(ctx, check, r); err != nil {
+			res.Status, res.Detail = Fail, err.Error()
+			var skip *skipError
+			if errors.As(err, &skip) {
+				res.Status = Skip
+			}
+		}
+		res.Duration = time.Since(start)
+		r.Results = append(r.Results, res)
+	}
+	return r
+}
+
+// run runs a check over a new connection, filling in the metadata of the
+// server in the report, if not yet known.
+func (s *Suite) run(ctx context.Context, check Check, r *Report) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout())
+	defer cancel()
+	c, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	if r.ProtocolVersion == "" {
+		r.ProtocolVersion = c.ServerProtocolVersion().String()
+		r.DesktopName = c.DesktopName()
+		r.Width, r.Height = c.FramebufferWidth(), c.FramebufferHeight()
+	}
+	return check.Run(ctx, c)
+}
+
+//-----------------------------------------------------------------------------
+
+// Conn is the connection to the server of a check.
+type Conn struct {
+	*vnc.ClientConn
+
+	msgs    chan vnc.ServerMessage
+	log     bytes.Buffer  // The log of the connection, written until done.
+	started bool          // Whether messages are read.
+	done    chan struct{} // Closed once messages are no longer read.
+}
+
+// connect connects to the server.
+func (s *Suite) connect(ctx context.Context) (*Conn, error) {
+	nc, err := s.Dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connecting: %s", err)
+	}
+	c := &Conn{msgs: make(chan vnc.ServerMessage, 16), done: make(chan struct{})}
+	cfg := vnc.NewClientConfig(s.Password)
+	if s.Password == "" {
+		cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	}
+	cfg.ServerMessageCh = c.msgs
+	cfg.Logger = log.New(&c.log, "", 0)
+	if c.ClientConn, err = vnc.Connect(ctx, nc, cfg); err != nil {
+		return nil, fmt.Errorf("connecting: %s", err)
+	}
+	return c, nil
+}
+
+// Start starts reading the messages from the server.
+func (c *Conn) Start() {
+	c.started = true
+	go func() {
+		defer close(c.done)
+		c.ListenAndHandle()
+	}()
+}
+
+// close closes the connection, discarding the messages still being read.
+func (c *Conn) close() {
+	c.Close()
+	if !c.started {
+		return
+	}
+	for {
+		select {
+		case <-c.msgs:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Next returns the next message from the server.
+func (c *Conn) Next(ctx context.Context) (vnc.ServerMessage, error) {
+	select {
+	case msg := <-c.msgs:
+		return msg, nil
+	case <-c.done:
+		// The errors logged are the reason the connection was closed.
+		select {
+		case msg := <-c.msgs:
+			return msg, nil
+		default:
+		}
+		var errs []string
+		for _, line := range strings.Split(c.log.String(), "\n") {
+			if strings.HasPrefix(line, "error") {
+				errs = append(errs, line)
+			}
+		}
+		return nil, fmt.Errorf("connection closed: %s", strings.Join(errs, "; "))
+	case <-ctx.Done():
+		return nil, fmt.Errorf("no message from the server: %s", ctx.Err())
+	}
+}
+
+// Update requests an update of the whole framebuffer, and returns it. Other
+// messages received first are discarded.
+func (c *Conn) Update(ctx context.Context, incremental bool) (*vnc.FramebufferUpdate, error) {
+	inc := rfbflags.BoolToRFBFlag(incremental)
+	if err := c.FramebufferUpdateRequest(inc, 0, 0, c.FramebufferWidth(), c.FramebufferHeight()); err != nil {
+		return nil, err
+	}
+	for {
+		msg, err := c.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if fu, ok := msg.(*vnc.FramebufferUpdate); ok {
+			return fu, nil
+		}
+	}
+}
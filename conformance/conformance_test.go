@@ -0,0 +1,110 @@
+package conformance
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/server"
+	"golang.org/x/net/context"
+)
+
+// dialServer returns a Dial func serving each connection with s.
+func dialServer(s *server.Server) func(context.Context) (io.ReadWriteCloser, error) {
+	return func(context.Context) (io.ReadWriteCloser, error) {
+		sc, cc := net.Pipe()
+		go s.ServeConn(sc)
+		return cc, nil
+	}
+}
+
+func TestSuite_Run(t *testing.T) {
+	s := &server.Server{Source: server.NewImageSource(image.NewRGBA(image.Rect(0, 0, 8, 6))), Name: "test"}
+	suite := &Suite{
+		Dial:          dialServer(s),
+		Timeout:       5 * time.Second,
+		ClipboardWait: 50 * time.Millisecond,
+	}
+	report := suite.Run(context.Background())
+
+	if got, want := report.DesktopName, "test"; got != want {
+		t.Errorf("incorrect desktop name; got = %q, want = %q", got, want)
+	}
+	if report.Width != 8 || report.Height != 6 {
+		t.Errorf("incorrect size; got = %dx%d, want = 8x6", report.Width, report.Height)
+	}
+	// The server only supports Raw encoding, and true color pixel formats.
+	want := map[string]Status{
+		"full-update": Pass,
+		"encodings":   Pass,
+		"resize":      Skip,
+		"clipboard":   Skip,
+		"color-map":   Fail,
+	}
+	if got, want := len(report.Results), len(want); got != want {
+		t.Fatalf("incorrect number of results; got = %v, want = %v", got, want)
+	}
+	for _, res := range report.Results {
+		if got, want := res.Status, want[res.Check]; got != want {
+			t.Errorf("%s: incorrect status; got = %v (%s), want = %v", res.Check, got, res.Detail, want)
+		}
+	}
+	if !report.Failed() {
+		t.Error("report not failed")
+	}
+}
+
+func TestSuite_Run_Errors(t *testing.T) {
+	errDial := errors.New("no route to host")
+	suite := &Suite{
+		Dial: func(context.Context) (io.ReadWriteCloser, error) { return nil, errDial },
+	}
+	report := suite.Run(context.Background())
+	for _, res := range report.Results {
+		if res.Status != Fail || !strings.Contains(res.Detail, errDial.Error()) {
+			t.Errorf("%s: incorrect result; got = %v (%s), want = %v", res.Check, res.Status, res.Detail, Fail)
+		}
+	}
+
+	// Checks may be skipped, or fail without reading messages.
+	s := &server.Server{Source: server.NewImageSource(image.NewRGBA(image.Rect(0, 0, 8, 6)))}
+	suite = &Suite{
+		Dial: dialServer(s),
+		Checks: []Check{
+			{"skip", func(context.Context, *Conn) error { return Skipf("not applicable") }},
+			{"fail", func(context.Context, *Conn) error { return errors.New("failed") }},
+		},
+	}
+	report = suite.Run(context.Background())
+	for i, want := range []Result{{Check: "skip", Status: Skip, Detail: "not applicable"}, {Check: "fail", Status: Fail, Detail: "failed"}} {
+		got := report.Results[i]
+		if got.Check != want.Check || got.Status != want.Status || got.Detail != want.Detail {
+			t.Errorf("incorrect result; got = %+v, want = %+v", got, want)
+		}
+	}
+}
+
+func TestStatus_JSON(t *testing.T) {
+	b, err := json.Marshal(Result{Check: "resize", Status: Skip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(b), `{"check":"resize","status":"skip","duration_ns":0}`; got != want {
+		t.Errorf("incorrect JSON; got = %s, want = %s", got, want)
+	}
+	var res Result
+	if err := json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Status, Skip; got != want {
+		t.Errorf("incorrect status; got = %v, want = %v", got, want)
+	}
+	if got, want := Status(7).String(), "Status(7)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
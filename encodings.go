@@ -5,7 +5,7 @@ https://tools.ietf.org/html/rfc6143#section-7.7
 package vnc
 
 import (
-	"bytes"
+	"encoding/binary"
 	"fmt"
 
 	"github.com/kward/go-vnc/encodings"
@@ -29,6 +29,14 @@ type Encoding interface {
 	Type() encodings.Encoding
 }
 
+// A PayloadReader is implemented by encodings whose rectangle payload can be
+// read off the wire without decoding the pixel data. It is used when the
+// ClientConfig requests lazy payloads.
+type PayloadReader interface {
+	// ReadPayload reads the undecoded payload of the rectangle.
+	ReadPayload(*ClientConn, *Rectangle) ([]byte, error)
+}
+
 // Encodings describes a slice of Encoding.
 type Encodings []Encoding
 
@@ -37,13 +45,21 @@ var _ Marshaler = (*Encodings)(nil)
 
 // Marshal implements the Marshaler interface.
 func (e Encodings) Marshal() ([]byte, error) {
-	buf := NewBuffer(nil)
+	b := make([]byte, 4*len(e))
+	for i, enc := range e {
+		binary.BigEndian.PutUint32(b[4*i:], uint32(enc.Type()))
+	}
+	return b, nil
+}
+
+// find returns the Encoding of type t.
+func (e Encodings) find(t encodings.Encoding) (Encoding, bool) {
 	for _, enc := range e {
-		if err := buf.Write(enc.Type()); err != nil {
-			return nil, err
+		if enc.Type() == t {
+			return enc, true
 		}
 	}
-	return buf.Bytes(), nil
+	return nil, false
 }
 
 //-----------------------------------------------------------------------------
@@ -80,26 +96,49 @@ func (e *RawEncoding) Marshal() ([]byte, error) {
 }
 
 // Read implements the Encoding interface.
-func (*RawEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
-	var buf bytes.Buffer
-	bytesPerPixel := int(c.pixelFormat.BPP / 8)
-	n := rect.Area() * bytesPerPixel
-	if err := c.receiveN(&buf, n); err != nil {
-		return nil, fmt.Errorf("unable to read rectangle with raw encoding: %s", err)
-	}
-
-	colors := make([]Color, rect.Area())
-	for y := uint16(0); y < rect.Height; y++ {
-		for x := uint16(0); x < rect.Width; x++ {
-			color := NewColor(&c.pixelFormat, &c.colorMap)
-			if err := color.Unmarshal(buf.Next(bytesPerPixel)); err != nil {
-				return nil, err
-			}
-			colors[int(y)*int(rect.Width)+int(x)] = *color
-		}
+//
+// If e already holds a non-nil Colors slice with enough capacity for the rectangle,
+// the pixel data is decoded into that slice and e itself is returned. This
+// allows callers to reuse pixel storage across rectangles. Otherwise, a new
+// RawEncoding is allocated.
+func (e *RawEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	pf := c.readPixelFormat()
+	bytesPerPixel := int(pf.BPP / 8)
+	if err := c.checkPayload(rect.Area() * bytesPerPixel); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with raw encoding: %s", err)
+	}
+	data, err := c.readPixels(rect.Area() * bytesPerPixel)
+	if err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with raw encoding: %s", err)
 	}
 
-	return &RawEncoding{colors}, nil
+	result := e
+	colors := e.Colors
+	if colors == nil || cap(colors) < rect.Area() {
+		result = &RawEncoding{}
+		colors = make([]Color, rect.Area())
+	}
+	colors = colors[:rect.Area()]
+
+	if err := pf.decodePixels(&c.colorMap, data, colors); err != nil {
+		return nil, err
+	}
+
+	result.Colors = colors
+	return result, nil
+}
+
+// ReadPayload implements the PayloadReader interface.
+func (*RawEncoding) ReadPayload(c *ClientConn, rect *Rectangle) ([]byte, error) {
+	n := rect.Area() * int(c.readPixelFormat().BPP/8)
+	if err := c.checkPayload(n); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with raw encoding: %s", err)
+	}
+	data := make([]byte, n)
+	if err := c.readFull(data); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with raw encoding: %s", err)
+	}
+	return data, nil
 }
 
 // String implements the fmt.Stringer interface.
@@ -108,6 +147,230 @@ func (*RawEncoding) String() string { return "RawEncoding" }
 // Type implements the Encoding interface.
 func (*RawEncoding) Type() encodings.Encoding { return encodings.Raw }
 
+//-----------------------------------------------------------------------------
+// CopyRect Encoding
+//
+// The CopyRect encoding instructs the client to copy a rectangle of pixel data
+// from elsewhere in its framebuffer. The source and destination rectangles may
+// overlap, in which case the copy behaves as though the source was first
+// copied into a temporary buffer (i.e. memmove semantics).
+//
+// See RFC 6143 §7.7.2.
+// https://tools.ietf.org/html/rfc6143#section-7.7.2
+
+// CopyRectEncoding holds the source position of a CopyRect rectangle.
+type CopyRectEncoding struct {
+	SX, SY uint16 // src-x-position, src-y-position
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CopyRectEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *CopyRectEncoding) Marshal() ([]byte, error) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint16(b[0:], e.SX)
+	binary.BigEndian.PutUint16(b[2:], e.SY)
+	return b, nil
+}
+
+// Read implements the Encoding interface.
+//
+// The source rectangle must lie within the framebuffer, unless the rectangle
+// has zero area, in which case there is nothing to copy.
+func (*CopyRectEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	hdr, err := c.readHeader(4)
+	if err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with copyrect encoding: %s", err)
+	}
+	e := &CopyRectEncoding{
+		SX: binary.BigEndian.Uint16(hdr[0:]),
+		SY: binary.BigEndian.Uint16(hdr[2:]),
+	}
+
+	src := Rectangle{X: e.SX, Y: e.SY, Width: rect.Width, Height: rect.Height}
+	if src.validateBounds(c.fbWidth, c.fbHeight) != nil {
+		return nil, protocolErrorf("CopyRect source %dx%d at (%d,%d) exceeds framebuffer size of %dx%d", src.Width, src.Height, src.X, src.Y, c.fbWidth, c.fbHeight)
+	}
+	return e, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *CopyRectEncoding) String() string {
+	return fmt.Sprintf("CopyRectEncoding{ sx: %d, sy: %d }", e.SX, e.SY)
+}
+
+// Type implements the Encoding interface.
+func (*CopyRectEncoding) Type() encodings.Encoding { return encodings.CopyRect }
+
+//-----------------------------------------------------------------------------
+// Lazy Encoding
+//
+// When lazy payloads are requested in the ClientConfig, rectangles hold their
+// payload as undecoded bytes. This avoids the decode cost for consumers, such
+// as proxies and recorders, that only forward or store the data.
+
+// LazyEncoding holds the undecoded payload of a rectangle.
+type LazyEncoding struct {
+	Enc  encodings.Encoding // The encoding of the payload.
+	Data []byte             // The payload, exactly as received.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*LazyEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *LazyEncoding) Marshal() ([]byte, error) {
+	return e.Data, nil
+}
+
+// Read implements the Encoding interface.
+func (e *LazyEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	enc, ok := c.Encodable(e.Enc)
+	if !ok {
+		return nil, wrapErrorf(ErrUnsupportedEncoding, "unsupported encoding type: %d", e.Enc)
+	}
+	return readLazy(c, rect, enc)
+}
+
+// String implements the fmt.Stringer interface.
+func (e *LazyEncoding) String() string {
+	return fmt.Sprintf("LazyEncoding{ enc: %v, len: %d }", e.Enc, len(e.Data))
+}
+
+// Type implements the Encoding interface.
+func (e *LazyEncoding) Type() encodings.Encoding { return e.Enc }
+
+// readLazy reads the payload of a rectangle with encoding enc. Encodings that
+// don't implement the PayloadReader interface are decoded, and then marshaled
+// back into wire format.
+func readLazy(c *ClientConn, rect *Rectangle, enc Encoding) (Encoding, error) {
+	if pr, ok := enc.(PayloadReader); ok {
+		data, err := pr.ReadPayload(c, rect)
+		if err != nil {
+			return nil, err
+		}
+		return &LazyEncoding{enc.Type(), data}, nil
+	}
+
+	dec, err := enc.Read(c, rect)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dec.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &LazyEncoding{enc.Type(), data}, nil
+}
+
+//-----------------------------------------------------------------------------
+// Unknown Encoding
+//
+// When tolerance of unknown encodings is requested in the ClientConfig,
+// rectangles with an unsupported encoding hold their payload as undecoded
+// bytes, rather than aborting the FramebufferUpdate. This is only possible
+// when the framing of the encoding payload is known, e.g. pseudo-encodings
+// which have no payload.
+
+// UnknownEncoding holds the undecoded payload of a rectangle whose encoding
+// isn't supported.
+type UnknownEncoding struct {
+	Enc  encodings.Encoding // The encoding of the payload.
+	Data []byte             // The payload, exactly as received.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*UnknownEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *UnknownEncoding) Marshal() ([]byte, error) {
+	return e.Data, nil
+}
+
+// Read implements the Encoding interface.
+func (e *UnknownEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	data, ok, err := readUnknownPayload(c, rect, e.Enc)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, wrapErrorf(ErrUnsupportedEncoding, "unsupported encoding type: %d", e.Enc)
+	}
+	return &UnknownEncoding{e.Enc, data}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (e *UnknownEncoding) String() string {
+	return fmt.Sprintf("UnknownEncoding{ enc: %v, len: %d }", e.Enc, len(e.Data))
+}
+
+// Type implements the Encoding interface.
+func (e *UnknownEncoding) Type() encodings.Encoding { return e.Enc }
+
+// readUnknownPayload reads the payload of a rectangle with encoding enc,
+// without interpreting it. If the framing of the encoding isn't known, false
+// is returned and nothing is read.
+func readUnknownPayload(c *ClientConn, rect *Rectangle, enc encodings.Encoding) ([]byte, bool, error) {
+	var n int
+	switch enc {
+	case encodings.DesktopSizePseudo, encodings.LastRectPseudo,
+		encodings.PointerPosPseudo, encodings.QEMUExtendedKeyEventPseudo:
+		return []byte{}, true, nil
+	case encodings.ColorPseudo: // Cursor pseudo-encoding.
+		if err := c.config.Limits.checkCursor(rect); err != nil {
+			return nil, true, err
+		}
+		bitmask := (int(rect.Width) + 7) / 8 * int(rect.Height)
+		n = rect.Area()*int(c.readPixelFormat().BPP/8) + bitmask
+	case encodings.XCursorPseudo:
+		if err := c.config.Limits.checkCursor(rect); err != nil {
+			return nil, true, err
+		}
+		if rect.Area() > 0 {
+			n = 6 + 2*((int(rect.Width)+7)/8)*int(rect.Height)
+		}
+	case encodings.DesktopNamePseudo:
+		hdr := make([]byte, 4)
+		if err := c.readFull(hdr); err != nil {
+			return nil, true, err
+		}
+		l := binary.BigEndian.Uint32(hdr)
+		if max := c.config.Limits.maxStringLength(); l > max {
+			return nil, true, wrapErrorf(ErrLimitExceeded, "name-length %d exceeds limit of %d", l, max)
+		}
+		name := make([]byte, l)
+		if err := c.readFull(name); err != nil {
+			return nil, true, err
+		}
+		return append(hdr, name...), true, nil
+	case encodings.ExtendedDesktopSizePseudo:
+		hdr := make([]byte, 4)
+		if err := c.readFull(hdr); err != nil {
+			return nil, true, err
+		}
+		screens := make([]byte, 16*int(hdr[0]))
+		if err := c.readFull(screens); err != nil {
+			return nil, true, err
+		}
+		return append(hdr, screens...), true, nil
+	case encodings.ZstdPrivate:
+		data, err := readZstdPayload(c, rect)
+		return data, true, err
+	default:
+		return nil, false, nil
+	}
+
+	if err := c.checkPayload(n); err != nil {
+		return nil, true, err
+	}
+	data := make([]byte, n)
+	if err := c.readFull(data); err != nil {
+		return nil, true, err
+	}
+	return data, true, nil
+}
+
 //=============================================================================
 // Pseudo-Encodings
 //
@@ -141,10 +404,15 @@ func (e *DesktopSizePseudoEncoding) Marshal() ([]byte, error) {
 
 // Read implements the Encoding interface.
 func (*DesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
-	c.fbWidth = rect.Width
-	c.fbHeight = rect.Height
+	return readDesktopSize(c, rect)
+}
 
-	return &DesktopSizePseudoEncoding{}, nil
+// ReadPayload implements the PayloadReader interface.
+func (e *DesktopSizePseudoEncoding) ReadPayload(c *ClientConn, rect *Rectangle) ([]byte, error) {
+	if _, err := e.Read(c, rect); err != nil {
+		return nil, err
+	}
+	return []byte{}, nil
 }
 
 // String implements the fmt.Stringer interface.
@@ -152,3 +420,388 @@ func (e *DesktopSizePseudoEncoding) String() string { return "DesktopSizePseudoE
 
 // Type implements the Encoding interface.
 func (*DesktopSizePseudoEncoding) Type() encodings.Encoding { return encodings.DesktopSizePseudo }
+
+//-----------------------------------------------------------------------------
+// Cursor Pseudo-Encoding
+//
+// A client which requests the Cursor pseudo-encoding is declaring that it is
+// capable of drawing a pointer cursor locally. The rectangle holds the cursor
+// shape as pixel data, followed by a bitmask of the visible pixels. The
+// position of the rectangle is the hotspot of the cursor.
+//
+// See RFC 6143 §7.8.1.
+// https://tools.ietf.org/html/rfc6143#section-7.8.1
+
+// CursorPseudoEncoding holds a cursor shape.
+type CursorPseudoEncoding struct {
+	Colors  []Color // cursor-pixels
+	Bitmask []byte  // bitmask; one bit per pixel, rows padded to whole bytes.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*CursorPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *CursorPseudoEncoding) Marshal() ([]byte, error) {
+	raw := &RawEncoding{e.Colors}
+	b, err := raw.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, e.Bitmask...), nil
+}
+
+// Read implements the Encoding interface.
+func (*CursorPseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return readCursor(c, rect)
+}
+
+// String implements the fmt.Stringer interface.
+func (e *CursorPseudoEncoding) String() string {
+	return fmt.Sprintf("CursorPseudoEncoding{ pixels: %d }", len(e.Colors))
+}
+
+// Type implements the Encoding interface.
+func (*CursorPseudoEncoding) Type() encodings.Encoding { return encodings.ColorPseudo }
+
+// Visible returns true if pixel i of the cursor is visible.
+func (e *CursorPseudoEncoding) Visible(rect *Rectangle, i int) bool {
+	x, y := i%int(rect.Width), i/int(rect.Width)
+	b := e.Bitmask[y*((int(rect.Width)+7)/8)+x/8]
+	return b&(0x80>>uint(x%8)) != 0
+}
+
+//-----------------------------------------------------------------------------
+// LastRect Pseudo-Encoding
+//
+// A LastRect rectangle marks the end of a FramebufferUpdate, allowing a
+// server to send an update without knowing the number of rectangles in
+// advance. Any remaining rectangles indicated by the number-of-rectangles
+// aren't sent.
+
+// LastRectPseudoEncoding marks the last rectangle of a FramebufferUpdate.
+type LastRectPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*LastRectPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*LastRectPseudoEncoding) Marshal() ([]byte, error) { return []byte{}, nil }
+
+// Read implements the Encoding interface.
+func (*LastRectPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return &LastRectPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*LastRectPseudoEncoding) String() string { return "LastRectPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*LastRectPseudoEncoding) Type() encodings.Encoding { return encodings.LastRectPseudo }
+
+//-----------------------------------------------------------------------------
+// PointerPos Pseudo-Encoding
+//
+// A PointerPos rectangle informs the client of the position of the pointer,
+// given by the position of the rectangle. There is no payload.
+
+// PointerPosPseudoEncoding marks a pointer position update.
+type PointerPosPseudoEncoding struct{}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*PointerPosPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*PointerPosPseudoEncoding) Marshal() ([]byte, error) { return []byte{}, nil }
+
+// Read implements the Encoding interface.
+func (*PointerPosPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return &PointerPosPseudoEncoding{}, nil
+}
+
+// String implements the fmt.Stringer interface.
+func (*PointerPosPseudoEncoding) String() string { return "PointerPosPseudoEncoding" }
+
+// Type implements the Encoding interface.
+func (*PointerPosPseudoEncoding) Type() encodings.Encoding { return encodings.PointerPosPseudo }
+
+//-----------------------------------------------------------------------------
+// DesktopName Pseudo-Encoding
+//
+// A DesktopName rectangle informs the client of a change to the desktop name.
+// The payload is a length-prefixed UTF-8 string.
+
+// DesktopNamePseudoEncoding holds a new desktop name.
+type DesktopNamePseudoEncoding struct {
+	Name string
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*DesktopNamePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *DesktopNamePseudoEncoding) Marshal() ([]byte, error) {
+	b := make([]byte, 4, 4+len(e.Name))
+	binary.BigEndian.PutUint32(b, uint32(len(e.Name)))
+	return append(b, e.Name...), nil
+}
+
+// Read implements the Encoding interface.
+func (*DesktopNamePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return readDesktopName(c, rect)
+}
+
+// String implements the fmt.Stringer interface.
+func (e *DesktopNamePseudoEncoding) String() string {
+	return fmt.Sprintf("DesktopNamePseudoEncoding{ name: %q }", e.Name)
+}
+
+// Type implements the Encoding interface.
+func (*DesktopNamePseudoEncoding) Type() encodings.Encoding { return encodings.DesktopNamePseudo }
+
+//-----------------------------------------------------------------------------
+// ExtendedDesktopSize Pseudo-Encoding
+//
+// An ExtendedDesktopSize rectangle informs the client of a change to the
+// framebuffer size, and of the layout of the screens (i.e. monitors) of the
+// desktop. The x-position of the rectangle is the reason for the change, and
+// the y-position its status; the framebuffer is only resized if the status is
+// zero. The payload is the list of screens.
+//
+// See https://github.com/rfbproto/rfbproto/blob/master/rfbproto.rst#extendeddesktopsize-pseudo-encoding
+
+// ExtendedDesktopSizePseudoEncoding holds a screen layout.
+type ExtendedDesktopSizePseudoEncoding struct {
+	Monitors []Monitor
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*ExtendedDesktopSizePseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (e *ExtendedDesktopSizePseudoEncoding) Marshal() ([]byte, error) {
+	if len(e.Monitors) > 0xff {
+		return nil, Errorf("%d screens exceed the limit of 255", len(e.Monitors))
+	}
+	b := make([]byte, 4, 4+16*len(e.Monitors))
+	b[0] = uint8(len(e.Monitors)) // number-of-screens
+	for _, m := range e.Monitors {
+		b = append(b, m.marshal()...)
+	}
+	return b, nil
+}
+
+// Read implements the Encoding interface.
+func (*ExtendedDesktopSizePseudoEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	return readExtendedDesktopSize(c, rect)
+}
+
+// String implements the fmt.Stringer interface.
+func (e *ExtendedDesktopSizePseudoEncoding) String() string {
+	return fmt.Sprintf("ExtendedDesktopSizePseudoEncoding{ screens: %v }", e.Monitors)
+}
+
+// Type implements the Encoding interface.
+func (*ExtendedDesktopSizePseudoEncoding) Type() encodings.Encoding {
+	return encodings.ExtendedDesktopSizePseudo
+}
+
+//-----------------------------------------------------------------------------
+// TurboVNC Fine-Quality and Subsampling Pseudo-Encodings
+//
+// TurboVNC servers accept a JPEG quality level from 0 to 100, and a chroma
+// subsampling level, advertised as pseudo-encodings. They give finer control
+// of the JPEG compression of the Tight encoding than the quality levels of
+// the Tight encoding. Both only advertise the preference of the client, and
+// are never sent as a rectangle.
+
+// FineQualityPseudoEncoding advertises a TurboVNC JPEG quality level.
+type FineQualityPseudoEncoding struct {
+	Level uint8 // The JPEG quality level, from 0 to 100. Higher levels are 100.
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*FineQualityPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*FineQualityPseudoEncoding) Marshal() ([]byte, error) { return []byte{}, nil }
+
+// Read implements the Encoding interface.
+func (e *FineQualityPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, protocolErrorf("unexpected rectangle with %v", e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (e *FineQualityPseudoEncoding) String() string {
+	return fmt.Sprintf("FineQualityPseudoEncoding{ level: %d }", e.Level)
+}
+
+// Type implements the Encoding interface.
+func (e *FineQualityPseudoEncoding) Type() encodings.Encoding {
+	level := e.Level
+	if level > 100 {
+		level = 100
+	}
+	return encodings.FineQualityLevel0Pseudo + encodings.Encoding(level)
+}
+
+// JPEGSubsampling is a TurboVNC chroma subsampling level.
+type JPEGSubsampling uint8
+
+// The chroma subsampling levels, in the order of their pseudo-encodings.
+const (
+	Subsampling1X   JPEGSubsampling = iota // No subsampling, i.e. 4:4:4.
+	Subsampling4X                          // 4:2:0.
+	Subsampling2X                          // 4:2:2.
+	SubsamplingGray                        // Grayscale.
+	Subsampling8X
+	Subsampling16X
+)
+
+var jpegSubsamplingNames = map[JPEGSubsampling]string{
+	Subsampling1X:   "1X",
+	Subsampling4X:   "4X",
+	Subsampling2X:   "2X",
+	SubsamplingGray: "Gray",
+	Subsampling8X:   "8X",
+	Subsampling16X:  "16X",
+}
+
+func (s JPEGSubsampling) String() string {
+	if name, ok := jpegSubsamplingNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("JPEGSubsampling(%d)", int(s))
+}
+
+// SubsamplingPseudoEncoding advertises a TurboVNC chroma subsampling level.
+type SubsamplingPseudoEncoding struct {
+	Subsampling JPEGSubsampling
+}
+
+// Verify that interfaces are honored.
+var _ Encoding = (*SubsamplingPseudoEncoding)(nil)
+
+// Marshal implements the Marshaler interface.
+func (*SubsamplingPseudoEncoding) Marshal() ([]byte, error) { return []byte{}, nil }
+
+// Read implements the Encoding interface.
+func (e *SubsamplingPseudoEncoding) Read(*ClientConn, *Rectangle) (Encoding, error) {
+	return nil, protocolErrorf("unexpected rectangle with %v", e.Type())
+}
+
+// String implements the fmt.Stringer interface.
+func (e *SubsamplingPseudoEncoding) String() string {
+	return fmt.Sprintf("SubsamplingPseudoEncoding{ subsampling: %v }", e.Subsampling)
+}
+
+// Type implements the Encoding interface.
+func (e *SubsamplingPseudoEncoding) Type() encodings.Encoding {
+	return encodings.Subsamp1XPseudo + encodings.Encoding(e.Subsampling)
+}
+
+//-----------------------------------------------------------------------------
+// Pseudo-encoding dispatch
+//
+// Pseudo-encoding rectangles carry changes to the connection state rather
+// than pixel data, so they are dispatched to dedicated handlers instead of the
+// Encoding advertised by the client. The state change is applied even when
+// lazy payloads are requested. Pseudo-encodings without a handler (e.g. those
+// defined by the user) are read using their Encoding.
+//
+// The Fence pseudo-encoding (encodings.FencePseudo) only advertises support
+// for Fence messages, and is never sent as a rectangle.
+
+// pseudoHandler reads the payload of a pseudo-encoding rectangle, and applies
+// it to the connection.
+type pseudoHandler func(c *ClientConn, rect *Rectangle) (Encoding, error)
+
+var pseudoHandlers = map[encodings.Encoding]pseudoHandler{
+	encodings.ColorPseudo:               readCursor,
+	encodings.DesktopNamePseudo:         readDesktopName,
+	encodings.DesktopSizePseudo:         readDesktopSize,
+	encodings.ExtendedDesktopSizePseudo: readExtendedDesktopSize,
+	encodings.LastRectPseudo: func(*ClientConn, *Rectangle) (Encoding, error) {
+		return &LastRectPseudoEncoding{}, nil
+	},
+	encodings.PointerPosPseudo: func(*ClientConn, *Rectangle) (Encoding, error) {
+		return &PointerPosPseudoEncoding{}, nil
+	},
+}
+
+func readCursor(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if err := c.config.Limits.checkCursor(rect); err != nil {
+		return nil, err
+	}
+	pf := c.readPixelFormat()
+	n := rect.Area() * int(pf.BPP/8)
+	bitmaskLen := (int(rect.Width) + 7) / 8 * int(rect.Height)
+	if err := c.checkPayload(n + bitmaskLen); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with cursor pseudo-encoding: %s", err)
+	}
+	data := make([]byte, n+bitmaskLen)
+	if err := c.readFull(data); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with cursor pseudo-encoding: %s", err)
+	}
+
+	e := &CursorPseudoEncoding{
+		Colors:  make([]Color, rect.Area()),
+		Bitmask: data[n:],
+	}
+	if err := pf.decodePixels(&c.colorMap, data[:n], e.Colors); err != nil {
+		return nil, err
+	}
+	c.publish(Event{Kind: EventCursorChanged})
+	return e, nil
+}
+
+func readDesktopName(c *ClientConn, _ *Rectangle) (Encoding, error) {
+	hdr, err := c.readHeader(4)
+	if err != nil {
+		return nil, err
+	}
+	l := binary.BigEndian.Uint32(hdr)
+	if max := c.config.Limits.maxStringLength(); l > max {
+		return nil, wrapErrorf(ErrLimitExceeded, "name-length %d exceeds limit of %d", l, max)
+	}
+	name := make([]byte, l)
+	if err := c.readFull(name); err != nil {
+		return nil, err
+	}
+	c.setDesktopName(string(name))
+	return &DesktopNamePseudoEncoding{string(name)}, nil
+}
+
+func readDesktopSize(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if err := c.config.Limits.checkFramebuffer(rect.Width, rect.Height); err != nil {
+		return nil, err
+	}
+	c.setFramebufferSize(rect.Width, rect.Height)
+	c.publish(Event{Kind: EventResized, Width: rect.Width, Height: rect.Height})
+	return &DesktopSizePseudoEncoding{}, nil
+}
+
+func readExtendedDesktopSize(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	hdr, err := c.readHeader(4)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 16*int(hdr[0]))
+	if err := c.readFull(data); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with ExtendedDesktopSize pseudo-encoding: %s", err)
+	}
+	e := &ExtendedDesktopSizePseudoEncoding{Monitors: make([]Monitor, hdr[0])}
+	for i := range e.Monitors {
+		e.Monitors[i] = unmarshalMonitor(data[16*i:])
+	}
+	if rect.Y != 0 { // The status of a failed request to change the layout.
+		return e, nil
+	}
+	if err := c.config.Limits.checkFramebuffer(rect.Width, rect.Height); err != nil {
+		return nil, err
+	}
+	c.setMonitors(e.Monitors)
+	c.setFramebufferSize(rect.Width, rect.Height)
+	c.publish(Event{Kind: EventResized, Width: rect.Width, Height: rect.Height})
+	return e, nil
+}
@@ -4,36 +4,38 @@ package encodings
 
 import "fmt"
 
-const (
-	_Encoding_name_0 = "ColorPseudo"
-	_Encoding_name_1 = "DesktopSizePseudo"
-	_Encoding_name_2 = "RawCopyRectRRE"
-	_Encoding_name_3 = "Hextile"
-	_Encoding_name_4 = "TRLEZRLE"
-)
-
-var (
-	_Encoding_index_0 = [...]uint8{0, 11}
-	_Encoding_index_1 = [...]uint8{0, 17}
-	_Encoding_index_2 = [...]uint8{0, 3, 11, 14}
-	_Encoding_index_3 = [...]uint8{0, 7}
-	_Encoding_index_4 = [...]uint8{0, 4, 8}
-)
+var _Encoding_map = map[Encoding]string{
+	-32768:     "UltraServerStatePseudo",
+	-32767:     "UltraEnableKeepAlivePseudo",
+	-768:       "Subsamp1XPseudo",
+	-767:       "Subsamp4XPseudo",
+	-766:       "Subsamp2XPseudo",
+	-765:       "SubsampGrayPseudo",
+	-764:       "Subsamp8XPseudo",
+	-763:       "Subsamp16XPseudo",
+	-512:       "FineQualityLevel0Pseudo",
+	-412:       "FineQualityLevel100Pseudo",
+	-312:       "FencePseudo",
+	-308:       "ExtendedDesktopSizePseudo",
+	-307:       "DesktopNamePseudo",
+	-258:       "QEMUExtendedKeyEventPseudo",
+	-240:       "XCursorPseudo",
+	-239:       "ColorPseudo",
+	-232:       "PointerPosPseudo",
+	-224:       "LastRectPseudo",
+	-223:       "DesktopSizePseudo",
+	0:          "Raw",
+	1:          "CopyRect",
+	2:          "RRE",
+	5:          "Hextile",
+	15:         "TRLE",
+	16:         "ZRLE",
+	1735358976: "ZstdPrivate",
+}
 
 func (i Encoding) String() string {
-	switch {
-	case i == -239:
-		return _Encoding_name_0
-	case i == -223:
-		return _Encoding_name_1
-	case 0 <= i && i <= 2:
-		return _Encoding_name_2[_Encoding_index_2[i]:_Encoding_index_2[i+1]]
-	case i == 5:
-		return _Encoding_name_3
-	case 15 <= i && i <= 16:
-		i -= 15
-		return _Encoding_name_4[_Encoding_index_4[i]:_Encoding_index_4[i+1]]
-	default:
-		return fmt.Sprintf("Encoding(%d)", i)
+	if str, ok := _Encoding_map[i]; ok {
+		return str
 	}
+	return fmt.Sprintf("Encoding(%d)", i)
 }
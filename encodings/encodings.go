@@ -10,12 +10,38 @@ type Encoding int32
 //go:generate stringer -type=Encoding
 
 const (
-	Raw               Encoding = 0
-	CopyRect          Encoding = 1
-	RRE               Encoding = 2
-	Hextile           Encoding = 5
-	TRLE              Encoding = 15
-	ZRLE              Encoding = 16
-	ColorPseudo       Encoding = -239
-	DesktopSizePseudo Encoding = -223
+	Raw                        Encoding = 0
+	CopyRect                   Encoding = 1
+	RRE                        Encoding = 2
+	Hextile                    Encoding = 5
+	TRLE                       Encoding = 15
+	ZRLE                       Encoding = 16
+	ColorPseudo                Encoding = -239
+	XCursorPseudo              Encoding = -240
+	DesktopSizePseudo          Encoding = -223
+	LastRectPseudo             Encoding = -224
+	PointerPosPseudo           Encoding = -232
+	QEMUExtendedKeyEventPseudo Encoding = -258
+	DesktopNamePseudo          Encoding = -307
+	ExtendedDesktopSizePseudo  Encoding = -308
+	FencePseudo                Encoding = -312
+
+	// TurboVNC pseudo-encodings. The fine-grained JPEG quality levels 0 to
+	// 100 are FineQualityLevel0Pseudo to FineQualityLevel100Pseudo.
+	FineQualityLevel0Pseudo   Encoding = -512
+	FineQualityLevel100Pseudo Encoding = -412
+	Subsamp1XPseudo           Encoding = -768
+	Subsamp4XPseudo           Encoding = -767
+	Subsamp2XPseudo           Encoding = -766
+	SubsampGrayPseudo         Encoding = -765
+	Subsamp8XPseudo           Encoding = -764
+	Subsamp16XPseudo          Encoding = -763
+
+	// UltraVNC pseudo-encodings.
+	UltraServerStatePseudo     Encoding = -32768 // 0xFFFF8000
+	UltraEnableKeepAlivePseudo Encoding = -32767 // 0xFFFF8001
+
+	// Private encodings of this package, which other clients and servers
+	// don't implement.
+	ZstdPrivate Encoding = 0x676f7a00 // "goz\0"; ZSTD-compressed raw pixel data.
 )
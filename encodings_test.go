@@ -3,6 +3,7 @@ package vnc
 // TODO(kward): Fully test the encodings.
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/kward/go-vnc/encodings"
@@ -58,7 +59,59 @@ func TestRawEncoding_Marshal(t *testing.T) {
 	}
 }
 
-func TestRawEncoding_Read(t *testing.T) {}
+func TestRawEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	for _, tt := range []struct {
+		desc   string
+		e      *RawEncoding
+		data   []byte
+		reused bool
+	}{
+		{"no buffer",
+			&RawEncoding{},
+			[]byte{0, 127, 127, 255}, false},
+		{"buffer too small",
+			&RawEncoding{make([]Color, 0, 1)},
+			[]byte{0, 127, 127, 255}, false},
+		{"buffer reused",
+			&RawEncoding{make([]Color, 0, 4)},
+			[]byte{0, 127, 127, 255}, true},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		rect := &Rectangle{Width: 2, Height: 1}
+		enc, err := tt.e.Read(conn, rect)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		raw := enc.(*RawEncoding)
+		if got, want := len(raw.Colors), rect.Area(); got != want {
+			t.Errorf("%s: incorrect number of colors; got = %v, want = %v", tt.desc, got, want)
+			continue
+		}
+		if got, want := raw == tt.e, tt.reused; got != want {
+			t.Errorf("%s: incorrect buffer reuse; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := raw.Colors[1].R, uint16(32767); got != want {
+			t.Errorf("%s: incorrect R value; got = %v, want = %v", tt.desc, got, want)
+		}
+		data, err := raw.Marshal()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := data, tt.data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect result; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
 
 func TestDesktopSizePseudoEncoding_Type(t *testing.T) {
 	e := &DesktopSizePseudoEncoding{}
@@ -66,3 +119,390 @@ func TestDesktopSizePseudoEncoding_Type(t *testing.T) {
 		t.Errorf("incorrect encoding; got = %s, want = %s", got, want)
 	}
 }
+
+func TestLazyEncoding(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{LazyPayloads: true})
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}
+	conn.pixelFormat = PixelFormat16bit
+	conn.fbWidth, conn.fbHeight = 320, 240
+
+	for _, tt := range []struct {
+		desc string
+		msg  rectangleMessage
+		data []byte
+	}{
+		{"raw",
+			rectangleMessage{1, 2, 2, 1, encodings.Raw},
+			[]byte{0, 127, 127, 255}},
+		{"desktop size",
+			rectangleMessage{0, 0, 640, 480, encodings.DesktopSizePseudo},
+			[]byte{}},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		rect := NewRectangle(conn.Encodable)
+		if err := rect.Read(conn); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		lazy, ok := rect.Enc.(*LazyEncoding)
+		if !ok {
+			t.Errorf("%s: expected LazyEncoding; got %T", tt.desc, rect.Enc)
+			continue
+		}
+		if got, want := lazy.Type(), tt.msg.E; got != want {
+			t.Errorf("%s: incorrect encoding-type; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := lazy.Data, tt.data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect payload; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := mockConn.b.Len(), 0; got != want {
+			t.Errorf("%s: unread data remaining; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+
+	// The DesktopSize pseudo-encoding is still applied.
+	if got, want := conn.FramebufferWidth(), uint16(640); got != want {
+		t.Errorf("incorrect framebuffer width; got = %v, want = %v", got, want)
+	}
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+	conn.fbWidth, conn.fbHeight = 320, 240
+
+	for _, tt := range []struct {
+		desc     string
+		tolerate bool
+		msg      rectangleMessage
+		data     []byte
+		ok       bool
+	}{
+		{"not tolerated",
+			false, rectangleMessage{0, 0, 0, 0, encodings.LastRectPseudo},
+			[]byte{}, false},
+		{"last rect",
+			true, rectangleMessage{0, 0, 0, 0, encodings.LastRectPseudo},
+			[]byte{}, true},
+		{"cursor",
+			true, rectangleMessage{0, 0, 9, 2, encodings.ColorPseudo},
+			append(make([]byte, 9*2*2), 1, 2, 3, 4), true},
+		{"x cursor",
+			true, rectangleMessage{0, 0, 9, 2, encodings.XCursorPseudo},
+			make([]byte, 6+2*2*2), true},
+		{"oversized cursor",
+			true, rectangleMessage{0, 0, 0xffff, 0xffff, encodings.ColorPseudo},
+			[]byte{}, false},
+		{"oversized x cursor",
+			true, rectangleMessage{0, 0, 0xffff, 0xffff, encodings.XCursorPseudo},
+			[]byte{}, false},
+		{"desktop name",
+			true, rectangleMessage{0, 0, 0, 0, encodings.DesktopNamePseudo},
+			[]byte{0, 0, 0, 3, 'f', 'o', 'o'}, true},
+		{"extended desktop size",
+			true, rectangleMessage{0, 0, 640, 480, encodings.ExtendedDesktopSizePseudo},
+			append([]byte{1, 0, 0, 0}, make([]byte, 16)...), true},
+		{"unknown framing",
+			true, rectangleMessage{0, 0, 1, 1, encodings.Encoding(-1000)},
+			[]byte{}, false},
+	} {
+		mockConn.Reset()
+		conn.config.TolerateUnknownEncodings = tt.tolerate
+		if err := conn.send(tt.msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		rect := NewRectangle(conn.Encodable)
+		err := rect.Read(conn)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if !tt.ok {
+			continue
+		}
+		unknown, ok := rect.Enc.(*UnknownEncoding)
+		if !ok {
+			t.Errorf("%s: expected UnknownEncoding; got %T", tt.desc, rect.Enc)
+			continue
+		}
+		if got, want := unknown.Type(), tt.msg.E; got != want {
+			t.Errorf("%s: incorrect encoding-type; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := unknown.Data, tt.data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: incorrect payload; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := mockConn.b.Len(), 0; got != want {
+			t.Errorf("%s: unread data remaining; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestCopyRectEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	for _, tt := range []struct {
+		desc   string
+		rect   Rectangle
+		sx, sy uint16
+		ok     bool
+	}{
+		{"within framebuffer", Rectangle{X: 0, Y: 0, Width: 5, Height: 5}, 5, 5, true},
+		{"overlapping", Rectangle{X: 1, Y: 1, Width: 5, Height: 5}, 2, 2, true},
+		{"source out of bounds", Rectangle{X: 0, Y: 0, Width: 5, Height: 5}, 6, 0, false},
+		{"zero area", Rectangle{X: 0, Y: 0, Width: 0, Height: 5}, 100, 100, true},
+	} {
+		mockConn.Reset()
+		if err := conn.send([2]uint16{tt.sx, tt.sy}); err != nil {
+			t.Fatal(err)
+		}
+
+		enc, err := (&CopyRectEncoding{}).Read(conn, &tt.rect)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			}
+			continue
+		}
+		got, want := enc.(*CopyRectEncoding), &CopyRectEncoding{tt.sx, tt.sy}
+		if *got != *want {
+			t.Errorf("%s: got = %v, want = %v", tt.desc, got, want)
+		}
+		b, err := got.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !operators.EqualSlicesOfByte(b, []byte{byte(tt.sx >> 8), byte(tt.sx), byte(tt.sy >> 8), byte(tt.sy)}) {
+			t.Errorf("%s: Marshal() = %v", tt.desc, b)
+		}
+	}
+}
+
+func TestCursorPseudoEncoding_Limit(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{Limits: Limits{MaxCursorArea: 4}})
+	conn.encodings = Encodings{&RawEncoding{}, &CursorPseudoEncoding{}}
+	conn.pixelFormat = PixelFormat32bit
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	for _, tt := range []struct {
+		desc string
+		w, h uint16
+		want error
+	}{
+		{"within limit", 2, 2, nil},
+		{"over limit", 3, 2, ErrLimitExceeded},
+		{"maximum size", 0xffff, 0xffff, ErrLimitExceeded},
+	} {
+		mockConn.Reset()
+		if err := conn.send(rectangleMessage{0, 0, tt.w, tt.h, encodings.ColorPseudo}); err != nil {
+			t.Fatal(err)
+		}
+		if tt.want == nil {
+			if err := conn.send(make([]byte, int(tt.w)*int(tt.h)*4+int(tt.h))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rect := NewRectangle(conn.Encodable)
+		if err := rect.Read(conn); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.desc, err, tt.want)
+		}
+	}
+}
+
+func TestFramebufferArea_Limit(t *testing.T) {
+	monitors, err := (&ExtendedDesktopSizePseudoEncoding{testMonitors}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		desc string
+		enc  encodings.Encoding
+		data []byte
+		w, h uint16
+		want error
+	}{
+		{"DesktopSize within limit", encodings.DesktopSizePseudo, nil, 20, 5, nil},
+		{"DesktopSize over limit", encodings.DesktopSizePseudo, nil, 11, 10, ErrLimitExceeded},
+		{"DesktopSize maximum size", encodings.DesktopSizePseudo, nil, 0xffff, 0xffff, ErrLimitExceeded},
+		{"ExtendedDesktopSize within limit", encodings.ExtendedDesktopSizePseudo, monitors, 10, 10, nil},
+		{"ExtendedDesktopSize over limit", encodings.ExtendedDesktopSizePseudo, monitors, 0xffff, 0xffff, ErrLimitExceeded},
+	} {
+		conn := NewClientConn(&MockConn{}, &ClientConfig{Limits: Limits{MaxFramebufferArea: 100}})
+		conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}, &ExtendedDesktopSizePseudoEncoding{}}
+		conn.fbWidth, conn.fbHeight = 10, 10
+
+		rect := &Rectangle{Width: tt.w, Height: tt.h}
+		_, err := conn.UnmarshalEncoding(tt.enc, rect, tt.data)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.desc, err, tt.want)
+		}
+		w, h := uint16(10), uint16(10)
+		if tt.want == nil {
+			w, h = tt.w, tt.h
+		}
+		if conn.FramebufferWidth() != w || conn.FramebufferHeight() != h {
+			t.Errorf("%s: framebuffer size = %dx%d, want %dx%d", tt.desc, conn.FramebufferWidth(), conn.FramebufferHeight(), w, h)
+		}
+	}
+}
+
+func TestPseudoEncodings(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{
+		&RawEncoding{},
+		&CursorPseudoEncoding{},
+		&DesktopNamePseudoEncoding{},
+		&DesktopSizePseudoEncoding{},
+		&LastRectPseudoEncoding{},
+		&PointerPosPseudoEncoding{},
+	}
+	conn.pixelFormat = PixelFormat32bit
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	for _, d := range []interface{}{
+		// FramebufferUpdate with an unknown number of rectangles.
+		[3]byte{0, 0xff, 0xff},
+		// Cursor, 2x2 with hotspot (1,0); two visible pixels.
+		rectangleMessage{1, 0, 2, 2, encodings.ColorPseudo},
+		[4]uint32{1, 2, 3, 4},
+		[2]byte{0x80, 0x40},
+		// DesktopName.
+		rectangleMessage{0, 0, 0, 0, encodings.DesktopNamePseudo},
+		uint32(3), []byte("foo"),
+		// PointerPos.
+		rectangleMessage{5, 6, 0, 0, encodings.PointerPosPseudo},
+		// DesktopSize.
+		rectangleMessage{0, 0, 20, 30, encodings.DesktopSizePseudo},
+		// LastRect.
+		rectangleMessage{0, 0, 0, 0, encodings.LastRectPseudo},
+	} {
+		if err := conn.send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msg, err := (&FramebufferUpdate{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fu := msg.(*FramebufferUpdate)
+	if got, want := len(fu.Rects), 5; got != want {
+		t.Fatalf("incorrect number of rectangles; got = %d, want = %d", got, want)
+	}
+	if got, want := mockConn.b.Len(), 0; got != want {
+		t.Errorf("%d bytes unread", got)
+	}
+
+	cursor, ok := fu.Rects[0].Enc.(*CursorPseudoEncoding)
+	if !ok {
+		t.Fatalf("expected CursorPseudoEncoding; got %T", fu.Rects[0].Enc)
+	}
+	if got, want := len(cursor.Colors), 4; got != want {
+		t.Errorf("incorrect cursor pixel count; got = %d, want = %d", got, want)
+	}
+	for i, want := range []bool{true, false, false, true} {
+		if got := cursor.Visible(&fu.Rects[0], i); got != want {
+			t.Errorf("cursor.Visible(%d) = %v, want = %v", i, got, want)
+		}
+	}
+	if got, want := conn.DesktopName(), "foo"; got != want {
+		t.Errorf("DesktopName() = %q, want = %q", got, want)
+	}
+	if _, ok := fu.Rects[2].Enc.(*PointerPosPseudoEncoding); !ok || fu.Rects[2].X != 5 || fu.Rects[2].Y != 6 {
+		t.Errorf("incorrect pointer position rectangle %v", &fu.Rects[2])
+	}
+	if conn.FramebufferWidth() != 20 || conn.FramebufferHeight() != 30 {
+		t.Errorf("framebuffer size = %dx%d, want 20x30", conn.FramebufferWidth(), conn.FramebufferHeight())
+	}
+
+	// The wire format of each handled rectangle survives a round trip.
+	for _, tt := range []struct {
+		enc  Encoding
+		want []byte
+	}{
+		{fu.Rects[1].Enc, []byte{0, 0, 0, 3, 'f', 'o', 'o'}},
+		{fu.Rects[3].Enc, []byte{}},
+		{fu.Rects[4].Enc, []byte{}},
+	} {
+		got, err := tt.enc.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !operators.EqualSlicesOfByte(got, tt.want) {
+			t.Errorf("%v: Marshal() = %v, want = %v", tt.enc, got, tt.want)
+		}
+	}
+}
+
+func TestPseudoEncodings_LastRectFunc(t *testing.T) {
+	mockConn := &MockConn{}
+	var n int
+	conn := NewClientConn(mockConn, &ClientConfig{
+		RectFunc: func(*Rectangle, int, int) error { n++; return nil },
+	})
+	conn.encodings = Encodings{&LastRectPseudoEncoding{}}
+
+	for _, d := range []interface{}{
+		[3]byte{0, 0xff, 0xff},
+		rectangleMessage{0, 0, 0, 0, encodings.LastRectPseudo},
+	} {
+		if err := conn.send(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	msg, err := (&FramebufferUpdate{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := msg.(*FramebufferUpdate).NumRect, uint16(1); got != want {
+		t.Errorf("NumRect = %d, want = %d", got, want)
+	}
+	if got, want := n, 1; got != want {
+		t.Errorf("RectFunc called %d times, want %d", got, want)
+	}
+}
+
+func TestTurboVNCPseudoEncodings(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		enc  Encoding
+		want encodings.Encoding
+	}{
+		{"quality 0", &FineQualityPseudoEncoding{0}, encodings.FineQualityLevel0Pseudo},
+		{"quality 80", &FineQualityPseudoEncoding{80}, -432},
+		{"quality 100", &FineQualityPseudoEncoding{100}, encodings.FineQualityLevel100Pseudo},
+		{"quality 255", &FineQualityPseudoEncoding{255}, encodings.FineQualityLevel100Pseudo},
+		{"subsampling 1X", &SubsamplingPseudoEncoding{Subsampling1X}, encodings.Subsamp1XPseudo},
+		{"subsampling gray", &SubsamplingPseudoEncoding{SubsamplingGray}, encodings.SubsampGrayPseudo},
+		{"subsampling 16X", &SubsamplingPseudoEncoding{Subsampling16X}, encodings.Subsamp16XPseudo},
+	} {
+		if got, want := tt.enc.Type(), tt.want; got != want {
+			t.Errorf("%s: Type() = %v, want = %v", tt.desc, got, want)
+		}
+		if _, err := tt.enc.Read(nil, &Rectangle{}); err == nil {
+			t.Errorf("%s: expected error reading rectangle", tt.desc)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Lifecycle events of connections.
+
+package vnc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+// The kinds of Event.
+const (
+	// EventConnected is published when Connect completes.
+	EventConnected EventKind = iota
+	// EventAuthenticated is published when the security handshake succeeds.
+	EventAuthenticated
+	// EventResized is published when the server resizes the framebuffer.
+	EventResized
+	// EventCursorChanged is published when the server changes the cursor.
+	EventCursorChanged
+	// EventClipboardReceived is published when the server sends cut text.
+	EventClipboardReceived
+	// EventReconnecting is never published by the library, which doesn't
+	// reconnect; supervisors which reconnect publish it themselves.
+	EventReconnecting
+	// EventClosed is published when the connection is closed.
+	EventClosed
+	// EventIdle is published when the remote screen hasn't changed for
+	// ClientConfig.IdleTimeout.
+	EventIdle
+	// EventActive is published when the remote screen changes once idle.
+	EventActive
+)
+
+var eventKindNames = map[EventKind]string{
+	EventConnected:         "Connected",
+	EventAuthenticated:     "Authenticated",
+	EventResized:           "Resized",
+	EventCursorChanged:     "CursorChanged",
+	EventClipboardReceived: "ClipboardReceived",
+	EventReconnecting:      "Reconnecting",
+	EventClosed:            "Closed",
+	EventIdle:              "Idle",
+	EventActive:            "Active",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("EventKind(%d)", int(k))
+}
+
+// Event is a lifecycle event of a connection.
+type Event struct {
+	Kind EventKind
+	Time time.Time
+	Conn *ClientConn // The connection, if any.
+
+	Width, Height uint16 // The framebuffer size, for EventConnected and EventResized.
+	Text          string // The cut text, for EventClipboardReceived.
+	Err           error  // The cause, for EventReconnecting, if any.
+}
+
+// EventBus publishes events to its subscribers. Set ClientConfig.Events to
+// receive the events of connections; as the bus is independent of the
+// connection, events can be subscribed to before connecting, and a bus can
+// be shared by successive connections.
+//
+// Publishing never blocks: events are dropped for subscribers whose channel
+// is full.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewEventBus returns a new EventBus.
+func NewEventBus() *EventBus {
+	return &EventBus{subs: map[chan Event]struct{}{}}
+}
+
+// Subscribe returns a channel receiving the events published from now on,
+// buffering up to size events, and a function which cancels the subscription,
+// closing the channel.
+func (b *EventBus) Subscribe(size int) (<-chan Event, func()) {
+	ch := make(chan Event, size)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish publishes the event to the subscribers, setting its time if unset.
+// A nil EventBus publishes nothing.
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publish publishes an event of the connection.
+func (c *ClientConn) publish(e Event) {
+	e.Conn = c
+	if c.eventConn != nil {
+		e.Conn = c.eventConn
+	}
+	c.config.Events.Publish(e)
+}
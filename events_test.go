@@ -0,0 +1,91 @@
+package vnc
+
+import (
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+	ch1, cancel1 := bus.Subscribe(1)
+	ch2, cancel2 := bus.Subscribe(2)
+	defer cancel2()
+
+	bus.Publish(Event{Kind: EventConnected})
+	bus.Publish(Event{Kind: EventClosed}) // Dropped for ch1, which is full.
+
+	for _, tt := range []struct {
+		desc  string
+		ch    <-chan Event
+		kinds []EventKind
+	}{
+		{"ch1", ch1, []EventKind{EventConnected}},
+		{"ch2", ch2, []EventKind{EventConnected, EventClosed}},
+	} {
+		if got, want := len(tt.ch), len(tt.kinds); got != want {
+			t.Fatalf("%s: got %d events, want %d", tt.desc, got, want)
+		}
+		for _, want := range tt.kinds {
+			e := <-tt.ch
+			if e.Kind != want {
+				t.Errorf("%s: event = %v, want %v", tt.desc, e.Kind, want)
+			}
+			if e.Time.IsZero() {
+				t.Errorf("%s: event time not set", tt.desc)
+			}
+		}
+	}
+
+	cancel1()
+	cancel1() // Cancelling twice is harmless.
+	if _, ok := <-ch1; ok {
+		t.Error("channel not closed by cancel")
+	}
+	bus.Publish(Event{Kind: EventResized})
+	if got, want := len(ch2), 1; got != want {
+		t.Errorf("got %d events after cancel of another subscriber, want %d", got, want)
+	}
+
+	var nilBus *EventBus
+	nilBus.Publish(Event{}) // Doesn't panic.
+}
+
+func TestClientConn_Events(t *testing.T) {
+	bus := NewEventBus()
+	ch, cancel := bus.Subscribe(10)
+	defer cancel()
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{Events: bus})
+
+	if _, err := readDesktopSize(conn, &Rectangle{Width: 800, Height: 600}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send([]byte{0, 0, 0, 0, 0, 0, 2, 'h', 'i'}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&ServerCutText{}).Read(conn); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	conn.Close() // Published once.
+
+	for _, want := range []Event{
+		{Kind: EventResized, Width: 800, Height: 600},
+		{Kind: EventClipboardReceived, Text: "hi"},
+		{Kind: EventClosed},
+	} {
+		select {
+		case got := <-ch:
+			if got.Kind != want.Kind || got.Width != want.Width || got.Height != want.Height || got.Text != want.Text {
+				t.Errorf("event = %+v, want %+v", got, want)
+			}
+			if got.Conn != conn {
+				t.Errorf("%v event has wrong connection", got.Kind)
+			}
+		default:
+			t.Fatalf("missing %v event", want.Kind)
+		}
+	}
+	if got := len(ch); got != 0 {
+		t.Errorf("got %d unexpected events", got)
+	}
+}
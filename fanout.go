@@ -0,0 +1,148 @@
+// Fan-out of the updates of a screen to independent consumers.
+
+package vnc
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// maxDamageRects is the number of changed rectangles a FrameSubscriber holds,
+// beyond which they are merged into their bounds.
+const maxDamageRects = 64
+
+// FrameSubscriber receives the frames of a Screen, each with the rectangles
+// changed since the last it received. Subscribers are isolated from each
+// other, and from the connection: while a subscriber is busy, e.g. encoding a
+// frame, the updates applied meanwhile are coalesced into the next frame it
+// receives, rather than delaying the others, so a recorder, a streamer and a
+// change detector can each consume the frames of one connection at their own
+// pace.
+type FrameSubscriber struct {
+	s *Screen
+
+	mu      sync.Mutex
+	changed []image.Rectangle // Since the last frame received.
+	updates int               // The updates coalesced since the last frame.
+	time    time.Time         // When the last update was applied.
+	closed  bool
+	ready   chan struct{} // Holds a token while there are changes.
+}
+
+// SubscribeFrames returns a subscriber to the frames of the screen made by
+// the updates applied from now on. It must be closed once no longer used.
+func (s *Screen) SubscribeFrames() *FrameSubscriber {
+	fs := &FrameSubscriber{s: s, ready: make(chan struct{}, 1)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs == nil {
+		s.subs = map[*FrameSubscriber]struct{}{}
+	}
+	s.subs[fs] = struct{}{}
+	return fs
+}
+
+// Next waits for the screen to change, and returns the current frame, with
+// the rectangles changed since the last frame returned, and the time of the
+// last update changing them.
+func (fs *FrameSubscriber) Next(ctx context.Context) (Frame, error) {
+	for {
+		select {
+		case <-fs.ready:
+		case <-ctx.Done():
+			return Frame{}, ctx.Err()
+		}
+		if f, ok, err := fs.take(); ok || err != nil {
+			return f, err
+		}
+	}
+}
+
+// take returns the current frame, if it changed since the last taken.
+func (fs *FrameSubscriber) take() (Frame, bool, error) {
+	// The frame is taken with the screen locked, so the changes match it.
+	fs.s.mu.Lock()
+	defer fs.s.mu.Unlock()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.closed {
+		return Frame{}, false, wrapErrorf(ErrClosed, "frame subscription closed")
+	}
+	if fs.updates == 0 {
+		return Frame{}, false, nil // Taken with the changes of an earlier token.
+	}
+	f := Frame{Time: fs.time, Image: fs.s.fb.Image(), Changed: fs.changed}
+	fs.changed, fs.updates = nil, 0
+	return f, true, nil
+}
+
+// Pending returns the number of updates applied since the last frame
+// returned by Next, which are coalesced into the next.
+func (fs *FrameSubscriber) Pending() int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.updates
+}
+
+// Close cancels the subscription. Next then returns an error.
+func (fs *FrameSubscriber) Close() {
+	fs.s.mu.Lock()
+	delete(fs.s.subs, fs)
+	fs.s.mu.Unlock()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !fs.closed {
+		fs.closed = true
+		fs.notify()
+	}
+}
+
+// add adds the rectangles changed by an update applied at time t.
+func (fs *FrameSubscriber) add(changed []image.Rectangle, t time.Time) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.changed = append(fs.changed, changed...)
+	if len(fs.changed) > maxDamageRects {
+		var r image.Rectangle
+		for _, c := range fs.changed {
+			r = r.Union(c)
+		}
+		fs.changed = append(fs.changed[:0], r)
+	}
+	fs.updates++
+	fs.time = t
+	fs.notify()
+}
+
+// notify makes Next return, without blocking.
+func (fs *FrameSubscriber) notify() {
+	select {
+	case fs.ready <- struct{}{}:
+	default:
+	}
+}
+
+// publish passes the rectangles changed by an update to the subscribers. The
+// screen must be locked.
+func (s *Screen) publish(changed []image.Rectangle, t time.Time) {
+	for fs := range s.subs {
+		fs.add(changed, t)
+	}
+}
+
+// changedRect returns the rectangle of the framebuffer of size w x h changed
+// by applying rect, if any.
+func changedRect(rect *Rectangle, w, h int) (image.Rectangle, bool) {
+	if rect.IsResize() {
+		return image.Rect(0, 0, int(rect.Width), int(rect.Height)), true
+	}
+	if rect.Area() == 0 || rect.Enc == nil || rect.Enc.Type() < 0 {
+		return image.Rectangle{}, false
+	}
+	r := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+	r = r.Intersect(image.Rect(0, 0, w, h))
+	return r, !r.Empty()
+}
@@ -0,0 +1,88 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestScreen_SubscribeFrames(t *testing.T) {
+	s, _ := newTestScreen()
+	slow := s.SubscribeFrames()
+	defer slow.Close()
+	fast := s.SubscribeFrames()
+	defer fast.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The fast subscriber receives each frame, while the slow one falls
+	// behind, without holding up the screen.
+	for i, tt := range []struct {
+		x    uint16
+		want image.Rectangle
+	}{
+		{0, image.Rect(0, 0, 1, 1)},
+		{2, image.Rect(2, 0, 3, 1)},
+	} {
+		if err := s.Handle(rawUpdate(tt.x, 0, Color{R: 0xffff})); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fast.Next(ctx)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if len(f.Changed) != 1 || f.Changed[0] != tt.want {
+			t.Errorf("%d: changed = %v, want [%v]", i, f.Changed, tt.want)
+		}
+		if got, want := f.Image.RGBAAt(int(tt.x), 0).R, uint8(0xff); got != want {
+			t.Errorf("%d: red = %#x, want %#x", i, got, want)
+		}
+	}
+
+	if got, want := slow.Pending(), 2; got != want {
+		t.Errorf("Pending() = %d, want %d", got, want)
+	}
+	f, err := slow.Next(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(f.Changed), 2; got != want {
+		t.Errorf("len(changed) = %d, want %d coalesced", got, want)
+	}
+	if got, want := slow.Pending(), 0; got != want {
+		t.Errorf("Pending() = %d, want %d", got, want)
+	}
+
+	// Nothing changed since.
+	short, cancelShort := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancelShort()
+	if _, err := slow.Next(short); err == nil {
+		t.Error("Next() returned without changes")
+	}
+
+	slow.Close()
+	if _, err := slow.Next(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("Next() error = %v, want %v once closed", err, ErrClosed)
+	}
+}
+
+func TestFrameSubscriber_Coalesce(t *testing.T) {
+	s, _ := newTestScreen()
+	fs := s.SubscribeFrames()
+	defer fs.Close()
+	for i := 0; i < maxDamageRects+1; i++ {
+		if err := s.Handle(rawUpdate(uint16(i%4), uint16(i%3), Color{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := fs.Next(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := image.Rect(0, 0, 4, 3); len(f.Changed) != 1 || f.Changed[0] != want {
+		t.Errorf("changed = %v, want [%v]", f.Changed, want)
+	}
+}
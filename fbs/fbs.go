@@ -0,0 +1,145 @@
+/*
+Package fbs reads and writes framebuffer stream (FBS) files.
+
+An FBS file holds a recording of the server-to-client half of an RFB
+session, as captured by rfbproxy and replayed by players such as noVNC. The
+file starts with a version header, followed by blocks of data exactly as they
+were received from the server, each stamped with the time since the recording
+started.
+
+	header:    "FBS 001.000\n"
+	block:     length (U32), data (length bytes, padded to a multiple of 4),
+	           timestamp in milliseconds (U32)
+
+All integers are big-endian.
+*/
+package fbs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Header is the version header of an FBS file.
+const Header = "FBS 001.000\n"
+
+// DefaultMaxBlockLength is the default maximum length of the data of a block
+// read, enough for a raw update of a 4K framebuffer of 32 bits per pixel.
+const DefaultMaxBlockLength = 64 << 20 // 64 MiB
+
+// Block holds one block of recorded data.
+type Block struct {
+	Data      []byte
+	Timestamp time.Duration // Since the start of the recording.
+}
+
+// padLen returns the padding needed to align n bytes to 4 bytes.
+func padLen(n int) int { return (4 - n%4) % 4 }
+
+//-----------------------------------------------------------------------------
+// Writer
+
+// Writer records data written to it as FBS blocks.
+type Writer struct {
+	w     io.Writer
+	start time.Time
+	now   func() time.Time
+}
+
+// Verify that interfaces are honored.
+var _ io.Writer = (*Writer)(nil)
+
+// NewWriter writes the FBS header to w, and returns a Writer whose timestamps
+// are relative to now.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return newWriter(w, time.Now)
+}
+
+func newWriter(w io.Writer, now func() time.Time) (*Writer, error) {
+	if _, err := io.WriteString(w, Header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, start: now(), now: now}, nil
+}
+
+// Write records p as a single block, stamped with the current time.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBlock(&Block{p, w.now().Sub(w.start)})
+}
+
+// WriteBlock records a block with an explicit timestamp.
+func (w *Writer) WriteBlock(b *Block) (int, error) {
+	if len(b.Data) == 0 {
+		return 0, nil
+	}
+
+	n := len(b.Data)
+	buf := make([]byte, 4+n+padLen(n)+4)
+	binary.BigEndian.PutUint32(buf[0:], uint32(n))
+	copy(buf[4:], b.Data)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(b.Timestamp/time.Millisecond))
+	if _, err := w.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+//-----------------------------------------------------------------------------
+// Reader
+
+// Reader reads the blocks of an FBS file.
+type Reader struct {
+	r io.Reader
+
+	// MaxBlockLength is the maximum length of the data of a block, so that a
+	// corrupt or hostile file can't exhaust memory. If zero,
+	// DefaultMaxBlockLength is used.
+	MaxBlockLength int
+}
+
+// NewReader reads and validates the FBS header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	hdr := make([]byte, len(Header))
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, fmt.Errorf("error reading FBS header: %v", err)
+	}
+	if string(hdr) != Header {
+		return nil, fmt.Errorf("invalid FBS header %q", hdr)
+	}
+	return &Reader{r: r}, nil
+}
+
+// Next returns the next block, or io.EOF at the end of the file.
+func (r *Reader) Next() (*Block, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r.r, l[:]); err != nil {
+		return nil, err // io.EOF at a block boundary.
+	}
+	n := int(binary.BigEndian.Uint32(l[:]))
+	if max := r.maxBlockLength(); n > max {
+		return nil, fmt.Errorf("FBS block of %d bytes exceeds the maximum of %d", n, max)
+	}
+
+	buf := make([]byte, n+padLen(n)+4)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	ts := binary.BigEndian.Uint32(buf[len(buf)-4:])
+	return &Block{
+		Data:      buf[:n:n],
+		Timestamp: time.Duration(ts) * time.Millisecond,
+	}, nil
+}
+
+// maxBlockLength returns the effective maximum length of a block.
+func (r *Reader) maxBlockLength() int {
+	if r.MaxBlockLength <= 0 {
+		return DefaultMaxBlockLength
+	}
+	return r.MaxBlockLength
+}
@@ -0,0 +1,112 @@
+package fbs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWriterReader(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+
+	var buf bytes.Buffer
+	w, err := newWriter(&buf, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	blocks := []Block{
+		{[]byte("RFB 003.008\n"), 0},
+		{[]byte{1, 2, 3}, 1500 * time.Millisecond},
+		{[]byte{4, 5, 6, 7, 8}, 3 * time.Second},
+	}
+	for _, b := range blocks {
+		now = time.Unix(1000, 0).Add(b.Timestamp)
+		if n, err := w.Write(b.Data); err != nil || n != len(b.Data) {
+			t.Fatalf("Write() = %d, %v; want %d, nil", n, err, len(b.Data))
+		}
+	}
+	if n, err := w.Write(nil); n != 0 || err != nil {
+		t.Errorf("Write(nil) = %d, %v; want 0, nil", n, err)
+	}
+	if got, want := buf.Len(), len(Header)+(4+12+4)+(4+4+4)+(4+8+4); got != want {
+		t.Errorf("file length = %d, want = %d", got, want)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i, want := range blocks {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("block %d: unexpected error: %s", i, err)
+		}
+		if !bytes.Equal(got.Data, want.Data) || got.Timestamp != want.Timestamp {
+			t.Errorf("block %d = %v, want = %v", i, got, want)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF; got %v", err)
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		data string
+		ok   bool
+	}{
+		{"valid", Header, true},
+		{"bad version", "FBS 002.000\n", false},
+		{"short", "FBS", false},
+		{"empty", "", false},
+	} {
+		_, err := NewReader(bytes.NewBufferString(tt.data))
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+	}
+}
+
+func TestReader_Truncated(t *testing.T) {
+	data := Header + "\x00\x00\x00\x05abc"
+	r, err := NewReader(bytes.NewBufferString(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF; got %v", err)
+	}
+}
+
+func TestReader_MaxBlockLength(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		max  int
+		data string
+		ok   bool
+	}{
+		{"default", 0, "\x00\x00\x00\x03abc\x00\x00\x00\x00\x00", true},
+		{"default exceeded", 0, "\xff\xff\xff\xff", false},
+		{"within", 4, "\x00\x00\x00\x04abcd\x00\x00\x00\x00", true},
+		{"exceeded", 4, "\x00\x00\x00\x05abcde", false},
+	} {
+		r, err := NewReader(bytes.NewBufferString(Header + tt.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.MaxBlockLength = tt.max
+		_, err = r.Next()
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Filters of the decoded rectangles of framebuffer updates.
+
+package vnc
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// RectFilter describes a function filtering each rectangle of a
+// FramebufferUpdate once decoded, before it is delivered to RectFunc, or with
+// the update, e.g. to redact a region of the screen from a recording. A
+// non-nil error aborts reading of the message.
+//
+// The colors of the rectangle may be shared, e.g. by a RectCache, so filters
+// mustn't modify them in place, but set rect.Enc to a filtered copy instead.
+type RectFilter func(rect *Rectangle) error
+
+// filterRect runs the configured filters on the rectangle.
+func (c *ClientConn) filterRect(rect *Rectangle) error {
+	for _, f := range c.config.RectFilters {
+		if err := f(rect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RedactFilter returns a filter painting the regions of the framebuffer with
+// color c, in the pixel format of each rectangle. CopyRect rectangles are kept,
+// as they only copy pixels which were already filtered. Rectangles over the
+// regions whose pixels aren't decoded, e.g. with LazyPayloads, fail with
+// ErrUnsupportedEncoding, so that nothing is leaked.
+func RedactFilter(c color.Color, regions ...image.Rectangle) RectFilter {
+	return func(rect *Rectangle) error {
+		if rect.Area() == 0 || rect.Enc == nil || rect.Enc.Type() < 0 {
+			return nil
+		}
+		r := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+		var over []image.Rectangle
+		for _, region := range regions {
+			if o := region.Intersect(r); !o.Empty() {
+				over = append(over, o.Sub(r.Min))
+			}
+		}
+		if len(over) == 0 {
+			return nil
+		}
+		if _, ok := rect.Enc.(*CopyRectEncoding); ok {
+			return nil
+		}
+		colors, ok := rectColors(rect)
+		if !ok {
+			return wrapErrorf(ErrUnsupportedEncoding, "unable to redact rectangle with encoding %v", rect.Enc.Type())
+		}
+		colors = append([]Color(nil), colors...)
+		w := int(rect.Width)
+		for _, o := range over {
+			for y := o.Min.Y; y < o.Max.Y; y++ {
+				for x := o.Min.X; x < o.Max.X; x++ {
+					colors[y*w+x] = colorLike(c, colors[y*w+x])
+				}
+			}
+		}
+		setRectColors(rect, colors)
+		return nil
+	}
+}
+
+// GrayscaleFilter returns a filter converting the pixels of each rectangle to
+// shades of gray, in the pixel format of the rectangle.
+func GrayscaleFilter() RectFilter {
+	return mapFilter(func(c Color) Color {
+		return colorLike(color.Gray16Model.Convert(c), c)
+	})
+}
+
+// NormalizeFilter returns a filter converting the pixels of each rectangle to
+// 16-bit RGB colors, regardless of the pixel format of the connection, e.g.
+// for consumers comparing the colors of servers of different pixel formats.
+// The colors can't be marshaled once normalized.
+func NormalizeFilter() RectFilter {
+	return mapFilter(func(c Color) Color {
+		r, g, b, _ := c.RGBA()
+		return Color{R: uint16(r), G: uint16(g), B: uint16(b)}
+	})
+}
+
+// mapFilter returns a filter mapping each pixel of the rectangles whose
+// pixels are decoded with fn.
+func mapFilter(fn func(Color) Color) RectFilter {
+	return func(rect *Rectangle) error {
+		colors, ok := rectColors(rect)
+		if !ok || len(colors) == 0 {
+			return nil
+		}
+		mapped := make([]Color, len(colors))
+		for i, c := range colors {
+			mapped[i] = fn(c)
+		}
+		setRectColors(rect, mapped)
+		return nil
+	}
+}
+
+// rectColors returns the decoded pixels of the rectangle, if any.
+func rectColors(rect *Rectangle) ([]Color, bool) {
+	switch enc := rect.Enc.(type) {
+	case *RawEncoding:
+		return enc.Colors, true
+	case *ZstdEncoding:
+		return enc.Colors, true
+	}
+	return nil, false
+}
+
+// setRectColors sets the decoded pixels of the rectangle to a copy of its
+// encoding holding colors.
+func setRectColors(rect *Rectangle, colors []Color) {
+	switch enc := rect.Enc.(type) {
+	case *RawEncoding:
+		rect.Enc = &RawEncoding{Colors: colors}
+	case *ZstdEncoding:
+		e := *enc
+		e.Colors = colors
+		rect.Enc = &e
+	}
+}
+
+// colorLike returns c as a Color of the pixel format and color map of like.
+// Colors of color map pixel formats are the closest of the color map.
+func colorLike(c color.Color, like Color) Color {
+	r, g, b, _ := c.RGBA()
+	rgb := Color{R: uint16(r), G: uint16(g), B: uint16(b)}
+	switch {
+	case like.pf == nil:
+		return rgb
+	case rfbflags.IsTrueColor(like.pf.TrueColor):
+		c, _ := convertColor(rgb, like.pf)
+		c.cm = like.cm
+		return c
+	case like.cm == nil:
+		return like
+	}
+	best, bestDist := 0, uint64(1<<64-1)
+	for i, e := range like.cm {
+		dr, dg, db := int64(r)-int64(e.R), int64(g)-int64(e.G), int64(b)-int64(e.B)
+		if d := uint64(dr*dr + dg*dg + db*db); d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	e := like.cm[best]
+	e.pf, e.cm, e.cmIndex = like.pf, like.cm, uint32(best)
+	return e
+}
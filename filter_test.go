@@ -0,0 +1,119 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// filterPixelFormat is a 32bpp pixel format of 8 bits per color.
+var filterPixelFormat = PixelFormat{BPP: 32, Depth: 24, TrueColor: RFBTrue, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8}
+
+func TestRedactFilter(t *testing.T) {
+	pf := filterPixelFormat
+	colors := make([]Color, 4*2)
+	for i := range colors {
+		colors[i] = Color{pf: &pf, R: 0xff}
+	}
+	rect := &Rectangle{X: 1, Y: 1, Width: 4, Height: 2, Enc: &RawEncoding{colors}}
+	// The region covers the pixels at (2, 2) and (3, 2) of the framebuffer.
+	f := RedactFilter(color.White, image.Rect(2, 2, 4, 10), image.Rect(20, 20, 30, 30))
+	if err := f(rect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := rect.Enc.(*RawEncoding).Colors
+	for i, c := range got {
+		want := Color{pf: &pf, R: 0xff}
+		if i == 5 || i == 6 {
+			want = Color{pf: &pf, R: 0xff, G: 0xff, B: 0xff}
+		}
+		if c != want {
+			t.Errorf("Colors[%d] = %v, want %v", i, c, want)
+		}
+	}
+	if colors[5].G != 0 {
+		t.Error("colors of the rectangle modified in place")
+	}
+
+	for _, tt := range []struct {
+		desc string
+		rect *Rectangle
+		ok   bool
+	}{
+		{"copy", &Rectangle{X: 2, Y: 2, Width: 1, Height: 1, Enc: &CopyRectEncoding{}}, true},
+		{"outside", &Rectangle{Width: 1, Height: 1, Enc: &LazyEncoding{}}, true},
+		{"lazy", &Rectangle{X: 2, Y: 2, Width: 1, Height: 1, Enc: &LazyEncoding{}}, false},
+	} {
+		err := f(tt.rect)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrUnsupportedEncoding) {
+			t.Errorf("%s: error %v, want ErrUnsupportedEncoding", tt.desc, err)
+		}
+	}
+}
+
+func TestGrayscaleFilter(t *testing.T) {
+	pf := filterPixelFormat
+	rect := &Rectangle{Width: 2, Height: 1, Enc: &RawEncoding{[]Color{
+		{pf: &pf, R: 0xff},
+		{pf: &pf, R: 0xff, G: 0xff, B: 0xff},
+	}}}
+	if err := GrayscaleFilter()(rect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, c := range rect.Enc.(*RawEncoding).Colors {
+		if c.R != c.G || c.G != c.B {
+			t.Errorf("Colors[%d] = %v, want gray", i, c)
+		}
+	}
+	if got := rect.Enc.(*RawEncoding).Colors[1]; got.R != 0xff {
+		t.Errorf("white = %v, want white", got)
+	}
+}
+
+func TestNormalizeFilter(t *testing.T) {
+	pf := PixelFormat16bit // 5 bits of red.
+	rect := &Rectangle{Width: 1, Height: 1, Enc: &RawEncoding{[]Color{{pf: &pf, R: pf.RedMax}}}}
+	if err := NormalizeFilter()(rect); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := rect.Enc.(*RawEncoding).Colors[0], (Color{R: 0xffff}); got != want {
+		t.Errorf("color = %v, want %v", got, want)
+	}
+}
+
+func TestFramebufferUpdate_RectFilters(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.config.RectFilters = []RectFilter{
+		RedactFilter(color.Black, image.Rect(0, 0, 1, 1)),
+		func(rect *Rectangle) error {
+			if c := rect.Enc.(*RawEncoding).Colors[0]; c.R != 0 {
+				t.Errorf("filters run out of order; color = %v", c)
+			}
+			return nil
+		},
+	}
+	conn.pixelFormat = filterPixelFormat
+	conn.fbWidth, conn.fbHeight = 4, 3
+	pf := conn.pixelFormat
+	bytes, err := newFramebufferUpdate([]Rectangle{
+		{0, 0, 1, 1, &RawEncoding{[]Color{{pf: &pf, R: 0xff}}}, conn.Encodable},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(bytes[1:]); err != nil { // Strip message-type.
+		t.Fatal(err)
+	}
+	msg, err := (&FramebufferUpdate{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c := msg.(*FramebufferUpdate).Rects[0].Enc.(*RawEncoding).Colors[0]; c.R != 0 {
+		t.Errorf("color = %v, want black", c)
+	}
+}
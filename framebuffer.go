@@ -0,0 +1,266 @@
+// A client-side copy of the remote framebuffer.
+
+package vnc
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+)
+
+// Framebuffer holds a client-side copy of the remote framebuffer. It is kept
+// up to date by applying the rectangles of each FramebufferUpdate message.
+type Framebuffer struct {
+	Width, Height int
+	Pixels        []Color // Row-major order.
+}
+
+// NewFramebuffer returns a new Framebuffer of the given dimensions.
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{
+		Width:  width,
+		Height: height,
+		Pixels: make([]Color, width*height),
+	}
+}
+
+// At returns the color of the pixel at (x, y).
+func (fb *Framebuffer) At(x, y int) Color {
+	return fb.Pixels[y*fb.Width+x]
+}
+
+// Image returns a copy of the framebuffer as an image.
+func (fb *Framebuffer) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, fb.Width, fb.Height))
+	for i, c := range fb.Pixels {
+		r, g, b, _ := c.RGBA()
+		p := img.Pix[4*i : 4*i+4]
+		p[0], p[1], p[2], p[3] = uint8(r>>8), uint8(g>>8), uint8(b>>8), 0xff
+	}
+	return img
+}
+
+// Apply updates the framebuffer with the contents of rect.
+//
+// Rectangles with zero area are ignored, as are pseudo-encodings other than
+// the resizes, DesktopSize and ExtendedDesktopSize, which resize (and clear)
+// the framebuffer. CopyRect source and destination rectangles may overlap.
+func (fb *Framebuffer) Apply(rect *Rectangle) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Framebuffer." + logging.FnName())
+	}
+
+	if rect.IsResize() {
+		*fb = *NewFramebuffer(int(rect.Width), int(rect.Height))
+		return nil
+	}
+	if rect.Area() == 0 || rect.Enc.Type() < 0 {
+		return nil
+	}
+	if err := rect.validateBounds(uint16(fb.Width), uint16(fb.Height)); err != nil {
+		return err
+	}
+
+	x, y, w, h := int(rect.X), int(rect.Y), int(rect.Width), int(rect.Height)
+	switch enc := rect.Enc.(type) {
+	case *RawEncoding, *ZstdEncoding:
+		colors := rawColors(enc)
+		if len(colors) != rect.Area() {
+			return Errorf("raw rectangle has %d pixels; expected %d", len(colors), rect.Area())
+		}
+		for row := 0; row < h; row++ {
+			copy(fb.Pixels[(y+row)*fb.Width+x:], colors[row*w:(row+1)*w])
+		}
+	case *CopyRectEncoding:
+		src := Rectangle{X: enc.SX, Y: enc.SY, Width: rect.Width, Height: rect.Height}
+		if err := src.validateBounds(uint16(fb.Width), uint16(fb.Height)); err != nil {
+			return err
+		}
+		fb.copyRect(int(enc.SX), int(enc.SY), x, y, w, h)
+	default:
+		return wrapErrorf(ErrUnsupportedEncoding, "unable to apply encoding %v to framebuffer", rect.Enc.Type())
+	}
+	return nil
+}
+
+// rawColors returns the pixels of a rectangle with an encoding holding raw
+// pixel data, i.e. Raw or ZSTD, which is decompressed to raw pixel data.
+func rawColors(enc Encoding) []Color {
+	switch enc := enc.(type) {
+	case *RawEncoding:
+		return enc.Colors
+	case *ZstdEncoding:
+		return enc.Colors
+	}
+	return nil
+}
+
+// copyRect copies a w x h rectangle of pixels from (sx, sy) to (dx, dy). The
+// rectangles may overlap. Rows are copied in the direction which doesn't
+// overwrite source rows before they are read, and copy() handles overlap
+// within a row.
+func (fb *Framebuffer) copyRect(sx, sy, dx, dy, w, h int) {
+	if sy >= dy {
+		for row := 0; row < h; row++ {
+			fb.copyRow(sx, sy+row, dx, dy+row, w)
+		}
+		return
+	}
+	for row := h - 1; row >= 0; row-- {
+		fb.copyRow(sx, sy+row, dx, dy+row, w)
+	}
+}
+
+func (fb *Framebuffer) copyRow(sx, sy, dx, dy, w int) {
+	src := fb.Pixels[sy*fb.Width+sx : sy*fb.Width+sx+w]
+	copy(fb.Pixels[dy*fb.Width+dx:], src)
+}
+
+// matches returns true if the region of the framebuffer matches img, with
+// each color channel differing by at most tolerance (0-255). The origin of
+// img is at the top-left corner of the region.
+func (fb *Framebuffer) matches(r image.Rectangle, img image.Image, tolerance int) bool {
+	if !r.In(image.Rect(0, 0, fb.Width, fb.Height)) {
+		return false
+	}
+	b := img.Bounds()
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			r1, g1, b1, _ := fb.At(r.Min.X+x, r.Min.Y+y).RGBA()
+			r2, g2, b2, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			if channelDiff(r1, r2) > tolerance || channelDiff(g1, g2) > tolerance || channelDiff(b1, b2) > tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// channelDiff returns the absolute difference of two 16-bit color channels,
+// scaled to 8 bits.
+func channelDiff(a, b uint32) int {
+	d := int(a>>8) - int(b>>8)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+//-----------------------------------------------------------------------------
+// Template matching
+//
+// Locating a small reference image (e.g. a button) on the screen, for
+// automation. The matchers are brute force, and intended for needles much
+// smaller than the framebuffer.
+
+// FindImage returns the position of the first exact occurrence of needle in
+// the framebuffer, scanning rows from the top. Colors are compared with 8 bits
+// per channel, and fully transparent pixels of needle match any color.
+func (fb *Framebuffer) FindImage(needle image.Image) (image.Point, bool) {
+	hay, n := fb.Image(), toRGBA(needle)
+	w, h := n.Rect.Dx(), n.Rect.Dy()
+	if w == 0 || h == 0 || w > fb.Width || h > fb.Height {
+		return image.Point{}, false
+	}
+	for y := 0; y <= fb.Height-h; y++ {
+	Position:
+		for x := 0; x <= fb.Width-w; x++ {
+			for ny := 0; ny < h; ny++ {
+				hp := hay.Pix[hay.PixOffset(x, y+ny):]
+				np := n.Pix[n.PixOffset(0, ny):]
+				for i := 0; i < 4*w; i += 4 {
+					if np[i+3] == 0 {
+						continue
+					}
+					if hp[i] != np[i] || hp[i+1] != np[i+1] || hp[i+2] != np[i+2] {
+						continue Position
+					}
+				}
+			}
+			return image.Pt(x, y), true
+		}
+	}
+	return image.Point{}, false
+}
+
+// BestMatch returns the position at which needle best matches the
+// framebuffer, and the score of the match. The score is the normalized
+// cross-correlation of the grayscale images, from -1 to 1, where 1 is a
+// perfect match. Unlike FindImage, it tolerates changes of brightness and
+// contrast. A score of 0 is returned if needle doesn't fit the framebuffer.
+func (fb *Framebuffer) BestMatch(needle image.Image) (image.Point, float64) {
+	n := toRGBA(needle)
+	w, h := n.Rect.Dx(), n.Rect.Dy()
+	if w == 0 || h == 0 || w > fb.Width || h > fb.Height {
+		return image.Point{}, 0
+	}
+	hay := grayscale(fb.Image())
+	tmpl := grayscale(n)
+
+	// Zero-mean the template.
+	var mean float64
+	for _, v := range tmpl {
+		mean += v
+	}
+	mean /= float64(len(tmpl))
+	var tVar float64
+	for i := range tmpl {
+		tmpl[i] -= mean
+		tVar += tmpl[i] * tmpl[i]
+	}
+
+	best, bestScore := image.Point{}, math.Inf(-1)
+	size := float64(w * h)
+	for y := 0; y <= fb.Height-h; y++ {
+		for x := 0; x <= fb.Width-w; x++ {
+			var sum, sumSq, cross float64
+			for ny := 0; ny < h; ny++ {
+				row := hay[(y+ny)*fb.Width+x:]
+				t := tmpl[ny*w:]
+				for nx := 0; nx < w; nx++ {
+					v := row[nx]
+					sum += v
+					sumSq += v * v
+					cross += v * t[nx]
+				}
+			}
+			hVar := sumSq - sum*sum/size
+			var score float64
+			switch {
+			case hVar > 1e-9 && tVar > 1e-9:
+				score = cross / math.Sqrt(hVar*tVar)
+			case hVar <= 1e-9 && tVar <= 1e-9:
+				// Both are flat, so they match if their brightness does.
+				score = 1 - math.Abs(sum/size-mean)/0xff
+			}
+			if score > bestScore {
+				best, bestScore = image.Pt(x, y), score
+			}
+		}
+	}
+	return best, bestScore
+}
+
+// toRGBA returns img as an RGBA image, with its origin at (0, 0).
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// grayscale returns the luma of each pixel of img, in row-major order.
+func grayscale(img *image.RGBA) []float64 {
+	g := make([]float64, 0, len(img.Pix)/4)
+	for i := 0; i < len(img.Pix); i += 4 {
+		p := img.Pix[i : i+3]
+		g = append(g, 0.299*float64(p[0])+0.587*float64(p[1])+0.114*float64(p[2]))
+	}
+	return g
+}
@@ -0,0 +1,255 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// newTestFramebuffer returns a framebuffer where each pixel has a unique color.
+func newTestFramebuffer(width, height int) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	for i := range fb.Pixels {
+		fb.Pixels[i] = Color{R: uint16(i)}
+	}
+	return fb
+}
+
+func TestFramebuffer_Apply(t *testing.T) {
+	fb := newTestFramebuffer(4, 3)
+
+	// Raw.
+	colors := []Color{{R: 100}, {R: 101}, {R: 102}, {R: 103}}
+	if err := fb.Apply(&Rectangle{X: 1, Y: 1, Width: 2, Height: 2, Enc: &RawEncoding{colors}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, tt := range []struct {
+		x, y int
+		r    uint16
+	}{
+		{0, 0, 0}, {1, 1, 100}, {2, 1, 101}, {1, 2, 102}, {2, 2, 103}, {3, 2, 11},
+	} {
+		if got, want := fb.At(tt.x, tt.y).R, tt.r; got != want {
+			t.Errorf("At(%d, %d) = %d, want = %d", tt.x, tt.y, got, want)
+		}
+	}
+
+	// ZSTD, decompressed to raw pixel data.
+	colors = []Color{{R: 200}, {R: 201}}
+	if err := fb.Apply(&Rectangle{X: 2, Y: 0, Width: 2, Height: 1, Enc: &ZstdEncoding{Colors: colors}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := fb.At(3, 0).R, uint16(201); got != want {
+		t.Errorf("At(3, 0) = %d, want = %d", got, want)
+	}
+
+	// Zero-area rectangles are ignored, wherever they are.
+	for _, rect := range []*Rectangle{
+		{X: 0, Y: 0, Width: 0, Height: 0, Enc: &RawEncoding{}},
+		{X: 100, Y: 100, Width: 0, Height: 5, Enc: &RawEncoding{}},
+		{X: 1, Y: 1, Width: 3, Height: 0, Enc: &CopyRectEncoding{SX: 200, SY: 200}},
+	} {
+		if err := fb.Apply(rect); err != nil {
+			t.Errorf("%v: unexpected error: %s", rect, err)
+		}
+	}
+
+	// Out of bounds.
+	for _, rect := range []*Rectangle{
+		{X: 3, Y: 0, Width: 2, Height: 1, Enc: &RawEncoding{make([]Color, 2)}},
+		{X: 0, Y: 0, Width: 2, Height: 2, Enc: &CopyRectEncoding{SX: 3, SY: 0}},
+	} {
+		if err := fb.Apply(rect); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%v: error = %v, want ErrProtocol", rect, err)
+		}
+	}
+
+	// Raw pixel count mismatch.
+	if err := fb.Apply(&Rectangle{Width: 2, Height: 2, Enc: &RawEncoding{make([]Color, 3)}}); err == nil {
+		t.Error("expected error")
+	}
+
+	// DesktopSize.
+	if err := fb.Apply(&Rectangle{Width: 8, Height: 6, Enc: &DesktopSizePseudoEncoding{}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := len(fb.Pixels), 8*6; fb.Width != 8 || fb.Height != 6 || got != want {
+		t.Errorf("framebuffer %dx%d with %d pixels, want 8x6 with %d", fb.Width, fb.Height, got, want)
+	}
+}
+
+func TestFramebuffer_CopyRect(t *testing.T) {
+	const width, height = 8, 6
+	for _, tt := range []struct {
+		desc           string
+		sx, sy, dx, dy int
+		w, h           int
+	}{
+		{"disjoint", 0, 0, 4, 3, 3, 3},
+		{"overlap down", 1, 1, 1, 2, 4, 3},
+		{"overlap up", 1, 2, 1, 1, 4, 3},
+		{"overlap right", 1, 1, 2, 1, 4, 3},
+		{"overlap left", 2, 1, 1, 1, 4, 3},
+		{"overlap down right", 0, 0, 1, 1, 7, 5},
+		{"overlap up left", 1, 1, 0, 0, 7, 5},
+		{"overlap down left", 1, 0, 0, 1, 7, 5},
+		{"overlap up right", 0, 1, 1, 0, 7, 5},
+		{"same position", 2, 2, 2, 2, 3, 3},
+	} {
+		fb := newTestFramebuffer(width, height)
+
+		// Copy via a temporary buffer to compute the expected result.
+		want := newTestFramebuffer(width, height)
+		tmp := make([]Color, tt.w*tt.h)
+		for y := 0; y < tt.h; y++ {
+			for x := 0; x < tt.w; x++ {
+				tmp[y*tt.w+x] = want.At(tt.sx+x, tt.sy+y)
+			}
+		}
+		for y := 0; y < tt.h; y++ {
+			for x := 0; x < tt.w; x++ {
+				want.Pixels[(tt.dy+y)*width+tt.dx+x] = tmp[y*tt.w+x]
+			}
+		}
+
+		rect := &Rectangle{
+			X: uint16(tt.dx), Y: uint16(tt.dy), Width: uint16(tt.w), Height: uint16(tt.h),
+			Enc: &CopyRectEncoding{SX: uint16(tt.sx), SY: uint16(tt.sy)},
+		}
+		if err := fb.Apply(rect); err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		for i := range want.Pixels {
+			if got, want := fb.Pixels[i].R, want.Pixels[i].R; got != want {
+				t.Errorf("%s: incorrect pixel (%d, %d); got = %d, want = %d", tt.desc, i%width, i/width, got, want)
+				break
+			}
+		}
+	}
+}
+
+func TestFramebuffer_Image(t *testing.T) {
+	pf := PixelFormat16bit
+	pf.TrueColor, pf.RedMax, pf.GreenMax, pf.BlueMax = RFBTrue, 31, 63, 31
+	fb := NewFramebuffer(3, 1)
+	fb.Pixels[0] = Color{pf: &pf, R: 31, G: 0, B: 0}
+	fb.Pixels[1] = Color{pf: &pf, R: 0, G: 63, B: 31}
+	fb.Pixels[2] = Color{R: 0x8000, G: 0x4000, B: 0xffff} // Color map entry.
+
+	img := fb.Image()
+	if got, want := img.Bounds(), image.Rect(0, 0, 3, 1); got != want {
+		t.Fatalf("Bounds() = %v, want = %v", got, want)
+	}
+	for i, want := range []color.RGBA{
+		{0xff, 0, 0, 0xff},
+		{0, 0xff, 0xff, 0xff},
+		{0x80, 0x40, 0xff, 0xff},
+	} {
+		if got := img.RGBAAt(i, 0); got != want {
+			t.Errorf("pixel %d = %v, want = %v", i, got, want)
+		}
+	}
+}
+
+// newGrayFramebuffer returns a framebuffer with a distinct, scrambled, gray
+// level for each pixel.
+func newGrayFramebuffer(width, height int) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	for i := range fb.Pixels {
+		v := uint16((i*i*37+i*11)%256) << 8
+		fb.Pixels[i] = Color{R: v, G: v, B: v}
+	}
+	return fb
+}
+
+func TestFramebuffer_FindImage(t *testing.T) {
+	fb := newGrayFramebuffer(8, 6)
+	hay := fb.Image()
+
+	// A needle with a non-zero origin, and a transparent corner.
+	needle := image.NewRGBA(image.Rect(10, 10, 13, 12))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			needle.Set(10+x, 10+y, hay.At(4+x, 3+y))
+		}
+	}
+	holey := image.NewRGBA(needle.Rect)
+	copy(holey.Pix, needle.Pix)
+	holey.Set(10, 10, color.Transparent)
+	missing := image.NewRGBA(needle.Rect)
+	copy(missing.Pix, needle.Pix)
+	missing.Set(11, 11, color.White)
+
+	for _, tt := range []struct {
+		desc   string
+		needle image.Image
+		pt     image.Point
+		ok     bool
+	}{
+		{"found", needle, image.Pt(4, 3), true},
+		{"transparent pixels", holey, image.Pt(4, 3), true},
+		{"not found", missing, image.Point{}, false},
+		{"whole framebuffer", hay, image.Point{}, true},
+		{"too large", image.NewRGBA(image.Rect(0, 0, 9, 1)), image.Point{}, false},
+		{"empty", image.NewRGBA(image.Rect(0, 0, 0, 0)), image.Point{}, false},
+	} {
+		pt, ok := fb.FindImage(tt.needle)
+		if got, want := ok, tt.ok; got != want {
+			t.Errorf("%s: FindImage() ok = %v, want = %v", tt.desc, got, want)
+			continue
+		}
+		if got, want := pt, tt.pt; got != want {
+			t.Errorf("%s: FindImage() = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestFramebuffer_BestMatch(t *testing.T) {
+	fb := newGrayFramebuffer(8, 6)
+	hay := fb.Image()
+
+	// The needle is a darker, lower contrast copy of part of the framebuffer.
+	needle := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			c := hay.RGBAAt(2+x, 1+y)
+			v := c.R/2 + 10
+			needle.SetRGBA(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+	if _, ok := fb.FindImage(needle); ok {
+		t.Fatal("FindImage() found the altered needle")
+	}
+	pt, score := fb.BestMatch(needle)
+	if got, want := pt, image.Pt(2, 1); got != want {
+		t.Errorf("BestMatch() = %v, want = %v", got, want)
+	}
+	if score < 0.99 {
+		t.Errorf("BestMatch() score = %v, want >= 0.99", score)
+	}
+
+	// Flat needles match flat regions of the same brightness.
+	fb = NewFramebuffer(4, 4)
+	for i := range fb.Pixels {
+		if i%4 >= 2 {
+			fb.Pixels[i] = Color{R: 0xffff, G: 0xffff, B: 0xffff}
+		}
+	}
+	white := image.NewUniform(color.White)
+	flat := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(flat, flat.Rect, white, image.Point{}, draw.Src)
+	pt, score = fb.BestMatch(flat)
+	if got, want := pt, image.Pt(2, 0); got != want {
+		t.Errorf("BestMatch(flat) = %v, want = %v", got, want)
+	}
+	if got, want := score, 1.0; got != want {
+		t.Errorf("BestMatch(flat) score = %v, want = %v", got, want)
+	}
+
+	if _, score := fb.BestMatch(image.NewRGBA(image.Rect(0, 0, 5, 1))); score != 0 {
+		t.Errorf("BestMatch(too large) score = %v, want = 0", score)
+	}
+}
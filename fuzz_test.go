@@ -0,0 +1,64 @@
+package vnc
+
+// Fuzz targets for the server message and encoding decoders.
+//
+//   $ go test -run=NONE -fuzz=FuzzServerMessage
+
+import (
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// newFuzzConn returns a ClientConn with all the supported encodings enabled.
+func newFuzzConn(pf PixelFormat) *ClientConn {
+	conn := NewClientConn(newByteConn(nil), NewClientConfig(""))
+	conn.config.TolerateUnknownEncodings = true
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}
+	conn.pixelFormat = pf
+	conn.fbWidth, conn.fbHeight = 64, 64
+	return conn
+}
+
+func FuzzServerMessage(f *testing.F) {
+	for _, c := range loadCorpora(f) {
+		f.Add(c.stream)
+	}
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 2, 0, 0, 0, 0})             // FramebufferUpdate
+	f.Add([]byte{1, 0, 0, 254, 0, 1, 1, 2, 3, 4, 5, 6})                       // SetColorMapEntries
+	f.Add([]byte{2})                                                          // Bell
+	f.Add([]byte{3, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'})                      // ServerCutText
+	f.Add([]byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0x20}) // LastRect
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, pf := range []PixelFormat{PixelFormat8bit, PixelFormat16bit, PixelFormat32bit} {
+			conn := newFuzzConn(pf)
+			msg, err := conn.UnmarshalServerMessage(data)
+			if err == nil && msg == nil {
+				t.Fatal("nil message without error")
+			}
+		}
+	})
+}
+
+func FuzzEncoding(f *testing.F) {
+	f.Add(int32(encodings.Raw), uint16(2), uint16(2), []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	f.Add(int32(encodings.DesktopSizePseudo), uint16(640), uint16(480), []byte{})
+	f.Add(int32(encodings.DesktopNamePseudo), uint16(0), uint16(0), []byte{0, 0, 0, 1, 'x'})
+	f.Add(int32(encodings.ExtendedDesktopSizePseudo), uint16(1), uint16(1), []byte{0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, enc int32, w, h uint16, data []byte) {
+		conn := newFuzzConn(PixelFormat16bit)
+		rect := &Rectangle{Width: w, Height: h}
+		e, err := conn.UnmarshalEncoding(encodings.Encoding(enc), rect, data)
+		if err != nil {
+			return
+		}
+		if e == nil {
+			t.Fatal("nil encoding without error")
+		}
+		if _, err := e.Marshal(); err != nil {
+			t.Fatalf("error marshaling decoded encoding: %v", err)
+		}
+	})
+}
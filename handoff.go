@@ -0,0 +1,108 @@
+// Handoff of authenticated connections between processes.
+
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+)
+
+// ErrHandoffUnsupported is returned by Handoff and ReceiveHandoff on systems
+// which can't pass file descriptors between processes.
+var ErrHandoffUnsupported = errors.New("handoff of connections is not supported on this system")
+
+// filer is implemented by connections with a file descriptor, e.g. a
+// *net.TCPConn.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handoffOOBLen is the length of the buffer for the control message holding
+// the file descriptor of a handoff.
+const handoffOOBLen = 64
+
+// Handoff hands off the connection to another process, e.g. by a connection
+// broker which dials and authenticates connections, and passes them to the
+// processes using them. The file descriptor of the network connection, and
+// the State of the connection, with the framebuffer fb if non-nil, are sent
+// over the Unix domain socket uc, to the process calling ReceiveHandoff.
+//
+// The connection is closed in this process once handed off, which leaves the
+// network connection open in the other. Handoff must not be called while
+// messages are read from the server, and the network connection must have a
+// file descriptor, e.g. a *net.TCPConn.
+func (c *ClientConn) Handoff(uc *net.UnixConn, fb *Framebuffer) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	fc, ok := c.raw.(filer)
+	if !ok {
+		return Errorf("handoff: connection of type %T has no file descriptor", c.raw)
+	}
+	f, err := fc.File()
+	if err != nil {
+		return wrapErrorf(err, "handoff: %s", err)
+	}
+	defer f.Close()
+	oob, err := fileRights(f)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0}) // The length of the state.
+	if err := c.State(fb).Write(&buf); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	// The descriptor is sent with the length, and the state follows, as it
+	// may be too large for a single message.
+	if _, _, err := uc.WriteMsgUnix(b[:4], oob, nil); err != nil {
+		return wrapErrorf(err, "handoff: %s", err)
+	}
+	if _, err := uc.Write(b[4:]); err != nil {
+		return wrapErrorf(err, "handoff: %s", err)
+	}
+	return c.Close()
+}
+
+// ReceiveHandoff receives a connection handed off with Handoff over the Unix
+// domain socket uc, and resumes it with Resume.
+func ReceiveHandoff(uc *net.UnixConn, cfg *ClientConfig) (*ClientConn, error) {
+	var hdr [4]byte
+	oob := make([]byte, handoffOOBLen)
+	n, oobn, _, _, err := uc.ReadMsgUnix(hdr[:], oob)
+	if err != nil {
+		return nil, wrapErrorf(err, "receiving handoff: %s", err)
+	}
+	f, err := parseFileRights(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	nc, err := net.FileConn(f)
+	if err != nil {
+		return nil, wrapErrorf(err, "receiving handoff: %s", err)
+	}
+	if _, err := io.ReadFull(uc, hdr[n:]); err != nil {
+		nc.Close()
+		return nil, wrapErrorf(err, "receiving handoff: %s", err)
+	}
+
+	st, err := ReadState(io.LimitReader(uc, int64(binary.BigEndian.Uint32(hdr[:]))))
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	conn, err := Resume(nc, cfg, st)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return conn, nil
+}
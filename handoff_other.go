@@ -0,0 +1,14 @@
+//go:build !unix
+// +build !unix
+
+package vnc
+
+import "os"
+
+func fileRights(f *os.File) ([]byte, error) {
+	return nil, ErrHandoffUnsupported
+}
+
+func parseFileRights(oob []byte) (*os.File, error) {
+	return nil, ErrHandoffUnsupported
+}
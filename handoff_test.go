@@ -0,0 +1,124 @@
+package vnc
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/kward/go-vnc/keys"
+)
+
+// unixPair returns a pair of connected Unix domain sockets.
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	dir, err := ioutil.TempDir("", "handoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a, err := net.DialUnix("unix", nil, l.Addr().(*net.UnixAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func TestHandoff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("handoff of connections is not supported on Windows")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	broker, worker := unixPair(t)
+	defer broker.Close()
+	defer worker.Close()
+
+	conn := NewClientConn(nc, &ClientConfig{})
+	conn.protocolVersion = PROTO_VERS_3_8
+	conn.desktopName = "desktop"
+	conn.fbWidth, conn.fbHeight = 640, 480
+	fb := NewFramebuffer(640, 480) // Too large for a single message.
+	errc := make(chan error, 1)
+	go func() { errc <- conn.Handoff(broker, fb) }()
+
+	resumed, err := ReceiveHandoff(worker, &ClientConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resumed.Close()
+	if got, want := resumed.DesktopName(), "desktop"; got != want {
+		t.Errorf("DesktopName() = %q, want = %q", got, want)
+	}
+	if w, h := resumed.FramebufferWidth(), resumed.FramebufferHeight(); w != 640 || h != 480 {
+		t.Errorf("framebuffer size = %dx%d, want 640x480", w, h)
+	}
+
+	// The connection stays open to the server, though closed by the broker.
+	SetSettle(0) // Disable UI settling for tests.
+	if err := resumed.KeyEvent(keys.Digit0, PressKey); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var msg KeyEventMessage
+	if err := NewClientConn(sc, &ClientConfig{}).receive(&msg); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := msg.Key, keys.Digit0; got != want {
+		t.Errorf("incorrect key; got = %v, want = %v", got, want)
+	}
+}
+
+func TestHandoff_NoDescriptor(t *testing.T) {
+	broker, worker := unixPair(t)
+	defer broker.Close()
+	defer worker.Close()
+
+	conn := NewClientConn(NewStreamConn(eofReader{}, ioutil.Discard), &ClientConfig{})
+	if err := conn.Handoff(broker, nil); err == nil {
+		t.Error("expected error")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	// A message without a descriptor isn't a handoff.
+	if _, err := broker.Write([]byte{0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReceiveHandoff(worker, &ClientConfig{}); err == nil || errors.Is(err, ErrHandoffUnsupported) {
+		t.Errorf("incorrect error; got = %v", err)
+	}
+}
+
+// eofReader is a reader at its end.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
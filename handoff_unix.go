@@ -0,0 +1,38 @@
+//go:build unix
+// +build unix
+
+package vnc
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileRights returns the control message passing the file descriptor of f.
+func fileRights(f *os.File) ([]byte, error) {
+	return syscall.UnixRights(int(f.Fd())), nil
+}
+
+// parseFileRights returns the file descriptor passed by the control message
+// oob, closing any others.
+func parseFileRights(oob []byte) (*os.File, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, wrapErrorf(err, "receiving handoff: %s", err)
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+	if len(fds) == 0 {
+		return nil, Errorf("receiving handoff: no file descriptor")
+	}
+	for _, fd := range fds[1:] {
+		syscall.Close(fd)
+	}
+	return os.NewFile(uintptr(fds[0]), "handoff"), nil
+}
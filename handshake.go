@@ -3,6 +3,7 @@
 package vnc
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"github.com/golang/glog"
@@ -13,19 +14,28 @@ import (
 
 const pvLen = 12 // ProtocolVersion message length.
 
+// parseProtocolVersion parses a ProtocolVersion message of the form
+// "RFB xxx.yyy\n", where xxx and yyy are zero padded decimal numbers.
 func parseProtocolVersion(pv []byte) (uint, uint, error) {
-	var major, minor uint
-
 	if len(pv) < pvLen {
 		return 0, 0, fmt.Errorf("ProtocolVersion message too short (%v < %v)", len(pv), pvLen)
 	}
-
-	l, err := fmt.Sscanf(string(pv), "RFB %d.%d\n", &major, &minor)
-	if l != 2 {
-		return 0, 0, fmt.Errorf("error parsing ProtocolVersion.")
+	if string(pv[0:4]) != "RFB " || pv[7] != '.' || pv[11] != '\n' {
+		return 0, 0, fmt.Errorf("error parsing ProtocolVersion %q", pv[:pvLen])
 	}
-	if err != nil {
-		return 0, 0, err
+
+	var major, minor uint
+	for i, b := range pv[4:11] {
+		switch {
+		case i == 3:
+			continue
+		case b < '0' || b > '9':
+			return 0, 0, fmt.Errorf("error parsing ProtocolVersion %q", pv[:pvLen])
+		case i < 3:
+			major = major*10 + uint(b-'0')
+		default:
+			minor = minor*10 + uint(b-'0')
+		}
 	}
 
 	return major, minor, nil
@@ -38,6 +48,70 @@ const (
 	PROTO_VERS_3_8   = "RFB 003.008\n"
 )
 
+// ProtocolVersion is an RFB protocol version.
+type ProtocolVersion struct {
+	Major, Minor uint
+}
+
+// Known protocol versions.
+var (
+	ProtocolVersion33 = ProtocolVersion{3, 3}
+	ProtocolVersion37 = ProtocolVersion{3, 7}
+	ProtocolVersion38 = ProtocolVersion{3, 8}
+
+	// ProtocolVersionApple is announced by macOS Screen Sharing and Apple
+	// Remote Desktop. It is handled as version 3.8.
+	ProtocolVersionApple = ProtocolVersion{3, 889}
+
+	// ProtocolVersionRealVNC4 and ProtocolVersionRealVNC5 are announced by
+	// RealVNC Server 4 and 5 (and later) respectively. They are handled as
+	// version 3.8.
+	ProtocolVersionRealVNC4 = ProtocolVersion{4, 1}
+	ProtocolVersionRealVNC5 = ProtocolVersion{5, 0}
+)
+
+// String implements the fmt.Stringer interface.
+func (v ProtocolVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Less returns true if v is an earlier version than o.
+func (v ProtocolVersion) Less(o ProtocolVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	return v.Minor < o.Minor
+}
+
+// Standard returns false for versions not defined by RFC 6143 or its
+// predecessors (e.g. the Apple 3.889 version).
+func (v ProtocolVersion) Standard() bool {
+	switch v {
+	case ProtocolVersion33, ProtocolVersion37, ProtocolVersion38:
+		return true
+	}
+	return false
+}
+
+// negotiateProtocolVersion returns the ProtocolVersion message of the highest
+// version supported by the client that is no later than the server version.
+// Servers announcing a later major version are expected to support 3.8, and
+// the non-standard minor versions of 3.x servers are rounded down (e.g. 3.6
+// servers are spoken to as 3.3, and 3.889 servers as 3.8).
+func negotiateProtocolVersion(server ProtocolVersion) string {
+	switch {
+	case server.Major > 3:
+		return PROTO_VERS_3_8
+	case server.Major < 3:
+		return PROTO_VERS_UNSUP
+	case server.Minor >= 8:
+		return PROTO_VERS_3_8
+	case server.Minor >= 3:
+		return PROTO_VERS_3_3
+	}
+	return PROTO_VERS_UNSUP
+}
+
 // protocolVersionHandshake implements §7.1.1 ProtocolVersion Handshake.
 func (c *ClientConn) protocolVersionHandshake(ctx context.Context) error {
 	if logging.V(logging.FnDeclLevel) {
@@ -47,7 +121,7 @@ func (c *ClientConn) protocolVersionHandshake(ctx context.Context) error {
 	var protocolVersion [pvLen]byte
 
 	// Read the ProtocolVersion message sent by the server.
-	if err := c.receive(&protocolVersion); err != nil {
+	if err := c.readFull(protocolVersion[:]); err != nil {
 		return err
 	}
 	if logging.V(logging.ResultLevel) {
@@ -56,26 +130,26 @@ func (c *ClientConn) protocolVersionHandshake(ctx context.Context) error {
 
 	major, minor, err := parseProtocolVersion(protocolVersion[:])
 	if err != nil {
-		return err
+		return wrapErrorf(ErrProtocol, "ProtocolVersion handshake failed; %s", err)
 	}
-	pv := PROTO_VERS_UNSUP
-	if major == 3 {
-		if minor >= 8 {
-			pv = PROTO_VERS_3_8
-		} else if minor >= 3 {
-			pv = PROTO_VERS_3_3
+	c.serverVersion = ProtocolVersion{major, minor}
+	if !c.serverVersion.Standard() {
+		if logging.V(logging.FlowLevel) {
+			glog.Infof("non-standard server protocolVersion: %v", c.serverVersion)
 		}
 	}
+	c.detectProfile()
+
+	pv := negotiateProtocolVersion(c.serverVersion)
 	if pv == PROTO_VERS_UNSUP {
-		return NewVNCError(fmt.Sprintf("ProtocolVersion handshake failed; unsupported version '%v'", string(protocolVersion[:])))
+		return wrapErrorf(ErrUnsupportedVersion, "ProtocolVersion handshake failed; unsupported version '%v'", string(protocolVersion[:]))
 	}
 
-	if mpv := ctx.Value("vnc_max_proto_version"); mpv != nil && mpv != "" {
+	// Never exceed the version supported by the server.
+	if mpv := ctx.Value("vnc_max_proto_version"); mpv != nil && mpv != "" && pv == PROTO_VERS_3_8 {
 		switch mpv {
 		case "3.3":
 			pv = PROTO_VERS_3_3
-		case "3.8":
-			pv = PROTO_VERS_3_8
 		}
 	}
 
@@ -85,13 +159,29 @@ func (c *ClientConn) protocolVersionHandshake(ctx context.Context) error {
 	c.protocolVersion = pv
 
 	// Respond with the version we will support
-	if err = c.send([]byte(pv)); err != nil {
+	if err = c.send([]byte(c.protocolVersionReply(pv))); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// ServerProtocolVersion returns the protocol version announced by the server.
+// It may be a non-standard version (e.g. ProtocolVersionApple).
+func (c *ClientConn) ServerProtocolVersion() ProtocolVersion {
+	return c.serverVersion
+}
+
+// ProtocolVersion returns the protocol version negotiated with the server, or
+// the zero value if the ProtocolVersion handshake hasn't completed.
+func (c *ClientConn) ProtocolVersion() ProtocolVersion {
+	major, minor, err := parseProtocolVersion([]byte(c.protocolVersion))
+	if err != nil {
+		return ProtocolVersion{}
+	}
+	return ProtocolVersion{major, minor}
+}
+
 // securityHandshake implements §7.1.2 Security Handshake.
 func (c *ClientConn) securityHandshake() error {
 	if logging.V(logging.FnDeclLevel) {
@@ -108,7 +198,7 @@ func (c *ClientConn) securityHandshake() error {
 			return err
 		}
 	default:
-		return NewVNCError(fmt.Sprintf("Security handshake failed; unsupported protocol"))
+		return wrapErrorf(ErrUnsupportedVersion, "Security handshake failed; unsupported protocol")
 	}
 
 	return nil
@@ -119,10 +209,11 @@ func (c *ClientConn) securityHandshake33() error {
 		glog.Infof(logging.FnName())
 	}
 
-	var secType uint32
-	if err := c.receive(&secType); err != nil {
+	hdr, err := c.readHeader(4)
+	if err != nil {
 		return err
 	}
+	secType := binary.BigEndian.Uint32(hdr)
 
 	var auth ClientAuth
 	switch uint8(secType) { // 3.3 uses uint32, but 3.8 uses uint8. Unify on 3.8.
@@ -137,7 +228,7 @@ func (c *ClientConn) securityHandshake33() error {
 	case secTypeVNCAuth:
 		auth = &ClientAuthVNC{c.config.Password}
 	default:
-		return NewVNCError(fmt.Sprintf("Security handshake failed; invalid security type: %v", secType))
+		return wrapErrorf(ErrProtocol, "Security handshake failed; invalid security type: %v", secType)
 	}
 	c.config.secType = auth.SecurityType()
 	if err := auth.Handshake(c); err != nil {
@@ -153,10 +244,11 @@ func (c *ClientConn) securityHandshake38() error {
 	}
 
 	// Determine server supported security types.
-	var numSecurityTypes uint8
-	if err := c.receive(&numSecurityTypes); err != nil {
+	hdr, err := c.readHeader(1)
+	if err != nil {
 		return err
 	}
+	numSecurityTypes := hdr[0]
 	if numSecurityTypes == 0 {
 		reason, err := c.readErrorReason()
 		if err != nil {
@@ -165,7 +257,7 @@ func (c *ClientConn) securityHandshake38() error {
 		return NewVNCError(fmt.Sprintf("Security handshake failed; no security types: %v", reason))
 	}
 	securityTypes := make([]uint8, numSecurityTypes)
-	if err := c.receive(&securityTypes); err != nil {
+	if err := c.readFull(securityTypes); err != nil {
 		return err
 	}
 	if logging.V(logging.ResultLevel) {
@@ -173,20 +265,12 @@ func (c *ClientConn) securityHandshake38() error {
 	}
 
 	// Choose client security type.
-	// TODO(kward): try "better" security types first.
-	var auth ClientAuth
-FindAuth:
-	for _, securityType := range securityTypes {
-		for _, a := range c.config.Auth {
-			if a.SecurityType() == securityType {
-				// We use the first matching supported authentication.
-				auth = a
-				break FindAuth
-			}
-		}
-	}
+	auth := c.chooseAuth(securityTypes)
 	if auth == nil {
-		return NewVNCError(fmt.Sprintf("Security handshake failed; no suitable auth schemes found; server supports: %#v", securityTypes))
+		if c.profile == ProfileRealVNC && realVNCSecurityOnly(securityTypes) {
+			return wrapErrorf(ErrUnsupportedSecurity, "Security handshake failed; server requires RealVNC proprietary security: %#v", securityTypes)
+		}
+		return wrapErrorf(ErrUnsupportedSecurity, "Security handshake failed; no suitable auth schemes found; server supports: %#v", securityTypes)
 	}
 	if err := c.send(auth.SecurityType()); err != nil {
 		return err
@@ -204,14 +288,16 @@ func (c *ClientConn) securityResultHandshake() error {
 		glog.Info(logging.FnName())
 	}
 
-	if c.config.secType == secTypeNone {
+	// Prior to version 3.8, no SecurityResult is sent for security type None.
+	if c.config.secType == secTypeNone && c.protocolVersion == PROTO_VERS_3_3 {
 		return nil
 	}
 
-	var securityResult uint32
-	if err := c.receive(&securityResult); err != nil {
+	hdr, err := c.readHeader(4)
+	if err != nil {
 		return err
 	}
+	securityResult := binary.BigEndian.Uint32(hdr)
 	switch securityResult {
 	case 0:
 	case 1:
@@ -219,9 +305,9 @@ func (c *ClientConn) securityResultHandshake() error {
 		if err != nil {
 			return err
 		}
-		return NewVNCError(fmt.Sprintf("SecurityResult handshake failed: %s", reason))
+		return wrapErrorf(ErrAuthFailed, "SecurityResult handshake failed: %s", reason)
 	default:
-		return NewVNCError(fmt.Sprintf("Invalid SecurityResult status: %v", securityResult))
+		return wrapErrorf(ErrProtocol, "Invalid SecurityResult status: %v", securityResult)
 	}
 
 	return nil
@@ -233,13 +319,17 @@ func (c *ClientConn) readErrorReason() (string, error) {
 		glog.Info(logging.FnName())
 	}
 
-	var reasonLen uint32
-	if err := c.receive(&reasonLen); err != nil {
+	hdr, err := c.readHeader(4)
+	if err != nil {
 		return "", err
 	}
+	reasonLen := binary.BigEndian.Uint32(hdr)
 
+	if max := c.config.Limits.maxStringLength(); reasonLen > max {
+		return "", wrapErrorf(ErrLimitExceeded, "reason-length %d exceeds limit of %d", reasonLen, max)
+	}
 	reason := make([]uint8, reasonLen)
-	if err := c.receive(&reason); err != nil {
+	if err := c.readFull(reason); err != nil {
 		return "", err
 	}
 
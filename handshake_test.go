@@ -31,6 +31,14 @@ func TestParseProtocolVersion(t *testing.T) {
 		{[]byte{82, 70, 66, 10}, 0, 0, false},
 		// (empty) -- too short
 		{[]byte{}, 0, 0, false},
+		// RFB 003.00a\n -- not a number
+		{[]byte("RFB 003.00a\n"), 0, 0, false},
+		// RFC 003.008\n -- bad prefix
+		{[]byte("RFC 003.008\n"), 0, 0, false},
+		// RFB 003,008\n -- bad separator
+		{[]byte("RFB 003,008\n"), 0, 0, false},
+		// RFB 003.008  -- missing newline
+		{[]byte("RFB 003.008 "), 0, 0, false},
 	}
 
 	for i, tt := range tests {
@@ -69,8 +77,12 @@ func TestProtocolVersionHandshake(t *testing.T) {
 		{"RFB 003.006\n", "RFB 003.003\n", true},
 		{"RFB 003.008\n", "RFB 003.008\n", true},
 		{"RFB 003.389\n", "RFB 003.008\n", true},
+		{"RFB 003.889\n", "RFB 003.008\n", true},
+		{"RFB 004.001\n", "RFB 003.008\n", true},
 		// Unsupported versions.
 		{server: "RFB 002.009\n", ok: false},
+		{server: "RFB 003.002\n", ok: false},
+		{server: "RFB 03.008\n\n", ok: false},
 	}
 
 	mockConn := &MockConn{}
@@ -104,6 +116,16 @@ func TestProtocolVersionHandshake(t *testing.T) {
 		if string(client[:]) != tt.client && tt.ok {
 			t.Errorf("protocolVersionHandshake() client version: got = %v, want = %v", string(client[:]), tt.client)
 		}
+		if tt.ok {
+			major, minor, _ := parseProtocolVersion([]byte(tt.server))
+			if got, want := conn.ServerProtocolVersion(), (ProtocolVersion{major, minor}); got != want {
+				t.Errorf("ServerProtocolVersion() = %v, want = %v", got, want)
+			}
+			major, minor, _ = parseProtocolVersion([]byte(tt.client))
+			if got, want := conn.ProtocolVersion(), (ProtocolVersion{major, minor}); got != want {
+				t.Errorf("ProtocolVersion() = %v, want = %v", got, want)
+			}
+		}
 
 		// Ensure nothing extra was sent.
 		var buf []byte
@@ -113,6 +135,93 @@ func TestProtocolVersionHandshake(t *testing.T) {
 	}
 }
 
+func TestProtocolVersionHandshake_MaxVersion(t *testing.T) {
+	for _, tt := range []struct {
+		desc   string
+		server string
+		max    string
+		client string
+	}{
+		{"max below server", "RFB 003.008\n", "3.3", "RFB 003.003\n"},
+		{"max equal to server", "RFB 003.008\n", "3.8", "RFB 003.008\n"},
+		{"max above server", "RFB 003.003\n", "3.8", "RFB 003.003\n"},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		if err := conn.send([]byte(tt.server)); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := context.WithValue(context.Background(), "vnc_max_proto_version", tt.max)
+		if err := conn.protocolVersionHandshake(ctx); err != nil {
+			t.Errorf("%s: unexpected error; %s", tt.desc, err)
+			continue
+		}
+		var client [pvLen]byte
+		if err := conn.receive(&client); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(client[:]), tt.client; got != want {
+			t.Errorf("%s: client version = %q, want = %q", tt.desc, got, want)
+		}
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	for _, tt := range []struct {
+		v        ProtocolVersion
+		str      string
+		standard bool
+	}{
+		{ProtocolVersion33, "3.3", true},
+		{ProtocolVersion38, "3.8", true},
+		{ProtocolVersionApple, "3.889", false},
+		{ProtocolVersion{3, 6}, "3.6", false},
+	} {
+		if got, want := tt.v.String(), tt.str; got != want {
+			t.Errorf("String() = %q, want = %q", got, want)
+		}
+		if got, want := tt.v.Standard(), tt.standard; got != want {
+			t.Errorf("%v: Standard() = %v, want = %v", tt.v, got, want)
+		}
+	}
+	if !ProtocolVersion33.Less(ProtocolVersion38) || ProtocolVersion38.Less(ProtocolVersion33) {
+		t.Error("Less() ordering incorrect")
+	}
+	if !ProtocolVersion38.Less(ProtocolVersion{4, 0}) {
+		t.Error("Less() ordering incorrect for major versions")
+	}
+}
+
+func TestSecurityResultHandshake_None(t *testing.T) {
+	for _, tt := range []struct {
+		version string
+		sent    bool
+	}{
+		{PROTO_VERS_3_3, false},
+		{PROTO_VERS_3_8, true},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{secType: secTypeNone})
+		conn.protocolVersion = tt.version
+		if tt.sent {
+			if err := conn.send(uint32(0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := conn.send([]byte("next")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := conn.securityResultHandshake(); err != nil {
+			t.Errorf("%q: unexpected error: %s", tt.version, err)
+		}
+		if got, want := mockConn.b.String(), "next"; got != want {
+			t.Errorf("%q: remaining data = %q, want = %q", tt.version, got, want)
+		}
+	}
+}
+
 func writeVNCAuthChallenge(w io.Writer) error {
 	var ch vncAuthChallenge = vncAuthChallenge{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
 	return binary.Write(w, binary.BigEndian, ch)
@@ -368,3 +477,43 @@ func TestSecurityResultHandshake(t *testing.T) {
 		}
 	}
 }
+
+func TestProtocolVersionHandshake_Profile(t *testing.T) {
+	for _, tt := range []struct {
+		desc    string
+		profile Profile
+		server  string
+		client  string
+		want    Profile
+	}{
+		{"standard", ProfileStandard, "RFB 003.889\n", "RFB 003.008\n", ProfileStandard},
+		{"auto apple", ProfileAuto, "RFB 003.889\n", "RFB 003.889\n", ProfileApple},
+		{"auto other", ProfileAuto, "RFB 003.008\n", "RFB 003.008\n", ProfileStandard},
+		{"apple", ProfileApple, "RFB 003.889\n", "RFB 003.889\n", ProfileApple},
+		{"apple, standard server", ProfileApple, "RFB 003.008\n", "RFB 003.008\n", ProfileApple},
+		{"auto realvnc 4", ProfileAuto, "RFB 004.001\n", "RFB 003.008\n", ProfileRealVNC},
+		{"auto realvnc 5", ProfileAuto, "RFB 005.000\n", "RFB 003.008\n", ProfileRealVNC},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{Profile: tt.profile})
+		if err := conn.send([]byte(tt.server)); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.protocolVersionHandshake(context.Background()); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		var client [pvLen]byte
+		if err := conn.receive(&client); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(client[:]), tt.client; got != want {
+			t.Errorf("%s: client version = %q, want = %q", tt.desc, got, want)
+		}
+		if got, want := conn.Profile(), tt.want; got != want {
+			t.Errorf("%s: Profile() = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := conn.ProtocolVersion(), ProtocolVersion38; got != want {
+			t.Errorf("%s: ProtocolVersion() = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// History of the frames of a screen, for debugging automation failures.
+
+package vnc
+
+import (
+	"image"
+	"time"
+)
+
+// Frame is a retained state of the screen.
+type Frame struct {
+	Time    time.Time         // When the frame became current.
+	Image   *image.RGBA       // A copy of the screen.
+	Changed []image.Rectangle // The rectangles changed by the update making the frame; nil if unknown.
+}
+
+// frameHistory holds the frames before the current one as a chain of deltas,
+// each of which undoes an update. The memory of the deltas is reserved from
+// the MemoryBudget of the connection, if any.
+type frameHistory struct {
+	budget *connBudget
+	max    int          // The maximum number of deltas.
+	time   time.Time    // When the current frame became current.
+	deltas []frameDelta // Oldest first.
+}
+
+// frameDelta undoes an update.
+type frameDelta struct {
+	prevTime time.Time    // When the frame before the update became current.
+	patches  []framePatch // In the order the update was applied.
+}
+
+// framePatch restores the pixels of a rectangle, or of the whole framebuffer
+// if the update resized it.
+type framePatch struct {
+	r      image.Rectangle
+	resize bool
+	pixels []Color
+}
+
+// SetHistory retains the last n frames of the screen, including the current
+// one, as deltas of the rectangles changed by each update. Zero, or one,
+// disables the history, and discards the frames retained.
+//
+// If an update would take the history over the MemoryBudget of the
+// connection, the frames retained are discarded, and the history restarts
+// from the frame of the update.
+func (s *Screen) SetHistory(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if n <= 1 {
+		if s.history != nil {
+			s.history.drop(nil)
+		}
+		s.history = nil
+		return
+	}
+	if s.history == nil {
+		s.history = &frameHistory{budget: &s.c.budget, time: time.Now()}
+	}
+	s.history.max = n - 1
+	s.history.trim()
+}
+
+// HistoryLen returns the number of frames retained, including the current
+// one.
+func (s *Screen) HistoryLen() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.history == nil {
+		return 1
+	}
+	return len(s.history.deltas) + 1
+}
+
+// Frame returns the frame i updates before the current one, which is frame
+// zero. Frames up to HistoryLen()-1 are retained.
+func (s *Screen) Frame(i int) (Frame, error) {
+	frames := s.frames(func(j int, _ time.Time) bool { return j <= i })
+	if i < 0 || i >= len(frames) {
+		return Frame{}, Errorf("frame %d isn't retained", i)
+	}
+	return frames[i], nil
+}
+
+// FramesSince returns the frames which were current at any time since t,
+// newest first, e.g. to show what changed in the last 10 seconds.
+func (s *Screen) FramesSince(t time.Time) []Frame {
+	// The oldest frame returned became current before t, but was current
+	// at t.
+	var prev time.Time
+	return s.frames(func(j int, frameTime time.Time) bool {
+		ok := j == 0 || prev.After(t)
+		prev = frameTime
+		return ok
+	})
+}
+
+// frames returns the frames, newest first, while want returns true for the
+// index and time of the frame.
+func (s *Screen) frames(want func(i int, t time.Time) bool) []Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fb := &Framebuffer{Width: s.fb.Width, Height: s.fb.Height, Pixels: append([]Color(nil), s.fb.Pixels...)}
+	if s.history == nil {
+		if !want(0, time.Time{}) {
+			return nil
+		}
+		return []Frame{{Image: fb.Image()}}
+	}
+
+	h := s.history
+	var frames []Frame
+	t := h.time
+	for i := 0; i <= len(h.deltas); i++ {
+		if !want(i, t) {
+			break
+		}
+		f := Frame{Time: t, Image: fb.Image()}
+		if j := len(h.deltas) - 1 - i; j >= 0 {
+			d := &h.deltas[j]
+			for _, p := range d.patches {
+				f.Changed = append(f.Changed, p.r)
+			}
+			d.undo(fb)
+			t = d.prevTime
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+// save returns the patch restoring the pixels which applying rect to fb
+// changes, and false if it changes none.
+func (h *frameHistory) save(fb *Framebuffer, rect *Rectangle) (framePatch, bool) {
+	if rect.IsResize() {
+		return framePatch{
+			r:      image.Rect(0, 0, fb.Width, fb.Height),
+			resize: true,
+			pixels: append([]Color(nil), fb.Pixels...),
+		}, true
+	}
+	if rect.Area() == 0 || rect.Enc == nil || rect.Enc.Type() < 0 {
+		return framePatch{}, false
+	}
+	r := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height))
+	r = r.Intersect(image.Rect(0, 0, fb.Width, fb.Height))
+	p := framePatch{r: r, pixels: make([]Color, 0, r.Dx()*r.Dy())}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		p.pixels = append(p.pixels, fb.Pixels[y*fb.Width+r.Min.X:y*fb.Width+r.Max.X]...)
+	}
+	return p, true
+}
+
+// commit adds the delta undoing an update applied at time now.
+func (h *frameHistory) commit(patches []framePatch, now time.Time) {
+	h.deltas = append(h.deltas, frameDelta{prevTime: h.time, patches: patches})
+	h.time = now
+	h.trim()
+}
+
+// trim discards the oldest deltas beyond the maximum.
+func (h *frameHistory) trim() {
+	if n := len(h.deltas) - h.max; n > 0 {
+		for _, d := range h.deltas[:n] {
+			h.budget.release(d.size())
+		}
+		h.deltas = append(h.deltas[:0:0], h.deltas[n:]...)
+	}
+}
+
+// drop discards the deltas, and the patches of an update not yet committed,
+// returning their memory to the budget.
+func (h *frameHistory) drop(patches []framePatch) {
+	for _, d := range h.deltas {
+		h.budget.release(d.size())
+	}
+	for _, p := range patches {
+		h.budget.release(p.size())
+	}
+	h.deltas = nil
+}
+
+// restart restarts the history from the frame made current at time now.
+func (h *frameHistory) restart(now time.Time) {
+	h.drop(nil)
+	h.time = now
+}
+
+// size returns the memory used by the delta.
+func (d *frameDelta) size() int64 {
+	var n int64
+	for _, p := range d.patches {
+		n += p.size()
+	}
+	return n
+}
+
+// size returns the memory used by the patch.
+func (p *framePatch) size() int64 {
+	return colorSize * int64(len(p.pixels))
+}
+
+// undo restores the framebuffer to its state before the update.
+func (d *frameDelta) undo(fb *Framebuffer) {
+	for i := len(d.patches) - 1; i >= 0; i-- {
+		p := &d.patches[i]
+		if p.resize {
+			fb.Width, fb.Height = p.r.Dx(), p.r.Dy()
+			fb.Pixels = append(fb.Pixels[:0:0], p.pixels...)
+			continue
+		}
+		w := p.r.Dx()
+		for row := 0; row < p.r.Dy(); row++ {
+			copy(fb.Pixels[(p.r.Min.Y+row)*fb.Width+p.r.Min.X:], p.pixels[row*w:(row+1)*w])
+		}
+	}
+}
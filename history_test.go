@@ -0,0 +1,74 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestScreen_History(t *testing.T) {
+	s, _ := newTestScreen()
+	s.SetHistory(3)
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+
+	start := time.Now()
+	for _, fu := range []*FramebufferUpdate{
+		rawUpdate(0, 0, Color{R: 0xffff}),
+		rawUpdate(1, 0, Color{R: 0xffff}),
+		newFramebufferUpdate([]Rectangle{{Width: 2, Height: 2, Enc: &DesktopSizePseudoEncoding{}}}),
+	} {
+		if err := s.Handle(fu); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := s.HistoryLen(), 3; got != want {
+		t.Fatalf("HistoryLen() = %d, want %d", got, want)
+	}
+
+	for _, tt := range []struct {
+		i       int
+		bounds  image.Rectangle
+		pixels  [2]color.RGBA // At (0, 0) and (1, 0).
+		changed []image.Rectangle
+	}{
+		{0, image.Rect(0, 0, 2, 2), [2]color.RGBA{black, black}, []image.Rectangle{image.Rect(0, 0, 4, 3)}},
+		{1, image.Rect(0, 0, 4, 3), [2]color.RGBA{red, red}, []image.Rectangle{image.Rect(1, 0, 2, 1)}},
+		{2, image.Rect(0, 0, 4, 3), [2]color.RGBA{red, black}, nil}, // The update making the oldest frame isn't retained.
+	} {
+		f, err := s.Frame(tt.i)
+		if err != nil {
+			t.Fatalf("Frame(%d): unexpected error: %s", tt.i, err)
+		}
+		if got, want := f.Image.Bounds(), tt.bounds; got != want {
+			t.Errorf("Frame(%d) bounds = %v, want %v", tt.i, got, want)
+		}
+		for x, want := range tt.pixels {
+			if got := f.Image.RGBAAt(x, 0); got != want {
+				t.Errorf("Frame(%d) pixel (%d, 0) = %v, want %v", tt.i, x, got, want)
+			}
+		}
+		if len(f.Changed) != len(tt.changed) || (len(f.Changed) > 0 && f.Changed[0] != tt.changed[0]) {
+			t.Errorf("Frame(%d) changed = %v, want %v", tt.i, f.Changed, tt.changed)
+		}
+		if f.Time.Before(start) {
+			t.Errorf("Frame(%d) time = %v, before the start", tt.i, f.Time)
+		}
+	}
+	if _, err := s.Frame(3); err == nil {
+		t.Error("Frame(3): expected error")
+	}
+
+	if got, want := len(s.FramesSince(start)), 3; got != want {
+		t.Errorf("len(FramesSince(start)) = %d, want %d", got, want)
+	}
+	if got, want := len(s.FramesSince(time.Now())), 1; got != want {
+		t.Errorf("len(FramesSince(now)) = %d, want %d", got, want)
+	}
+
+	s.SetHistory(0)
+	if got, want := s.HistoryLen(), 1; got != want {
+		t.Errorf("HistoryLen() = %d, want %d once disabled", got, want)
+	}
+}
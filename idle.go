@@ -0,0 +1,116 @@
+// Detection of the activity, and idleness, of the remote screen.
+
+package vnc
+
+import (
+	"sync"
+	"time"
+)
+
+// idleDetector tracks when the remote screen last changed, and publishes
+// EventIdle once it hasn't for the window, and EventActive once it changes
+// again.
+type idleDetector struct {
+	window  time.Duration // Zero disables the events.
+	publish func(Event)
+
+	mu     sync.Mutex
+	last   time.Time // When the screen last changed.
+	idle   bool
+	timer  *time.Timer
+	closed bool
+}
+
+// start starts the window at now.
+func (d *idleDetector) start(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = now
+	if d.window > 0 {
+		d.timer = time.AfterFunc(d.window, d.expire)
+	}
+}
+
+// activity records that the screen changed at now.
+func (d *idleDetector) activity(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last = now
+	if d.closed || d.timer == nil {
+		return
+	}
+	d.timer.Reset(d.window)
+	if d.idle {
+		d.idle = false
+		d.publish(Event{Kind: EventActive, Time: now})
+	}
+}
+
+// expire is called once the window may have passed without the screen
+// changing.
+func (d *idleDetector) expire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed || d.idle {
+		return
+	}
+	if left := d.window - time.Since(d.last); left > 0 {
+		d.timer.Reset(left) // Raced with activity.
+		return
+	}
+	d.idle = true
+	d.publish(Event{Kind: EventIdle})
+}
+
+// stop stops publishing events.
+func (d *idleDetector) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// recordActivity records that the screen changed at now, on the connection
+// itself when c is a copy used to unmarshal messages.
+func (c *ClientConn) recordActivity(now time.Time) {
+	if c.eventConn != nil {
+		c = c.eventConn
+	}
+	c.idle.activity(now)
+}
+
+// changesScreen returns whether the rectangle changes the screen: whether it
+// holds pixel data, or resizes the framebuffer.
+func (r *Rectangle) changesScreen() bool {
+	if r.IsResize() {
+		return true
+	}
+	return r.Enc != nil && r.Area() > 0 && r.Enc.Type() >= 0
+}
+
+// LastActivity returns when the remote screen last changed, i.e. when a
+// rectangle of pixel data, or a resize, was last received, or when the
+// connection was made if none was. Servers also send rectangles which don't
+// change, e.g. when requested non-incrementally, which count as activity.
+func (c *ClientConn) LastActivity() time.Time {
+	c.idle.mu.Lock()
+	defer c.idle.mu.Unlock()
+	return c.idle.last
+}
+
+// ChangedWithin returns whether the remote screen changed within d. See
+// LastActivity.
+func (c *ClientConn) ChangedWithin(d time.Duration) bool {
+	return time.Since(c.LastActivity()) < d
+}
+
+// Idle returns whether the remote screen hasn't changed for
+// ClientConfig.IdleTimeout, and EventIdle was published. It is always false
+// if IdleTimeout isn't set.
+func (c *ClientConn) Idle() bool {
+	c.idle.mu.Lock()
+	defer c.idle.mu.Unlock()
+	return c.idle.idle
+}
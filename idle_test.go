@@ -0,0 +1,123 @@
+package vnc
+
+import (
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event received on ch, failing after a second.
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event published")
+	}
+	return Event{}
+}
+
+func TestClientConn_IdleEvents(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(4)
+	defer cancel()
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{Events: bus, IdleTimeout: 20 * time.Millisecond})
+	defer conn.Close()
+	conn.pixelFormat = PixelFormat{} // No pixel data.
+	conn.fbWidth, conn.fbHeight = 4, 3
+	conn.encodings = Encodings{&RawEncoding{}, &LastRectPseudoEncoding{}}
+
+	if conn.Idle() {
+		t.Error("Idle() = true before the timeout")
+	}
+	if got, want := nextEvent(t, events).Kind, EventIdle; got != want {
+		t.Fatalf("event = %v, want %v", got, want)
+	}
+	if !conn.Idle() {
+		t.Error("Idle() = false once idle")
+	}
+	if conn.ChangedWithin(20 * time.Millisecond) {
+		t.Error("ChangedWithin() = true once idle")
+	}
+
+	// Pseudo-encodings aren't activity.
+	for _, rect := range []Rectangle{
+		{Width: 1, Height: 1, Enc: &LastRectPseudoEncoding{}},
+		{Width: 1, Height: 1, Enc: &RawEncoding{}},
+	} {
+		bytes, err := rect.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.send(bytes); err != nil {
+			t.Fatal(err)
+		}
+		r := NewRectangle(conn.encodable())
+		if err := conn.readRect(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got, want := conn.Idle(), rect.Enc.Type() < 0; got != want {
+			t.Errorf("%v: Idle() = %v, want %v", rect.Enc.Type(), got, want)
+		}
+	}
+	if got, want := nextEvent(t, events).Kind, EventActive; got != want {
+		t.Fatalf("event = %v, want %v", got, want)
+	}
+	if !conn.ChangedWithin(time.Second) {
+		t.Error("ChangedWithin() = false once active")
+	}
+	if got, want := nextEvent(t, events).Kind, EventIdle; got != want {
+		t.Fatalf("event = %v, want %v", got, want)
+	}
+}
+
+func TestClientConn_IdleDisabled(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(4)
+	defer cancel()
+	start := time.Now()
+	conn := NewClientConn(&MockConn{}, &ClientConfig{Events: bus})
+	time.Sleep(10 * time.Millisecond)
+	if conn.Idle() {
+		t.Error("Idle() = true without IdleTimeout")
+	}
+	if got := conn.LastActivity(); got.Before(start) {
+		t.Errorf("LastActivity() = %v, want the connection time", got)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e.Kind)
+	default:
+	}
+}
+
+func TestClientConn_IdleUnmarshal(t *testing.T) {
+	bus := NewEventBus()
+	events, cancel := bus.Subscribe(4)
+	defer cancel()
+	conn := NewClientConn(&MockConn{}, &ClientConfig{Events: bus, IdleTimeout: 50 * time.Millisecond})
+	defer conn.Close()
+	conn.pixelFormat = PixelFormat{} // No pixel data.
+	conn.fbWidth, conn.fbHeight = 4, 3
+
+	// Each update unmarshaled is activity of the connection, and the copies
+	// of the connection unmarshaling them never go idle.
+	update := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0}
+	for start := time.Now(); time.Since(start) < 150*time.Millisecond; time.Sleep(10 * time.Millisecond) {
+		if _, err := conn.UnmarshalServerMessage(update); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if conn.Idle() {
+		t.Error("Idle() = true while active")
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e.Kind)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if got, want := nextEvent(t, events).Kind, EventIdle; got != want {
+		t.Errorf("event = %v, want %v", got, want)
+	}
+}
@@ -3,6 +3,7 @@
 package vnc
 
 import (
+	"encoding/binary"
 	"io"
 
 	"github.com/golang/glog"
@@ -16,7 +17,7 @@ func (c *ClientConn) clientInit() error {
 		glog.Info(logging.FnName())
 	}
 
-	sharedFlag := rfbflags.BoolToRFBFlag(!c.config.Exclusive)
+	sharedFlag := rfbflags.BoolToRFBFlag(!c.config.Exclusive || c.config.ViewOnly)
 	if logging.V(logging.ResultLevel) {
 		glog.Infof("sharedFlag: %d", sharedFlag)
 	}
@@ -53,12 +54,16 @@ func (m *ServerInit) Read(r io.Reader) error {
 	return m.Unmarshal(buf)
 }
 
+// Unmarshal implements the Unmarshaler interface.
 func (m *ServerInit) Unmarshal(data []byte) error {
-	buf := NewBuffer(data)
-	var msg ServerInit
-	if err := buf.Read(&msg); err != nil {
-		return err
+	if len(data) < serverInitLen {
+		return Errorf("ServerInit message too short (%v < %v)", len(data), serverInitLen)
 	}
+	var msg ServerInit
+	msg.FBWidth = binary.BigEndian.Uint16(data[0:])
+	msg.FBHeight = binary.BigEndian.Uint16(data[2:])
+	msg.PixelFormat.get(data[4:])
+	msg.NameLength = binary.BigEndian.Uint32(data[20:])
 	*m = msg
 	return nil
 }
@@ -77,15 +82,23 @@ func (c *ClientConn) serverInit() error {
 		glog.Infof("ServerInit message: %v", msg)
 	}
 
-	c.setFramebufferWidth(msg.FBWidth)
-	c.setFramebufferHeight(msg.FBHeight)
-	c.pixelFormat = msg.PixelFormat
+	if err := c.config.Limits.checkFramebuffer(msg.FBWidth, msg.FBHeight); err != nil {
+		return err
+	}
+	c.setFramebufferSize(msg.FBWidth, msg.FBHeight)
+	c.setPixelFormat(msg.PixelFormat)
 
+	if max := c.config.Limits.maxStringLength(); msg.NameLength > max {
+		return wrapErrorf(ErrLimitExceeded, "name-length %d exceeds limit of %d", msg.NameLength, max)
+	}
 	name := make([]uint8, msg.NameLength)
-	if err := c.receive(&name); err != nil {
+	if err := c.readFull(name); err != nil {
 		return err
 	}
 	c.setDesktopName(string(name))
 
+	if c.config.secType == secTypeTight && c.tight != nil {
+		return c.tightInteractionCapabilities()
+	}
 	return nil
 }
@@ -1,17 +1,19 @@
 package vnc
 
 import (
+	"errors"
 	"io"
 	"testing"
 )
 
 func TestClientInit(t *testing.T) {
 	tests := []struct {
-		exclusive bool
-		shared    uint8
+		exclusive, viewOnly bool
+		shared              uint8
 	}{
-		{true, 0},
-		{false, 1},
+		{true, false, 0},
+		{false, false, 1},
+		{true, true, 1}, // View-only connections are always shared.
 	}
 
 	mockConn := &MockConn{}
@@ -22,6 +24,7 @@ func TestClientInit(t *testing.T) {
 
 		// Send client initialization.
 		conn.config.Exclusive = tt.exclusive
+		conn.config.ViewOnly = tt.viewOnly
 		if err := conn.clientInit(); err != nil {
 			t.Fatalf("unexpected error; %s", err)
 		}
@@ -128,3 +131,31 @@ func TestServerInit(t *testing.T) {
 		}
 	}
 }
+
+func TestServerInit_Limit(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{Limits: Limits{MaxFramebufferArea: 100}})
+	for _, tt := range []struct {
+		desc string
+		w, h uint16
+		want error
+	}{
+		{"within limit", 10, 10, nil},
+		{"over limit", 10, 11, ErrLimitExceeded},
+		{"maximum size", 0xffff, 0xffff, ErrLimitExceeded},
+	} {
+		mockConn.Reset()
+		pf, err := PixelFormat32bit.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []interface{}{tt.w, tt.h, pf, uint32(0)} {
+			if err := conn.send(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := conn.serverInit(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.desc, err, tt.want)
+		}
+	}
+}
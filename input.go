@@ -0,0 +1,63 @@
+// High-level input helpers, built on the KeyEvent and PointerEvent messages.
+
+package vnc
+
+import (
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+)
+
+// KeyPress presses and releases a key.
+func (c *ClientConn) KeyPress(key keys.Key) error {
+	if err := c.KeyEvent(key, true); err != nil {
+		return err
+	}
+	return c.KeyEvent(key, false)
+}
+
+// KeyCombo presses the keys in order, and then releases them in reverse
+// order, e.g. KeyCombo(keys.ControlLeft, keys.AltLeft, keys.Delete).
+func (c *ClientConn) KeyCombo(ks ...keys.Key) error {
+	for i, key := range ks {
+		if err := c.KeyEvent(key, true); err != nil {
+			// Release what was pressed, so no keys are left held down.
+			for j := i - 1; j >= 0; j-- {
+				c.KeyEvent(ks[j], false)
+			}
+			return err
+		}
+	}
+	for i := len(ks) - 1; i >= 0; i-- {
+		if err := c.KeyEvent(ks[i], false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Type types the text, one key press per character. With a KeyboardLayout,
+// each character is typed by the keys of the layout, holding the modifiers
+// it needs.
+func (c *ClientConn) Type(text string) error {
+	layout := c.config.KeyboardLayout
+	for _, r := range text {
+		var err error
+		if layout == nil {
+			err = c.KeyPress(keys.FromRune(r))
+		} else {
+			err = c.KeyCombo(layout.Stroke(r).Keys()...)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Click moves the pointer to (x, y), and then presses and releases the button.
+func (c *ClientConn) Click(button buttons.Button, x, y uint16) error {
+	if err := c.PointerEvent(button, x, y); err != nil {
+		return err
+	}
+	return c.PointerEvent(buttons.None, x, y)
+}
@@ -0,0 +1,145 @@
+package vnc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// readKeyEvents returns the keys and their down state sent to the server.
+func readKeyEvents(t *testing.T, conn *ClientConn, mockConn *MockConn) ([]keys.Key, []bool) {
+	var ks []keys.Key
+	var downs []bool
+	for mockConn.b.Len() > 0 {
+		var msg KeyEventMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Msg != messages.KeyEvent {
+			t.Fatalf("unexpected message-type %v", msg.Msg)
+		}
+		ks = append(ks, msg.Key)
+		downs = append(downs, msg.DownFlag == rfbflags.RFBTrue)
+	}
+	return ks, downs
+}
+
+func TestClientConn_KeyCombo(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.KeyCombo(keys.ControlLeft, keys.AltLeft, keys.Delete); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ks, downs := readKeyEvents(t, conn, mockConn)
+	wantKeys := []keys.Key{keys.ControlLeft, keys.AltLeft, keys.Delete, keys.Delete, keys.AltLeft, keys.ControlLeft}
+	wantDowns := []bool{true, true, true, false, false, false}
+	if len(ks) != len(wantKeys) {
+		t.Fatalf("got %d key events, want %d", len(ks), len(wantKeys))
+	}
+	for i := range ks {
+		if ks[i] != wantKeys[i] || downs[i] != wantDowns[i] {
+			t.Errorf("event %d = %v/%v, want %v/%v", i, ks[i], downs[i], wantKeys[i], wantDowns[i])
+		}
+	}
+}
+
+func TestClientConn_Type(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.Type("Hi\n"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ks, downs := readKeyEvents(t, conn, mockConn)
+	wantKeys := []keys.Key{keys.H, keys.H, keys.SmallI, keys.SmallI, keys.Return, keys.Return}
+	if len(ks) != len(wantKeys) {
+		t.Fatalf("got %d key events, want %d", len(ks), len(wantKeys))
+	}
+	for i := range ks {
+		if ks[i] != wantKeys[i] || downs[i] != (i%2 == 0) {
+			t.Errorf("event %d = %v/%v, want %v/%v", i, ks[i], downs[i], wantKeys[i], i%2 == 0)
+		}
+	}
+}
+
+func TestClientConn_Click(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	if err := conn.Click(buttons.Right, 10, 20); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, want := range []PointerEventMessage{
+		{messages.PointerEvent, uint8(buttons.Right), 10, 20},
+		{messages.PointerEvent, uint8(buttons.None), 10, 20},
+	} {
+		var got PointerEventMessage
+		if err := conn.receive(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestClientConn_Type_KeyboardLayout(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{KeyboardLayout: keys.LayoutDE})
+
+	if err := conn.Type("z@"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ks, downs := readKeyEvents(t, conn, mockConn)
+	wantKeys := []keys.Key{keys.SmallY, keys.SmallY, keys.AltRight, keys.SmallQ, keys.SmallQ, keys.AltRight}
+	wantDowns := []bool{true, false, true, true, false, false}
+	if !reflect.DeepEqual(ks, wantKeys) || !reflect.DeepEqual(downs, wantDowns) {
+		t.Errorf("key events = %v/%v, want %v/%v", ks, downs, wantKeys, wantDowns)
+	}
+}
+
+func TestClientConn_ViewOnly(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{ViewOnly: true})
+
+	if !conn.ViewOnly() {
+		t.Fatal("ViewOnly() = false, want true")
+	}
+	for _, tt := range []struct {
+		desc string
+		fn   func() error
+	}{
+		{"KeyEvent", func() error { return conn.KeyEvent(keys.A, true) }},
+		{"PointerEvent", func() error { return conn.PointerEvent(buttons.Left, 1, 1) }},
+		{"ClientCutText", func() error { return conn.ClientCutText("text") }},
+		{"Type", func() error { return conn.Type("text") }},
+		{"Click", func() error { return conn.Click(buttons.Left, 1, 1) }},
+	} {
+		if err := tt.fn(); !errors.Is(err, ErrViewOnly) {
+			t.Errorf("%s: error = %v, want %v", tt.desc, err, ErrViewOnly)
+		}
+	}
+	if got := mockConn.b.Len(); got != 0 {
+		t.Errorf("%d bytes sent in view-only mode", got)
+	}
+
+	// Override.
+	conn.SetViewOnly(false)
+	if err := conn.KeyPress(keys.A); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if ks, _ := readKeyEvents(t, conn, mockConn); len(ks) != 2 {
+		t.Errorf("got %d key events, want 2", len(ks))
+	}
+}
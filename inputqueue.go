@@ -0,0 +1,210 @@
+// Scheduled injection of input events.
+
+package vnc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"golang.org/x/net/context"
+)
+
+// InputQueue sends input events to the server at scheduled times, so timed
+// sequences (e.g. holding a key for 500ms) need no sleeps by the caller.
+//
+// Events are MacroEvents, whose Delay is relative to the previous event
+// scheduled, or to the time they are scheduled if the queue is empty. Events
+// are sent in the order scheduled, by a goroutine of the queue. The positions
+// of pointer events are transformed as set by SetPointerTransform, as with
+// PointerEvent.
+type InputQueue struct {
+	c    *ClientConn
+	wake chan struct{} // Signals the scheduler that events were added.
+	done chan struct{} // Closed when the scheduler stops.
+	stop context.CancelFunc
+
+	mu     sync.Mutex
+	events []queuedEvent
+	last   time.Time     // When the last event scheduled is due.
+	idle   chan struct{} // Closed while no events are queued.
+	err    error         // Why the scheduler stopped.
+}
+
+type queuedEvent struct {
+	due time.Time
+	e   MacroEvent
+}
+
+// NewInputQueue returns an InputQueue sending events on the connection,
+// until the context is done or the queue is closed.
+func NewInputQueue(ctx context.Context, c *ClientConn) *InputQueue {
+	ctx, stop := context.WithCancel(ctx)
+	q := &InputQueue{
+		c:    c,
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		stop: stop,
+		idle: make(chan struct{}),
+	}
+	close(q.idle)
+	go q.run(ctx)
+	return q
+}
+
+// Schedule queues the events. The Delay of each event is relative to the
+// previous event.
+func (q *InputQueue) Schedule(events ...MacroEvent) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+	for i, e := range events {
+		if e.Kind != KeyMacroEvent && e.Kind != PointerMacroEvent {
+			return Errorf("event %d has invalid kind %q", i, e.Kind)
+		}
+		if e.Delay < 0 {
+			return Errorf("event %d has negative delay", i)
+		}
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	if now := time.Now(); q.last.Before(now) {
+		q.last = now
+	}
+	if len(q.events) == 0 {
+		q.idle = make(chan struct{})
+	}
+	for _, e := range events {
+		q.last = q.last.Add(e.Delay)
+		q.events = append(q.events, queuedEvent{q.last, e})
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// KeyHold schedules pressing the key, and releasing it d later.
+func (q *InputQueue) KeyHold(key keys.Key, d time.Duration) error {
+	return q.Schedule(
+		MacroEvent{Kind: KeyMacroEvent, Key: key, Down: true},
+		MacroEvent{Delay: d, Kind: KeyMacroEvent, Key: key},
+	)
+}
+
+// PointerHold schedules pressing the buttons at (x, y), and releasing them d
+// later.
+func (q *InputQueue) PointerHold(button buttons.Button, x, y uint16, d time.Duration) error {
+	return q.Schedule(
+		MacroEvent{Kind: PointerMacroEvent, Buttons: button, X: x, Y: y},
+		MacroEvent{Delay: d, Kind: PointerMacroEvent, Buttons: buttons.None, X: x, Y: y},
+	)
+}
+
+// Flush waits until all the events queued have been sent.
+func (q *InputQueue) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	idle := q.idle
+	q.mu.Unlock()
+	select {
+	case <-idle:
+	case <-q.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// Close stops the queue, discarding the events not yet sent, and returns the
+// error which stopped it, if any.
+func (q *InputQueue) Close() error {
+	q.stop()
+	<-q.done
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err == ErrClosed {
+		return nil
+	}
+	return q.err
+}
+
+// send sends the event, transforming the position of pointer events.
+func (q *InputQueue) send(e MacroEvent) error {
+	if e.Kind == PointerMacroEvent {
+		return q.c.PointerEvent(e.Buttons, e.X, e.Y)
+	}
+	return e.send(q.c)
+}
+
+// run sends the events as they become due, until the context is done, or
+// an event can't be sent.
+func (q *InputQueue) run(ctx context.Context) {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		for len(q.events) > 0 && !q.events[0].due.After(time.Now()) {
+			e := q.events[0].e
+			q.mu.Unlock()
+			err := q.send(e)
+			q.mu.Lock()
+			if err != nil {
+				q.stopLocked(err)
+				q.mu.Unlock()
+				return
+			}
+			q.events = q.events[1:]
+		}
+		var (
+			timer *time.Timer
+			due   <-chan time.Time
+		)
+		if len(q.events) > 0 {
+			timer = time.NewTimer(time.Until(q.events[0].due))
+			due = timer.C
+		} else {
+			q.setIdle()
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-due:
+		case <-q.wake:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-ctx.Done():
+			q.mu.Lock()
+			err := ctx.Err()
+			if err == context.Canceled {
+				err = ErrClosed
+			}
+			q.stopLocked(err)
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// stopLocked records why the scheduler stopped, and discards the events.
+func (q *InputQueue) stopLocked(err error) {
+	q.err = err
+	q.events = nil
+	q.setIdle()
+}
+
+// setIdle closes the idle channel, if not already closed.
+func (q *InputQueue) setIdle() {
+	select {
+	case <-q.idle:
+	default:
+		close(q.idle)
+	}
+}
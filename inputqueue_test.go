@@ -0,0 +1,104 @@
+package vnc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"golang.org/x/net/context"
+)
+
+func TestInputQueue(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 100, 100
+	conn.SetPointerTransform(PointerTransform{OffsetX: 10, OffsetY: 20})
+	rec := conn.RecordMacro()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q := NewInputQueue(ctx, conn)
+	defer q.Close()
+
+	start := time.Now()
+	if err := q.KeyHold(keys.A, 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.PointerHold(buttons.Left, 1, 2, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Flush(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if elapsed, min := time.Since(start), 70*time.Millisecond; elapsed < min {
+		t.Errorf("events sent after %v, want at least %v", elapsed, min)
+	}
+
+	// The pointer events are transformed.
+	m := rec.Stop()
+	want := []MacroEvent{
+		{Kind: KeyMacroEvent, Key: keys.A, Down: true},
+		{Kind: KeyMacroEvent, Key: keys.A},
+		{Kind: PointerMacroEvent, Buttons: buttons.Left, X: 11, Y: 22},
+		{Kind: PointerMacroEvent, X: 11, Y: 22},
+	}
+	if got := len(m.Events); got != len(want) {
+		t.Fatalf("got %d events, want %d", got, len(want))
+	}
+	for i, e := range m.Events {
+		if e.Delay < 0 {
+			t.Errorf("event %d has negative delay", i)
+		}
+		e.Delay = 0
+		if e != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, e, want[i])
+		}
+	}
+	if d := m.Events[1].Delay; d < 50*time.Millisecond {
+		t.Errorf("key held for %v, want at least 50ms", d)
+	}
+}
+
+func TestInputQueue_Errors(t *testing.T) {
+	SetSettle(0)
+	conn := NewClientConn(&MockConn{}, &ClientConfig{ViewOnly: true})
+	q := NewInputQueue(context.Background(), conn)
+
+	if err := q.Schedule(MacroEvent{Kind: "bogus"}); err == nil {
+		t.Error("expected error for invalid kind")
+	}
+	if err := q.Schedule(MacroEvent{Kind: KeyMacroEvent, Delay: -1}); err == nil {
+		t.Error("expected error for negative delay")
+	}
+
+	// The error sending an event stops the queue.
+	if err := q.KeyHold(keys.A, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Flush(context.Background()); !errors.Is(err, ErrViewOnly) {
+		t.Errorf("Flush() = %v, want %v", err, ErrViewOnly)
+	}
+	if err := q.KeyHold(keys.A, 0); !errors.Is(err, ErrViewOnly) {
+		t.Errorf("Schedule() after error = %v, want %v", err, ErrViewOnly)
+	}
+	if err := q.Close(); !errors.Is(err, ErrViewOnly) {
+		t.Errorf("Close() = %v, want %v", err, ErrViewOnly)
+	}
+}
+
+func TestInputQueue_Close(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	q := NewInputQueue(context.Background(), conn)
+	if err := q.KeyHold(keys.A, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Close(); err != nil {
+		t.Errorf("Close() = %v, want nil", err)
+	}
+	if err := q.Schedule(MacroEvent{Kind: KeyMacroEvent}); err != ErrClosed {
+		t.Errorf("Schedule() after Close = %v, want %v", err, ErrClosed)
+	}
+}
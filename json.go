@@ -0,0 +1,216 @@
+// JSON encoding of messages, for debug dumps and transcripts.
+
+package vnc
+
+import (
+	"encoding/json"
+)
+
+// JSONDataLimit is the maximum number of bytes of pixel data, and other
+// payloads, included in the JSON encoding of a message. Payloads are base64
+// encoded, and summarized by their length; longer payloads are truncated.
+var JSONDataLimit = 64
+
+// Verify that interfaces are honored.
+var (
+	_ json.Marshaler = (*SetPixelFormatMessage)(nil)
+	_ json.Marshaler = (*SetEncodingsMessage)(nil)
+	_ json.Marshaler = (*FramebufferUpdateRequestMessage)(nil)
+	_ json.Marshaler = (*KeyEventMessage)(nil)
+	_ json.Marshaler = (*PointerEventMessage)(nil)
+	_ json.Marshaler = (*ClientCutTextMessage)(nil)
+
+	_ json.Marshaler = (*FramebufferUpdate)(nil)
+	_ json.Marshaler = (*SetColorMapEntries)(nil)
+	_ json.Marshaler = (*Bell)(nil)
+	_ json.Marshaler = (*ServerCutText)(nil)
+
+	_ json.Marshaler = (*Rectangle)(nil)
+	_ json.Marshaler = (*RawEncoding)(nil)
+	_ json.Marshaler = (*CursorPseudoEncoding)(nil)
+	_ json.Marshaler = (*LazyEncoding)(nil)
+	_ json.Marshaler = (*UnknownEncoding)(nil)
+)
+
+// jsonData summarizes a payload.
+type jsonData struct {
+	Length    int
+	Data      []byte // Base64 encoded by encoding/json.
+	Truncated bool   `json:",omitempty"`
+}
+
+func newJSONData(b []byte) jsonData {
+	d := jsonData{Length: len(b), Data: b}
+	if JSONDataLimit >= 0 && len(b) > JSONDataLimit {
+		d.Data, d.Truncated = b[:JSONDataLimit], true
+	}
+	return d
+}
+
+// newColorData summarizes the colors as 8-bit RGB triplets, independent of
+// the pixel format. The length is that of all the triplets, and the data is
+// truncated to the whole triplets within the JSONDataLimit.
+func newColorData(colors []Color) jsonData {
+	n := len(colors)
+	if JSONDataLimit >= 0 && 3*n > JSONDataLimit {
+		n = JSONDataLimit / 3
+	}
+	b := make([]byte, 0, 3*n)
+	for _, c := range colors[:n] {
+		r, g, bl, _ := c.RGBA()
+		b = append(b, uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+	}
+	return jsonData{Length: 3 * len(colors), Data: b, Truncated: n < len(colors)}
+}
+
+//-----------------------------------------------------------------------------
+// Client-to-Server messages
+//
+// The message-type is encoded by name.
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *SetPixelFormatMessage) MarshalJSON() ([]byte, error) {
+	type alias SetPixelFormatMessage
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Msg.String(), (*alias)(m)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *SetEncodingsMessage) MarshalJSON() ([]byte, error) {
+	type alias SetEncodingsMessage
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Msg.String(), (*alias)(m)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *FramebufferUpdateRequestMessage) MarshalJSON() ([]byte, error) {
+	type alias FramebufferUpdateRequestMessage
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Msg.String(), (*alias)(m)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *KeyEventMessage) MarshalJSON() ([]byte, error) {
+	type alias KeyEventMessage
+	return json.Marshal(struct {
+		Msg string
+		Key string
+		*alias
+	}{m.Msg.String(), m.Key.String(), (*alias)(m)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *PointerEventMessage) MarshalJSON() ([]byte, error) {
+	type alias PointerEventMessage
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Msg.String(), (*alias)(m)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *ClientCutTextMessage) MarshalJSON() ([]byte, error) {
+	type alias ClientCutTextMessage
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Msg.String(), (*alias)(m)})
+}
+
+//-----------------------------------------------------------------------------
+// Server-to-Client messages
+//
+// The message-type, which the messages don't hold, is added by name.
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *FramebufferUpdate) MarshalJSON() ([]byte, error) {
+	type alias FramebufferUpdate
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Type().String(), (*alias)(m)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *SetColorMapEntries) MarshalJSON() ([]byte, error) {
+	type color struct{ R, G, B uint16 }
+	colors := make([]color, len(m.Colors))
+	for i, c := range m.Colors {
+		colors[i] = color{c.R, c.G, c.B}
+	}
+	return json.Marshal(struct {
+		Msg        string
+		FirstColor uint16
+		Colors     []color
+	}{m.Type().String(), m.FirstColor, colors})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *Bell) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Msg string }{m.Type().String()})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (m *ServerCutText) MarshalJSON() ([]byte, error) {
+	type alias ServerCutText
+	return json.Marshal(struct {
+		Msg string
+		*alias
+	}{m.Type().String(), (*alias)(m)})
+}
+
+//-----------------------------------------------------------------------------
+// Rectangles and encodings
+//
+// The pixel data of encodings is summarized as 8-bit RGB triplets, which are
+// truncated to the JSONDataLimit.
+
+// MarshalJSON implements the json.Marshaler interface.
+func (r *Rectangle) MarshalJSON() ([]byte, error) {
+	var enc string
+	if r.Enc != nil {
+		enc = r.Enc.Type().String()
+	}
+	return json.Marshal(struct {
+		X, Y, Width, Height uint16
+		Encoding            string
+		Enc                 Encoding `json:",omitempty"`
+	}{r.X, r.Y, r.Width, r.Height, enc, r.Enc})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *RawEncoding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Pixels jsonData
+	}{newColorData(e.Colors)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *CursorPseudoEncoding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Pixels  jsonData
+		Bitmask jsonData
+	}{newColorData(e.Colors), newJSONData(e.Bitmask)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *LazyEncoding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Enc     string
+		Payload jsonData
+	}{e.Enc.String(), newJSONData(e.Data)})
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (e *UnknownEncoding) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Enc     string
+		Payload jsonData
+	}{e.Enc.String(), newJSONData(e.Data)})
+}
@@ -0,0 +1,55 @@
+package vnc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	defer func(limit int) { JSONDataLimit = limit }(JSONDataLimit)
+	JSONDataLimit = 4
+
+	white := Color{R: 0xffff, G: 0xffff, B: 0xffff}
+	for _, tt := range []struct {
+		desc string
+		v    interface{}
+		want string
+	}{
+		{"KeyEvent",
+			&KeyEventMessage{Msg: messages.KeyEvent, DownFlag: rfbflags.RFBTrue, Key: keys.Return},
+			`{"Msg":"KeyEvent","Key":"Return","DownFlag":1}`},
+		{"PointerEvent",
+			&PointerEventMessage{Msg: messages.PointerEvent, Mask: 1, X: 2, Y: 3},
+			`{"Msg":"PointerEvent","Mask":1,"X":2,"Y":3}`},
+		{"Bell", &Bell{}, `{"Msg":"Bell"}`},
+		{"ServerCutText", &ServerCutText{Text: "hi"}, `{"Msg":"ServerCutText","Text":"hi"}`},
+		{"SetColorMapEntries",
+			&SetColorMapEntries{FirstColor: 1, Colors: []Color{{R: 1, G: 2, B: 3}}},
+			`{"Msg":"SetColorMapEntries","FirstColor":1,"Colors":[{"R":1,"G":2,"B":3}]}`},
+		{"FramebufferUpdate",
+			&FramebufferUpdate{NumRect: 1, Rects: []Rectangle{
+				{X: 1, Y: 2, Width: 2, Height: 1, Enc: &RawEncoding{Colors: []Color{white, white}}},
+			}},
+			`{"Msg":"FramebufferUpdate","NumRect":1,"Rects":[{"X":1,"Y":2,"Width":2,"Height":1,"Encoding":"Raw",` +
+				`"Enc":{"Pixels":{"Length":6,"Data":"////","Truncated":true}}}]}`},
+		{"CursorPseudoEncoding",
+			&CursorPseudoEncoding{Colors: []Color{white}, Bitmask: []byte{0x80}},
+			`{"Pixels":{"Length":3,"Data":"////"},"Bitmask":{"Length":1,"Data":"gA=="}}`},
+		{"LazyEncoding",
+			&LazyEncoding{Enc: -223, Data: []byte{1, 2, 3}},
+			`{"Enc":"DesktopSizePseudo","Payload":{"Length":3,"Data":"AQID"}}`},
+	} {
+		got, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got = %s, want = %s", tt.desc, got, tt.want)
+		}
+	}
+}
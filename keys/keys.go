@@ -1,7 +1,10 @@
 // Package keys provides constants for all the keyboard inputs.
 package keys
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Key represents a VNC key press.
 type Key uint32
@@ -34,6 +37,75 @@ func IntToKeys(v int) Keys {
 	return k
 }
 
+// FromRune returns the Key that types the rune r. Latin-1 keysyms match their
+// code points, control characters map to their function keys, and all other
+// runes map to Unicode keysyms.
+func FromRune(r rune) Key {
+	switch {
+	case r == '\n' || r == '\r':
+		return Return
+	case r == '\t':
+		return Tab
+	case r == '\b':
+		return BackSpace
+	case r == 0x1b:
+		return Escape
+	case r >= 0x20 && r <= 0x7e, r >= 0xa0 && r <= 0xff:
+		return Key(r)
+	}
+	return Key(0x01000000 + r)
+}
+
+// names maps the names of keys, as used by automation scripts, to keys.
+var names = map[string]Key{
+	"alt":       AltLeft,
+	"altgr":     AltRight,
+	"bsp":       BackSpace,
+	"backspace": BackSpace,
+	"ctrl":      ControlLeft,
+	"del":       Delete,
+	"delete":    Delete,
+	"down":      Down,
+	"end":       End,
+	"enter":     Return,
+	"esc":       Escape,
+	"escape":    Escape,
+	"home":      Home,
+	"left":      Left,
+	"meta":      MetaLeft,
+	"pgdn":      PageDown,
+	"pgup":      PageUp,
+	"return":    Return,
+	"right":     Right,
+	"shift":     ShiftLeft,
+	"space":     Space,
+	"super":     SuperLeft,
+	"tab":       Tab,
+	"up":        Up,
+	"f1":        F1,
+	"f2":        F2,
+	"f3":        F3,
+	"f4":        F4,
+	"f5":        F5,
+	"f6":        F6,
+	"f7":        F7,
+	"f8":        F8,
+	"f9":        F9,
+	"f10":       F10,
+	"f11":       F11,
+	"f12":       F12,
+}
+
+// Lookup returns the Key with the given name (e.g. "enter", "ctrl", or "f1"),
+// ignoring case. A name of a single character returns the key that types it.
+func Lookup(name string) (Key, bool) {
+	if r := []rune(name); len(r) == 1 {
+		return FromRune(r[0]), true
+	}
+	k, ok := names[strings.ToLower(name)]
+	return k, ok
+}
+
 // Latin 1 (byte 3 = 0)
 // ISO/IEC 8859-1 = Unicode U+0020..U+00FF
 const (
@@ -20,3 +20,44 @@ func TestIntToKeys(t *testing.T) {
 		}
 	}
 }
+
+func TestFromRune(t *testing.T) {
+	for _, tt := range []struct {
+		r   rune
+		key Key
+	}{
+		{'a', SmallA},
+		{'A', A},
+		{' ', Space},
+		{'~', AsciiTilde},
+		{'\n', Return},
+		{'\t', Tab},
+		{'é', Key(0xe9)},
+		{'€', Key(0x010020ac)},
+	} {
+		if got, want := FromRune(tt.r), tt.key; got != want {
+			t.Errorf("FromRune(%q) = %v, want %v", tt.r, got, want)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		key  Key
+		ok   bool
+	}{
+		{"enter", Return, true},
+		{"Ctrl", ControlLeft, true},
+		{"F12", F12, true},
+		{"x", SmallX, true},
+		{"-", Minus, true},
+		{"bogus", 0, false},
+		{"", 0, false},
+	} {
+		got, ok := Lookup(tt.name)
+		if ok != tt.ok || got != tt.key {
+			t.Errorf("Lookup(%q) = %v, %v; want %v, %v", tt.name, got, ok, tt.key, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package keys
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Stroke is a key press, with the modifiers held while it is pressed.
+type Stroke struct {
+	Key  Key
+	Mods Keys
+}
+
+// Keys returns the modifiers followed by the key, in the order they are
+// pressed.
+func (s Stroke) Keys() Keys {
+	return append(append(Keys{}, s.Mods...), s.Key)
+}
+
+// Layout maps the runes typed on a keyboard layout to the strokes typing
+// them. Servers which map keysyms to the keys of a US keyboard (e.g. QEMU and
+// VMware), rather than to the characters of their own layout, need the keysym
+// of the US key at the position of the key, with the modifiers (Shift or
+// AltGr) of the layout. Dead keys, and the extra key of ISO keyboards, have
+// no US key, so the runes only typed with them aren't mapped.
+type Layout struct {
+	Name    string
+	strokes map[rune]Stroke
+}
+
+// Stroke returns the stroke typing the rune r. Runes the layout doesn't map,
+// and all runes of a nil layout, are typed by the keysym of the rune. See
+// FromRune.
+func (l *Layout) Stroke(r rune) Stroke {
+	if l != nil {
+		if s, ok := l.strokes[r]; ok {
+			return s
+		}
+	}
+	return Stroke{Key: FromRune(r)}
+}
+
+// String implements the fmt.Stringer interface.
+func (l *Layout) String() string { return fmt.Sprintf("Layout(%s)", l.Name) }
+
+// The characters of the keys of a US keyboard, by row. The backslash key is
+// last on the home row, at the position of the matching key of ISO keyboards.
+const (
+	usNormal = "`1234567890-=" + "qwertyuiop[]" + "asdfghjkl;'\\" + "zxcvbnm,./"
+	usShift  = "~!@#$%^&*()_+" + "QWERTYUIOP{}" + "ASDFGHJKL:\"|" + "ZXCVBNM<>?"
+)
+
+// The supported layouts.
+var (
+	LayoutUS = newLayout("us", usNormal, usShift, nil)
+	LayoutUK = newLayout("uk",
+		"`1234567890-="+"qwertyuiop[]"+"asdfghjkl;'#"+"zxcvbnm,./",
+		"¬!\"£$%^&*()_+"+"QWERTYUIOP{}"+"ASDFGHJKL:@~"+"ZXCVBNM<>?",
+		map[rune]rune{'`': '¦', '4': '€'})
+	LayoutDE = newLayout("de",
+		" 1234567890ß "+"qwertzuiopü+"+"asdfghjklöä#"+"yxcvbnm,.-",
+		"°!\"§$%&/()=? "+"QWERTZUIOPÜ*"+"ASDFGHJKLÖÄ'"+"YXCVBNM;:_",
+		map[rune]rune{'2': '²', '3': '³', '7': '{', '8': '[', '9': ']', '0': '}',
+			'-': '\\', 'q': '@', 'e': '€', ']': '~', 'm': 'µ'})
+	LayoutFR = newLayout("fr",
+		"²&é\"'(-è_çà)="+"azertyuiop $"+"qsdfghjklmù*"+"wxcvbn,;:!",
+		" 1234567890°+"+"AZERTYUIOP £"+"QSDFGHJKLM%µ"+"WXCVBN?./§",
+		map[rune]rune{'3': '#', '4': '{', '5': '[', '6': '|', '8': '\\', '9': '^',
+			'0': '@', '-': ']', '=': '}', 'e': '€', ']': '¤'})
+)
+
+var layouts = map[string]*Layout{}
+
+func init() {
+	for _, l := range []*Layout{LayoutUS, LayoutUK, LayoutDE, LayoutFR} {
+		layouts[l.Name] = l
+	}
+}
+
+// LookupLayout returns the layout with the name, e.g. "de".
+func LookupLayout(name string) (*Layout, bool) {
+	l, ok := layouts[strings.ToLower(name)]
+	return l, ok
+}
+
+// LayoutNames returns the names of the supported layouts, sorted.
+func LayoutNames() []string {
+	var names []string
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newLayout returns the layout typing the characters of normal and shift on
+// the keys of usNormal, alone and with Shift, and the values of altGr on the
+// keys of its keys with AltGr. Spaces mark keys which type no character, or
+// a dead key.
+func newLayout(name, normal, shift string, altGr map[rune]rune) *Layout {
+	base, n, s := []rune(usNormal), []rune(normal), []rune(shift)
+	if len(n) != len(base) || len(s) != len(base) {
+		panic(fmt.Sprintf("keyboard layout %s has %d and %d keys, want %d", name, len(n), len(s), len(base)))
+	}
+	l := &Layout{Name: name, strokes: map[rune]Stroke{}}
+	add := func(r rune, stroke Stroke) {
+		if _, ok := l.strokes[r]; r != ' ' && !ok {
+			l.strokes[r] = stroke
+		}
+	}
+	for i, k := range base {
+		add(n[i], Stroke{Key: Key(k)})
+	}
+	for i, k := range base {
+		add(s[i], Stroke{Key: Key(k), Mods: Keys{ShiftLeft}})
+	}
+	for k, r := range altGr {
+		add(r, Stroke{Key: Key(k), Mods: Keys{AltRight}})
+	}
+	return l
+}
@@ -0,0 +1,56 @@
+package keys
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLayout_Stroke(t *testing.T) {
+	shift, altGr := Keys{ShiftLeft}, Keys{AltRight}
+	for _, tt := range []struct {
+		desc   string
+		layout *Layout
+		r      rune
+		stroke Stroke
+	}{
+		{"nil layout", nil, '@', Stroke{Key: At}},
+		{"us letter", LayoutUS, 'z', Stroke{Key: SmallZ}},
+		{"us upper-case", LayoutUS, 'Z', Stroke{SmallZ, shift}},
+		{"us symbol", LayoutUS, '@', Stroke{Digit2, shift}},
+		{"us unmapped", LayoutUS, '\n', Stroke{Key: Return}},
+		{"uk symbol", LayoutUK, '@', Stroke{Apostrophe, shift}},
+		{"uk pound", LayoutUK, '£', Stroke{Digit3, shift}},
+		{"de letter", LayoutDE, 'z', Stroke{Key: SmallY}},
+		{"de altgr", LayoutDE, '@', Stroke{SmallQ, altGr}},
+		{"de umlaut", LayoutDE, 'ö', Stroke{Key: Semicolon}},
+		{"de dead key", LayoutDE, '^', Stroke{Key: FromRune('^')}},
+		{"fr letter", LayoutFR, 'a', Stroke{Key: SmallQ}},
+		{"fr digit", LayoutFR, '1', Stroke{Digit1, shift}},
+		{"fr altgr", LayoutFR, '@', Stroke{Digit0, altGr}},
+	} {
+		if got, want := tt.layout.Stroke(tt.r), tt.stroke; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Stroke(%q) = %v, want %v", tt.desc, tt.r, got, want)
+		}
+	}
+}
+
+func TestStroke_Keys(t *testing.T) {
+	if got, want := (Stroke{SmallQ, Keys{AltRight}}).Keys(), (Keys{AltRight, SmallQ}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() = %v, want %v", got, want)
+	}
+}
+
+func TestLookupLayout(t *testing.T) {
+	for _, name := range LayoutNames() {
+		l, ok := LookupLayout(name)
+		if !ok || l.Name != name {
+			t.Errorf("LookupLayout(%q) = %v, %v", name, l, ok)
+		}
+	}
+	if l, ok := LookupLayout("DE"); !ok || l != LayoutDE {
+		t.Errorf("LookupLayout(%q) = %v, %v; want %v", "DE", l, ok, LayoutDE)
+	}
+	if _, ok := LookupLayout("bogus"); ok {
+		t.Errorf("LookupLayout(%q) succeeded", "bogus")
+	}
+}
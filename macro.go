@@ -0,0 +1,165 @@
+// Recording and replay of input macros.
+
+package vnc
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/logging"
+	"golang.org/x/net/context"
+)
+
+// Macro is a recorded sequence of input events, with their timing. Macros
+// are serialized as JSON, with Write and ReadMacro.
+type Macro struct {
+	Events []MacroEvent `json:"events"`
+}
+
+// MacroEventKind is the kind of a MacroEvent.
+type MacroEventKind string
+
+// The kinds of MacroEvent.
+const (
+	KeyMacroEvent     MacroEventKind = "key"
+	PointerMacroEvent MacroEventKind = "pointer"
+)
+
+// MacroEvent is a KeyEvent or PointerEvent of a Macro.
+type MacroEvent struct {
+	// Delay is the time since the previous event, or the start of recording.
+	Delay time.Duration  `json:"delay"`
+	Kind  MacroEventKind `json:"kind"`
+
+	// KeyEvent fields.
+	Key  keys.Key `json:"key,omitempty"`
+	Down bool     `json:"down,omitempty"`
+
+	// PointerEvent fields.
+	Buttons buttons.Button `json:"buttons,omitempty"`
+	X       uint16         `json:"x,omitempty"`
+	Y       uint16         `json:"y,omitempty"`
+}
+
+// ReadMacro reads a macro written by Macro.Write.
+func ReadMacro(r io.Reader) (*Macro, error) {
+	m := &Macro{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, wrapErrorf(err, "reading macro: %s", err)
+	}
+	for i, e := range m.Events {
+		if e.Kind != KeyMacroEvent && e.Kind != PointerMacroEvent {
+			return nil, Errorf("reading macro: event %d has invalid kind %q", i, e.Kind)
+		}
+		if e.Delay < 0 {
+			return nil, Errorf("reading macro: event %d has negative delay", i)
+		}
+	}
+	return m, nil
+}
+
+// Write writes the macro as JSON.
+func (m *Macro) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+// Duration returns the total duration of the macro.
+func (m *Macro) Duration() time.Duration {
+	var d time.Duration
+	for _, e := range m.Events {
+		d += e.Delay
+	}
+	return d
+}
+
+// Play sends the events of the macro to the server. The delays between events
+// are divided by speed, so 2 plays the macro twice as fast; if speed is zero,
+// the events are sent without delay.
+func (m *Macro) Play(ctx context.Context, c *ClientConn, speed float64) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Macro." + logging.FnName())
+	}
+	// Sleep until each event is due, so that delays don't accumulate.
+	start := time.Now()
+	var due time.Duration
+	for _, e := range m.Events {
+		if speed > 0 {
+			due += time.Duration(float64(e.Delay) / speed)
+			if wait := due - time.Since(start); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.send(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send sends the event to the server.
+func (e *MacroEvent) send(c *ClientConn) error {
+	switch e.Kind {
+	case KeyMacroEvent:
+		return c.KeyEvent(e.Key, e.Down)
+	case PointerMacroEvent:
+		return c.pointerEvent(e.Buttons, e.X, e.Y)
+	}
+	return Errorf("invalid macro event kind %q", e.Kind)
+}
+
+//-----------------------------------------------------------------------------
+
+// MacroRecorder records the input events sent on a connection.
+type MacroRecorder struct {
+	c     *ClientConn
+	last  time.Time
+	macro *Macro
+}
+
+// RecordMacro starts recording the KeyEvent and PointerEvent messages sent on
+// the connection, including those sent by the input helpers (e.g. Type), until
+// the recorder is stopped. Starting a new recording stops the current one.
+func (c *ClientConn) RecordMacro() *MacroRecorder {
+	r := &MacroRecorder{c: c, last: time.Now(), macro: &Macro{}}
+	c.macroMu.Lock()
+	c.macro = r
+	c.macroMu.Unlock()
+	return r
+}
+
+// Stop stops recording, and returns the recorded macro.
+func (r *MacroRecorder) Stop() *Macro {
+	r.c.macroMu.Lock()
+	defer r.c.macroMu.Unlock()
+	if r.c.macro == r {
+		r.c.macro = nil
+	}
+	return r.macro
+}
+
+// record adds the event to the current recording, if any.
+func (c *ClientConn) record(e MacroEvent) {
+	c.macroMu.Lock()
+	defer c.macroMu.Unlock()
+	r := c.macro
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	e.Delay = now.Sub(r.last)
+	r.last = now
+	r.macro.Events = append(r.macro.Events, e)
+}
@@ -0,0 +1,135 @@
+package vnc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"golang.org/x/net/context"
+)
+
+func TestMacro_RecordAndPlay(t *testing.T) {
+	SetSettle(0)
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+
+	conn.KeyPress(keys.A) // Not recorded.
+	r := conn.RecordMacro()
+	conn.Type("Hi")
+	conn.Click(buttons.Left, 10, 20)
+	m := r.Stop()
+	conn.KeyPress(keys.B) // Not recorded.
+
+	want := []MacroEvent{
+		{Kind: KeyMacroEvent, Key: keys.H, Down: true},
+		{Kind: KeyMacroEvent, Key: keys.H},
+		{Kind: KeyMacroEvent, Key: keys.SmallI, Down: true},
+		{Kind: KeyMacroEvent, Key: keys.SmallI},
+		{Kind: PointerMacroEvent, Buttons: buttons.Left, X: 10, Y: 20},
+		{Kind: PointerMacroEvent, Buttons: buttons.None, X: 10, Y: 20},
+	}
+	if got, want := len(m.Events), len(want); got != want {
+		t.Fatalf("got %d events, want %d", got, want)
+	}
+	for i, e := range m.Events {
+		if e.Delay < 0 {
+			t.Errorf("event %d has negative delay %s", i, e.Delay)
+		}
+		e.Delay = 0
+		if got, want := e, want[i]; got != want {
+			t.Errorf("event %d = %v, want %v", i, got, want)
+		}
+	}
+
+	// Round trip through the serialized form.
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	m2, err := ReadMacro(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := len(m2.Events), len(m.Events); got != want {
+		t.Fatalf("got %d events after round trip, want %d", got, want)
+	}
+	for i := range m.Events {
+		if got, want := m2.Events[i], m.Events[i]; got != want {
+			t.Errorf("event %d = %v after round trip, want %v", i, got, want)
+		}
+	}
+
+	// Replay, and check the messages sent.
+	mockConn.Reset()
+	if err := m2.Play(context.Background(), conn, 0); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i, e := range want {
+		switch e.Kind {
+		case KeyMacroEvent:
+			var msg KeyEventMessage
+			if err := conn.receive(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Key != e.Key {
+				t.Errorf("message %d key = %v, want %v", i, msg.Key, e.Key)
+			}
+		case PointerMacroEvent:
+			var msg PointerEventMessage
+			if err := conn.receive(&msg); err != nil {
+				t.Fatal(err)
+			}
+			if msg.Mask != uint8(e.Buttons) || msg.X != e.X || msg.Y != e.Y {
+				t.Errorf("message %d = %v, want %v", i, msg, e)
+			}
+		}
+	}
+}
+
+func TestMacro_PlayTiming(t *testing.T) {
+	SetSettle(0)
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	m := &Macro{Events: []MacroEvent{
+		{Delay: 40 * time.Millisecond, Kind: KeyMacroEvent, Key: keys.A, Down: true},
+		{Delay: 40 * time.Millisecond, Kind: KeyMacroEvent, Key: keys.A},
+	}}
+	if got, want := m.Duration(), 80*time.Millisecond; got != want {
+		t.Errorf("Duration() = %s, want %s", got, want)
+	}
+
+	start := time.Now()
+	if err := m.Play(context.Background(), conn, 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := time.Since(start); got < 40*time.Millisecond {
+		t.Errorf("played in %s, want at least 40ms", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := m.Play(ctx, conn, 1); err != context.Canceled {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestReadMacro(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		json string
+		ok   bool
+	}{
+		{"empty", `{"events": []}`, true},
+		{"key", `{"events": [{"delay": 5, "kind": "key", "key": 97, "down": true}]}`, true},
+		{"invalid kind", `{"events": [{"kind": "mouse"}]}`, false},
+		{"negative delay", `{"events": [{"delay": -1, "kind": "key"}]}`, false},
+		{"invalid json", `{`, false},
+	} {
+		_, err := ReadMacro(strings.NewReader(tt.json))
+		if got, want := err == nil, tt.ok; got != want {
+			t.Errorf("%s: unexpected error state; got = %v, want = %v (%v)", tt.desc, got, want, err)
+		}
+	}
+}
@@ -41,3 +41,13 @@ const (
 	Bell
 	ServerCutText
 )
+
+// UltraVNC Server-to-Client message types.
+// https://www.iana.org/assignments/rfb/rfb.xhtml#rfb-4
+const (
+	UltraResizeFrameBuffer ServerMessage = 4
+	UltraFileTransfer      ServerMessage = 7
+	UltraTextChat          ServerMessage = 11
+	UltraKeepAlive         ServerMessage = 13
+	UltraServerState       ServerMessage = 173
+)
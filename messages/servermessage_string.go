@@ -4,13 +4,35 @@ package messages
 
 import "fmt"
 
-const _ServerMessage_name = "FramebufferUpdateSetColorMapEntriesBellServerCutText"
+const (
+	_ServerMessage_name_0 = "FramebufferUpdateSetColorMapEntriesBellServerCutTextUltraResizeFrameBuffer"
+	_ServerMessage_name_1 = "UltraFileTransfer"
+	_ServerMessage_name_2 = "UltraTextChat"
+	_ServerMessage_name_3 = "UltraKeepAlive"
+	_ServerMessage_name_4 = "UltraServerState"
+)
 
-var _ServerMessage_index = [...]uint8{0, 17, 35, 39, 52}
+var (
+	_ServerMessage_index_0 = [...]uint8{0, 17, 35, 39, 52, 74}
+	_ServerMessage_index_1 = [...]uint8{0, 17}
+	_ServerMessage_index_2 = [...]uint8{0, 13}
+	_ServerMessage_index_3 = [...]uint8{0, 14}
+	_ServerMessage_index_4 = [...]uint8{0, 16}
+)
 
 func (i ServerMessage) String() string {
-	if i >= ServerMessage(len(_ServerMessage_index)-1) {
+	switch {
+	case i <= 4:
+		return _ServerMessage_name_0[_ServerMessage_index_0[i]:_ServerMessage_index_0[i+1]]
+	case i == 7:
+		return _ServerMessage_name_1
+	case i == 11:
+		return _ServerMessage_name_2
+	case i == 13:
+		return _ServerMessage_name_3
+	case i == 173:
+		return _ServerMessage_name_4
+	default:
 		return fmt.Sprintf("ServerMessage(%d)", i)
 	}
-	return _ServerMessage_name[_ServerMessage_index[i]:_ServerMessage_index[i+1]]
 }
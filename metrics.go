@@ -0,0 +1,179 @@
+// Hooks for exporting connection statistics to a metrics system.
+
+package vnc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// MetricsRegistry creates the metrics in which the statistics of connections
+// are recorded. Implement it to export the statistics to a metrics system;
+// the metric interfaces are satisfied by the Prometheus client types, e.g.
+// prometheus.Counter.
+//
+// The registry is asked for its metrics each time a connection is created
+// with it, so it must return the same metrics for the same name (or, to
+// monitor connections separately, metrics labeled for the connection).
+type MetricsRegistry interface {
+	// Counter returns the counter of the name.
+	Counter(name, help string) MetricCounter
+	// Histogram returns the histogram of the name. Durations are observed
+	// in seconds.
+	Histogram(name, help string) MetricHistogram
+}
+
+// MetricCounter is a metric which only increases.
+type MetricCounter interface {
+	Add(float64)
+}
+
+// MetricHistogram is a metric which records the distribution of values.
+type MetricHistogram interface {
+	Observe(float64)
+}
+
+// The metrics recorded for connections.
+const (
+	MetricConnections    = "vnc_connections_total"
+	MetricConnectErrors  = "vnc_connect_errors_total"
+	MetricBytesReceived  = "vnc_bytes_received_total"
+	MetricBytesSent      = "vnc_bytes_sent_total"
+	MetricUpdates        = "vnc_framebuffer_updates_total"
+	MetricRects          = "vnc_rectangles_total"
+	MetricRectDecodeTime = "vnc_rectangle_decode_seconds"
+)
+
+// connMetrics records the statistics of a connection. A nil *connMetrics
+// records nothing.
+type connMetrics struct {
+	connections   MetricCounter
+	connectErrors MetricCounter
+	bytesReceived MetricCounter
+	bytesSent     MetricCounter
+	updates       MetricCounter
+	rects         MetricCounter
+	rectDecode    MetricHistogram
+}
+
+// newConnMetrics returns the metrics of the registry, or nil if it is nil.
+func newConnMetrics(r MetricsRegistry) *connMetrics {
+	if r == nil {
+		return nil
+	}
+	// Reconnects are counted by MetricConnections, as each is a new
+	// connection.
+	return &connMetrics{
+		connections:   r.Counter(MetricConnections, "Connections established, including reconnects."),
+		connectErrors: r.Counter(MetricConnectErrors, "Connections which failed during the handshake."),
+		bytesReceived: r.Counter(MetricBytesReceived, "Bytes received from servers."),
+		bytesSent:     r.Counter(MetricBytesSent, "Bytes sent to servers."),
+		updates:       r.Counter(MetricUpdates, "FramebufferUpdate messages received."),
+		rects:         r.Counter(MetricRects, "FramebufferUpdate rectangles received."),
+		rectDecode:    r.Histogram(MetricRectDecodeTime, "Time to read and decode each rectangle, including network waits."),
+	}
+}
+
+func (m *connMetrics) connected(err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.connectErrors.Add(1)
+		return
+	}
+	m.connections.Add(1)
+}
+
+func (m *connMetrics) received(n int) {
+	if m != nil {
+		m.bytesReceived.Add(float64(n))
+	}
+}
+
+func (m *connMetrics) sent(n int) {
+	if m != nil {
+		m.bytesSent.Add(float64(n))
+	}
+}
+
+func (m *connMetrics) update() {
+	if m != nil {
+		m.updates.Add(1)
+	}
+}
+
+// rect records a rectangle, whose reading started at start.
+func (m *connMetrics) rect(start time.Time) {
+	if m != nil {
+		m.rects.Add(1)
+		m.rectDecode.Observe(time.Since(start).Seconds())
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Encoding statistics
+
+// EncodingStats are the statistics of the rectangles received with an
+// encoding, for choosing the encodings fastest for a server and CPU.
+type EncodingStats struct {
+	Rects  uint64 // The number of rectangles.
+	Pixels uint64 // The pixels of the rectangles. Zero for pseudo-encodings.
+	Bytes  uint64 // The bytes received, including the rectangle headers.
+
+	// DecodeTime is the time spent reading and decoding the rectangles,
+	// excluding the time spent waiting for data from the network.
+	DecodeTime time.Duration
+}
+
+// encodingStats records the EncodingStats of a connection.
+type encodingStats struct {
+	mu    sync.Mutex
+	stats map[encodings.Encoding]EncodingStats
+}
+
+// record records a rectangle of n bytes, decoded in d.
+func (s *encodingStats) record(rect *Rectangle, n uint64, d time.Duration) {
+	if rect.Enc == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stats == nil {
+		s.stats = map[encodings.Encoding]EncodingStats{}
+	}
+	enc := rect.Enc.Type()
+	st := s.stats[enc]
+	st.Rects++
+	if enc >= 0 {
+		st.Pixels += uint64(rect.Area())
+	}
+	st.Bytes += n
+	if d > 0 {
+		st.DecodeTime += d
+	}
+	s.stats[enc] = st
+}
+
+// EncodingStats returns the statistics of the rectangles received, by the
+// encoding of each, since the connection was made or ResetEncodingStats was
+// last called.
+func (c *ClientConn) EncodingStats() map[encodings.Encoding]EncodingStats {
+	c.encStats.mu.Lock()
+	defer c.encStats.mu.Unlock()
+	stats := make(map[encodings.Encoding]EncodingStats, len(c.encStats.stats))
+	for enc, st := range c.encStats.stats {
+		stats[enc] = st
+	}
+	return stats
+}
+
+// ResetEncodingStats clears the statistics of the rectangles received, e.g.
+// before measuring another mix of encodings.
+func (c *ClientConn) ResetEncodingStats() {
+	c.encStats.mu.Lock()
+	defer c.encStats.mu.Unlock()
+	c.encStats.stats = nil
+}
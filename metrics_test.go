@@ -0,0 +1,151 @@
+package vnc
+
+import (
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"golang.org/x/net/context"
+)
+
+// fakeRegistry records metrics in memory.
+type fakeRegistry struct {
+	counters   map[string]*fakeCounter
+	histograms map[string]*fakeHistogram
+}
+
+type fakeCounter struct{ v float64 }
+
+func (c *fakeCounter) Add(v float64) { c.v += v }
+
+type fakeHistogram struct{ vs []float64 }
+
+func (h *fakeHistogram) Observe(v float64) { h.vs = append(h.vs, v) }
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{map[string]*fakeCounter{}, map[string]*fakeHistogram{}}
+}
+
+func (r *fakeRegistry) Counter(name, _ string) MetricCounter {
+	if _, ok := r.counters[name]; !ok {
+		r.counters[name] = &fakeCounter{}
+	}
+	return r.counters[name]
+}
+
+func (r *fakeRegistry) Histogram(name, _ string) MetricHistogram {
+	if _, ok := r.histograms[name]; !ok {
+		r.histograms[name] = &fakeHistogram{}
+	}
+	return r.histograms[name]
+}
+
+func TestMetrics(t *testing.T) {
+	reg := newFakeRegistry()
+	conn := NewClientConn(&MockConn{}, &ClientConfig{Metrics: reg})
+	conn.pixelFormat = PixelFormat{} // No pixel data.
+	conn.fbWidth, conn.fbHeight = 640, 480
+
+	rects := []Rectangle{
+		{1, 2, 3, 4, &RawEncoding{}, conn.Encodable},
+		{5, 6, 7, 8, &RawEncoding{}, conn.Encodable},
+	}
+	bytes, err := newFramebufferUpdate(rects).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(bytes[1:]); err != nil { // Strip message-type.
+		t.Fatal(err)
+	}
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want float64
+	}{
+		{MetricBytesSent, float64(len(bytes) - 1)},
+		{MetricBytesReceived, float64(len(bytes) - 1)},
+		{MetricUpdates, 1},
+		{MetricRects, 2},
+		{MetricConnections, 0},
+	} {
+		if got, want := reg.counters[tt.name].v, tt.want; got != want {
+			t.Errorf("%s = %v, want = %v", tt.name, got, want)
+		}
+	}
+	if got, want := len(reg.histograms[MetricRectDecodeTime].vs), 2; got != want {
+		t.Errorf("%s has %d observations, want = %d", MetricRectDecodeTime, got, want)
+	}
+
+	// A failed handshake.
+	if _, err := Connect(context.Background(), &MockConn{}, &ClientConfig{Metrics: reg}); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := reg.counters[MetricConnectErrors].v, 1.0; got != want {
+		t.Errorf("%s = %v, want = %v", MetricConnectErrors, got, want)
+	}
+}
+
+func TestMetrics_Disabled(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if conn.stats != nil {
+		t.Fatal("metrics unexpectedly enabled")
+	}
+	// Recording with no registry is a no-op.
+	conn.stats.received(1)
+	conn.stats.connected(nil)
+}
+
+func TestEncodingStats(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+	conn.fbWidth, conn.fbHeight = 640, 480
+
+	for _, data := range [][]byte{
+		{0, 0, 3}, // number-of-rectangles
+		{0, 1, 0, 2, 0, 2, 0, 1, 0, 0, 0, 0}, {0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 3, 0, 1, 0, 0, 0, 0}, {0, 0, 0, 0, 0, 0},
+		{0, 4, 0, 4, 0, 2, 0, 1, 0, 0, 0, 1}, {0, 0, 0, 0},
+	} {
+		if err := conn.send(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.encodings = append(conn.encodings, &CopyRectEncoding{})
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	stats := conn.EncodingStats()
+	for _, tt := range []struct {
+		enc                  encodings.Encoding
+		rects, pixels, bytes uint64
+	}{
+		{encodings.Raw, 2, 5, 2*12 + 4 + 6},
+		{encodings.CopyRect, 1, 2, 12 + 4},
+	} {
+		st := stats[tt.enc]
+		if got, want := st.Rects, tt.rects; got != want {
+			t.Errorf("%v: incorrect rects; got = %v, want = %v", tt.enc, got, want)
+		}
+		if got, want := st.Pixels, tt.pixels; got != want {
+			t.Errorf("%v: incorrect pixels; got = %v, want = %v", tt.enc, got, want)
+		}
+		if got, want := st.Bytes, tt.bytes; got != want {
+			t.Errorf("%v: incorrect bytes; got = %v, want = %v", tt.enc, got, want)
+		}
+		if st.DecodeTime < 0 {
+			t.Errorf("%v: negative decode time %v", tt.enc, st.DecodeTime)
+		}
+	}
+	if got, want := len(stats), 2; got != want {
+		t.Errorf("incorrect number of encodings; got = %v, want = %v", got, want)
+	}
+
+	conn.ResetEncodingStats()
+	if got := conn.EncodingStats(); len(got) != 0 {
+		t.Errorf("stats not reset; got = %v", got)
+	}
+}
@@ -0,0 +1,99 @@
+// Streaming of the screen to browsers as MJPEG.
+
+package vnc
+
+import (
+	"bytes"
+	"fmt"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"time"
+)
+
+// The defaults of MJPEGHandler.
+const (
+	DefaultMJPEGFPS     = 5.0
+	DefaultMJPEGQuality = jpeg.DefaultQuality
+)
+
+// MJPEGHandler is an http.Handler streaming the screen as multipart MJPEG,
+// which browsers show like a video, e.g. to watch an automation session
+// without any client-side code:
+//
+//	http.Handle("/screen", &vnc.MJPEGHandler{Screen: screen, FPS: 2})
+//
+// A frame is sent when the screen changes, at most FPS times a second. Each
+// client is a FrameSubscriber of the screen, so slow clients only get fewer
+// frames.
+type MJPEGHandler struct {
+	Screen *Screen
+	// FPS is the maximum frame rate, or DefaultMJPEGFPS if zero.
+	FPS float64
+	// Quality is the JPEG quality, from 1 to 100, or DefaultMJPEGQuality if
+	// zero.
+	Quality int
+}
+
+func (h *MJPEGHandler) interval() time.Duration {
+	fps := h.FPS
+	if fps <= 0 {
+		fps = DefaultMJPEGFPS
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+func (h *MJPEGHandler) quality() int {
+	if h.Quality > 0 {
+		return h.Quality
+	}
+	return DefaultMJPEGQuality
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (h *MJPEGHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	fs := h.Screen.SubscribeFrames()
+	defer fs.Close()
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+
+	var buf bytes.Buffer
+	img := h.Screen.Image() // The first frame is the screen as it is.
+	for {
+		sent := time.Now()
+		buf.Reset()
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: h.quality()}); err != nil {
+			return
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":   {"image/jpeg"},
+			"Content-Length": {fmt.Sprint(buf.Len())},
+		})
+		if err != nil {
+			return
+		}
+		if _, err := buf.WriteTo(part); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		// The changes made meanwhile are coalesced into the next frame.
+		select {
+		case <-time.After(h.interval() - time.Since(sent)):
+		case <-ctx.Done():
+			return
+		}
+		f, err := fs.Next(ctx)
+		if err != nil {
+			return
+		}
+		img = f.Image
+	}
+}
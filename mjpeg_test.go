@@ -0,0 +1,62 @@
+package vnc
+
+import (
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMJPEGHandler(t *testing.T) {
+	s, _ := newTestScreen()
+	srv := httptest.NewServer(&MJPEGHandler{Screen: s, FPS: 100, Quality: 90})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mediaType, "multipart/x-mixed-replace"; got != want {
+		t.Fatalf("media type = %q, want %q", got, want)
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+
+	// The screen as it is, and then as changed, to red.
+	red := make([]Color, 12)
+	for i := range red {
+		red[i] = Color{R: 0xffff}
+	}
+	update := newFramebufferUpdate([]Rectangle{{Width: 4, Height: 3, Enc: &RawEncoding{red}}})
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			if err := s.Handle(update); err != nil {
+				t.Fatal(err)
+			}
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if got, want := part.Header.Get("Content-Type"), "image/jpeg"; got != want {
+			t.Errorf("%d: content type = %q, want %q", i, got, want)
+		}
+		img, err := jpeg.Decode(part)
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %s", i, err)
+		}
+		if got, want := img.Bounds().Dx(), 4; got != want {
+			t.Errorf("%d: width = %d, want %d", i, got, want)
+		}
+		r, _, _, _ := img.At(0, 0).RGBA()
+		if red := r > 0x8000; red != (i > 0) {
+			t.Errorf("%d: red = %#x", i, r)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Multi-monitor desktops, whose screen layout is sent by the server with the
+// ExtendedDesktopSize pseudo-encoding.
+
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	"github.com/kward/go-vnc/buttons"
+)
+
+// Monitor is a screen of a desktop, i.e. the area of the framebuffer shown by
+// one monitor of a multi-head desktop.
+type Monitor struct {
+	ID                  uint32 // The id of the screen, assigned by the server.
+	X, Y, Width, Height uint16 // The area of the framebuffer.
+	Flags               uint32 // Currently unused by the protocol.
+}
+
+// String implements the fmt.Stringer interface.
+func (m Monitor) String() string {
+	return fmt.Sprintf("Monitor{ id: %d, x: %d, y: %d, w: %d, h: %d }", m.ID, m.X, m.Y, m.Width, m.Height)
+}
+
+// Bounds returns the area of the framebuffer of the monitor.
+func (m Monitor) Bounds() image.Rectangle {
+	return image.Rect(int(m.X), int(m.Y), int(m.X)+int(m.Width), int(m.Y)+int(m.Height))
+}
+
+// Point returns the framebuffer position of the position (x, y) of the
+// monitor, or an error if it is outside the monitor.
+func (m Monitor) Point(x, y uint16) (uint16, uint16, error) {
+	if x >= m.Width || y >= m.Height {
+		return 0, 0, Errorf("position (%d, %d) is outside %v", x, y, m)
+	}
+	return m.X + x, m.Y + y, nil
+}
+
+// marshal returns the wire format of the monitor, as a SCREEN structure.
+func (m Monitor) marshal() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint32(b[0:], m.ID)
+	binary.BigEndian.PutUint16(b[4:], m.X)
+	binary.BigEndian.PutUint16(b[6:], m.Y)
+	binary.BigEndian.PutUint16(b[8:], m.Width)
+	binary.BigEndian.PutUint16(b[10:], m.Height)
+	binary.BigEndian.PutUint32(b[12:], m.Flags)
+	return b
+}
+
+// unmarshalMonitor decodes a SCREEN structure of 16 bytes.
+func unmarshalMonitor(b []byte) Monitor {
+	return Monitor{
+		ID:     binary.BigEndian.Uint32(b[0:]),
+		X:      binary.BigEndian.Uint16(b[4:]),
+		Y:      binary.BigEndian.Uint16(b[6:]),
+		Width:  binary.BigEndian.Uint16(b[8:]),
+		Height: binary.BigEndian.Uint16(b[10:]),
+		Flags:  binary.BigEndian.Uint32(b[12:]),
+	}
+}
+
+// PointerEventOn sends a PointerEvent at the position (x, y) of the monitor,
+// regardless of the pointer transform.
+func (c *ClientConn) PointerEventOn(m Monitor, button buttons.Button, x, y uint16) error {
+	fx, fy, err := m.Point(x, y)
+	if err != nil {
+		return err
+	}
+	return c.pointerEvent(button, fx, fy)
+}
+
+// ClickOn clicks the button at the position (x, y) of the monitor. See Click.
+func (c *ClientConn) ClickOn(m Monitor, button buttons.Button, x, y uint16) error {
+	fx, fy, err := m.Point(x, y)
+	if err != nil {
+		return err
+	}
+	return c.click(button, fx, fy)
+}
+
+// MonitorImage returns a copy of the area of the screen shown by the monitor,
+// with bounds starting at (0, 0).
+func (s *Screen) MonitorImage(m Monitor) *image.RGBA {
+	return s.subImage(m.Bounds())
+}
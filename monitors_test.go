@@ -0,0 +1,110 @@
+package vnc
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/encodings"
+)
+
+var testMonitors = []Monitor{
+	{ID: 1, Width: 4, Height: 2},
+	{ID: 2, X: 4, Width: 2, Height: 2, Flags: 0},
+}
+
+func TestExtendedDesktopSize(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		status   uint16
+		width    uint16
+		monitors []Monitor
+	}{
+		{"layout", 0, 6, testMonitors},
+		{"failed request", 1, 10, nil},
+	} {
+		conn := NewClientConn(&MockConn{}, &ClientConfig{})
+		conn.encodings = Encodings{&RawEncoding{}, &ExtendedDesktopSizePseudoEncoding{}}
+		conn.fbWidth, conn.fbHeight = 10, 10
+
+		data, err := (&ExtendedDesktopSizePseudoEncoding{testMonitors}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rect := &Rectangle{X: 1, Y: tt.status, Width: 6, Height: 2}
+		enc, err := conn.UnmarshalEncoding(encodings.ExtendedDesktopSizePseudo, rect, data)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if got, want := enc.(*ExtendedDesktopSizePseudoEncoding).Monitors, testMonitors; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: Monitors = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := conn.Monitors(), tt.monitors; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: conn.Monitors() = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := conn.FramebufferWidth(), tt.width; got != want {
+			t.Errorf("%s: FramebufferWidth() = %d, want %d", tt.desc, got, want)
+		}
+	}
+}
+
+func TestMonitor_Point(t *testing.T) {
+	m := testMonitors[1]
+	for _, tt := range []struct {
+		desc   string
+		x, y   uint16
+		fx, fy uint16
+		ok     bool
+	}{
+		{"origin", 0, 0, 4, 0, true},
+		{"inside", 1, 1, 5, 1, true},
+		{"outside", 2, 0, 0, 0, false},
+	} {
+		fx, fy, err := m.Point(tt.x, tt.y)
+		if got, want := err == nil, tt.ok; got != want {
+			t.Errorf("%s: error = %v", tt.desc, err)
+			continue
+		}
+		if fx != tt.fx || fy != tt.fy {
+			t.Errorf("%s: Point() = (%d, %d), want (%d, %d)", tt.desc, fx, fy, tt.fx, tt.fy)
+		}
+	}
+	if got, want := m.Bounds(), image.Rect(4, 0, 6, 2); got != want {
+		t.Errorf("Bounds() = %v, want %v", got, want)
+	}
+}
+
+func TestClientConn_ClickOn(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	if err := conn.ClickOn(testMonitors[1], buttons.Left, 1, 1); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var msg PointerEventMessage
+	if err := conn.receive(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := [2]uint16{msg.X, msg.Y}, [2]uint16{5, 1}; got != want {
+		t.Errorf("position = %v, want %v", got, want)
+	}
+	if err := conn.ClickOn(testMonitors[1], buttons.Left, 2, 0); err == nil {
+		t.Error("expected error for position outside the monitor")
+	}
+}
+
+func TestScreen_MonitorImage(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 6, 2
+	s := NewScreen(conn)
+	s.fb.Pixels[4] = Color{pf: &conn.pixelFormat, R: 0xffff}
+
+	img := s.MonitorImage(testMonitors[1])
+	if got, want := img.Bounds(), image.Rect(0, 0, 2, 2); got != want {
+		t.Fatalf("Bounds() = %v, want %v", got, want)
+	}
+	if got, want := img.RGBAAt(0, 0).R, uint8(0xff); got != want {
+		t.Errorf("red at (0, 0) = %d, want %d", got, want)
+	}
+}
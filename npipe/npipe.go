@@ -0,0 +1,89 @@
+/*
+Package npipe provides connections over Windows named pipes, on which Hyper-V
+and some enterprise agents expose RFB endpoints rather than on TCP.
+
+The connections and listeners implement the net.Conn and net.Listener
+interfaces, so they can be passed to vnc.Connect, or served like a TCP
+listener. Named pipes are only supported on Windows; elsewhere Dial and Listen
+return ErrUnsupported.
+*/
+package npipe
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsupported is returned by Dial and Listen on systems without named
+// pipes.
+var ErrUnsupported = errors.New("npipe: named pipes are only supported on Windows")
+
+// Addr is the path of a named pipe, e.g. `\\.\pipe\vnc`.
+type Addr string
+
+// Network implements the net.Addr interface.
+func (a Addr) Network() string { return "pipe" }
+
+// String implements the net.Addr interface.
+func (a Addr) String() string { return string(a) }
+
+// IsPath returns true if s is the path of a named pipe, i.e. `\\.\pipe\name`
+// for a local pipe, or `\\host\pipe\name` for a pipe of another host.
+func IsPath(s string) bool {
+	if !strings.HasPrefix(s, `\\`) {
+		return false
+	}
+	parts := strings.SplitN(s[2:], `\`, 3)
+	return len(parts) == 3 && parts[0] != "" && strings.EqualFold(parts[1], "pipe") && parts[2] != ""
+}
+
+// deadline is the read or write deadline of a connection. As the I/O of a
+// pipe can't be polled, cancel is called to abort the pending I/O when the
+// deadline expires.
+type deadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	gen     int // Incremented by set, to ignore timers already firing.
+	expired bool
+}
+
+// set sets the deadline to t. A zero t means no deadline.
+func (d *deadline) set(t time.Time, cancel func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.gen++
+	d.expired = false
+	if t.IsZero() {
+		return
+	}
+	dur := time.Until(t)
+	if dur <= 0 {
+		d.expired = true
+		cancel()
+		return
+	}
+	gen := d.gen
+	d.timer = time.AfterFunc(dur, func() {
+		d.mu.Lock()
+		if d.gen != gen {
+			d.mu.Unlock()
+			return
+		}
+		d.expired = true
+		d.mu.Unlock()
+		cancel()
+	})
+}
+
+// isExpired returns true if the deadline has expired.
+func (d *deadline) isExpired() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
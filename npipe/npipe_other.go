@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package npipe
+
+import (
+	"net"
+
+	"golang.org/x/net/context"
+)
+
+// Dial connects to the named pipe at path. Named pipes are only supported on
+// Windows.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: ErrUnsupported}
+}
+
+// Listen creates the named pipe at path, and returns a listener accepting
+// connections to it. Named pipes are only supported on Windows.
+func Listen(path string) (net.Listener, error) {
+	return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: ErrUnsupported}
+}
@@ -0,0 +1,97 @@
+package npipe
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestIsPath(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		s    string
+		want bool
+	}{
+		{"local", `\\.\pipe\vnc`, true},
+		{"remote", `\\hyperv\pipe\vm\console`, true},
+		{"case insensitive", `\\.\PIPE\vnc`, true},
+		{"no name", `\\.\pipe\`, false},
+		{"no host", `\\\pipe\vnc`, false},
+		{"not a pipe", `\\server\share\vnc`, false},
+		{"tcp", "127.0.0.1:5900", false},
+		{"empty", "", false},
+	} {
+		if got := IsPath(tt.s); got != tt.want {
+			t.Errorf("%s: IsPath(%q) = %v, want %v", tt.desc, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestAddr(t *testing.T) {
+	a := Addr(`\\.\pipe\vnc`)
+	if got, want := a.Network(), "pipe"; got != want {
+		t.Errorf("incorrect network; got = %v, want = %v", got, want)
+	}
+	if got, want := a.String(), `\\.\pipe\vnc`; got != want {
+		t.Errorf("incorrect string; got = %v, want = %v", got, want)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("named pipes are supported on Windows")
+	}
+	if _, err := Dial(context.Background(), `\\.\pipe\vnc`); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Dial() error = %v, want %v", err, ErrUnsupported)
+	}
+	if _, err := Listen(`\\.\pipe\vnc`); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Listen() error = %v, want %v", err, ErrUnsupported)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	var d deadline
+	var cancels int32
+	cancel := func() { atomic.AddInt32(&cancels, 1) }
+
+	d.set(time.Now().Add(-time.Second), cancel)
+	if !d.isExpired() {
+		t.Error("past deadline not expired")
+	}
+	if got, want := atomic.LoadInt32(&cancels), int32(1); got != want {
+		t.Errorf("incorrect number of cancels; got = %v, want = %v", got, want)
+	}
+
+	d.set(time.Time{}, cancel)
+	if d.isExpired() {
+		t.Error("zero deadline expired")
+	}
+
+	d.set(time.Now().Add(10*time.Millisecond), cancel)
+	if d.isExpired() {
+		t.Error("future deadline expired")
+	}
+	for start := time.Now(); !d.isExpired(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("deadline never expired")
+		}
+	}
+	if got, want := atomic.LoadInt32(&cancels), int32(2); got != want {
+		t.Errorf("incorrect number of cancels; got = %v, want = %v", got, want)
+	}
+
+	// A deadline reset before it expires never cancels.
+	d.set(time.Now().Add(10*time.Millisecond), cancel)
+	d.set(time.Time{}, cancel)
+	time.Sleep(30 * time.Millisecond)
+	if d.isExpired() {
+		t.Error("reset deadline expired")
+	}
+	if got, want := atomic.LoadInt32(&cancels), int32(2); got != want {
+		t.Errorf("incorrect number of cancels; got = %v, want = %v", got, want)
+	}
+}
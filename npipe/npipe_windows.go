@@ -0,0 +1,354 @@
+//go:build windows
+// +build windows
+
+package npipe
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	kernel32                = syscall.NewLazyDLL("kernel32.dll")
+	procCancelIoEx          = kernel32.NewProc("CancelIoEx")
+	procConnectNamedPipe    = kernel32.NewProc("ConnectNamedPipe")
+	procCreateEventW        = kernel32.NewProc("CreateEventW")
+	procCreateNamedPipeW    = kernel32.NewProc("CreateNamedPipeW")
+	procGetOverlappedResult = kernel32.NewProc("GetOverlappedResult")
+	procWaitNamedPipeW      = kernel32.NewProc("WaitNamedPipeW")
+)
+
+const (
+	errorBrokenPipe        syscall.Errno = 109
+	errorPipeBusy          syscall.Errno = 231
+	errorPipeNotConnected  syscall.Errno = 233
+	errorPipeConnected     syscall.Errno = 535
+	errorOperationAborted  syscall.Errno = 995
+	fileFlagFirstInstance                = 0x00080000
+	pipeAccessDuplex                     = 0x00000003
+	pipeTypeByte                         = 0x00000000
+	pipeUnlimitedInstances               = 255
+	pipeBufferSize                       = 64 << 10
+	waitBusyTimeout                      = 100 // Milliseconds.
+)
+
+// Dial connects to the named pipe at path. While all instances of the pipe
+// are busy, Dial waits for one until the context is done.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: err}
+	}
+	for {
+		h, err := openPipe(name)
+		if err == nil {
+			return newConn(h, Addr(path)), nil
+		}
+		if err != errorPipeBusy {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: err}
+		}
+		procWaitNamedPipeW.Call(uintptr(unsafe.Pointer(name)), waitBusyTimeout)
+		select {
+		case <-ctx.Done():
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: ctx.Err()}
+		default:
+		}
+	}
+}
+
+// openPipe opens the client end of an instance of the named pipe, for
+// overlapped I/O.
+func openPipe(name *uint16) (syscall.Handle, error) {
+	return syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil, syscall.OPEN_EXISTING,
+		syscall.FILE_FLAG_OVERLAPPED, 0)
+}
+
+// Listen creates the named pipe at path, and returns a listener accepting
+// connections to it. It fails if the pipe already exists.
+func Listen(path string) (net.Listener, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: err}
+	}
+	// The first instance is created now, so Listen reports the errors.
+	h, err := createPipe(name, true)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: err}
+	}
+	return &listener{addr: Addr(path), name: name, next: h}, nil
+}
+
+// createPipe creates the server end of an instance of the named pipe, for
+// overlapped I/O.
+func createPipe(name *uint16, first bool) (syscall.Handle, error) {
+	mode := uintptr(pipeAccessDuplex | syscall.FILE_FLAG_OVERLAPPED)
+	if first {
+		mode |= fileFlagFirstInstance
+	}
+	r, _, err := procCreateNamedPipeW.Call(uintptr(unsafe.Pointer(name)), mode, pipeTypeByte,
+		pipeUnlimitedInstances, pipeBufferSize, pipeBufferSize, 0, 0)
+	if h := syscall.Handle(r); h != syscall.InvalidHandle {
+		return h, nil
+	}
+	return syscall.InvalidHandle, err
+}
+
+// listener accepts connections to a named pipe, each on a new instance of
+// the pipe.
+type listener struct {
+	addr Addr
+	name *uint16
+
+	mu     sync.Mutex
+	next   syscall.Handle // The instance of the pipe to accept on, if valid.
+	closed bool
+}
+
+// Verify that interfaces are honored.
+var _ net.Listener = (*listener)(nil)
+
+// Accept implements the net.Listener interface.
+func (l *listener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, l.opError(net.ErrClosed)
+	}
+	h := l.next
+	l.next = syscall.InvalidHandle
+	l.mu.Unlock()
+
+	if h == syscall.InvalidHandle {
+		var err error
+		if h, err = createPipe(l.name, false); err != nil {
+			return nil, l.opError(err)
+		}
+	}
+	if _, err := overlappedIO(h, nil, nil, func(o *syscall.Overlapped) error {
+		if r, _, err := procConnectNamedPipe.Call(uintptr(h), uintptr(unsafe.Pointer(o))); r == 0 {
+			return err
+		}
+		return nil
+	}); err != nil {
+		syscall.CloseHandle(h)
+		return nil, l.opError(err)
+	}
+
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		syscall.CloseHandle(h)
+		return nil, l.opError(net.ErrClosed)
+	}
+	return newConn(h, l.addr), nil
+}
+
+// Close implements the net.Listener interface. An Accept blocked waiting for
+// a connection is woken by connecting to the pipe.
+func (l *listener) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	next := l.next
+	l.next = syscall.InvalidHandle
+	l.mu.Unlock()
+
+	if h, err := openPipe(l.name); err == nil {
+		syscall.CloseHandle(h)
+	}
+	if next != syscall.InvalidHandle {
+		syscall.CloseHandle(next)
+	}
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (l *listener) Addr() net.Addr { return l.addr }
+
+func (l *listener) opError(err error) error {
+	return &net.OpError{Op: "accept", Net: "pipe", Addr: l.addr, Err: err}
+}
+
+// overlappedIO starts overlapped I/O on h with fn, and waits for it to
+// complete. The pending I/O is tracked by p, if not nil, so it can be
+// cancelled, and is cancelled once started if the deadline d has already
+// expired.
+func overlappedIO(h syscall.Handle, p *pendingIO, d *deadline, fn func(o *syscall.Overlapped) error) (uint32, error) {
+	// A manual-reset event, signaled when the I/O completes.
+	r, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	ev := syscall.Handle(r)
+	defer syscall.CloseHandle(ev)
+	o := &syscall.Overlapped{HEvent: ev}
+	if p != nil {
+		p.start(o)
+		defer p.done()
+	}
+	switch err := fn(o); err {
+	case nil, syscall.ERROR_IO_PENDING:
+	case errorPipeConnected:
+		// A client connected before ConnectNamedPipe, which completed
+		// without signaling the event.
+		return 0, nil
+	default:
+		return 0, err
+	}
+	// A deadline expiring before the I/O started cancelled nothing.
+	if d != nil && d.isExpired() {
+		procCancelIoEx.Call(uintptr(h), uintptr(unsafe.Pointer(o)))
+	}
+	var n uint32
+	if r, _, err := procGetOverlappedResult.Call(uintptr(h), uintptr(unsafe.Pointer(o)), uintptr(unsafe.Pointer(&n)), 1); r == 0 {
+		return n, err
+	}
+	return n, nil
+}
+
+// pendingIO is the pending overlapped I/O of a pipe in one direction, which
+// is cancelled when the deadline of that direction expires.
+type pendingIO struct {
+	h syscall.Handle
+
+	mu sync.Mutex
+	o  *syscall.Overlapped
+}
+
+func (p *pendingIO) start(o *syscall.Overlapped) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.o = o
+}
+
+func (p *pendingIO) done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.o = nil
+}
+
+// cancel aborts the pending I/O, if any.
+func (p *pendingIO) cancel() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.o != nil {
+		procCancelIoEx.Call(uintptr(p.h), uintptr(unsafe.Pointer(p.o)))
+	}
+}
+
+// conn is a connection over an instance of a named pipe. The pipe is opened
+// for overlapped I/O, as otherwise Windows serializes the I/O of the handle,
+// and a write would wait for a pending read to complete.
+type conn struct {
+	h    syscall.Handle
+	addr Addr
+
+	rd, wd    deadline
+	rio, wio  pendingIO
+	closeOnce sync.Once
+}
+
+// Verify that interfaces are honored.
+var _ net.Conn = (*conn)(nil)
+
+func newConn(h syscall.Handle, addr Addr) *conn {
+	return &conn{h: h, addr: addr, rio: pendingIO{h: h}, wio: pendingIO{h: h}}
+}
+
+// Read implements the net.Conn interface.
+func (c *conn) Read(b []byte) (int, error) {
+	if c.rd.isExpired() {
+		return 0, c.opError("read", os.ErrDeadlineExceeded)
+	}
+	n, err := overlappedIO(c.h, &c.rio, &c.rd, func(o *syscall.Overlapped) error {
+		return syscall.ReadFile(c.h, b, nil, o)
+	})
+	switch {
+	case err == nil && n == 0 && len(b) > 0:
+		return 0, io.EOF
+	case err == errorBrokenPipe, err == errorPipeNotConnected:
+		return int(n), io.EOF
+	case err == errorOperationAborted && c.rd.isExpired():
+		return int(n), c.opError("read", os.ErrDeadlineExceeded)
+	case err != nil:
+		return int(n), c.opError("read", err)
+	}
+	return int(n), nil
+}
+
+// Write implements the net.Conn interface.
+func (c *conn) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		if c.wd.isExpired() {
+			return written, c.opError("write", os.ErrDeadlineExceeded)
+		}
+		n, err := overlappedIO(c.h, &c.wio, &c.wd, func(o *syscall.Overlapped) error {
+			return syscall.WriteFile(c.h, b[written:], nil, o)
+		})
+		written += int(n)
+		if err == errorOperationAborted && c.wd.isExpired() {
+			return written, c.opError("write", os.ErrDeadlineExceeded)
+		}
+		if err != nil {
+			return written, c.opError("write", err)
+		}
+	}
+	return written, nil
+}
+
+// Close implements the net.Conn interface. Pending reads and writes are
+// cancelled.
+func (c *conn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.cancel()
+		err = syscall.CloseHandle(c.h)
+	})
+	return err
+}
+
+// LocalAddr implements the net.Conn interface.
+func (c *conn) LocalAddr() net.Addr { return c.addr }
+
+// RemoteAddr implements the net.Conn interface.
+func (c *conn) RemoteAddr() net.Addr { return c.addr }
+
+// SetDeadline implements the net.Conn interface.
+func (c *conn) SetDeadline(t time.Time) error {
+	c.rd.set(t, c.rio.cancel)
+	c.wd.set(t, c.wio.cancel)
+	return nil
+}
+
+// SetReadDeadline implements the net.Conn interface.
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.rd.set(t, c.rio.cancel)
+	return nil
+}
+
+// SetWriteDeadline implements the net.Conn interface.
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.wd.set(t, c.wio.cancel)
+	return nil
+}
+
+// cancel aborts the pending I/O of the pipe, in both directions.
+func (c *conn) cancel() {
+	procCancelIoEx.Call(uintptr(c.h), 0)
+}
+
+func (c *conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "pipe", Source: c.addr, Addr: c.addr, Err: err}
+}
@@ -0,0 +1,106 @@
+//go:build windows
+// +build windows
+
+package npipe
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// pipePair returns the client and server ends of a connection over a new
+// named pipe.
+func pipePair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	path := fmt.Sprintf(`\\.\pipe\go-vnc-test-%d-%d`, os.Getpid(), time.Now().UnixNano())
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() unexpected error: %s", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Errorf("Accept() unexpected error: %s", err)
+		}
+		accepted <- c
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err = Dial(ctx, path)
+	if err != nil {
+		t.Fatalf("Dial() unexpected error: %s", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if server = <-accepted; server == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() { server.Close() })
+	return client, server
+}
+
+func TestConn_ConcurrentReadWrite(t *testing.T) {
+	client, server := pipePair(t)
+
+	// A read pending on each end doesn't block the writes.
+	read := func(c net.Conn) <-chan error {
+		ch := make(chan error, 1)
+		go func() {
+			b := make([]byte, 4)
+			_, err := io.ReadFull(c, b)
+			if err == nil && string(b) != "ping" {
+				err = fmt.Errorf("read %q, want %q", b, "ping")
+			}
+			ch <- err
+		}()
+		return ch
+	}
+	clientRead, serverRead := read(client), read(server)
+	time.Sleep(50 * time.Millisecond) // Let the reads block.
+
+	written := make(chan error, 2)
+	go func() {
+		_, err := client.Write([]byte("ping"))
+		written <- err
+	}()
+	go func() {
+		_, err := server.Write([]byte("ping"))
+		written <- err
+	}()
+	for _, ch := range []<-chan error{written, written, clientRead, serverRead} {
+		select {
+		case err := <-ch:
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("I/O blocked")
+		}
+	}
+}
+
+func TestConn_ReadDeadline(t *testing.T) {
+	client, server := pipePair(t)
+
+	// The expiry of the read deadline aborts the read, but not the writes.
+	client.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := client.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read() error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if _, err := client.Write([]byte("x")); err != nil {
+		t.Fatalf("Write() unexpected error: %s", err)
+	}
+	b := make([]byte, 1)
+	if _, err := server.Read(b); err != nil || b[0] != 'x' {
+		t.Errorf("Read() = %q, %v, want %q", b, err, "x")
+	}
+}
@@ -0,0 +1,72 @@
+// Hooks for reading text from the screen with an OCR engine.
+
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+	"golang.org/x/net/context"
+)
+
+// OCRProvider recognizes text in images. The library doesn't include an OCR
+// engine; implement OCRProvider with one, e.g. a tesseract binding or a cloud
+// API.
+type OCRProvider interface {
+	// Recognize returns the text in the image.
+	Recognize(ctx context.Context, img image.Image) (string, error)
+}
+
+// OCRFunc adapts a function to the OCRProvider interface.
+type OCRFunc func(ctx context.Context, img image.Image) (string, error)
+
+// Verify that interfaces are honored.
+var _ OCRProvider = OCRFunc(nil)
+
+// Recognize calls f(ctx, img).
+func (f OCRFunc) Recognize(ctx context.Context, img image.Image) (string, error) {
+	return f(ctx, img)
+}
+
+// ReadText returns the text in the region of the screen, as recognized by
+// ocr. The region is clipped to the screen. The screen is read as is; call
+// Refresh first if it may be out of date.
+func (s *Screen) ReadText(ctx context.Context, ocr OCRProvider, region image.Rectangle) (string, error) {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	region = region.Intersect(s.Bounds())
+	if region.Empty() {
+		return "", Errorf("empty region %v", region)
+	}
+	text, err := ocr.Recognize(ctx, s.Image().SubImage(region))
+	if err != nil {
+		return "", wrapErrorf(err, "recognizing text: %s", err)
+	}
+	return text, nil
+}
+
+// WaitForText waits until the text recognized by ocr in the region of the
+// screen contains text. The screen is read after each update of the region.
+func (s *Session) WaitForText(ocr OCRProvider, region image.Rectangle, text string) *Session {
+	return s.Do(fmt.Sprintf("wait for text %q in %v", text, region), func(ctx context.Context) error {
+		if err := s.screen.Refresh(ctx); err != nil {
+			return err
+		}
+		for {
+			got, err := s.screen.ReadText(ctx, ocr, region)
+			if err != nil {
+				return err
+			}
+			if strings.Contains(got, text) {
+				return nil
+			}
+			if err := s.screen.WaitForChange(ctx, region); err != nil {
+				return err
+			}
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// colorOCR "recognizes" the color of the top-left pixel of images.
+var colorOCR = OCRFunc(func(ctx context.Context, img image.Image) (string, error) {
+	r, g, b, _ := img.At(img.Bounds().Min.X, img.Bounds().Min.Y).RGBA()
+	switch {
+	case r == 0xffff && g == 0 && b == 0:
+		return "red", nil
+	case r == 0 && g == 0 && b == 0:
+		return "black", nil
+	}
+	return "", nil
+})
+
+func TestScreen_ReadText(t *testing.T) {
+	s, _ := newTestScreen()
+	s.Handle(rawUpdate(2, 1, Color{R: 0xffff}))
+
+	for _, tt := range []struct {
+		desc   string
+		region image.Rectangle
+		text   string
+		ok     bool
+	}{
+		{"red", image.Rect(2, 1, 4, 3), "red", true},
+		{"black", image.Rect(0, 0, 2, 2), "black", true},
+		{"clipped", image.Rect(2, 1, 10, 10), "red", true},
+		{"outside", image.Rect(10, 10, 20, 20), "", false},
+	} {
+		text, err := s.ReadText(context.Background(), colorOCR, tt.region)
+		if err == nil != tt.ok {
+			t.Errorf("%s: unexpected error state; got = %v, want = %v", tt.desc, err == nil, tt.ok)
+			continue
+		}
+		if got, want := text, tt.text; got != want {
+			t.Errorf("%s: ReadText() = %q, want = %q", tt.desc, got, want)
+		}
+	}
+
+	errOCR := errors.New("no engine")
+	_, err := s.ReadText(context.Background(), OCRFunc(func(context.Context, image.Image) (string, error) {
+		return "", errOCR
+	}), image.Rect(0, 0, 1, 1))
+	if !errors.Is(err, errOCR) {
+		t.Errorf("error = %v, want %v", err, errOCR)
+	}
+}
+
+func TestSession_WaitForText(t *testing.T) {
+	s, _ := newTestScreen(
+		rawUpdate(0, 0, Color{}), // Refresh.
+		rawUpdate(1, 1, Color{R: 0xffff}),
+	)
+	err := NewSession(context.Background(), s.c, s).
+		Timeout(5*time.Second).
+		WaitForText(colorOCR, image.Rect(1, 1, 2, 2), "red").
+		Err()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
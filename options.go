@@ -0,0 +1,200 @@
+// Functional options for configuring connections.
+
+package vnc
+
+import (
+	"log"
+	"time"
+
+	"github.com/kward/go-vnc/keys"
+)
+
+// An Option configures a connection made with Connect.
+//
+// A *ClientConfig is itself an Option, replacing the whole configuration, so
+// existing callers of Connect are unaffected. When Connect is given a single
+// *ClientConfig, it is used as is; otherwise the configuration starts from
+// NewClientConfig(""), and the options are applied in order.
+type Option interface {
+	apply(*ClientConfig)
+}
+
+// optionFunc adapts a function to the Option interface.
+type optionFunc func(*ClientConfig)
+
+func (f optionFunc) apply(cfg *ClientConfig) { f(cfg) }
+
+// apply implements the Option interface.
+func (c *ClientConfig) apply(cfg *ClientConfig) { *cfg = *c }
+
+// newConfig returns the configuration given by the options.
+func newConfig(opts []Option) *ClientConfig {
+	if len(opts) == 1 {
+		if cfg, ok := opts[0].(*ClientConfig); ok {
+			return cfg
+		}
+	}
+	cfg := NewClientConfig("")
+	for _, opt := range opts {
+		opt.apply(cfg)
+	}
+	return cfg
+}
+
+// WithAuth sets the supported authentication methods, in order of preference.
+func WithAuth(auth ...ClientAuth) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Auth = auth })
+}
+
+// WithPassword sets the password for VNC authentication, and adds VNC
+// authentication to the supported authentication methods if missing.
+func WithPassword(p string) Option {
+	return optionFunc(func(cfg *ClientConfig) {
+		cfg.Password = p
+		cfg.Auth = withVNCPassword(cfg.Auth, p)
+	})
+}
+
+// withVNCPassword returns a copy of auth, with the VNC authentication of the
+// password p in place of any other, or else appended. The caller's slice, which
+// may be shared, is left unchanged.
+func withVNCPassword(auth []ClientAuth, p string) []ClientAuth {
+	out := make([]ClientAuth, 0, len(auth)+1)
+	found := false
+	for _, a := range auth {
+		if _, ok := a.(*ClientAuthVNC); ok {
+			a, found = &ClientAuthVNC{p}, true
+		}
+		out = append(out, a)
+	}
+	if !found {
+		out = append(out, &ClientAuthVNC{p})
+	}
+	return out
+}
+
+// WithEncodings sets the encodings advertised once connected.
+func WithEncodings(encs ...Encoding) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Encodings = encs })
+}
+
+// WithLogger sets the logger of the connection.
+func WithLogger(l *log.Logger) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Logger = l })
+}
+
+// WithExclusive requests exclusive access to the server, disconnecting other
+// clients.
+func WithExclusive(exclusive bool) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Exclusive = exclusive })
+}
+
+// WithViewOnly sets whether input sent to the server is suppressed.
+func WithViewOnly(viewOnly bool) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.ViewOnly = viewOnly })
+}
+
+// WithClipboardHistory sets the number of cut texts received from the server
+// retained.
+func WithClipboardHistory(n int) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.ClipboardHistory = n })
+}
+
+// WithServerMessageCh sets the channel receiving the messages from the server.
+func WithServerMessageCh(ch chan ServerMessage) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.ServerMessageCh = ch })
+}
+
+// WithServerMessages adds messages that can be read from the server, in
+// addition to the RFC-required messages.
+func WithServerMessages(msgs ...ServerMessage) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.ServerMessages = append(cfg.ServerMessages, msgs...) })
+}
+
+// WithLimits sets the limits on the size of messages read from the server.
+func WithLimits(l Limits) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Limits = l })
+}
+
+// WithRectFilters adds filters run on each decoded rectangle of the
+// FramebufferUpdate messages read from the server.
+func WithRectFilters(filters ...RectFilter) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.RectFilters = append(cfg.RectFilters, filters...) })
+}
+
+// WithMemoryBudget sets the budget capping the memory used by the connection.
+func WithMemoryBudget(b *MemoryBudget) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.MemoryBudget = b })
+}
+
+// WithPointerTransform sets the transform of the coordinates of the pointer
+// events sent onto the framebuffer.
+func WithPointerTransform(t PointerTransform) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.PointerTransform = &t })
+}
+
+// WithPalette sets the colors of the pixels of color map pixel formats, until
+// the server sends SetColorMapEntries.
+func WithPalette(p ColorMap) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Palette = &p })
+}
+
+// WithCoalesceWrites sets the delay for which messages sent are held back,
+// to write those sent close together at once.
+func WithCoalesceWrites(d time.Duration) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.CoalesceWrites = d })
+}
+
+// WithPrioritySend sets whether the messages sent wait in a queue ordered by
+// their priority.
+func WithPrioritySend(prioritySend bool) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.PrioritySend = prioritySend })
+}
+
+// WithTimeouts sets the timeouts of the stages of the handshake.
+func WithTimeouts(t HandshakeTimeouts) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Timeouts = t })
+}
+
+// WithProfile sets the workarounds used for the quirks of the server.
+func WithProfile(p Profile) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Profile = p })
+}
+
+// WithKeyboardLayout sets the keyboard layout of the server, used by Type.
+func WithKeyboardLayout(l *keys.Layout) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.KeyboardLayout = l })
+}
+
+// WithPixelFormatFallback sets the fallback of the pixel format while the
+// bandwidth of the link is low.
+func WithPixelFormatFallback(f *PixelFormatFallback) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.PixelFormatFallback = f })
+}
+
+// WithEvents sets the bus receiving the lifecycle events of the connection.
+func WithEvents(b *EventBus) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Events = b })
+}
+
+// WithIdleTimeout sets the time without the remote screen changing after
+// which the connection is idle.
+func WithIdleTimeout(d time.Duration) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.IdleTimeout = d })
+}
+
+// WithMetrics sets the registry receiving the statistics of the connection.
+func WithMetrics(r MetricsRegistry) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Metrics = r })
+}
+
+// WithTracer sets the tracer of the connection.
+func WithTracer(t Tracer) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Tracer = t })
+}
+
+// WithStrict sets whether violations of the RFB protocol by the server abort
+// the message being read.
+func WithStrict(strict bool) Option {
+	return optionFunc(func(cfg *ClientConfig) { cfg.Strict = strict })
+}
@@ -0,0 +1,78 @@
+package vnc
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestNewConfig(t *testing.T) {
+	cfg := &ClientConfig{Strict: true}
+	if got := newConfig([]Option{cfg}); got != cfg {
+		t.Error("single *ClientConfig not used as is")
+	}
+
+	got := newConfig([]Option{cfg, WithProfile(ProfileApple)})
+	if got == cfg {
+		t.Fatal("*ClientConfig used as is with other options")
+	}
+	if !got.Strict || got.Profile != ProfileApple {
+		t.Errorf("config = %+v, want Strict and ProfileApple", got)
+	}
+	if cfg.Profile != ProfileStandard {
+		t.Error("options modified the given *ClientConfig")
+	}
+
+	got = newConfig(nil)
+	if got.ServerMessages == nil {
+		t.Error("default config has no ServerMessages")
+	}
+
+	got = newConfig([]Option{
+		WithAuth(&ClientAuthNone{}),
+		WithPassword("secret"),
+		WithEncodings(&RawEncoding{}, &DesktopSizePseudoEncoding{}),
+		WithServerMessages(&UltraKeepAlive{}),
+	})
+	if got, want := len(got.Auth), 2; got != want {
+		t.Fatalf("got %d auth methods, want %d", got, want)
+	}
+	if a, ok := got.Auth[1].(*ClientAuthVNC); !ok || a.Password != "secret" || got.Password != "secret" {
+		t.Errorf("Auth[1] = %v, want VNC authentication with password", got.Auth[1])
+	}
+	if got, want := len(got.Encodings), 2; got != want {
+		t.Errorf("got %d encodings, want %d", got, want)
+	}
+	if got, want := len(got.ServerMessages), 5; got != want {
+		t.Errorf("got %d server messages, want %d", got, want)
+	}
+
+	got = newConfig([]Option{WithPassword("a"), WithPassword("b")})
+	if got, want := len(got.Auth), 2; got != want {
+		t.Errorf("got %d auth methods, want %d", got, want)
+	}
+
+	// The Auth slice given isn't modified.
+	shared := []ClientAuth{&ClientAuthVNC{"shared"}}
+	got = newConfig([]Option{WithAuth(shared...), WithPassword("secret")})
+	if a := got.Auth[0].(*ClientAuthVNC); a.Password != "secret" {
+		t.Errorf("Auth[0] password = %q, want %q", a.Password, "secret")
+	}
+	if a := shared[0].(*ClientAuthVNC); a.Password != "shared" {
+		t.Errorf("shared Auth[0] password = %q, want %q", a.Password, "shared")
+	}
+}
+
+func TestConnect_Options(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New(&buf, "", 0)
+	if _, err := Connect(context.Background(), &MockConn{}, WithLogger(logger), WithStrict(true)); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := buf.String(), "VNC Client connection closed."; !strings.Contains(got, want) {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
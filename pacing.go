@@ -0,0 +1,95 @@
+// Adaptive pacing of framebuffer update requests.
+
+package vnc
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+	"golang.org/x/net/context"
+)
+
+// The defaults of Pacer.
+const (
+	DefaultPacerMin = time.Second / 60
+	DefaultPacerMax = time.Second
+)
+
+// Pacer adapts the interval between framebuffer update requests to the
+// screen. The interval is halved by each update which changes the screen,
+// down to Min, so a busy screen is followed closely, and grows by half with
+// each update which doesn't, up to Max, so an idle screen costs few requests.
+// Under decode pressure, i.e. when applying and handling updates takes much
+// of the interval, the interval backs off to twice the time taken.
+type Pacer struct {
+	Min, Max time.Duration // The bounds of the interval. If zero, the defaults.
+
+	interval time.Duration
+}
+
+// NewPacer returns a Pacer with the interval bounded by min and max.
+func NewPacer(min, max time.Duration) *Pacer {
+	return &Pacer{Min: min, Max: max}
+}
+
+func (p *Pacer) min() time.Duration {
+	if p.Min <= 0 {
+		return DefaultPacerMin
+	}
+	return p.Min
+}
+
+func (p *Pacer) max() time.Duration {
+	if p.Max <= 0 {
+		return DefaultPacerMax
+	}
+	return p.Max
+}
+
+// Interval returns the current interval between requests.
+func (p *Pacer) Interval() time.Duration {
+	if p.interval == 0 {
+		return p.min()
+	}
+	return p.interval
+}
+
+// Next updates the interval after an update, given whether it changed the
+// screen, and the time spent applying and handling it, and returns the
+// interval before the next request.
+func (p *Pacer) Next(changed bool, busy time.Duration) time.Duration {
+	interval := p.Interval()
+	if changed {
+		interval /= 2
+	} else {
+		interval += interval / 2
+	}
+	if interval < 2*busy {
+		interval = 2 * busy
+	}
+	if interval < p.min() {
+		interval = p.min()
+	}
+	if interval > p.max() {
+		interval = p.max()
+	}
+	p.interval = interval
+	return interval
+}
+
+// PollAdaptive watches the screen for changes, calling fn each time it
+// changes, like Poll, but with the interval between incremental requests
+// paced by p. See Pacer.
+func (s *Screen) PollAdaptive(ctx context.Context, p *Pacer, fn PollFunc) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return err
+	}
+	if err := fn(s.Image(), s.Bounds()); err != nil {
+		return err
+	}
+	return s.poll(ctx, fn, p.Interval(), p.Next)
+}
@@ -0,0 +1,72 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestPacer_Next(t *testing.T) {
+	p := NewPacer(10*time.Millisecond, 100*time.Millisecond)
+	for _, tt := range []struct {
+		desc    string
+		changed bool
+		busy    time.Duration
+		want    time.Duration
+	}{
+		{"idle", false, 0, 15 * time.Millisecond},
+		{"idle again", false, 0, 22500 * time.Microsecond},
+		{"busy", true, 0, 11250 * time.Microsecond},
+		{"busy at min", true, 0, 10 * time.Millisecond},
+		{"decode pressure", true, 30 * time.Millisecond, 60 * time.Millisecond},
+		{"decode pressure at max", true, time.Second, 100 * time.Millisecond},
+		{"idle at max", false, 0, 100 * time.Millisecond},
+	} {
+		if got, want := p.Next(tt.changed, tt.busy), tt.want; got != want {
+			t.Errorf("%s: Next() = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := p.Interval(), tt.want; got != want {
+			t.Errorf("%s: Interval() = %v, want %v", tt.desc, got, want)
+		}
+	}
+
+	if got, want := (&Pacer{}).Interval(), DefaultPacerMin; got != want {
+		t.Errorf("default Interval() = %v, want %v", got, want)
+	}
+}
+
+func TestScreen_PollAdaptive(t *testing.T) {
+	s, _ := newTestScreen(
+		rawUpdate(0, 0, Color{}),          // Refresh.
+		rawUpdate(0, 0, Color{}),          // Unchanged.
+		rawUpdate(1, 1, Color{R: 0xffff}), // Changed.
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := NewPacer(time.Millisecond, 10*time.Millisecond)
+	var got []image.Rectangle
+	errStop := errors.New("stop")
+	err := s.PollAdaptive(ctx, p, func(img *image.RGBA, changed image.Rectangle) error {
+		got = append(got, changed)
+		if len(got) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("error = %v, want %v", err, errStop)
+	}
+	for i, want := range []image.Rectangle{image.Rect(0, 0, 4, 3), image.Rect(1, 1, 2, 2)} {
+		if got[i] != want {
+			t.Errorf("call %d changed = %v, want %v", i, got[i], want)
+		}
+	}
+	// The unchanged update slowed the requests down.
+	if got, min := p.Interval(), 1500*time.Microsecond; got < min {
+		t.Errorf("Interval() = %v, want at least %v", got, min)
+	}
+}
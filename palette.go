@@ -0,0 +1,64 @@
+// Default palettes of color map pixel formats.
+
+package vnc
+
+// The standard palettes, for ClientConfig.Palette.
+var (
+	// PaletteBGR233 holds the colors of 8-bit pixels of 3 bits of red, in the
+	// low bits, 3 of green, and 2 of blue, in the high bits, as used by many
+	// servers for 8-bit true color. It is the default palette.
+	PaletteBGR233 = bgr233Palette()
+	// PaletteGrayscale holds 256 shades of gray, from black to white.
+	PaletteGrayscale = grayscalePalette()
+	// PaletteWebSafe holds the 216 web-safe colors, of 6 levels each of red,
+	// green and blue, at index 36*r + 6*g + b. The remaining entries are
+	// black.
+	PaletteWebSafe = webSafePalette()
+)
+
+func bgr233Palette() ColorMap {
+	var p ColorMap
+	for i := range p {
+		r, g, b := i&7, (i>>3)&7, i>>6
+		p[i] = Color{R: uint16(r * 0xffff / 7), G: uint16(g * 0xffff / 7), B: uint16(b * 0xffff / 3)}
+	}
+	return p
+}
+
+func grayscalePalette() ColorMap {
+	var p ColorMap
+	for i := range p {
+		v := uint16(i * 0x101)
+		p[i] = Color{R: v, G: v, B: v}
+	}
+	return p
+}
+
+func webSafePalette() ColorMap {
+	var p ColorMap
+	for i := 0; i < 216; i++ {
+		r, g, b := i/36, (i/6)%6, i%6
+		p[i] = Color{R: uint16(r * 0x3333), G: uint16(g * 0x3333), B: uint16(b * 0x3333)}
+	}
+	return p
+}
+
+// palette returns the palette of the connection.
+func (c *ClientConn) palette() *ColorMap {
+	if c.pal != nil {
+		return c.pal
+	}
+	if p := c.config.Palette; p != nil {
+		return p
+	}
+	return &PaletteBGR233
+}
+
+// SetPalette sets the colors of pixels of a color map pixel format until the
+// server sends SetColorMapEntries, overriding ClientConfig.Palette, and
+// replaces the color map with them. It must not be called while messages are
+// read from the server.
+func (c *ClientConn) SetPalette(p ColorMap) {
+	c.pal = &p
+	c.colorMap = p
+}
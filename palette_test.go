@@ -0,0 +1,65 @@
+package vnc
+
+import (
+	"testing"
+)
+
+func TestPalettes(t *testing.T) {
+	white := [3]uint16{0xffff, 0xffff, 0xffff}
+	for _, tt := range []struct {
+		desc    string
+		palette ColorMap
+		index   int
+		want    [3]uint16
+	}{
+		{"bgr233 black", PaletteBGR233, 0, [3]uint16{}},
+		{"bgr233 red", PaletteBGR233, 0x07, [3]uint16{0xffff, 0, 0}},
+		{"bgr233 green", PaletteBGR233, 0x38, [3]uint16{0, 0xffff, 0}},
+		{"bgr233 blue", PaletteBGR233, 0xc0, [3]uint16{0, 0, 0xffff}},
+		{"bgr233 white", PaletteBGR233, 0xff, white},
+		{"grayscale mid", PaletteGrayscale, 0x80, [3]uint16{0x8080, 0x8080, 0x8080}},
+		{"grayscale white", PaletteGrayscale, 0xff, white},
+		{"web-safe white", PaletteWebSafe, 215, white},
+		{"web-safe red", PaletteWebSafe, 5 * 36, [3]uint16{0xffff, 0, 0}},
+		{"web-safe unused", PaletteWebSafe, 216, [3]uint16{}},
+	} {
+		c := tt.palette[tt.index]
+		if got := [3]uint16{c.R, c.G, c.B}; got != tt.want {
+			t.Errorf("%s: color %d = %v, want %v", tt.desc, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestClientConn_Palette(t *testing.T) {
+	// An 8-bit pixel decoded before SetColorMapEntries.
+	decode := func(conn *ClientConn) Color {
+		pf := PixelFormat8bit
+		colors := make([]Color, 1)
+		if err := pf.decodePixels(&conn.colorMap, []byte{0x07}, colors); err != nil {
+			t.Fatal(err)
+		}
+		return colors[0]
+	}
+
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if got, want := decode(conn).R, uint16(0xffff); got != want {
+		t.Errorf("default palette red = %#x, want %#x", got, want)
+	}
+
+	conn = NewClientConn(&MockConn{}, &ClientConfig{Palette: &PaletteGrayscale})
+	if got, want := decode(conn).R, uint16(0x0707); got != want {
+		t.Errorf("grayscale palette red = %#x, want %#x", got, want)
+	}
+
+	// SetPixelFormat reinstates the palette, from the next message read.
+	conn.SetPalette(PaletteWebSafe)
+	conn.readPixelFormat()
+	conn.colorMap[7] = Color{}
+	if err := conn.SetPixelFormat(PixelFormat8bit); err != nil {
+		t.Fatal(err)
+	}
+	conn.readPixelFormat()
+	if got, want := decode(conn).B, uint16(0x3333); got != want {
+		t.Errorf("web-safe palette blue = %#x, want %#x", got, want)
+	}
+}
@@ -72,13 +72,42 @@ func (pf PixelFormat) Marshal() ([]byte, error) {
 		return nil, NewVNCError(fmt.Sprintf("Invalid Depth value %v; must be 8, 16, or 32.", pf.Depth))
 	}
 
-	// Create the slice of bytes
-	buf := NewBuffer(nil)
-	if err := buf.Write(&pf); err != nil {
-		return nil, err
-	}
+	b := make([]byte, pixelFormatLen)
+	pf.put(b)
+	return b, nil
+}
 
-	return buf.Bytes(), nil
+// put writes the wire format of the PixelFormat into b, without validation.
+// The slice must be at least pixelFormatLen bytes long.
+func (pf *PixelFormat) put(b []byte) {
+	b[0] = pf.BPP
+	b[1] = pf.Depth
+	b[2] = uint8(pf.BigEndian)
+	b[3] = uint8(pf.TrueColor)
+	binary.BigEndian.PutUint16(b[4:], pf.RedMax)
+	binary.BigEndian.PutUint16(b[6:], pf.GreenMax)
+	binary.BigEndian.PutUint16(b[8:], pf.BlueMax)
+	b[10] = pf.RedShift
+	b[11] = pf.GreenShift
+	b[12] = pf.BlueShift
+	b[13], b[14], b[15] = 0, 0, 0 // padding
+}
+
+// get reads the wire format of a PixelFormat from b. The slice must be at
+// least pixelFormatLen bytes long.
+func (pf *PixelFormat) get(b []byte) {
+	*pf = PixelFormat{
+		BPP:        b[0],
+		Depth:      b[1],
+		BigEndian:  rfbflags.RFBFlag(b[2]),
+		TrueColor:  rfbflags.RFBFlag(b[3]),
+		RedMax:     binary.BigEndian.Uint16(b[4:]),
+		GreenMax:   binary.BigEndian.Uint16(b[6:]),
+		BlueMax:    binary.BigEndian.Uint16(b[8:]),
+		RedShift:   b[10],
+		GreenShift: b[11],
+		BlueShift:  b[12],
+	}
 }
 
 // Read reads from an io.Reader, and populates the PixelFormat.
@@ -92,12 +121,12 @@ func (pf *PixelFormat) Read(r io.Reader) error {
 
 // Unmarshal implements the Unmarshaler interface.
 func (pf *PixelFormat) Unmarshal(data []byte) error {
-	buf := NewBuffer(data)
+	if len(data) < pixelFormatLen {
+		return NewVNCError(fmt.Sprintf("PixelFormat too short (%v < %v)", len(data), pixelFormatLen))
+	}
 
 	var msg PixelFormat
-	if err := buf.Read(&msg); err != nil {
-		return err
-	}
+	msg.get(data)
 	if rfbflags.IsTrueColor(msg.TrueColor) {
 		msg.TrueColor = rfbflags.RFBTrue // Use our constant value.
 	}
@@ -118,3 +147,79 @@ func (pf PixelFormat) order() binary.ByteOrder {
 	}
 	return binary.LittleEndian
 }
+
+// pixelDecoder translates wire format pixel data into colors.
+type pixelDecoder func(pf *PixelFormat, cm *ColorMap, data []byte, colors []Color) error
+
+// decoder returns the pixelDecoder best suited to the pixel format. The
+// dominant formats have specialized, allocation-free conversion loops, with
+// all others falling back to the generic shifting of Color.Unmarshal.
+func (pf *PixelFormat) decoder() pixelDecoder {
+	if !rfbflags.IsTrueColor(pf.TrueColor) || rfbflags.IsBigEndian(pf.BigEndian) {
+		return decodePixelsGeneric
+	}
+	switch {
+	case pf.BPP == 32 && pf.isRGB(255, 255, 255, 16, 8, 0):
+		return decodePixelsBGRA32LE
+	case pf.BPP == 32 && pf.isRGB(255, 255, 255, 0, 8, 16):
+		return decodePixelsRGBA32LE
+	case pf.BPP == 16 && pf.isRGB(31, 63, 31, 11, 5, 0):
+		return decodePixelsRGB565LE
+	}
+	return decodePixelsGeneric
+}
+
+// isRGB returns true if the color maximums and shifts match those given.
+func (pf *PixelFormat) isRGB(rm, gm, bm uint16, rs, gs, bs uint8) bool {
+	return pf.RedMax == rm && pf.GreenMax == gm && pf.BlueMax == bm &&
+		pf.RedShift == rs && pf.GreenShift == gs && pf.BlueShift == bs
+}
+
+// decodePixels translates the pixel data into colors, using the fastest
+// available conversion for the pixel format. The data must hold exactly
+// len(colors) pixels.
+func (pf *PixelFormat) decodePixels(cm *ColorMap, data []byte, colors []Color) error {
+	if bpp := int(pf.BPP / 8); len(data) != bpp*len(colors) {
+		return NewVNCError(fmt.Sprintf("Invalid pixel data length %v; expected %v", len(data), bpp*len(colors)))
+	}
+	return pf.decoder()(pf, cm, data, colors)
+}
+
+func decodePixelsGeneric(pf *PixelFormat, cm *ColorMap, data []byte, colors []Color) error {
+	bpp := int(pf.BPP / 8)
+	for i := range colors {
+		color := &colors[i]
+		*color = Color{pf: pf, cm: cm}
+		if err := color.Unmarshal(data[i*bpp : (i+1)*bpp]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodePixelsBGRA32LE handles 32bpp little-endian with blue in the first byte.
+func decodePixelsBGRA32LE(pf *PixelFormat, cm *ColorMap, data []byte, colors []Color) error {
+	for i := range colors {
+		p := data[i*4 : i*4+4]
+		colors[i] = Color{pf: pf, cm: cm, R: uint16(p[2]), G: uint16(p[1]), B: uint16(p[0])}
+	}
+	return nil
+}
+
+// decodePixelsRGBA32LE handles 32bpp little-endian with red in the first byte.
+func decodePixelsRGBA32LE(pf *PixelFormat, cm *ColorMap, data []byte, colors []Color) error {
+	for i := range colors {
+		p := data[i*4 : i*4+4]
+		colors[i] = Color{pf: pf, cm: cm, R: uint16(p[0]), G: uint16(p[1]), B: uint16(p[2])}
+	}
+	return nil
+}
+
+// decodePixelsRGB565LE handles 16bpp little-endian 5-6-5 true color.
+func decodePixelsRGB565LE(pf *PixelFormat, cm *ColorMap, data []byte, colors []Color) error {
+	for i := range colors {
+		p := uint16(data[i*2]) | uint16(data[i*2+1])<<8
+		colors[i] = Color{pf: pf, cm: cm, R: p >> 11, G: (p >> 5) & 0x3f, B: p & 0x1f}
+	}
+	return nil
+}
@@ -143,3 +143,74 @@ func equalPixelFormat(g, w PixelFormat) bool {
 	}
 	return operators.EqualSlicesOfByte(got, want)
 }
+
+func TestPixelFormat_decodePixels(t *testing.T) {
+	data := make([]byte, 4*64)
+	for i := range data {
+		data[i] = byte(i * 37)
+	}
+
+	for _, tt := range []struct {
+		desc string
+		pf   PixelFormat
+	}{
+		{"32bpp BGRA LE", PixelFormat{BPP: 32, Depth: 24, TrueColor: RFBTrue, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}},
+		{"32bpp RGBA LE", PixelFormat{BPP: 32, Depth: 24, TrueColor: RFBTrue, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 0, GreenShift: 8, BlueShift: 16}},
+		{"16bpp 565 LE", PixelFormat{BPP: 16, Depth: 16, TrueColor: RFBTrue, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0}},
+		{"32bpp BGRA BE", PixelFormat{BPP: 32, Depth: 24, BigEndian: RFBTrue, TrueColor: RFBTrue, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}},
+		{"16bpp", PixelFormat16bit},
+		{"32bpp", PixelFormat32bit},
+	} {
+		pf := tt.pf
+		bpp := int(pf.BPP / 8)
+		n := len(data) / bpp
+
+		got := make([]Color, n)
+		if err := pf.decodePixels(&ColorMap{}, data[:n*bpp], got); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		want := make([]Color, n)
+		if err := decodePixelsGeneric(&pf, &ColorMap{}, data[:n*bpp], want); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		for i := range want {
+			if got[i].R != want[i].R || got[i].G != want[i].G || got[i].B != want[i].B {
+				t.Errorf("%s: incorrect color [%d]; got = %v, want = %v", tt.desc, i, got[i], want[i])
+				break
+			}
+		}
+	}
+
+	// Invalid data length.
+	pf := PixelFormat16bit
+	if err := pf.decodePixels(&ColorMap{}, []byte{1, 2, 3}, make([]Color, 2)); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func BenchmarkPixelFormat_decodePixels(b *testing.B) {
+	for _, bm := range []struct {
+		desc string
+		pf   PixelFormat
+	}{
+		{"BGRA32LE", PixelFormat{BPP: 32, Depth: 24, TrueColor: RFBTrue, RedMax: 255, GreenMax: 255, BlueMax: 255, RedShift: 16, GreenShift: 8, BlueShift: 0}},
+		{"RGB565LE", PixelFormat{BPP: 16, Depth: 16, TrueColor: RFBTrue, RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0}},
+		{"Generic32", PixelFormat32bit},
+	} {
+		pf := bm.pf
+		b.Run(bm.desc, func(b *testing.B) {
+			cm := &ColorMap{}
+			colors := make([]Color, 64*64)
+			data := make([]byte, len(colors)*int(pf.BPP/8))
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := pf.decodePixels(cm, data, colors); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Transforms of the coordinates of pointer events.
+
+package vnc
+
+import (
+	"image"
+	"math"
+
+	"github.com/kward/go-vnc/buttons"
+)
+
+// PointerTransform maps the coordinates of a local view of the remote
+// framebuffer, e.g. scaled or letterboxed, onto the framebuffer:
+//
+//	remote = local*Scale + Offset
+//
+// for each of x and y. A zero scale is taken as 1, so the zero value is the
+// identity. The coordinates are rounded, and clamped to the framebuffer.
+type PointerTransform struct {
+	ScaleX, ScaleY   float64
+	OffsetX, OffsetY float64
+}
+
+// ScaleTransform returns the transform mapping the rectangle of a local view
+// onto the rectangle of the remote framebuffer it shows.
+func ScaleTransform(view, remote image.Rectangle) PointerTransform {
+	t := PointerTransform{ScaleX: 1, ScaleY: 1}
+	if view.Dx() > 0 {
+		t.ScaleX = float64(remote.Dx()) / float64(view.Dx())
+	}
+	if view.Dy() > 0 {
+		t.ScaleY = float64(remote.Dy()) / float64(view.Dy())
+	}
+	t.OffsetX = float64(remote.Min.X) - float64(view.Min.X)*t.ScaleX
+	t.OffsetY = float64(remote.Min.Y) - float64(view.Min.Y)*t.ScaleY
+	return t
+}
+
+// Apply returns the coordinates of the framebuffer of width x height of the
+// local coordinates (x, y). A zero size clamps to the range of the protocol.
+func (t PointerTransform) Apply(x, y uint16, width, height uint16) (uint16, uint16) {
+	return transformCoord(x, t.ScaleX, t.OffsetX, width), transformCoord(y, t.ScaleY, t.OffsetY, height)
+}
+
+// transformCoord transforms the coordinate v, clamped to [0, size).
+func transformCoord(v uint16, scale, offset float64, size uint16) uint16 {
+	if scale == 0 {
+		scale = 1
+	}
+	max := float64(math.MaxUint16)
+	if size > 0 {
+		max = float64(size - 1)
+	}
+	return uint16(math.Max(0, math.Min(max, math.Round(float64(v)*scale+offset))))
+}
+
+// SetPointerTransform sets the transform of the coordinates of the pointer
+// events sent with PointerEvent, Click, and the helpers built on them, e.g.
+// to automate a letterboxed Screen (see Screen.PointerTransform), and the
+// events of an InputQueue. Events sent at the positions of monitors, and
+// macros replayed, are in the coordinates of the framebuffer, and aren't
+// transformed. Macros record the transformed coordinates.
+func (c *ClientConn) SetPointerTransform(t PointerTransform) {
+	c.pointerTransform.Store(&t)
+}
+
+// PointerTransform returns the transform of the coordinates of pointer
+// events. See SetPointerTransform.
+func (c *ClientConn) PointerTransform() PointerTransform {
+	if t := c.pointerTransform.Load(); t != nil {
+		return *t
+	}
+	return PointerTransform{}
+}
+
+// transformPointer returns the coordinates of the framebuffer of the local
+// coordinates (x, y).
+func (c *ClientConn) transformPointer(x, y uint16) (uint16, uint16) {
+	t := c.pointerTransform.Load()
+	if t == nil {
+		return x, y
+	}
+	return t.Apply(x, y, c.FramebufferWidth(), c.FramebufferHeight())
+}
+
+// click sends a click of the button at the position (x, y) of the
+// framebuffer.
+func (c *ClientConn) click(button buttons.Button, x, y uint16) error {
+	if err := c.pointerEvent(button, x, y); err != nil {
+		return err
+	}
+	return c.pointerEvent(buttons.None, x, y)
+}
+
+// PointerTransform returns the transform of the coordinates of the screen
+// onto the remote framebuffer, for ClientConn.SetPointerTransform. It is the
+// identity unless the screen is letterboxed (see ResizeLetterbox).
+func (s *Screen) PointerTransform() PointerTransform {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return PointerTransform{OffsetX: float64(-s.origin.X), OffsetY: float64(-s.origin.Y)}
+}
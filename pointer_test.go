@@ -0,0 +1,98 @@
+package vnc
+
+import (
+	"image"
+	"testing"
+
+	"github.com/kward/go-vnc/buttons"
+)
+
+func TestPointerTransform_Apply(t *testing.T) {
+	for _, tt := range []struct {
+		desc          string
+		t             PointerTransform
+		x, y          uint16
+		width, height uint16
+		wantX, wantY  uint16
+	}{
+		{"identity", PointerTransform{}, 10, 20, 100, 100, 10, 20},
+		{"scale", PointerTransform{ScaleX: 2, ScaleY: 0.5}, 10, 21, 100, 100, 20, 11},
+		{"offset", PointerTransform{OffsetX: -5, OffsetY: 5}, 10, 20, 100, 100, 5, 25},
+		{"clamped", PointerTransform{OffsetX: -50, OffsetY: 50}, 10, 60, 100, 100, 0, 99},
+		{"unsized", PointerTransform{ScaleX: 1000}, 100, 0, 0, 0, 65535, 0},
+	} {
+		x, y := tt.t.Apply(tt.x, tt.y, tt.width, tt.height)
+		if x != tt.wantX || y != tt.wantY {
+			t.Errorf("%s: Apply(%d, %d) = (%d, %d), want (%d, %d)", tt.desc, tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+		}
+	}
+}
+
+func TestScaleTransform(t *testing.T) {
+	// A 640x360 view of a 1920x1080 framebuffer, drawn at (10, 10).
+	tr := ScaleTransform(image.Rect(10, 10, 650, 370), image.Rect(0, 0, 1920, 1080))
+	for _, tt := range []struct {
+		x, y         uint16
+		wantX, wantY uint16
+	}{
+		{10, 10, 0, 0},
+		{330, 190, 960, 540},
+		{650, 370, 1919, 1079},
+	} {
+		if x, y := tr.Apply(tt.x, tt.y, 1920, 1080); x != tt.wantX || y != tt.wantY {
+			t.Errorf("Apply(%d, %d) = (%d, %d), want (%d, %d)", tt.x, tt.y, x, y, tt.wantX, tt.wantY)
+		}
+	}
+}
+
+func TestClientConn_SetPointerTransform(t *testing.T) {
+	SetSettle(0) // Disable UI settling for tests.
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{PointerTransform: &PointerTransform{ScaleX: 2, ScaleY: 2}})
+	conn.fbWidth, conn.fbHeight = 100, 100
+
+	recorder := conn.RecordMacro()
+	if err := conn.Click(buttons.Left, 10, 20); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.SetPointerTransform(PointerTransform{OffsetX: -1})
+	if got, want := conn.PointerTransform(), (PointerTransform{OffsetX: -1}); got != want {
+		t.Errorf("PointerTransform() = %+v, want %+v", got, want)
+	}
+	if err := conn.PointerEvent(buttons.None, 10, 20); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Monitors are in the coordinates of the framebuffer.
+	if err := conn.PointerEventOn(Monitor{Width: 100, Height: 100}, buttons.None, 10, 20); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i, want := range [][2]uint16{{20, 40}, {20, 40}, {9, 20}, {10, 20}} {
+		var msg PointerEventMessage
+		if err := conn.receive(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if got := [2]uint16{msg.X, msg.Y}; got != want {
+			t.Errorf("%d: position = %v, want %v", i, got, want)
+		}
+	}
+
+	// Macros record the coordinates of the framebuffer.
+	if got, want := recorder.Stop().Events[0], [2]uint16{20, 40}; [2]uint16{got.X, got.Y} != want {
+		t.Errorf("recorded position = %v, want %v", [2]uint16{got.X, got.Y}, want)
+	}
+}
+
+func TestScreen_PointerTransform(t *testing.T) {
+	s, _ := newTestScreen()
+	if got, want := s.PointerTransform(), (PointerTransform{}); got != want {
+		t.Errorf("PointerTransform() = %+v, want the identity", got)
+	}
+	s.SetResizePolicy(ResizeLetterbox)
+	if err := s.Handle(resizeUpdate(2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	// The 2x1 framebuffer is at (1, 1) of the 4x3 canvas.
+	if x, y := s.PointerTransform().Apply(2, 1, 2, 1); x != 1 || y != 0 {
+		t.Errorf("Apply(2, 1) = (%d, %d), want (1, 0)", x, y)
+	}
+}
@@ -0,0 +1,165 @@
+// Caching of decoded rectangles by the hash of their content.
+
+package vnc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// RectHash is the hash of the content of a rectangle: its encoding, size,
+// and payload. Identical rectangles, e.g. of a blinking cursor or a spinner,
+// have the same hash wherever they are, so recorders can use it to store
+// repeated rectangles once.
+type RectHash [sha256.Size]byte
+
+// hashRect returns the hash of a rectangle with the encoding, size, and
+// payload, decoded with the pixel format.
+func hashRect(enc encodings.Encoding, width, height uint16, pf PixelFormat, payload []byte) RectHash {
+	h := sha256.New()
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(enc))
+	binary.BigEndian.PutUint16(hdr[4:], width)
+	binary.BigEndian.PutUint16(hdr[6:], height)
+	h.Write(hdr[:])
+	if b, err := pf.Marshal(); err == nil {
+		h.Write(b)
+	}
+	h.Write(payload)
+	var sum RectHash
+	h.Sum(sum[:0])
+	return sum
+}
+
+// HashRect returns the hash of the content of the rectangle, which must hold
+// its encoding, as read with the pixel format pf.
+func HashRect(r *Rectangle, pf PixelFormat) (RectHash, error) {
+	if r.Enc == nil {
+		return RectHash{}, Errorf("rectangle %v has no encoding", r)
+	}
+	payload, err := r.Enc.Marshal()
+	if err != nil {
+		return RectHash{}, err
+	}
+	return hashRect(r.Enc.Type(), r.Width, r.Height, pf, payload), nil
+}
+
+// RectCache caches decoded rectangles by the hash of their content, so that
+// identical rectangles received again skip decoding, similar in spirit to the
+// cache encoding of UltraVNC. Only the pixel encodings which implement the
+// PayloadReader interface are cached. The least recently used rectangles are
+// evicted once the cache is full.
+//
+// The decoded encodings are shared by the rectangles with the same content,
+// so they must not be modified. The memory of the rectangles cached is
+// charged to the MemoryBudget of the connection which decoded them, until they
+// are evicted, and rectangles aren't cached over the budget.
+type RectCache struct {
+	max int
+
+	mu           sync.Mutex
+	entries      map[RectHash]*list.Element
+	lru          *list.List // Of *rectCacheEntry, most recently used first.
+	hits, misses uint64
+}
+
+type rectCacheEntry struct {
+	hash   RectHash
+	enc    Encoding
+	size   int64         // The memory of enc, reserved from budget.
+	budget *MemoryBudget // The budget charged, if any.
+}
+
+// NewRectCache returns a cache of at most n rectangles.
+func NewRectCache(n int) *RectCache {
+	return &RectCache{
+		max:     n,
+		entries: map[RectHash]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Len returns the number of rectangles cached.
+func (rc *RectCache) Len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.lru.Len()
+}
+
+// Stats returns the number of rectangles found, and not found, in the cache.
+func (rc *RectCache) Stats() (hits, misses uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.hits, rc.misses
+}
+
+func (rc *RectCache) get(h RectHash) (Encoding, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	el, ok := rc.entries[h]
+	if !ok {
+		rc.misses++
+		return nil, false
+	}
+	rc.hits++
+	rc.lru.MoveToFront(el)
+	return el.Value.(*rectCacheEntry).enc, true
+}
+
+// put caches enc, charging its memory to budget, unless that would exceed
+// the budget.
+func (rc *RectCache) put(h RectHash, enc Encoding, budget *MemoryBudget) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.max <= 0 {
+		return
+	}
+	if el, ok := rc.entries[h]; ok {
+		rc.lru.MoveToFront(el)
+		return
+	}
+	size := colorSize * int64(len(rawColors(enc)))
+	if !budget.reserve(size, false) {
+		return
+	}
+	rc.entries[h] = rc.lru.PushFront(&rectCacheEntry{h, enc, size, budget})
+	for rc.lru.Len() > rc.max {
+		el := rc.lru.Back()
+		rc.lru.Remove(el)
+		e := el.Value.(*rectCacheEntry)
+		delete(rc.entries, e.hash)
+		e.budget.release(e.size)
+	}
+}
+
+// readCached reads the payload of the rectangle with encoding enc, and
+// returns its decoding from the cache, or else decodes and caches it.
+func readCached(c *ClientConn, rect *Rectangle, enc Encoding, pr PayloadReader) (Encoding, error) {
+	data, err := pr.ReadPayload(c, rect)
+	if err != nil {
+		return nil, err
+	}
+	h := hashRect(enc.Type(), rect.Width, rect.Height, *c.readPixelFormat(), data)
+	rc := c.config.RectCache
+	if dec, ok := rc.get(h); ok {
+		return dec, nil
+	}
+
+	if raw, ok := enc.(*RawEncoding); ok && raw.Colors != nil {
+		enc = &RawEncoding{} // Don't cache storage which is reused.
+	}
+	var dec Encoding
+	if err := c.withData(data, func(c *ClientConn) error {
+		var err error
+		dec, err = enc.Read(c, rect)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	rc.put(h, dec, c.config.MemoryBudget)
+	return dec, nil
+}
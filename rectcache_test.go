@@ -0,0 +1,64 @@
+package vnc
+
+import (
+	"testing"
+)
+
+func TestRectCache(t *testing.T) {
+	rc := NewRectCache(2)
+	conn := NewClientConn(&MockConn{}, &ClientConfig{RectCache: rc})
+	conn.encodings = Encodings{&RawEncoding{Colors: make([]Color, 16)}}
+	conn.pixelFormat = PixelFormat16bit
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	// Three 1x1 raw rectangles, the first two identical but at different
+	// positions.
+	data := []byte{0, 0, 0, 3,
+		0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0xf8, 0x00,
+		0, 5, 0, 5, 0, 1, 0, 1, 0, 0, 0, 0, 0xf8, 0x00,
+		0, 1, 0, 1, 0, 1, 0, 1, 0, 0, 0, 0, 0x07, 0xe0,
+	}
+	msg, err := conn.UnmarshalServerMessage(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rects := msg.(*FramebufferUpdate).Rects
+	if rects[0].Enc != rects[1].Enc {
+		t.Error("identical rectangles weren't decoded once")
+	}
+	if rects[0].Enc == rects[2].Enc {
+		t.Error("different rectangles share their decoding")
+	}
+	if rects[0].Enc == conn.encodings[0] {
+		t.Error("the reused storage of the RawEncoding was cached")
+	}
+	if hits, misses := rc.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("Stats() = %d, %d; want 1, 2", hits, misses)
+	}
+	if got, want := rc.Len(), 2; got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+
+	// A third distinct rectangle evicts the least recently used.
+	rc.put(RectHash{1}, &RawEncoding{}, nil)
+	if got, want := rc.Len(), 2; got != want {
+		t.Errorf("Len() = %d, want %d", got, want)
+	}
+	if _, ok := rc.get(RectHash{1}); !ok {
+		t.Error("the rectangle added last was evicted")
+	}
+
+	// The hash of the content ignores the position.
+	h0, err := HashRect(&rects[0], conn.pixelFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, _ := HashRect(&rects[1], conn.pixelFormat)
+	h2, _ := HashRect(&rects[2], conn.pixelFormat)
+	if h0 != h1 || h0 == h2 {
+		t.Errorf("HashRect() = %x, %x, %x; want the first two equal", h0[:4], h1[:4], h2[:4])
+	}
+	if _, err := HashRect(&Rectangle{}, conn.pixelFormat); err == nil {
+		t.Error("HashRect(): expected error for rectangle without encoding")
+	}
+}
@@ -0,0 +1,87 @@
+// Watching regions of interest of the screen.
+
+package vnc
+
+import (
+	"image"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+	"golang.org/x/net/context"
+)
+
+// RegionFunc is called by WatchRegions with the index of a region of
+// interest which changed, and a copy of it, with bounds starting at (0, 0).
+type RegionFunc func(i int, img *image.RGBA) error
+
+// WatchRegions watches the regions of interest of the screen, calling fn
+// for each region which changes, until the context is done or fn returns an
+// error. Framebuffer update requests are only sent for the regions, so a
+// server sends nothing of the rest of the screen, e.g. when only a status bar
+// matters. The regions are requested whole first, and fn called with each.
+// Then incremental updates of each are requested at most once per interval.
+// The regions are clipped to the screen.
+func (s *Screen) WatchRegions(ctx context.Context, interval time.Duration, regions []image.Rectangle, fn RegionFunc) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	if len(regions) == 0 {
+		return Errorf("no regions of interest")
+	}
+	if interval <= 0 {
+		return Errorf("invalid interval %v", interval)
+	}
+	bounds := s.Bounds()
+	rs := make([]image.Rectangle, len(regions))
+	for i, r := range regions {
+		if rs[i] = r.Intersect(bounds); rs[i].Empty() {
+			return Errorf("region %d %v is outside the screen", i, r)
+		}
+	}
+
+	before := make([][]Color, len(rs))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for incremental := false; ; incremental = true {
+		if incremental {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		for i, r := range rs {
+			before[i] = s.region(r)
+		}
+		if err := s.requestRegions(ctx, incremental, rs); err != nil {
+			return err
+		}
+		for i, r := range rs {
+			if incremental && equalColors(s.region(r), before[i]) {
+				continue
+			}
+			if err := fn(i, s.subImage(r)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// requestRegions requests updates of the regions, and waits for the next
+// update. A server may answer the requests with one update, or several.
+// Updates answering the requests after the first are caught by the next
+// round of requests.
+func (s *Screen) requestRegions(ctx context.Context, incremental bool, regions []image.Rectangle) error {
+	var updated <-chan struct{}
+	for i, r := range regions {
+		ch, err := s.request(incremental, r)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			updated = ch
+		}
+	}
+	return s.wait(ctx, updated)
+}
@@ -0,0 +1,74 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestScreen_WatchRegions(t *testing.T) {
+	s, sc := newTestScreen(
+		rawUpdate(0, 0, Color{}),          // Initial, region 0.
+		rawUpdate(3, 2, Color{}),          // Initial, region 1.
+		rawUpdate(0, 0, Color{}),          // Unchanged, region 0.
+		rawUpdate(3, 2, Color{B: 0xffff}), // Changed, region 1.
+	)
+	sc.inline = true // Each round of requests is answered by two updates.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	regions := []image.Rectangle{image.Rect(0, 0, 2, 1), image.Rect(3, 2, 10, 10)}
+	var got []int
+	errStop := errors.New("stop")
+	err := s.WatchRegions(ctx, time.Millisecond, regions, func(i int, img *image.RGBA) error {
+		got = append(got, i)
+		if len(got) == 3 {
+			if b := img.Bounds(); b != image.Rect(0, 0, 1, 1) {
+				t.Errorf("bounds = %v, want the clipped region", b)
+			}
+			if c := img.RGBAAt(0, 0); c != (color.RGBA{0, 0, 0xff, 0xff}) {
+				t.Errorf("pixel = %v, want blue", c)
+			}
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("error = %v, want %v", err, errStop)
+	}
+	if want := []int{0, 1, 1}; len(got) != len(want) || got[2] != want[2] {
+		t.Errorf("regions changed = %v, want %v", got, want)
+	}
+
+	// Only the regions were requested.
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for i, req := range sc.requests {
+		r := image.Rect(int(req.X), int(req.Y), int(req.X+req.Width), int(req.Y+req.Height))
+		if want := regions[i%2].Intersect(s.Bounds()); r != want {
+			t.Errorf("request %d region = %v, want %v", i, r, want)
+		}
+	}
+}
+
+func TestScreen_WatchRegions_Errors(t *testing.T) {
+	s, _ := newTestScreen()
+	ctx := context.Background()
+	for _, tt := range []struct {
+		desc     string
+		interval time.Duration
+		regions  []image.Rectangle
+	}{
+		{"no regions", time.Second, nil},
+		{"zero interval", 0, []image.Rectangle{image.Rect(0, 0, 1, 1)}},
+		{"outside", time.Second, []image.Rectangle{image.Rect(10, 10, 20, 20)}},
+	} {
+		if err := s.WatchRegions(ctx, tt.interval, tt.regions, nil); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+}
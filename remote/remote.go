@@ -0,0 +1,162 @@
+/*
+Package remote exposes the VNC sessions hosted by a Go daemon to other
+services, so services written in other languages can drive them: take
+screenshots, type, click, and wait for the screen to change.
+
+A Host holds the sessions, by ID, and implements the operations, independently
+of the transport, for daemons to serve with the RPC framework of their choice:
+
+	h := remote.NewHost()
+	h.Add("build-42", conn, screen)
+	png, err := h.Screenshot(ctx, "build-42", image.Rectangle{})
+*/
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"sort"
+	"sync"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/buttons"
+	"golang.org/x/net/context"
+)
+
+// ErrNoSession is returned for the IDs of sessions which aren't hosted.
+var ErrNoSession = errors.New("no such session")
+
+// ErrOutOfBounds is returned for regions outside of the screen.
+var ErrOutOfBounds = errors.New("region out of the screen bounds")
+
+// session is a hosted session.
+type session struct {
+	c      *vnc.ClientConn
+	screen *vnc.Screen
+}
+
+// Host holds the sessions exposed to other services.
+type Host struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewHost returns a Host without sessions.
+func NewHost() *Host {
+	return &Host{sessions: map[string]*session{}}
+}
+
+// Add hosts the session of the connection c, with the ID id, replacing any
+// other of that ID. The screen must be kept up to date with the server
+// messages of the connection (see Screen.Listen).
+func (h *Host) Add(id string, c *vnc.ClientConn, screen *vnc.Screen) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sessions[id] = &session{c: c, screen: screen}
+}
+
+// Remove stops hosting the session with the ID id. The connection isn't
+// closed.
+func (h *Host) Remove(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.sessions, id)
+}
+
+// IDs returns the IDs of the sessions hosted, sorted.
+func (h *Host) IDs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := make([]string, 0, len(h.sessions))
+	for id := range h.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (h *Host) session(id string) (*session, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("session %q: %w", id, ErrNoSession)
+	}
+	return s, nil
+}
+
+// Screenshot returns the screen of the session as a PNG image. If region
+// isn't empty, only that region of the screen, clipped to its bounds, is
+// returned.
+func (h *Host) Screenshot(ctx context.Context, id string, region image.Rectangle) ([]byte, error) {
+	s, err := h.session(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	rgba := s.screen.Image()
+	var img image.Image = rgba
+	if !region.Empty() {
+		clipped := region.Intersect(rgba.Bounds())
+		if clipped.Empty() {
+			return nil, fmt.Errorf("region %v: %w", region, ErrOutOfBounds)
+		}
+		img = rgba.SubImage(clipped)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Type types the text in the session. See ClientConn.Type. The typing stops
+// at the first character after ctx is done.
+func (h *Host) Type(ctx context.Context, id, text string) error {
+	s, err := h.session(id)
+	if err != nil {
+		return err
+	}
+	for _, r := range text {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.c.Type(string(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Click clicks the button at (x, y) in the session, unless ctx is done.
+func (h *Host) Click(ctx context.Context, id string, button buttons.Button, x, y uint16) error {
+	s, err := h.session(id)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.c.Click(button, x, y)
+}
+
+// WaitForChange waits for the region of the screen of the session to change,
+// or, if region is empty, any of the screen. See Screen.WaitForChange.
+func (h *Host) WaitForChange(ctx context.Context, id string, region image.Rectangle) error {
+	s, err := h.session(id)
+	if err != nil {
+		return err
+	}
+	if region.Empty() {
+		region = s.screen.Bounds()
+	}
+	return s.screen.WaitForChange(ctx, region)
+}
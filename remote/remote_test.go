@@ -0,0 +1,138 @@
+package remote
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/server"
+	"golang.org/x/net/context"
+)
+
+// newTestHost returns a host of a session "test" with a server showing img.
+func newTestHost(t *testing.T, img *image.RGBA) (*Host, *server.ImageSource) {
+	src := server.NewImageSource(img)
+	sc, cc := net.Pipe()
+	go (&server.Server{Source: src}).ServeConn(sc)
+
+	cfg := vnc.NewClientConfig("")
+	cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	cfg.ServerMessageCh = make(chan vnc.ServerMessage, 16)
+	c, err := vnc.Connect(context.Background(), cc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go c.ListenAndHandle()
+	screen := vnc.NewScreen(c)
+	go screen.Listen(context.Background(), cfg.ServerMessageCh)
+
+	h := NewHost()
+	h.Add("test", c, screen)
+	return h, src
+}
+
+func TestHost(t *testing.T) {
+	vnc.SetSettle(0) // Disable UI settling for tests.
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	h, src := newTestHost(t, img)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if got, want := h.IDs(), []string{"test"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("IDs() = %v, want %v", got, want)
+	}
+	if err := h.Type(ctx, "test", "hi"); err != nil {
+		t.Errorf("Type() unexpected error: %s", err)
+	}
+	if err := h.Click(ctx, "test", buttons.Left, 1, 1); err != nil {
+		t.Errorf("Click() unexpected error: %s", err)
+	}
+
+	// The screen changes while waited for.
+	go func() {
+		for ctx.Err() == nil {
+			img.Set(2, 1, color.RGBA{0xff, 0, 0, 0xff})
+			src.Damage(image.Rect(2, 1, 3, 2))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	for {
+		if err := h.WaitForChange(ctx, "test", image.Rectangle{}); err != nil {
+			t.Fatalf("WaitForChange() unexpected error: %s", err)
+		}
+		b, err := h.Screenshot(ctx, "test", image.Rect(2, 1, 4, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		shot, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := shot.Bounds().Size(), image.Pt(2, 2); got != want {
+			t.Fatalf("screenshot size = %v, want %v", got, want)
+		}
+		if r, _, _, _ := shot.At(0, 0).RGBA(); r == 0xffff {
+			break
+		}
+	}
+
+	h.Remove("test")
+	if _, err := h.Screenshot(ctx, "test", image.Rectangle{}); !errors.Is(err, ErrNoSession) {
+		t.Errorf("Screenshot() error = %v, want %v", err, ErrNoSession)
+	}
+}
+
+func TestHost_Screenshot_Region(t *testing.T) {
+	h, _ := newTestHost(t, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	for _, tt := range []struct {
+		desc   string
+		region image.Rectangle
+		size   image.Point
+		err    error
+	}{
+		{"whole screen", image.Rectangle{}, image.Pt(4, 3), nil},
+		{"inside", image.Rect(1, 1, 3, 2), image.Pt(2, 1), nil},
+		{"overlapping", image.Rect(2, 1, 10, 10), image.Pt(2, 2), nil},
+		{"outside", image.Rect(5, 5, 6, 6), image.Point{}, ErrOutOfBounds},
+	} {
+		b, err := h.Screenshot(context.Background(), "test", tt.region)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: Screenshot() error = %v, want %v", tt.desc, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		shot, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := shot.Bounds().Size(); got != tt.size {
+			t.Errorf("%s: screenshot size = %v, want %v", tt.desc, got, tt.size)
+		}
+	}
+}
+
+func TestHost_Canceled(t *testing.T) {
+	h, _ := newTestHost(t, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := h.Screenshot(ctx, "test", image.Rectangle{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Screenshot() error = %v, want %v", err, context.Canceled)
+	}
+	if err := h.Type(ctx, "test", "hi"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Type() error = %v, want %v", err, context.Canceled)
+	}
+	if err := h.Click(ctx, "test", buttons.Left, 1, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Click() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,176 @@
+// Policies following the resizes of the remote framebuffer on a Screen.
+
+package vnc
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// ResizePolicy is how a Screen follows the resizes of the remote framebuffer,
+// e.g. a GUI following the server, or a recorder keeping a fixed size.
+type ResizePolicy int
+
+const (
+	// ResizeReallocate resizes, and clears, the screen, and requests the
+	// whole framebuffer. It is the default.
+	ResizeReallocate ResizePolicy = iota
+
+	// ResizePreserve resizes the screen, keeping the contents which remain
+	// within it, at the top-left corner.
+	ResizePreserve
+
+	// ResizeLetterbox keeps the size of the screen, the canvas, when the
+	// policy is set, and centers the remote framebuffer on it. The rest of the
+	// canvas is black, and remote framebuffers larger than the canvas are
+	// cropped. Regions of the screen are of the canvas, and are translated
+	// when requested from the server.
+	ResizeLetterbox
+)
+
+var resizePolicyNames = map[ResizePolicy]string{
+	ResizeReallocate: "Reallocate",
+	ResizePreserve:   "Preserve",
+	ResizeLetterbox:  "Letterbox",
+}
+
+// String implements the fmt.Stringer interface.
+func (p ResizePolicy) String() string {
+	if s, ok := resizePolicyNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("ResizePolicy(%d)", int(p))
+}
+
+// SetResizePolicy sets how the screen follows the resizes of the remote
+// framebuffer. Screens already letterboxed stay so until the next resize.
+func (s *Screen) SetResizePolicy(p ResizePolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+// ResizePolicy returns how the screen follows the resizes of the remote
+// framebuffer.
+func (s *Screen) ResizePolicy() ResizePolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy
+}
+
+// placed returns whether the remote framebuffer isn't the screen as is, but
+// placed on a canvas.
+func (s *Screen) placed() bool {
+	return s.origin != (image.Point{}) || s.remote != image.Pt(s.fb.Width, s.fb.Height)
+}
+
+// place returns rect, of the remote framebuffer, placed on the screen:
+// translated by the origin of the remote framebuffer, and cropped to the
+// screen. It returns nil if none of rect is on the screen. The resizes of
+// letterboxed screens are of the whole canvas.
+func (s *Screen) place(rect *Rectangle) (*Rectangle, error) {
+	if rect.IsResize() {
+		if s.policy != ResizeLetterbox {
+			return rect, nil
+		}
+		p := *rect
+		p.X, p.Y, p.Width, p.Height = 0, 0, uint16(s.fb.Width), uint16(s.fb.Height)
+		return &p, nil
+	}
+	if !s.placed() || rect.Area() == 0 || rect.Enc == nil || rect.Enc.Type() < 0 {
+		return rect, nil
+	}
+	if err := rect.validateBounds(uint16(s.remote.X), uint16(s.remote.Y)); err != nil {
+		return nil, err
+	}
+
+	screen := image.Rect(0, 0, s.fb.Width, s.fb.Height)
+	dr := image.Rect(int(rect.X), int(rect.Y), int(rect.X)+int(rect.Width), int(rect.Y)+int(rect.Height)).Add(s.origin)
+	switch enc := rect.Enc.(type) {
+	case *RawEncoding, *ZstdEncoding:
+		r := dr.Intersect(screen)
+		if r.Empty() {
+			return nil, nil
+		}
+		all := rawColors(enc)
+		if len(all) != rect.Area() {
+			return nil, Errorf("raw rectangle has %d pixels; expected %d", len(all), rect.Area())
+		}
+		colors := all
+		if r != dr {
+			colors = make([]Color, 0, r.Dx()*r.Dy())
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				row := (y - dr.Min.Y) * dr.Dx()
+				colors = append(colors, all[row+r.Min.X-dr.Min.X:row+r.Max.X-dr.Min.X]...)
+			}
+		}
+		return screenRect(r, &RawEncoding{Colors: colors}), nil
+	case *CopyRectEncoding:
+		// Only the pixels whose source and destination are both on the screen
+		// are copied.
+		d := image.Pt(int(enc.SX), int(enc.SY)).Add(s.origin).Sub(dr.Min)
+		r := dr.Intersect(screen).Intersect(screen.Sub(d))
+		if r.Empty() {
+			return nil, nil
+		}
+		src := r.Min.Add(d)
+		return screenRect(r, &CopyRectEncoding{SX: uint16(src.X), SY: uint16(src.Y)}), nil
+	}
+	return rect, nil // Unsupported by the framebuffer.
+}
+
+// screenRect returns the rectangle of the screen r, of encoding enc.
+func screenRect(r image.Rectangle, enc Encoding) *Rectangle {
+	return &Rectangle{
+		X: uint16(r.Min.X), Y: uint16(r.Min.Y),
+		Width: uint16(r.Dx()), Height: uint16(r.Dy()),
+		Enc: enc,
+	}
+}
+
+// resize follows the resize of the remote framebuffer to width x height,
+// following the policy. It returns whether the whole framebuffer should be
+// requested.
+func (s *Screen) resize(width, height int) bool {
+	s.remote = image.Pt(width, height)
+	switch s.policy {
+	case ResizePreserve:
+		fb := NewFramebuffer(width, height)
+		w, h := width, height
+		if s.fb.Width < w {
+			w = s.fb.Width
+		}
+		if s.fb.Height < h {
+			h = s.fb.Height
+		}
+		for y := 0; y < h; y++ {
+			copy(fb.Pixels[y*width:y*width+w], s.fb.Pixels[y*s.fb.Width:])
+		}
+		*s.fb, s.origin = *fb, image.Point{}
+	case ResizeLetterbox:
+		for i := range s.fb.Pixels {
+			s.fb.Pixels[i] = Color{}
+		}
+		s.origin = image.Pt((s.fb.Width-width)/2, (s.fb.Height-height)/2)
+	default:
+		*s.fb, s.origin = *NewFramebuffer(width, height), image.Point{}
+		return true
+	}
+	return false
+}
+
+// remoteRegion returns the region of the remote framebuffer shown by the
+// region of the screen.
+func (s *Screen) remoteRegion(region image.Rectangle) image.Rectangle {
+	return region.Sub(s.origin).Intersect(image.Rectangle{Max: s.remote})
+}
+
+// refresh requests the whole remote framebuffer.
+func (s *Screen) refresh() error {
+	s.mu.Lock()
+	r := image.Rectangle{Max: s.remote}
+	s.mu.Unlock()
+	return s.c.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, uint16(r.Dx()), uint16(r.Dy()))
+}
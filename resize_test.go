@@ -0,0 +1,124 @@
+package vnc
+
+import (
+	"image"
+	"testing"
+)
+
+// resizeUpdate returns an update resizing the framebuffer to width x height.
+func resizeUpdate(width, height uint16) *FramebufferUpdate {
+	return newFramebufferUpdate([]Rectangle{{Width: width, Height: height, Enc: &DesktopSizePseudoEncoding{}}})
+}
+
+func TestScreen_ResizePolicy(t *testing.T) {
+	red := Color{R: 0xffff}
+	for _, tt := range []struct {
+		desc    string
+		policy  ResizePolicy
+		bounds  image.Rectangle
+		kept    bool // Whether the red pixel at (1, 1) is kept.
+		refresh bool // Whether the whole framebuffer is requested.
+	}{
+		{"reallocate", ResizeReallocate, image.Rect(0, 0, 2, 5), false, true},
+		{"preserve", ResizePreserve, image.Rect(0, 0, 2, 5), true, false},
+		{"letterbox", ResizeLetterbox, image.Rect(0, 0, 4, 3), false, false},
+	} {
+		s, sc := newTestScreen()
+		s.SetResizePolicy(tt.policy)
+		if got, want := s.ResizePolicy(), tt.policy; got != want {
+			t.Errorf("%s: ResizePolicy() = %v, want %v", tt.desc, got, want)
+		}
+		for _, fu := range []*FramebufferUpdate{rawUpdate(1, 1, red), resizeUpdate(2, 5)} {
+			if err := s.Handle(fu); err != nil {
+				t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+			}
+		}
+		if got, want := s.Bounds(), tt.bounds; got != want {
+			t.Errorf("%s: Bounds() = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := s.Image().RGBAAt(1, 1).R == 0xff, tt.kept; got != want {
+			t.Errorf("%s: red pixel kept = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := len(sc.requests) == 1, tt.refresh; got != want {
+			t.Errorf("%s: refreshed = %v, want %v", tt.desc, got, want)
+		}
+		if tt.refresh && len(sc.requests) == 1 {
+			req := sc.requests[0]
+			if req.Inc != 0 || req.Width != 2 || req.Height != 5 {
+				t.Errorf("%s: request = %+v, want the whole framebuffer", tt.desc, req)
+			}
+		}
+	}
+}
+
+func TestScreen_Letterbox(t *testing.T) {
+	red, green := Color{R: 0xffff}, Color{G: 0xffff}
+	s, sc := newTestScreen()
+	s.SetResizePolicy(ResizeLetterbox)
+
+	// A 2x1 framebuffer is centered on the 4x3 canvas, at (1, 1).
+	for _, fu := range []*FramebufferUpdate{
+		resizeUpdate(2, 1),
+		rawUpdate(0, 0, red),
+		newFramebufferUpdate([]Rectangle{{X: 1, Width: 1, Height: 1, Enc: &CopyRectEncoding{}}}),
+	} {
+		if err := s.Handle(fu); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	img := s.Image()
+	for _, p := range []image.Point{{1, 1}, {2, 1}} {
+		if got := img.RGBAAt(p.X, p.Y); got.R != 0xff {
+			t.Errorf("pixel %v = %v, want red", p, got)
+		}
+	}
+	if got := img.RGBAAt(0, 1); got.R != 0 {
+		t.Errorf("pixel (0, 1) = %v, want black", got)
+	}
+	if err := s.Handle(rawUpdate(2, 0, red)); err == nil {
+		t.Error("expected error for a rectangle beyond the remote framebuffer")
+	}
+
+	// Regions of the canvas are requested of the remote framebuffer.
+	if _, err := s.request(true, s.Bounds()); err != nil {
+		t.Fatal(err)
+	}
+	if got := sc.requests[len(sc.requests)-1]; got.X != 0 || got.Y != 0 || got.Width != 2 || got.Height != 1 {
+		t.Errorf("request = %+v, want the 2x1 framebuffer", got)
+	}
+
+	// A 6x3 framebuffer is cropped to the canvas, at (-1, 0).
+	fu := resizeUpdate(6, 3)
+	fu.Rects = append(fu.Rects, Rectangle{Width: 6, Height: 1, Enc: &RawEncoding{[]Color{green, red, red, red, red, green}}})
+	if err := s.Handle(fu); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img = s.Image()
+	for x := 0; x < 4; x++ {
+		if got := img.RGBAAt(x, 0); got.R != 0xff {
+			t.Errorf("pixel (%d, 0) = %v, want red", x, got)
+		}
+	}
+	// ZSTD rectangles are cropped like raw ones.
+	fu = newFramebufferUpdate([]Rectangle{{Y: 2, Width: 6, Height: 1, Enc: &ZstdEncoding{Colors: []Color{red, green, green, green, green, red}}}})
+	if err := s.Handle(fu); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img = s.Image()
+	for x := 0; x < 4; x++ {
+		if got := img.RGBAAt(x, 2); got.G != 0xff {
+			t.Errorf("pixel (%d, 2) = %v, want green", x, got)
+		}
+	}
+	// Off the canvas, the source of the copy isn't copied.
+	if err := s.Handle(newFramebufferUpdate([]Rectangle{{X: 1, Y: 1, Width: 2, Height: 1, Enc: &CopyRectEncoding{SX: 0, SY: 0}}})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img = s.Image()
+	if got := img.RGBAAt(1, 1); got.R != 0xff {
+		t.Errorf("pixel (1, 1) = %v, want red", got)
+	}
+	if got := img.RGBAAt(0, 1); got.R != 0 {
+		t.Errorf("pixel (0, 1) = %v, want black", got)
+	}
+}
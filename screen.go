@@ -0,0 +1,443 @@
+// Screen-driven automation, built on a client-side framebuffer.
+
+package vnc
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// Screen keeps a Framebuffer up to date with the FramebufferUpdate messages
+// of a connection, and provides blocking helpers which wait for the screen to
+// change, or to match a reference image. The helpers send the framebuffer
+// update requests they need, so the caller needn't.
+//
+// The Screen must be given every server message, either with Handle or by
+// Listen.
+type Screen struct {
+	c *ClientConn
+
+	mu        sync.Mutex
+	fb        *Framebuffer
+	updated   chan struct{} // Closed, and replaced, by each update.
+	applyTime time.Duration // The time spent applying the last update.
+	history   *frameHistory // The frames retained, if any. See SetHistory.
+
+	policy ResizePolicy // See SetResizePolicy.
+	origin image.Point  // The position of the remote framebuffer on the screen.
+	remote image.Point  // The size of the remote framebuffer.
+
+	subs map[*FrameSubscriber]struct{} // See SubscribeFrames.
+
+	changes changeRate // See ChangeRate.
+
+	reserved int64 // The memory of fb reserved from the budget.
+	closed   bool
+}
+
+// NewScreen returns a Screen for the connection, sized to its framebuffer.
+func NewScreen(c *ClientConn) *Screen {
+	s := &Screen{
+		c:       c,
+		fb:      NewFramebuffer(int(c.FramebufferWidth()), int(c.FramebufferHeight())),
+		updated: make(chan struct{}),
+	}
+	s.remote = image.Pt(s.fb.Width, s.fb.Height)
+	s.reserve()
+	return s
+}
+
+// Close returns the memory of the screen, i.e. its framebuffer and frame
+// history, to the MemoryBudget of the connection. Updates handled once the
+// screen is closed are ignored.
+func (s *Screen) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.history != nil {
+		s.history.drop(nil)
+		s.history = nil
+	}
+	s.c.budget.release(s.reserved)
+	s.reserved = 0
+}
+
+// reserve reserves the memory of the framebuffer from the budget, or returns
+// what it no longer uses, once it is resized. Framebuffers are never refused.
+func (s *Screen) reserve() {
+	n := colorSize * int64(len(s.fb.Pixels))
+	if d := n - s.reserved; d > 0 {
+		s.c.budget.reserve(d, true)
+	} else if d < 0 {
+		s.c.budget.release(-d)
+	}
+	s.reserved = n
+}
+
+// Handle applies a FramebufferUpdate message to the screen. All other
+// messages are ignored. Resizes are followed as set by SetResizePolicy.
+func (s *Screen) Handle(msg ServerMessage) error {
+	fu, ok := msg.(*FramebufferUpdate)
+	if !ok {
+		return nil
+	}
+	refresh, err := s.apply(fu)
+	if err != nil {
+		return err
+	}
+	if refresh {
+		return s.refresh()
+	}
+	return nil
+}
+
+// apply applies the update to the screen, returning whether the whole
+// framebuffer should be requested, as the screen was reallocated.
+func (s *Screen) apply(fu *FramebufferUpdate) (refresh bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, nil
+	}
+	start := time.Now()
+	var (
+		patches []framePatch
+		dropped bool              // Whether the history was dropped, for want of memory.
+		changed []image.Rectangle // For the subscribers to the frames.
+		area    int               // The pixels changed, for ChangeRate.
+	)
+	if s.history != nil {
+		// The deltas of the rectangles applied are kept, even on error.
+		defer func() {
+			if dropped {
+				s.history.restart(time.Now())
+				return
+			}
+			s.history.commit(patches, time.Now())
+		}()
+	}
+	for i := range fu.Rects {
+		rect, err := s.place(&fu.Rects[i])
+		if err != nil {
+			return refresh, err
+		}
+		if rect == nil {
+			continue
+		}
+		var (
+			p     framePatch
+			saved bool
+		)
+		if s.history != nil && !dropped {
+			p, saved = s.history.save(s.fb, rect)
+			if saved && !s.c.budget.reserve(p.size(), false) {
+				s.c.logger().Printf("Dropping the frame history of %d frames, over the memory budget.", len(s.history.deltas)+1)
+				s.history.drop(patches)
+				patches, saved, dropped = nil, false, true
+			}
+		}
+		if len(s.subs) > 0 {
+			if r, ok := changedRect(rect, s.fb.Width, s.fb.Height); ok {
+				changed = append(changed, r)
+			}
+		}
+		if rect.IsResize() {
+			refresh = s.resize(int(fu.Rects[i].Width), int(fu.Rects[i].Height)) || refresh
+			s.reserve()
+		} else if err := s.fb.Apply(rect); err != nil {
+			return refresh, err
+		}
+		area += s.changedArea(rect)
+		if saved {
+			patches = append(patches, p)
+		}
+	}
+	s.applyTime = time.Since(start)
+	if area > 0 {
+		s.observeChanges(area, time.Now())
+	}
+	if len(changed) > 0 {
+		s.publish(changed, time.Now())
+	}
+	close(s.updated)
+	s.updated = make(chan struct{})
+	return refresh, nil
+}
+
+// Listen handles the messages received on msgs, until the context is done or
+// the channel is closed.
+func (s *Screen) Listen(ctx context.Context, msgs <-chan ServerMessage) error {
+	for {
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			if err := s.Handle(msg); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Image returns a copy of the screen as an image.
+func (s *Screen) Image() *image.RGBA {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fb.Image()
+}
+
+// Bounds returns the bounds of the screen.
+func (s *Screen) Bounds() image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return image.Rect(0, 0, s.fb.Width, s.fb.Height)
+}
+
+// FindImage returns the position of the first exact occurrence of needle on
+// the screen. See Framebuffer.FindImage.
+func (s *Screen) FindImage(needle image.Image) (image.Point, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fb.FindImage(needle)
+}
+
+// BestMatch returns the position at which needle best matches the screen,
+// and the score of the match. See Framebuffer.BestMatch.
+func (s *Screen) BestMatch(needle image.Image) (image.Point, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fb.BestMatch(needle)
+}
+
+// Refresh requests the whole framebuffer, and waits for it to be received.
+func (s *Screen) Refresh(ctx context.Context) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	updated, err := s.request(false, s.Bounds())
+	if err != nil {
+		return err
+	}
+	return s.wait(ctx, updated)
+}
+
+// WaitForChange waits until the contents of the region of the screen differ
+// from their contents when called, requesting incremental updates of the
+// region as needed. The region is clipped to the screen.
+func (s *Screen) WaitForChange(ctx context.Context, region image.Rectangle) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	region = region.Intersect(s.Bounds())
+	if region.Empty() {
+		return Errorf("empty region %v", region)
+	}
+	before := s.region(region)
+	for {
+		updated, err := s.request(true, region)
+		if err != nil {
+			return err
+		}
+		if err := s.wait(ctx, updated); err != nil {
+			return err
+		}
+		if !equalColors(s.region(region), before) {
+			return nil
+		}
+	}
+}
+
+// WaitForMatch waits until the region of the screen matches img, with each
+// color channel differing by at most tolerance (0-255). Only the part of img
+// which fits the region is compared, with the origin of img at the top-left
+// corner of the region. The whole region is requested first, and then
+// incremental updates.
+func (s *Screen) WaitForMatch(ctx context.Context, region image.Rectangle, img image.Image, tolerance int) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	b := img.Bounds()
+	region = region.Intersect(image.Rect(region.Min.X, region.Min.Y, region.Min.X+b.Dx(), region.Min.Y+b.Dy()))
+	region = region.Intersect(s.Bounds())
+	if region.Empty() {
+		return Errorf("empty region %v", region)
+	}
+	for incremental := false; ; incremental = true {
+		updated, err := s.request(incremental, region)
+		if err != nil {
+			return err
+		}
+		if err := s.wait(ctx, updated); err != nil {
+			return err
+		}
+		if s.matches(region, img, tolerance) {
+			return nil
+		}
+	}
+}
+
+// PollFunc is called by Poll with a copy of the screen, and the bounds of the
+// pixels which changed.
+type PollFunc func(img *image.RGBA, changed image.Rectangle) error
+
+// Poll watches the screen for changes, calling fn each time it changes, until
+// the context is done or fn returns an error. The whole screen is requested
+// first, and fn called with it. Then incremental updates are requested at most
+// fps times per second; as servers answer incremental requests only once
+// something changed, an idle screen costs nothing. Updates which don't change
+// any pixels are ignored.
+func (s *Screen) Poll(ctx context.Context, fps float64, fn PollFunc) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("Screen." + logging.FnName())
+	}
+	if fps <= 0 {
+		return Errorf("invalid frame rate %v", fps)
+	}
+	if err := s.Refresh(ctx); err != nil {
+		return err
+	}
+	if err := fn(s.Image(), s.Bounds()); err != nil {
+		return err
+	}
+
+	interval := time.Duration(float64(time.Second) / fps)
+	return s.poll(ctx, fn, interval, func(bool, time.Duration) time.Duration { return interval })
+}
+
+// poll calls fn with each change of the screen, requesting incremental
+// updates, the first after the interval first. After each update, next returns
+// the interval between the requests for the update, and the next, given
+// whether the update changed the screen, and the time spent applying and
+// handling it.
+func (s *Screen) poll(ctx context.Context, fn PollFunc, first time.Duration, next func(changed bool, busy time.Duration) time.Duration) error {
+	timer := time.NewTimer(first)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		start := time.Now()
+		bounds := s.Bounds()
+		before := s.region(bounds)
+		updated, err := s.request(true, bounds)
+		if err != nil {
+			return err
+		}
+		if err := s.wait(ctx, updated); err != nil {
+			return err
+		}
+		bounds = s.Bounds()
+		changed := bounds
+		if after := s.region(bounds); len(after) == len(before) {
+			changed = changedBounds(before, after, bounds.Dx())
+		}
+		s.mu.Lock()
+		busy := s.applyTime
+		s.mu.Unlock()
+		if !changed.Empty() {
+			handled := time.Now()
+			if err := fn(s.Image(), changed); err != nil {
+				return err
+			}
+			busy += time.Since(handled)
+		}
+		timer.Reset(next(!changed.Empty(), busy) - time.Since(start))
+	}
+}
+
+// request sends a FramebufferUpdateRequest for the region, returning the
+// channel closed by the next update.
+func (s *Screen) request(incremental bool, region image.Rectangle) (<-chan struct{}, error) {
+	s.mu.Lock()
+	updated := s.updated
+	region = s.remoteRegion(region)
+	s.mu.Unlock()
+	err := s.c.FramebufferUpdateRequest(rfbflags.BoolToRFBFlag(incremental),
+		uint16(region.Min.X), uint16(region.Min.Y), uint16(region.Dx()), uint16(region.Dy()))
+	return updated, err
+}
+
+// wait waits for the channel to be closed, or the context to be done.
+func (s *Screen) wait(ctx context.Context, updated <-chan struct{}) error {
+	select {
+	case <-updated:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// subImage returns a copy of the region of the screen, clipped to the
+// screen, with bounds starting at (0, 0).
+func (s *Screen) subImage(r image.Rectangle) *image.RGBA {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r = r.Intersect(image.Rect(0, 0, s.fb.Width, s.fb.Height))
+	img := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			img.Set(x, y, s.fb.At(r.Min.X+x, r.Min.Y+y))
+		}
+	}
+	return img
+}
+
+// region returns a copy of the colors of the region, which must be within
+// the screen.
+func (s *Screen) region(r image.Rectangle) []Color {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	colors := make([]Color, 0, r.Dx()*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y && y < s.fb.Height; y++ {
+		for x := r.Min.X; x < r.Max.X && x < s.fb.Width; x++ {
+			colors = append(colors, s.fb.At(x, y))
+		}
+	}
+	return colors
+}
+
+// matches returns true if the region of the screen matches img.
+func (s *Screen) matches(r image.Rectangle, img image.Image, tolerance int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fb.matches(r, img, tolerance)
+}
+
+func equalColors(a, b []Color) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// changedBounds returns the bounds of the pixels which differ between a and b,
+// the colors of images of the given width.
+func changedBounds(a, b []Color, width int) image.Rectangle {
+	var r image.Rectangle
+	for i := range a {
+		if a[i] != b[i] {
+			x, y := i%width, i/width
+			r = r.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	return r
+}
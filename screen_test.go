@@ -0,0 +1,224 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// screenConn answers each FramebufferUpdateRequest written to it with the
+// next of its updates, applied to the screen. Other messages are buffered.
+type screenConn struct {
+	MockConn
+	screen *Screen
+	inline bool // Apply the updates before the request returns, in order.
+
+	mu       sync.Mutex
+	updates  []*FramebufferUpdate
+	requests []FramebufferUpdateRequestMessage
+}
+
+func (c *screenConn) Write(b []byte) (int, error) {
+	if len(b) == 0 || messages.ClientMessage(b[0]) != messages.FramebufferUpdateRequest {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.MockConn.Write(b)
+	}
+	var req FramebufferUpdateRequestMessage
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &req); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	if len(c.updates) > 0 {
+		fu := c.updates[0]
+		c.updates = c.updates[1:]
+		if c.inline {
+			c.screen.Handle(fu)
+		} else {
+			go c.screen.Handle(fu)
+		}
+	}
+	return len(b), nil
+}
+
+// newTestScreen returns a 4x3 screen, answering requests with the updates.
+func newTestScreen(updates ...*FramebufferUpdate) (*Screen, *screenConn) {
+	sc := &screenConn{updates: updates}
+	conn := NewClientConn(sc, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 4, 3
+	sc.screen = NewScreen(conn)
+	return sc.screen, sc
+}
+
+// rawUpdate returns an update setting the pixel at (x, y) to c.
+func rawUpdate(x, y uint16, c Color) *FramebufferUpdate {
+	return &FramebufferUpdate{
+		NumRect: 1,
+		Rects:   []Rectangle{{X: x, Y: y, Width: 1, Height: 1, Enc: &RawEncoding{[]Color{c}}}},
+	}
+}
+
+func TestScreen_WaitForChange(t *testing.T) {
+	s, sc := newTestScreen(
+		rawUpdate(0, 0, Color{}),        // Unchanged.
+		rawUpdate(3, 2, Color{R: 0xff}), // Outside the region.
+		rawUpdate(1, 1, Color{R: 0xff}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.WaitForChange(ctx, image.Rect(0, 0, 2, 2)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if got, want := len(sc.requests), 3; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+	for _, req := range sc.requests {
+		if got, want := req, (FramebufferUpdateRequestMessage{messages.FramebufferUpdateRequest, rfbflags.RFBTrue, 0, 0, 2, 2}); got != want {
+			t.Errorf("request = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestScreen_WaitForChange_Timeout(t *testing.T) {
+	s, _ := newTestScreen()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.WaitForChange(ctx, image.Rect(0, 0, 2, 2)); err != context.DeadlineExceeded {
+		t.Errorf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := s.WaitForChange(ctx, image.Rect(10, 10, 20, 20)); err == nil {
+		t.Errorf("expected error for region outside the screen")
+	}
+}
+
+func TestScreen_WaitForMatch(t *testing.T) {
+	// The reference image is larger than the region, which is clipped to it.
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(1, 0, color.RGBA{0xff, 0, 0, 0xff})
+
+	s, sc := newTestScreen(
+		rawUpdate(2, 1, Color{R: 0xf0}), // Outside the tolerance.
+		rawUpdate(2, 1, Color{R: 0xfe00}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.WaitForMatch(ctx, image.Rect(1, 1, 3, 2), img, 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if got, want := len(sc.requests), 2; got != want {
+		t.Fatalf("got %d requests, want %d", got, want)
+	}
+	for i, inc := range []rfbflags.RFBFlag{rfbflags.RFBFalse, rfbflags.RFBTrue} {
+		req := sc.requests[i]
+		if got, want := req.Inc, inc; got != want {
+			t.Errorf("request %d incremental = %v, want %v", i, got, want)
+		}
+		if got, want := image.Rect(int(req.X), int(req.Y), int(req.X+req.Width), int(req.Y+req.Height)), image.Rect(1, 1, 3, 2); got != want {
+			t.Errorf("request %d region = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestScreen_Refresh(t *testing.T) {
+	s, _ := newTestScreen(rawUpdate(1, 2, Color{G: 0xffff}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := s.Image().RGBAAt(1, 2), (color.RGBA{0, 0xff, 0, 0xff}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestScreen_ExtendedDesktopSize(t *testing.T) {
+	s, sc := newTestScreen()
+	red := Color{R: 0xffff}
+	for _, tt := range []struct {
+		desc    string
+		fu      *FramebufferUpdate
+		bounds  image.Rectangle
+		refresh bool
+	}{
+		// The status, the y-position, of a failed change of the layout.
+		{"failed", newFramebufferUpdate([]Rectangle{{X: 1, Y: 3, Width: 8, Height: 6, Enc: &ExtendedDesktopSizePseudoEncoding{}}}),
+			image.Rect(0, 0, 4, 3), false},
+		{"resized", newFramebufferUpdate([]Rectangle{{X: 1, Width: 8, Height: 6, Enc: &ExtendedDesktopSizePseudoEncoding{}}}),
+			image.Rect(0, 0, 8, 6), true},
+		{"beyond the old size", rawUpdate(7, 5, red), image.Rect(0, 0, 8, 6), false},
+	} {
+		n := len(sc.requests)
+		if err := s.Handle(tt.fu); err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if got, want := s.Bounds(), tt.bounds; got != want {
+			t.Errorf("%s: Bounds() = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := len(sc.requests) > n, tt.refresh; got != want {
+			t.Errorf("%s: refreshed = %v, want %v", tt.desc, got, want)
+		}
+	}
+	if got := s.Image().RGBAAt(7, 5); got.R != 0xff {
+		t.Errorf("pixel (7, 5) = %v, want red", got)
+	}
+
+	// The resize policies follow ExtendedDesktopSize.
+	s, _ = newTestScreen()
+	s.SetResizePolicy(ResizeLetterbox)
+	if err := s.Handle(newFramebufferUpdate([]Rectangle{{Width: 2, Height: 1, Enc: &ExtendedDesktopSizePseudoEncoding{}}})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := s.PointerTransform(), (PointerTransform{OffsetX: -1, OffsetY: -1}); got != want {
+		t.Errorf("PointerTransform() = %+v, want %+v", got, want)
+	}
+}
+
+func TestScreen_Poll(t *testing.T) {
+	s, _ := newTestScreen(
+		rawUpdate(0, 0, Color{}),          // Refresh.
+		rawUpdate(0, 0, Color{}),          // Unchanged.
+		rawUpdate(1, 1, Color{R: 0xffff}), // Changed.
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var got []image.Rectangle
+	errStop := errors.New("stop")
+	err := s.Poll(ctx, 1000, func(img *image.RGBA, changed image.Rectangle) error {
+		got = append(got, changed)
+		if len(got) == 2 {
+			if c := img.RGBAAt(1, 1); c != (color.RGBA{0xff, 0, 0, 0xff}) {
+				t.Errorf("pixel = %v, want red", c)
+			}
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("error = %v, want %v", err, errStop)
+	}
+	for i, want := range []image.Rectangle{image.Rect(0, 0, 4, 3), image.Rect(1, 1, 2, 2)} {
+		if got[i] != want {
+			t.Errorf("call %d changed = %v, want %v", i, got[i], want)
+		}
+	}
+
+	if err := s.Poll(ctx, 0, nil); err == nil {
+		t.Error("expected error for zero frame rate")
+	}
+}
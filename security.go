@@ -13,6 +13,8 @@ const (
 	secTypeInvalid = uint8(0)
 	secTypeNone    = uint8(1)
 	secTypeVNCAuth = uint8(2)
+	secTypeRA2     = uint8(5) // RealVNC proprietary.
+	secTypeRA2ne   = uint8(6) // RealVNC proprietary.
 )
 
 // ClientAuth implements a method of authenticating with a remote server.
@@ -63,7 +65,7 @@ func (auth *ClientAuthVNC) Handshake(conn *ClientConn) error {
 
 	// Read challenge block
 	var challenge vncAuthChallenge
-	if err := conn.receive(&challenge); err != nil {
+	if err := conn.readFull(challenge[:]); err != nil {
 		return err
 	}
 
@@ -0,0 +1,125 @@
+// Prioritized sending of messages to the server.
+
+package vnc
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/kward/go-vnc/messages"
+)
+
+// SendPriority is the priority of a message waiting to be sent to the
+// server, when ClientConfig.PrioritySend is set.
+type SendPriority int
+
+// The priorities of the messages sent.
+const (
+	// SendPriorityLow is the priority of ClientCutText messages, which may
+	// be large.
+	SendPriorityLow SendPriority = iota
+	// SendPriorityMedium is the priority of FramebufferUpdateRequest
+	// messages, and all messages without another priority.
+	SendPriorityMedium
+	// SendPriorityHigh is the priority of KeyEvent and PointerEvent
+	// messages, so input is never delayed behind other messages.
+	SendPriorityHigh
+)
+
+var sendPriorityNames = map[SendPriority]string{
+	SendPriorityLow:    "Low",
+	SendPriorityMedium: "Medium",
+	SendPriorityHigh:   "High",
+}
+
+func (p SendPriority) String() string {
+	if name, ok := sendPriorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("SendPriority(%d)", int(p))
+}
+
+// messagePriority returns the priority of the message b.
+func messagePriority(b []byte) SendPriority {
+	if len(b) == 0 {
+		return SendPriorityMedium
+	}
+	switch messages.ClientMessage(b[0]) {
+	case messages.KeyEvent, messages.PointerEvent:
+		return SendPriorityHigh
+	case messages.ClientCutText:
+		return SendPriorityLow
+	}
+	return SendPriorityMedium
+}
+
+// sendQueue writes messages one at a time, in the order of their priority,
+// and of their sending within a priority. A message being written isn't
+// interrupted, as messages can't be interleaved, so the messages it reorders
+// are those waiting behind it, e.g. a keystroke sent while a large cut text
+// is being written is written before the cut text sent after it.
+//
+// The messages are written by a goroutine started when the first is sent,
+// which exits once no messages are waiting.
+type sendQueue struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	waiting [SendPriorityHigh + 1][]*queuedMessage
+	writing bool // Whether the goroutine writing the messages is running.
+}
+
+// queuedMessage is a message waiting to be written.
+type queuedMessage struct {
+	b    []byte
+	done chan error // Receives the error of the write.
+}
+
+func newSendQueue(w io.Writer) *sendQueue {
+	return &sendQueue{w: w}
+}
+
+// send queues the message b with priority p, and returns the error of
+// writing it, once written.
+func (q *sendQueue) send(p SendPriority, b []byte) error {
+	m := &queuedMessage{b: b, done: make(chan error, 1)}
+	q.mu.Lock()
+	q.waiting[p] = append(q.waiting[p], m)
+	if !q.writing {
+		q.writing = true
+		go q.write()
+	}
+	q.mu.Unlock()
+	return <-m.done
+}
+
+// write writes the waiting messages, until none are left.
+func (q *sendQueue) write() {
+	for {
+		q.mu.Lock()
+		m := q.next()
+		if m == nil {
+			q.writing = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+		_, err := q.w.Write(m.b)
+		m.done <- err
+	}
+}
+
+// next removes and returns the first message of the highest priority, or nil
+// if none are waiting.
+func (q *sendQueue) next() *queuedMessage {
+	for p := len(q.waiting) - 1; p >= 0; p-- {
+		if w := q.waiting[p]; len(w) > 0 {
+			m := w[0]
+			w[0] = nil
+			q.waiting[p] = w[1:]
+			return m
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package vnc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+func TestMessagePriority(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		b    []byte
+		want SendPriority
+	}{
+		{"empty", []byte{}, SendPriorityMedium},
+		{"key event", []byte{byte(messages.KeyEvent), 1}, SendPriorityHigh},
+		{"pointer event", []byte{byte(messages.PointerEvent), 0}, SendPriorityHigh},
+		{"update request", []byte{byte(messages.FramebufferUpdateRequest), 1}, SendPriorityMedium},
+		{"set encodings", []byte{byte(messages.SetEncodings), 0}, SendPriorityMedium},
+		{"cut text", []byte{byte(messages.ClientCutText), 0}, SendPriorityLow},
+	} {
+		if got := messagePriority(tt.b); got != tt.want {
+			t.Errorf("%s: messagePriority() = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSendPriority_String(t *testing.T) {
+	for _, tt := range []struct {
+		p    SendPriority
+		want string
+	}{
+		{SendPriorityLow, "Low"},
+		{SendPriorityHigh, "High"},
+		{SendPriority(7), "SendPriority(7)"},
+	} {
+		if got := tt.p.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// blockingWriter records each write made to it, once released.
+type blockingWriter struct {
+	release chan struct{}
+
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *blockingWriter) Write(b []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, string(b))
+	return len(b), nil
+}
+
+func TestSendQueue(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	q := newSendQueue(w)
+
+	var wg sync.WaitGroup
+	send := func(p SendPriority, msg string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := q.send(p, []byte(msg)); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		}()
+	}
+	// waitFor waits until a message is being written, and n are waiting.
+	waitFor := func(n int) {
+		for start := time.Now(); ; time.Sleep(time.Millisecond) {
+			q.mu.Lock()
+			queued, writing := 0, q.writing
+			for _, w := range q.waiting {
+				queued += len(w)
+			}
+			q.mu.Unlock()
+			if writing && queued == n {
+				return
+			}
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("messages never queued; got %d, want %d", queued, n)
+			}
+		}
+	}
+
+	// The first message is written while the others wait behind it.
+	send(SendPriorityLow, "cut text 1")
+	waitFor(0)
+	send(SendPriorityLow, "cut text 2")
+	waitFor(1)
+	send(SendPriorityMedium, "update request")
+	waitFor(2)
+	send(SendPriorityHigh, "key down")
+	waitFor(3)
+	send(SendPriorityHigh, "key up")
+	waitFor(4)
+	close(w.release)
+	wg.Wait()
+
+	want := []string{"cut text 1", "key down", "key up", "update request", "cut text 2"}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.writes) != len(want) {
+		t.Fatalf("incorrect writes; got = %q, want = %q", w.writes, want)
+	}
+	for i := range want {
+		if got, want := w.writes[i], want[i]; got != want {
+			t.Errorf("incorrect write %d; got = %q, want = %q", i, got, want)
+		}
+	}
+}
+
+func TestClientConn_PrioritySend(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.sendQ = newSendQueue(mockConn)
+
+	SetSettle(0) // Disable UI settling for tests.
+	if err := conn.KeyEvent(keys.Digit0, PressKey); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := conn.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 10, 10); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var key KeyEventMessage
+	if err := conn.receive(&key); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := key.Key, keys.Digit0; got != want {
+		t.Errorf("incorrect key; got = %v, want = %v", got, want)
+	}
+	var req FramebufferUpdateRequestMessage
+	if err := conn.receive(&req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := req.Msg, messages.FramebufferUpdateRequest; got != want {
+		t.Errorf("incorrect message-type; got = %v, want = %v", got, want)
+	}
+	if got, want := mockConn.b.Len(), 0; got != want {
+		t.Errorf("unread data remaining; got = %v, want = %v", got, want)
+	}
+}
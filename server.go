@@ -5,8 +5,11 @@ https://tools.ietf.org/html/rfc6143#section-7.6
 package vnc
 
 import (
+	"encoding/binary"
 	"fmt"
 	"image"
+	"image/color"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kward/go-vnc/encodings"
@@ -54,7 +57,7 @@ func newFramebufferUpdate(rects []Rectangle) *FramebufferUpdate {
 func (m *FramebufferUpdate) Type() messages.ServerMessage { return messages.FramebufferUpdate }
 
 // Read implements the ServerMessage interface.
-func (m *FramebufferUpdate) Read(c *ClientConn) (ServerMessage, error) {
+func (m *FramebufferUpdate) Read(c *ClientConn) (_ ServerMessage, err error) {
 	if logging.V(logging.FnDeclLevel) {
 		glog.Info("FramebufferUpdate." + logging.FnName())
 	}
@@ -67,30 +70,67 @@ func (m *FramebufferUpdate) Read(c *ClientConn) (ServerMessage, error) {
 	// encs[Raw] = &RawEncoding{} // Raw encoding support required.
 
 	// Read packet.
-	var pad [1]byte
-	if err := c.receive(&pad); err != nil {
+	hdr, err := c.readHeader(3)
+	if err != nil {
 		return nil, err
 	}
-	if logging.V(logging.ResultLevel) {
-		glog.Infof("pad: %v", pad)
-	}
-
-	var numRects uint16
-	if err := c.receive(&numRects); err != nil {
-		return nil, err
+	if !isZero(hdr[0:1]) {
+		if err := c.protocolViolation("FramebufferUpdate padding is non-zero: %v", hdr[0:1]); err != nil {
+			return nil, err
+		}
 	}
+	numRects := binary.BigEndian.Uint16(hdr[1:])
 	if logging.V(logging.ResultLevel) {
 		glog.Infof("numRects: %d", numRects)
 	}
+	if max := c.config.Limits.maxRects(); numRects > max {
+		return nil, wrapErrorf(ErrLimitExceeded, "number-of-rectangles %d exceeds limit of %d", numRects, max)
+	}
+	if numRects == 0 {
+		if err := c.protocolViolation("FramebufferUpdate has no rectangles"); err != nil {
+			return nil, err
+		}
+	}
+
+	c.stats.update()
+	encFn := c.encodable()
+	start, received := time.Now(), c.metrics["bytes-received"].Value()
+	defer func() {
+		if err == nil {
+			c.answerRequest()
+			n := int(c.metrics["bytes-received"].Value() - received)
+			err = c.observeUpdate(n, time.Since(start), time.Now())
+		}
+	}()
+
+	// Stream rectangles to the handler, if one is configured.
+	if fn := c.config.RectFunc; fn != nil {
+		for i := 0; i < int(numRects); i++ {
+			rect := NewRectangle(encFn)
+			if err := c.readRect(rect); err != nil {
+				return nil, err
+			}
+			if err := fn(rect, i, int(numRects)); err != nil {
+				return nil, err
+			}
+			if rect.isLastRect() {
+				return &FramebufferUpdate{NumRect: uint16(i + 1)}, nil
+			}
+		}
+		return &FramebufferUpdate{NumRect: numRects}, nil
+	}
 
 	// Extract rectangles.
 	rects := make([]Rectangle, numRects)
-	for i := 0; i < int(numRects); i++ {
-		rect := NewRectangle(c.Encodable)
-		if err := rect.Read(c); err != nil {
+	for i := range rects {
+		rects[i].encFn = encFn
+		if err := c.readRect(&rects[i]); err != nil {
 			return nil, err
 		}
-		rects[i] = *rect
+		if rects[i].isLastRect() {
+			rects = rects[:i+1]
+			break
+		}
 	}
 
 	return newFramebufferUpdate(rects), nil
@@ -102,52 +142,65 @@ func (m *FramebufferUpdate) Marshal() ([]byte, error) {
 		glog.Info("FramebufferUpdate." + logging.FnName())
 	}
 
-	buf := NewBuffer(nil)
-	msg := struct {
-		msg      messages.ServerMessage // message-type
-		_        [1]byte                // padding
-		numRects uint16                 // number-of-rectangles
-	}{
-		msg:      messages.FramebufferUpdate,
-		numRects: m.NumRect,
-	}
-	if err := buf.Write(msg); err != nil {
-		return nil, err
-	}
+	b := make([]byte, 4, 4+len(m.Rects)*rectangleMessageLen)
+	b[0] = uint8(messages.FramebufferUpdate) // message-type
+	// b[1] is padding.
+	binary.BigEndian.PutUint16(b[2:], m.NumRect) // number-of-rectangles
 	for _, rect := range m.Rects {
 		bytes, err := rect.Marshal()
 		if err != nil {
 			return nil, err
 		}
-		buf.Write(bytes)
+		b = append(b, bytes...)
 	}
 
-	return buf.Bytes(), nil
+	return b, nil
 }
 
-// Unmarshal implements the Unmarshaler interface.
-func (m *FramebufferUpdate) Unmarshal(_ []byte) error {
+// Unmarshal implements the Unmarshaler interface. See UnmarshalBinary.
+func (m *FramebufferUpdate) Unmarshal(data []byte) error {
 	if logging.V(logging.FnDeclLevel) {
 		glog.Info("FramebufferUpdate." + logging.FnName())
 	}
-	return fmt.Errorf("Unmarshal() unimplemented")
+	return m.UnmarshalBinary(data)
 }
 
+// RectFunc describes the function called with each rectangle of a
+// FramebufferUpdate as soon as it has been read. The index of the rectangle
+// within the update, and the total number of rectangles, are given as i and
+// n. A non-nil error aborts reading of the message.
+type RectFunc func(rect *Rectangle, i, n int) error
+
 // EncodableFunc describes the function for encoding a Rectangle.
 type EncodableFunc func(enc encodings.Encoding) (Encoding, bool)
 
 // Encodable returns the Encoding that can be used to encode a Rectangle, or
-// false if the encoding isn't recognized.
+// false if the encoding isn't recognized. Encodings retired by SetEncodings
+// are recognized.
 func (c *ClientConn) Encodable(enc encodings.Encoding) (Encoding, bool) {
 	if logging.V(logging.FnDeclLevel) {
 		glog.Info("ClientConn." + logging.FnName())
 	}
-	for _, e := range c.encodings {
-		if e.Type() == enc {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	if e, ok := c.encodings.find(enc); ok {
+		return e, true
+	}
+	return c.retired.find(enc)
+}
+
+// encodable returns an EncodableFunc for the encodings currently supported,
+// which is unaffected by later calls to SetEncodings.
+func (c *ClientConn) encodable() EncodableFunc {
+	c.metaMu.RLock()
+	encs, retired := c.encodings, c.retired
+	c.metaMu.RUnlock()
+	return func(enc encodings.Encoding) (Encoding, bool) {
+		if e, ok := encs.find(enc); ok {
 			return e, true
 		}
+		return retired.find(enc)
 	}
-	return nil, false
 }
 
 // rectangleMessage holds a Rectangle wire format message.
@@ -157,6 +210,28 @@ type rectangleMessage struct {
 	E    encodings.Encoding // encoding-type
 }
 
+const rectangleMessageLen = 12
+
+// put writes the wire format of the message into b, which must be at least
+// rectangleMessageLen bytes long.
+func (m *rectangleMessage) put(b []byte) {
+	binary.BigEndian.PutUint16(b[0:], m.X)
+	binary.BigEndian.PutUint16(b[2:], m.Y)
+	binary.BigEndian.PutUint16(b[4:], m.W)
+	binary.BigEndian.PutUint16(b[6:], m.H)
+	binary.BigEndian.PutUint32(b[8:], uint32(m.E))
+}
+
+// get reads the wire format of the message from b, which must be at least
+// rectangleMessageLen bytes long.
+func (m *rectangleMessage) get(b []byte) {
+	m.X = binary.BigEndian.Uint16(b[0:])
+	m.Y = binary.BigEndian.Uint16(b[2:])
+	m.W = binary.BigEndian.Uint16(b[4:])
+	m.H = binary.BigEndian.Uint16(b[6:])
+	m.E = encodings.Encoding(int32(binary.BigEndian.Uint32(b[8:])))
+}
+
 // Rectangle represents a rectangle of pixel data.
 type Rectangle struct {
 	X, Y          uint16
@@ -180,50 +255,88 @@ func (r *Rectangle) Read(c *ClientConn) error {
 		glog.Info("Rectangle." + logging.FnName())
 	}
 
-	var msg rectangleMessage
-	if err := c.receive(&msg); err != nil {
+	hdr, err := c.readHeader(rectangleMessageLen)
+	if err != nil {
 		return err
 	}
+	var msg rectangleMessage
+	msg.get(hdr)
 	r.X, r.Y, r.Width, r.Height = msg.X, msg.Y, msg.W, msg.H
 
-	encImpl, ok := r.encFn(msg.E)
-	if !ok {
-		return fmt.Errorf("unsupported encoding type: %d", msg.E)
+	// Pseudo-encodings (which have negative values) use the position and
+	// dimensions for other purposes, so only pixel data rectangles are bound
+	// by the framebuffer.
+	if msg.E >= 0 {
+		if err := r.validateBounds(c.fbWidth, c.fbHeight); err != nil {
+			return err
+		}
 	}
 
-	enc, err := encImpl.Read(c, r)
+	enc, err := r.readEncoding(c, msg.E)
 	if err != nil {
-		return fmt.Errorf("error reading rectangle encoding: %s", err)
+		return wrapErrorf(err, "error reading rectangle encoding: %s", err)
 	}
 
 	r.Enc = enc
 	return nil
 }
 
+// readEncoding reads the payload of the rectangle, which has encoding e.
+func (r *Rectangle) readEncoding(c *ClientConn, e encodings.Encoding) (Encoding, error) {
+	encImpl, ok := r.encFn(e)
+	if !ok {
+		if !c.config.TolerateUnknownEncodings {
+			return nil, wrapErrorf(ErrUnsupportedEncoding, "unsupported encoding type: %d", e)
+		}
+		encImpl = &UnknownEncoding{Enc: e}
+		return encImpl.Read(c, r)
+	}
+
+	if e < 0 {
+		h, ok := pseudoHandlers[e]
+		if !ok {
+			return encImpl.Read(c, r)
+		}
+		dec, err := h(c, r)
+		if err != nil || !c.config.LazyPayloads {
+			return dec, err
+		}
+		data, err := dec.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		return &LazyEncoding{e, data}, nil
+	}
+	if c.config.LazyPayloads {
+		return readLazy(c, r, encImpl)
+	}
+	if pr, ok := encImpl.(PayloadReader); ok && c.config.RectCache != nil {
+		return readCached(c, r, encImpl, pr)
+	}
+	return encImpl.Read(c, r)
+}
+
+// isLastRect returns true if the rectangle marks the end of a
+// FramebufferUpdate.
+func (r *Rectangle) isLastRect() bool {
+	return r.Enc != nil && r.Enc.Type() == encodings.LastRectPseudo
+}
+
 // Marshal implements the Marshaler interface.
 func (r *Rectangle) Marshal() ([]byte, error) {
 	if logging.V(logging.FnDeclLevel) {
 		glog.Info("Rectangle." + logging.FnName())
 	}
 
-	buf := NewBuffer(nil)
-
-	var msg rectangleMessage
-	msg.X, msg.Y, msg.W, msg.H = r.X, r.Y, r.Width, r.Height
-	msg.E = r.Enc.Type()
-	if err := buf.Write(msg); err != nil {
-		return nil, err
-	}
-
+	msg := rectangleMessage{r.X, r.Y, r.Width, r.Height, r.Enc.Type()}
 	bytes, err := r.Enc.Marshal()
 	if err != nil {
 		return nil, err
 	}
-	if err := buf.Write(bytes); err != nil {
-		return nil, err
-	}
 
-	return buf.Bytes(), nil
+	b := make([]byte, rectangleMessageLen, rectangleMessageLen+len(bytes))
+	msg.put(b)
+	return append(b, bytes...), nil
 }
 
 // Unmarshal implements the Unmarshaler interface.
@@ -232,12 +345,12 @@ func (r *Rectangle) Unmarshal(data []byte) error {
 		glog.Info("Rectangle." + logging.FnName())
 	}
 
-	buf := NewBuffer(data)
+	if len(data) < rectangleMessageLen {
+		return Errorf("Rectangle message too short (%v < %v)", len(data), rectangleMessageLen)
+	}
 
 	var msg rectangleMessage
-	if err := buf.Read(&msg); err != nil {
-		return err
-	}
+	msg.get(data)
 	r.X, r.Y, r.Width, r.Height = msg.X, msg.Y, msg.W, msg.H
 
 	switch msg.E {
@@ -254,9 +367,35 @@ func (r *Rectangle) String() string {
 	return fmt.Sprintf("{ x: %d y: %d, w: %d, h: %d, enc: %v }", r.X, r.Y, r.Width, r.Height, r.Enc)
 }
 
+// validateBounds returns a ProtocolError if the Rectangle doesn't fit within a
+// framebuffer of the given width and height. Rectangles with zero area contain
+// no pixels, and always fit.
+func (r *Rectangle) validateBounds(width, height uint16) error {
+	if r.Area() == 0 {
+		return nil
+	}
+	if int(r.X)+int(r.Width) > int(width) || int(r.Y)+int(r.Height) > int(height) {
+		return protocolErrorf("rectangle %dx%d at (%d,%d) exceeds framebuffer size of %dx%d", r.Width, r.Height, r.X, r.Y, width, height)
+	}
+	return nil
+}
+
 // Area returns the total area in pixels of the Rectangle.
 func (r *Rectangle) Area() int { return int(r.Width) * int(r.Height) }
 
+// IsResize returns whether the Rectangle resizes the framebuffer to its width
+// and height: a DesktopSize rectangle, or an ExtendedDesktopSize rectangle
+// whose status, the y-position, is success.
+func (r *Rectangle) IsResize() bool {
+	switch r.Enc.(type) {
+	case *DesktopSizePseudoEncoding:
+		return true
+	case *ExtendedDesktopSizePseudoEncoding:
+		return r.Y == 0
+	}
+	return false
+}
+
 //-----------------------------------------------------------------------------
 // SetColorMapEntries is sent by the server to set values into
 // the color map. This message will automatically update the color map
@@ -285,31 +424,45 @@ func (*SetColorMapEntries) Read(c *ClientConn) (ServerMessage, error) {
 		glog.Info("SetColorMapEntries." + logging.FnName())
 	}
 
-	// Read off the padding
-	var padding [1]byte
-	if err := c.receive(&padding); err != nil {
+	hdr, err := c.readHeader(5)
+	if err != nil {
 		return nil, err
 	}
-
-	var result SetColorMapEntries
-	if err := c.receive(&result.FirstColor); err != nil {
-		return nil, err
+	if !isZero(hdr[0:1]) {
+		if err := c.protocolViolation("SetColorMapEntries padding is non-zero: %v", hdr[0:1]); err != nil {
+			return nil, err
+		}
 	}
-
-	var numColors uint16
-	if err := c.receive(&numColors); err != nil {
-		return nil, err
+	var result SetColorMapEntries
+	result.FirstColor = binary.BigEndian.Uint16(hdr[1:])
+	numColors := binary.BigEndian.Uint16(hdr[3:])
+	if max := c.config.Limits.maxColorMapEntries(); numColors > max {
+		return nil, wrapErrorf(ErrLimitExceeded, "number-of-colors %d exceeds limit of %d", numColors, max)
+	}
+	// Entries beyond the end of the color map are read, but not applied, to
+	// the color map of the current pixel format.
+	c.readPixelFormat()
+	if int(result.FirstColor)+int(numColors) > len(c.colorMap) {
+		if err := c.protocolViolation("color map entries %d-%d exceed color map size of %d", result.FirstColor, int(result.FirstColor)+int(numColors)-1, len(c.colorMap)); err != nil {
+			return nil, err
+		}
 	}
 
 	result.Colors = make([]Color, numColors)
 	for i := uint16(0); i < numColors; i++ {
-		color := &result.Colors[i]
-		if err := c.receive(&color); err != nil {
+		rgb, err := c.readHeader(6)
+		if err != nil {
 			return nil, err
 		}
+		color := &result.Colors[i]
+		color.R = binary.BigEndian.Uint16(rgb[0:])
+		color.G = binary.BigEndian.Uint16(rgb[2:])
+		color.B = binary.BigEndian.Uint16(rgb[4:])
 
 		// Update the connection's color map
-		c.colorMap[result.FirstColor+i] = *color
+		if idx := int(result.FirstColor) + int(i); idx < len(c.colorMap) {
+			c.colorMap[idx] = *color
+		}
 	}
 
 	return &result, nil
@@ -325,6 +478,24 @@ type Color struct {
 
 // Verify that interfaces are honored.
 var _ MarshalerUnmarshaler = (*Color)(nil)
+var _ color.Color = Color{}
+
+// RGBA implements the color.Color interface. True colors are scaled from the
+// maximums of their pixel format, and color map entries are already 16-bit.
+func (c Color) RGBA() (r, g, b, a uint32) {
+	if c.pf == nil || !rfbflags.IsTrueColor(c.pf.TrueColor) {
+		return uint32(c.R), uint32(c.G), uint32(c.B), 0xffff
+	}
+	return scaleColor(c.R, c.pf.RedMax), scaleColor(c.G, c.pf.GreenMax), scaleColor(c.B, c.pf.BlueMax), 0xffff
+}
+
+// scaleColor scales the color value v, with maximum max, to 16 bits.
+func scaleColor(v, max uint16) uint32 {
+	if max == 0 {
+		return 0
+	}
+	return uint32(v) * 0xffff / uint32(max)
+}
 
 // ColorMap represents a translation map of colors.
 type ColorMap [256]Color
@@ -465,21 +636,27 @@ func (*ServerCutText) Read(c *ClientConn) (ServerMessage, error) {
 		glog.Info("ServerCutText." + logging.FnName())
 	}
 
-	// Read off the padding
-	var padding [1]byte
-	if err := c.receive(&padding); err != nil {
+	hdr, err := c.readHeader(7)
+	if err != nil {
 		return nil, err
 	}
-
-	var textLength uint32
-	if err := c.receive(&textLength); err != nil {
-		return nil, err
+	if !isZero(hdr[0:3]) {
+		if err := c.protocolViolation("ServerCutText padding is non-zero: %v", hdr[0:3]); err != nil {
+			return nil, err
+		}
+	}
+	textLength := binary.BigEndian.Uint32(hdr[3:])
+	if max := c.config.Limits.maxCutTextLength(); textLength > max {
+		return nil, wrapErrorf(ErrLimitExceeded, "cut text length %d exceeds limit of %d", textLength, max)
 	}
 
 	textBytes := make([]uint8, textLength)
-	if err := c.receive(&textBytes); err != nil {
+	if err := c.readFull(textBytes); err != nil {
 		return nil, err
 	}
 
-	return &ServerCutText{string(textBytes)}, nil
+	text := latin1String(textBytes)
+	c.recordCutText(text)
+	c.publish(Event{Kind: EventClipboardReceived, Text: text})
+	return &ServerCutText{text}, nil
 }
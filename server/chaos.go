@@ -0,0 +1,159 @@
+// Fault injection, for exercising the robustness of clients.
+
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/kward/go-vnc/messages"
+)
+
+// Fault is a fault injected into the updates sent to clients.
+type Fault int
+
+// The faults injected.
+const (
+	// FaultTruncate sends an update cut short, and carries on with the next,
+	// which the client reads as the rest of it.
+	FaultTruncate Fault = iota
+	// FaultInvalidLength sends a ServerCutText claiming 4 GiB of text before
+	// the update.
+	FaultInvalidLength
+	// FaultSlowWrite sends an update a byte at a time, like a slowloris.
+	FaultSlowWrite
+	// FaultDisconnect disconnects in the middle of an update.
+	FaultDisconnect
+	// FaultBogusEncoding sends an update with a rectangle of an unknown
+	// encoding.
+	FaultBogusEncoding
+)
+
+var faultNames = map[Fault]string{
+	FaultTruncate:      "truncate",
+	FaultInvalidLength: "invalid-length",
+	FaultSlowWrite:     "slow-write",
+	FaultDisconnect:    "disconnect",
+	FaultBogusEncoding: "bogus-encoding",
+}
+
+func (f Fault) String() string {
+	if name, ok := faultNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Fault(%d)", int(f))
+}
+
+// ParseFault returns the fault named s, e.g. "slow-write".
+func ParseFault(s string) (Fault, error) {
+	for f, name := range faultNames {
+		if strings.EqualFold(s, name) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fault %q", s)
+}
+
+// bogusEncoding is the encoding of the rectangles of FaultBogusEncoding.
+const bogusEncoding = 0x7f0ba5e5
+
+// DefaultSlowWriteDelay is the delay between the bytes of FaultSlowWrite.
+const DefaultSlowWriteDelay = 10 * time.Millisecond
+
+// errFaultDisconnect is returned by ServeConn after FaultDisconnect.
+var errFaultDisconnect = errors.New("disconnected by fault injection")
+
+// Chaos configures the injection of faults into the updates sent to clients.
+type Chaos struct {
+	// Faults are the faults injected, one chosen at random for each update
+	// with a fault. All are injected if empty.
+	Faults []Fault
+	// Rate is the fraction of updates with a fault, from 0 to 1.
+	Rate float64
+	// SlowWriteDelay is the delay between the bytes of FaultSlowWrite, or
+	// DefaultSlowWriteDelay if zero.
+	SlowWriteDelay time.Duration
+	// Seed seeds the choice of faults of each connection, making them
+	// reproducible, unless zero.
+	Seed int64
+}
+
+func (c *Chaos) slowWriteDelay() time.Duration {
+	if c.SlowWriteDelay > 0 {
+		return c.SlowWriteDelay
+	}
+	return DefaultSlowWriteDelay
+}
+
+// chaos chooses the faults injected into the updates of a connection.
+type chaos struct {
+	cfg *Chaos
+	rnd *rand.Rand
+}
+
+func newChaos(cfg *Chaos) *chaos {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaos{cfg: cfg, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// next returns the fault of the next update, if any.
+func (ch *chaos) next() (Fault, bool) {
+	if ch == nil || ch.rnd.Float64() >= ch.cfg.Rate {
+		return 0, false
+	}
+	faults := ch.cfg.Faults
+	if len(faults) == 0 {
+		return Fault(ch.rnd.Intn(len(faultNames))), true
+	}
+	return faults[ch.rnd.Intn(len(faults))], true
+}
+
+// writeUpdate writes the FramebufferUpdate message b, with the fault of the
+// update, if any.
+func (sc *serverConn) writeUpdate(b []byte) error {
+	f, ok := sc.chaos.next()
+	if !ok {
+		_, err := sc.c.Write(b)
+		return err
+	}
+	switch f {
+	case FaultTruncate:
+		_, err := sc.c.Write(b[:len(b)/2])
+		return err
+	case FaultInvalidLength:
+		cut := []byte{byte(messages.ServerCutText), 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(cut[4:], 0xffffffff)
+		_, err := sc.c.Write(append(cut, b...))
+		return err
+	case FaultSlowWrite:
+		for i := range b {
+			if i > 0 {
+				time.Sleep(sc.chaos.cfg.slowWriteDelay())
+			}
+			if _, err := sc.c.Write(b[i : i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FaultDisconnect:
+		if _, err := sc.c.Write(b[:len(b)/2]); err != nil {
+			return err
+		}
+		return errFaultDisconnect
+	case FaultBogusEncoding:
+		// The encoding of the first rectangle follows the message header, and
+		// the position and size of the rectangle.
+		b = append([]byte(nil), b...)
+		binary.BigEndian.PutUint32(b[4+8:], bogusEncoding)
+		_, err := sc.c.Write(b)
+		return err
+	}
+	return fmt.Errorf("unknown fault %v", f)
+}
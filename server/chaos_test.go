@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+func TestParseFault(t *testing.T) {
+	for f := range faultNames {
+		got, err := ParseFault(f.String())
+		if err != nil {
+			t.Errorf("%v: unexpected error: %s", f, err)
+			continue
+		}
+		if got != f {
+			t.Errorf("ParseFault(%q) = %v, want %v", f.String(), got, f)
+		}
+	}
+	if _, err := ParseFault("bogus"); err == nil {
+		t.Error("expected error")
+	}
+	if got, want := Fault(42).String(), "Fault(42)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestChaos_Rate(t *testing.T) {
+	var ch *chaos
+	if _, ok := ch.next(); ok {
+		t.Error("fault without chaos")
+	}
+	ch = newChaos(&Chaos{Rate: 0, Seed: 1})
+	for i := 0; i < 100; i++ {
+		if f, ok := ch.next(); ok {
+			t.Fatalf("unexpected fault %v at rate 0", f)
+		}
+	}
+	ch = newChaos(&Chaos{Faults: []Fault{FaultSlowWrite}, Rate: 1, Seed: 1})
+	for i := 0; i < 100; i++ {
+		if f, ok := ch.next(); !ok || f != FaultSlowWrite {
+			t.Fatalf("incorrect fault; got = %v, %v, want = %v", f, ok, FaultSlowWrite)
+		}
+	}
+}
+
+// bufferConn records the bytes written to it.
+type bufferConn struct {
+	bytes.Buffer
+}
+
+func (*bufferConn) Close() error { return nil }
+
+func TestWriteUpdate(t *testing.T) {
+	update := []byte{
+		byte(messages.FramebufferUpdate), 0, 0, 1, // header
+		0, 0, 0, 0, 0, 1, 0, 1, // rectangle
+		0, 0, 0, 0, // Raw
+		1, 2, 3, 4, // pixel
+	}
+	bogus := append([]byte(nil), update...)
+	binary.BigEndian.PutUint32(bogus[12:], bogusEncoding)
+	cut := []byte{byte(messages.ServerCutText), 0, 0, 0, 0xff, 0xff, 0xff, 0xff}
+
+	for _, tt := range []struct {
+		fault Fault
+		want  []byte
+		isErr bool
+	}{
+		{FaultTruncate, update[:10], false},
+		{FaultInvalidLength, append(cut, update...), false},
+		{FaultSlowWrite, update, false},
+		{FaultDisconnect, update[:10], true},
+		{FaultBogusEncoding, bogus, false},
+	} {
+		c := &bufferConn{}
+		sc := &serverConn{c: c, chaos: newChaos(&Chaos{
+			Faults:         []Fault{tt.fault},
+			Rate:           1,
+			SlowWriteDelay: time.Microsecond,
+		})}
+		err := sc.writeUpdate(update)
+		if got := err != nil; got != tt.isErr {
+			t.Errorf("%v: unexpected error state; got = %v, want = %v", tt.fault, err, tt.isErr)
+		}
+		if got := c.Bytes(); !bytes.Equal(got, tt.want) {
+			t.Errorf("%v: incorrect bytes; got = %v, want = %v", tt.fault, got, tt.want)
+		}
+	}
+}
+
+func TestServer_Chaos(t *testing.T) {
+	src := NewImageSource(image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	for _, f := range []Fault{FaultInvalidLength, FaultDisconnect, FaultBogusEncoding} {
+		server, client := net.Pipe()
+		s := &Server{Source: src, Chaos: &Chaos{Faults: []Fault{f}, Rate: 1}}
+		go s.ServeConn(server)
+
+		cfg := vnc.NewClientConfig("")
+		cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+		cfg.ServerMessageCh = make(chan vnc.ServerMessage, 1)
+		vc, err := vnc.Connect(context.Background(), client, cfg)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %s", f, err)
+		}
+		done := make(chan struct{})
+		go func() {
+			vc.ListenAndHandle()
+			close(done)
+		}()
+		if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 4, 3); err != nil {
+			t.Fatalf("%v: unexpected error: %s", f, err)
+		}
+		// The client gives up on the connection, rather than hanging.
+		select {
+		case <-done:
+		case msg := <-cfg.ServerMessageCh:
+			t.Errorf("%v: unexpected message %v", f, msg)
+			vc.Close()
+		case <-time.After(5 * time.Second):
+			t.Errorf("%v: client never gave up", f)
+			vc.Close()
+		}
+	}
+}
@@ -0,0 +1,445 @@
+/*
+Package server provides a minimal RFB server, serving a framebuffer from a
+FramebufferSource, e.g. for testing clients, or to expose a Go-rendered UI
+over VNC:
+
+	src := server.NewImageSource(img)
+	s := &server.Server{Source: src, Name: "ui"}
+	go s.Serve(l)
+	...
+	draw.Draw(img, r, ...)
+	src.Damage(r)
+*/
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/logging"
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// Security types, from RFC 6143 §7.2.
+const (
+	secTypeNone    = 1
+	secTypeVNCAuth = 2
+)
+
+// serverPixelFormat is the pixel format announced in ServerInit, until the
+// client asks for another.
+var serverPixelFormat = vnc.PixelFormat{
+	BPP:        32,
+	Depth:      24,
+	BigEndian:  rfbflags.RFBFalse,
+	TrueColor:  rfbflags.RFBTrue,
+	RedMax:     0xff,
+	GreenMax:   0xff,
+	BlueMax:    0xff,
+	RedShift:   16,
+	GreenShift: 8,
+	BlueShift:  0,
+}
+
+// Server is a minimal RFB 3.8 server, which serves the framebuffer of a
+// source using Raw encoding, or, with a Zstd encoder, the private ZSTD
+// encoding to clients which prefer it. Input from clients is ignored.
+type Server struct {
+	// Source provides the framebuffer served.
+	Source FramebufferSource
+	// Name is the desktop name sent to clients.
+	Name string
+	// Password, if set, must be given by clients with VNC authentication.
+	Password string
+	// Chaos, if set, injects faults into the updates sent to clients.
+	Chaos *Chaos
+	// Zstd, if set, compresses the updates sent to clients which prefer the
+	// ZSTD encoding (see vnc.ZstdEncoding) to Raw.
+	Zstd vnc.ZstdEncoder
+}
+
+// Serve accepts connections on l, serving each in its own goroutine, until
+// l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if logging.V(logging.FlowLevel) {
+				glog.Infof("%s: connected", c.RemoteAddr())
+			}
+			if err := s.ServeConn(c); err != nil && err != io.EOF && err != errFaultDisconnect {
+				glog.Errorf("%s: %s", c.RemoteAddr(), err)
+			}
+			if logging.V(logging.FlowLevel) {
+				glog.Infof("%s: disconnected", c.RemoteAddr())
+			}
+		}()
+	}
+}
+
+// updateRequest is a FramebufferUpdateRequest message.
+type updateRequest struct {
+	incremental bool
+	rect        image.Rectangle
+}
+
+// serverConn holds the state of a single client connection.
+type serverConn struct {
+	c     io.ReadWriteCloser
+	r     *bufio.Reader
+	pf    vnc.PixelFormat
+	minor int                // The minor protocol version selected by the client.
+	chaos *chaos             // Injects faults into updates, if set.
+	zstd  vnc.ZstdEncoder    // Compresses updates with the ZSTD encoding, if set.
+	enc   encodings.Encoding // The encoding of the updates sent.
+}
+
+// ServeConn serves a single client, closing the connection when done. The
+// connection is usually a net.Conn, but may be any io.ReadWriteCloser, e.g.
+// the os.Stdin and os.Stdout of a process started by inetd, joined with
+// vnc.NewStreamConn. Incremental update requests are answered once the
+// framebuffer changes, with the region changed.
+func (s *Server) ServeConn(c io.ReadWriteCloser) error {
+	defer c.Close()
+	sc := &serverConn{c: c, r: bufio.NewReader(c), pf: serverPixelFormat, zstd: s.Zstd, enc: encodings.Raw}
+	if s.Chaos != nil {
+		sc.chaos = newChaos(s.Chaos)
+	}
+	if err := s.handshake(sc); err != nil {
+		return err
+	}
+
+	msgs := make(chan interface{}, 16)
+	errc := make(chan error, 1)
+	go func() { errc <- sc.readMessages(msgs) }()
+
+	var (
+		pending *updateRequest
+		sent    bool   // Whether a frame has been sent.
+		sentSeq uint64 // The sequence number of the last frame sent.
+		seenSeq uint64 // The latest frame without damage within the request.
+	)
+	for {
+		var changed <-chan struct{}
+		if pending != nil && pending.incremental && sent {
+			changed = s.Source.Changed(seenSeq)
+		}
+		select {
+		case msg := <-msgs:
+			switch msg := msg.(type) {
+			case vnc.PixelFormat:
+				sc.pf = msg
+			case []encodings.Encoding:
+				sc.enc = sc.chooseEncoding(msg)
+			case updateRequest:
+				pending = &msg
+			}
+		case <-changed:
+		case err := <-errc:
+			return err
+		}
+		if pending == nil {
+			continue
+		}
+		frame, seq := s.Source.Frame()
+		rect := pending.rect
+		if pending.incremental && sent {
+			if seq != sentSeq {
+				rect = rect.Intersect(s.Source.Damaged(sentSeq))
+			}
+			if seq == sentSeq || rect.Empty() {
+				seenSeq = seq // Nothing changed within the request yet.
+				continue
+			}
+		}
+		if err := sc.sendUpdate(frame, rect); err != nil {
+			return err
+		}
+		pending, sent, sentSeq, seenSeq = nil, true, seq, seq
+	}
+}
+
+// handshake performs the protocol version, security, and initialization
+// handshakes of RFC 6143 §7.1 to §7.3.
+func (s *Server) handshake(sc *serverConn) error {
+	if _, err := io.WriteString(sc.c, "RFB 003.008\n"); err != nil {
+		return err
+	}
+	var version [12]byte
+	if _, err := io.ReadFull(sc.r, version[:]); err != nil {
+		return err
+	}
+	var major int
+	if _, err := fmt.Sscanf(string(version[:]), "RFB %03d.%03d\n", &major, &sc.minor); err != nil || major != 3 {
+		return fmt.Errorf("unsupported protocol version %q", version)
+	}
+
+	secType := uint8(secTypeNone)
+	if s.Password != "" {
+		secType = secTypeVNCAuth
+	}
+	if sc.minor >= 7 {
+		if _, err := sc.c.Write([]byte{1, secType}); err != nil {
+			return err
+		}
+		var selected [1]byte
+		if _, err := io.ReadFull(sc.r, selected[:]); err != nil {
+			return err
+		}
+		if selected[0] != secType {
+			return fmt.Errorf("unsupported security type %d", selected[0])
+		}
+	} else {
+		if err := binary.Write(sc.c, binary.BigEndian, uint32(secType)); err != nil {
+			return err
+		}
+	}
+
+	authErr := s.authenticate(sc, secType)
+	// The SecurityResult is only sent for None security from version 3.8.
+	if secType != secTypeNone || sc.minor >= 8 {
+		if err := sc.sendSecurityResult(authErr); err != nil {
+			return err
+		}
+	}
+	if authErr != nil {
+		return authErr
+	}
+
+	var shared [1]byte // ClientInit
+	if _, err := io.ReadFull(sc.r, shared[:]); err != nil {
+		return err
+	}
+	b := s.Source.Bounds()
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(b.Dx()))
+	binary.Write(&buf, binary.BigEndian, uint16(b.Dy()))
+	binary.Write(&buf, binary.BigEndian, sc.pf)
+	binary.Write(&buf, binary.BigEndian, uint32(len(s.Name)))
+	buf.WriteString(s.Name)
+	_, err := sc.c.Write(buf.Bytes())
+	return err
+}
+
+// authenticate performs the security handshake for the security type.
+func (s *Server) authenticate(sc *serverConn, secType uint8) error {
+	if secType == secTypeNone {
+		return nil
+	}
+	var challenge [16]byte
+	if _, err := rand.Read(challenge[:]); err != nil {
+		return err
+	}
+	if _, err := sc.c.Write(challenge[:]); err != nil {
+		return err
+	}
+	var response [16]byte
+	if _, err := io.ReadFull(sc.r, response[:]); err != nil {
+		return err
+	}
+	want, err := vncAuthResponse(s.Password, challenge)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(response[:], want[:]) != 1 {
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
+
+// sendSecurityResult sends the SecurityResult message, with the reason for
+// any failure from version 3.8.
+func (sc *serverConn) sendSecurityResult(authErr error) error {
+	if authErr == nil {
+		return binary.Write(sc.c, binary.BigEndian, uint32(0))
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+	if sc.minor >= 8 {
+		reason := authErr.Error()
+		binary.Write(&buf, binary.BigEndian, uint32(len(reason)))
+		buf.WriteString(reason)
+	}
+	_, err := sc.c.Write(buf.Bytes())
+	return err
+}
+
+// vncAuthResponse returns the expected response to a VNC authentication
+// challenge, i.e. the challenge DES encrypted with the password.
+func vncAuthResponse(password string, challenge [16]byte) ([16]byte, error) {
+	key := make([]byte, 8)
+	copy(key, password)
+	// The bits of each byte of the key are reversed.
+	for i := range key {
+		key[i] = (key[i]&0x55)<<1 | (key[i]&0xAA)>>1
+		key[i] = (key[i]&0x33)<<2 | (key[i]&0xCC)>>2
+		key[i] = (key[i]&0x0F)<<4 | (key[i]&0xF0)>>4
+	}
+	cipher, err := des.NewCipher(key)
+	if err != nil {
+		return challenge, err
+	}
+	for i := 0; i < len(challenge); i += cipher.BlockSize() {
+		cipher.Encrypt(challenge[i:], challenge[i:])
+	}
+	return challenge, nil
+}
+
+// chooseEncoding returns the first of the encodings, in the order of the
+// client's preference, which the connection can send. Raw is always sent
+// otherwise.
+func (sc *serverConn) chooseEncoding(encs []encodings.Encoding) encodings.Encoding {
+	for _, e := range encs {
+		switch {
+		case e == encodings.Raw:
+			return e
+		case e == encodings.ZstdPrivate && sc.zstd != nil:
+			return e
+		}
+	}
+	return encodings.Raw
+}
+
+// sendUpdate sends a FramebufferUpdate with a single rectangle, holding the
+// part of the frame within rect, in the encoding of the connection.
+func (sc *serverConn) sendUpdate(frame *image.RGBA, rect image.Rectangle) error {
+	rect = rect.Intersect(frame.Bounds())
+	var buf bytes.Buffer
+	buf.WriteByte(byte(messages.FramebufferUpdate))
+	buf.WriteByte(0) // padding
+	binary.Write(&buf, binary.BigEndian, uint16(1))
+	binary.Write(&buf, binary.BigEndian, []uint16{
+		uint16(rect.Min.X), uint16(rect.Min.Y), uint16(rect.Dx()), uint16(rect.Dy())})
+	binary.Write(&buf, binary.BigEndian, int32(sc.enc))
+	raw := encodeRaw(frame, rect, sc.pf)
+	if sc.enc == encodings.ZstdPrivate {
+		// length, followed by the ZSTD frame of the raw pixel data
+		b := sc.zstd.EncodeAll(raw, make([]byte, 4))
+		binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+		raw = b
+	}
+	buf.Write(raw)
+	return sc.writeUpdate(buf.Bytes())
+}
+
+// encodeRaw returns the pixels of the frame within rect in the pixel format,
+// which must be true color.
+func encodeRaw(frame *image.RGBA, rect image.Rectangle, pf vnc.PixelFormat) []byte {
+	bpp := int(pf.BPP) / 8
+	data := make([]byte, 0, rect.Dx()*rect.Dy()*bpp)
+	var px [4]byte
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			c := frame.RGBAAt(x, y)
+			v := uint32(c.R)*uint32(pf.RedMax)/0xff<<pf.RedShift |
+				uint32(c.G)*uint32(pf.GreenMax)/0xff<<pf.GreenShift |
+				uint32(c.B)*uint32(pf.BlueMax)/0xff<<pf.BlueShift
+			switch bpp {
+			case 1:
+				px[0] = uint8(v)
+			case 2:
+				if rfbflags.IsBigEndian(pf.BigEndian) {
+					binary.BigEndian.PutUint16(px[:], uint16(v))
+				} else {
+					binary.LittleEndian.PutUint16(px[:], uint16(v))
+				}
+			case 4:
+				if rfbflags.IsBigEndian(pf.BigEndian) {
+					binary.BigEndian.PutUint32(px[:], v)
+				} else {
+					binary.LittleEndian.PutUint32(px[:], v)
+				}
+			}
+			data = append(data, px[:bpp]...)
+		}
+	}
+	return data
+}
+
+// readMessages reads client messages until an error occurs, passing pixel
+// formats, encodings, and update requests to the channel. All other messages
+// are discarded.
+func (sc *serverConn) readMessages(msgs chan<- interface{}) error {
+	for {
+		msgType, err := sc.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch messages.ClientMessage(msgType) {
+		case messages.SetPixelFormat:
+			var buf [3 + 16]byte
+			if _, err := io.ReadFull(sc.r, buf[:]); err != nil {
+				return err
+			}
+			var pf vnc.PixelFormat
+			if err := pf.Unmarshal(buf[3:]); err != nil {
+				return err
+			}
+			if !rfbflags.IsTrueColor(pf.TrueColor) {
+				return fmt.Errorf("color map pixel formats are not supported")
+			}
+			msgs <- pf
+		case messages.SetEncodings:
+			var hdr [3]byte
+			if _, err := io.ReadFull(sc.r, hdr[:]); err != nil {
+				return err
+			}
+			buf := make([]byte, 4*int(binary.BigEndian.Uint16(hdr[1:])))
+			if _, err := io.ReadFull(sc.r, buf); err != nil {
+				return err
+			}
+			encs := make([]encodings.Encoding, len(buf)/4)
+			for i := range encs {
+				encs[i] = encodings.Encoding(binary.BigEndian.Uint32(buf[4*i:]))
+			}
+			msgs <- encs
+		case messages.FramebufferUpdateRequest:
+			var buf [9]byte
+			if _, err := io.ReadFull(sc.r, buf[:]); err != nil {
+				return err
+			}
+			x := int(binary.BigEndian.Uint16(buf[1:]))
+			y := int(binary.BigEndian.Uint16(buf[3:]))
+			w := int(binary.BigEndian.Uint16(buf[5:]))
+			h := int(binary.BigEndian.Uint16(buf[7:]))
+			msgs <- updateRequest{
+				incremental: rfbflags.ToBool(rfbflags.RFBFlag(buf[0])),
+				rect:        image.Rect(x, y, x+w, y+h),
+			}
+		case messages.KeyEvent:
+			if _, err := io.CopyN(ioutil.Discard, sc.r, 7); err != nil {
+				return err
+			}
+		case messages.PointerEvent:
+			if _, err := io.CopyN(ioutil.Discard, sc.r, 5); err != nil {
+				return err
+			}
+		case messages.ClientCutText:
+			var hdr [7]byte
+			if _, err := io.ReadFull(sc.r, hdr[:]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(ioutil.Discard, sc.r, int64(binary.BigEndian.Uint32(hdr[3:]))); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported message-type %d", msgType)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc"
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/go/operators"
+	"github.com/kward/go-vnc/rfbflags"
+	"golang.org/x/net/context"
+)
+
+// connect serves src over a pipe, and returns a client connected to it.
+func connect(src FramebufferSource, password string, cfg *vnc.ClientConfig) (*vnc.ClientConn, error) {
+	server, client := net.Pipe()
+	s := &Server{Source: src, Name: "test", Password: password}
+	go s.ServeConn(server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vc, err := vnc.Connect(ctx, client, cfg)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return vc, nil
+}
+
+func TestServer(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 2, color.RGBA{0x10, 0x20, 0x30, 0xff})
+	src := NewImageSource(img)
+
+	for _, tt := range []struct {
+		desc     string
+		password string
+		auth     vnc.ClientAuth
+		ok       bool
+	}{
+		{"none", "", &vnc.ClientAuthNone{}, true},
+		{"vnc auth", "secret", &vnc.ClientAuthVNC{Password: "secret"}, true},
+		{"bad password", "secret", &vnc.ClientAuthVNC{Password: "wrong"}, false},
+	} {
+		cfg := vnc.NewClientConfig("")
+		cfg.Auth = []vnc.ClientAuth{tt.auth}
+		cfg.ServerMessageCh = make(chan vnc.ServerMessage, 1)
+		vc, err := connect(src, tt.password, cfg)
+		if err == nil && !tt.ok {
+			vc.Close()
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			}
+			continue
+		}
+
+		if got, want := vc.DesktopName(), "test"; got != want {
+			t.Errorf("%s: DesktopName() = %q, want %q", tt.desc, got, want)
+		}
+		go vc.ListenAndHandle()
+		if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 4, 3); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		var fu *vnc.FramebufferUpdate
+		select {
+		case msg := <-cfg.ServerMessageCh:
+			fu = msg.(*vnc.FramebufferUpdate)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: timed out waiting for update", tt.desc)
+		}
+		fb := vnc.NewFramebuffer(4, 3)
+		for i := range fu.Rects {
+			if err := fb.Apply(&fu.Rects[i]); err != nil {
+				t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+			}
+		}
+		if got, want := fb.Image().RGBAAt(1, 2), (color.RGBA{0x10, 0x20, 0x30, 0xff}); got != want {
+			t.Errorf("%s: pixel = %v, want %v", tt.desc, got, want)
+		}
+		vc.Close()
+	}
+}
+
+// fakeZstd is a vnc.ZstdEncoder and vnc.ZstdDecoder which "compresses" by
+// prefixing the data with a magic number.
+type fakeZstd struct{}
+
+var fakeZstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func (fakeZstd) EncodeAll(src, dst []byte) []byte {
+	return append(append(dst, fakeZstdMagic...), src...)
+}
+
+func (fakeZstd) DecodeAll(input, dst []byte) ([]byte, error) {
+	if !bytes.HasPrefix(input, fakeZstdMagic) {
+		return nil, errors.New("invalid magic number")
+	}
+	return append(dst, input[len(fakeZstdMagic):]...), nil
+}
+
+func TestServer_Zstd(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 2, color.RGBA{0x10, 0x20, 0x30, 0xff})
+	src := NewImageSource(img)
+	zstd := &vnc.ZstdEncoding{Decoder: fakeZstd{}}
+
+	for _, tt := range []struct {
+		desc string
+		zstd vnc.ZstdEncoder
+		encs vnc.Encodings
+		want encodings.Encoding
+	}{
+		{"zstd preferred", fakeZstd{}, vnc.Encodings{zstd, &vnc.RawEncoding{}}, encodings.ZstdPrivate},
+		{"raw preferred", fakeZstd{}, vnc.Encodings{&vnc.RawEncoding{}, zstd}, encodings.Raw},
+		{"no encoder", nil, vnc.Encodings{zstd, &vnc.RawEncoding{}}, encodings.Raw},
+	} {
+		server, client := net.Pipe()
+		go (&Server{Source: src, Zstd: tt.zstd}).ServeConn(server)
+		cfg := vnc.NewClientConfig("")
+		cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+		cfg.ServerMessageCh = make(chan vnc.ServerMessage, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		vc, err := vnc.Connect(ctx, client, cfg)
+		cancel()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		go vc.ListenAndHandle()
+
+		if err := vc.SetEncodings(tt.encs); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 4, 3); err != nil {
+			t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+		}
+		fu := nextUpdate(t, cfg.ServerMessageCh)
+		fb := vnc.NewFramebuffer(4, 3)
+		for i := range fu.Rects {
+			if got := fu.Rects[i].Enc.Type(); got != tt.want {
+				t.Errorf("%s: encoding = %v, want %v", tt.desc, got, tt.want)
+			}
+			if err := fb.Apply(&fu.Rects[i]); err != nil {
+				t.Fatalf("%s: unexpected error: %s", tt.desc, err)
+			}
+		}
+		if got, want := fb.Image().RGBAAt(1, 2), (color.RGBA{0x10, 0x20, 0x30, 0xff}); got != want {
+			t.Errorf("%s: pixel = %v, want %v", tt.desc, got, want)
+		}
+		vc.Close()
+	}
+}
+
+// nextUpdate returns the next FramebufferUpdate received.
+func nextUpdate(t *testing.T, ch <-chan vnc.ServerMessage) *vnc.FramebufferUpdate {
+	select {
+	case msg := <-ch:
+		return msg.(*vnc.FramebufferUpdate)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for update")
+	}
+	return nil
+}
+
+// bounds returns the bounds of the rectangle.
+func bounds(r vnc.Rectangle) image.Rectangle {
+	return image.Rect(int(r.X), int(r.Y), int(r.X)+int(r.Width), int(r.Y)+int(r.Height))
+}
+
+func TestServer_Damage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	src := NewImageSource(img)
+	cfg := vnc.NewClientConfig("")
+	cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	cfg.ServerMessageCh = make(chan vnc.ServerMessage, 1)
+	vc, err := connect(src, "", cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer vc.Close()
+	go vc.ListenAndHandle()
+
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 8, 8); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := bounds(nextUpdate(t, cfg.ServerMessageCh).Rects[0]), image.Rect(0, 0, 8, 8); got != want {
+		t.Errorf("incorrect full update; got = %v, want = %v", got, want)
+	}
+
+	// An incremental request is answered once damaged, with the damage.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 8, 8); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case msg := <-cfg.ServerMessageCh:
+		t.Fatalf("unexpected update before damage: %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+	img.Set(3, 4, color.RGBA{0x10, 0x20, 0x30, 0xff})
+	src.Damage(image.Rect(2, 3, 5, 6))
+	fu := nextUpdate(t, cfg.ServerMessageCh)
+	if got, want := bounds(fu.Rects[0]), image.Rect(2, 3, 5, 6); got != want {
+		t.Errorf("incorrect incremental update; got = %v, want = %v", got, want)
+	}
+	fb := vnc.NewFramebuffer(8, 8)
+	if err := fb.Apply(&fu.Rects[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := fb.Image().RGBAAt(3, 4), (color.RGBA{0x10, 0x20, 0x30, 0xff}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+
+	// Damage outside the requested region isn't sent on its own, and the
+	// damage sent is the bounds of that since the last update.
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBTrue, 0, 0, 4, 4); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	src.Damage(image.Rect(6, 6, 8, 8))
+	src.Damage(image.Rect(1, 1, 2, 2))
+	if got, want := bounds(nextUpdate(t, cfg.ServerMessageCh).Rects[0]), image.Rect(1, 1, 4, 4); got != want {
+		t.Errorf("incorrect incremental update; got = %v, want = %v", got, want)
+	}
+}
+
+func TestServeConn_Stream(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(2, 1, color.RGBA{0x10, 0x20, 0x30, 0xff})
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	s := &Server{Source: NewImageSource(img), Name: "stdio"}
+	go s.ServeConn(vnc.NewStreamConn(sr, sw))
+
+	cfg := vnc.NewClientConfig("")
+	cfg.Auth = []vnc.ClientAuth{&vnc.ClientAuthNone{}}
+	cfg.ServerMessageCh = make(chan vnc.ServerMessage, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vc, err := vnc.Connect(ctx, vnc.NewStreamConn(cr, cw), cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer vc.Close()
+	if got, want := vc.DesktopName(), "stdio"; got != want {
+		t.Errorf("DesktopName() = %q, want %q", got, want)
+	}
+
+	go vc.ListenAndHandle()
+	if err := vc.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, 4, 3); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fu := nextUpdate(t, cfg.ServerMessageCh)
+	fb := vnc.NewFramebuffer(4, 3)
+	if err := fb.Apply(&fu.Rects[0]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := fb.Image().RGBAAt(2, 1), (color.RGBA{0x10, 0x20, 0x30, 0xff}); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeRaw(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	img.Set(1, 0, color.RGBA{0, 0, 0xff, 0xff})
+	rgb565 := vnc.PixelFormat{
+		BPP: 16, Depth: 16, TrueColor: rfbflags.RFBTrue,
+		RedMax: 31, GreenMax: 63, BlueMax: 31,
+		RedShift: 11, GreenShift: 5, BlueShift: 0,
+	}
+
+	for _, tt := range []struct {
+		desc string
+		pf   vnc.PixelFormat
+		data []byte
+	}{
+		{"32 bpp", serverPixelFormat, []byte{0, 0, 0xff, 0, 0xff, 0, 0, 0}},
+		{"16 bpp", rgb565, []byte{0x00, 0xf8, 0x1f, 0x00}},
+	} {
+		if got, want := encodeRaw(img, img.Bounds(), tt.pf), tt.data; !operators.EqualSlicesOfByte(got, want) {
+			t.Errorf("%s: encodeRaw() = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
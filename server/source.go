@@ -0,0 +1,132 @@
+// Sources of the framebuffer served to clients.
+
+package server
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+)
+
+// FramebufferSource provides the framebuffer served to clients. Each change
+// of the framebuffer makes a new frame, numbered in sequence.
+type FramebufferSource interface {
+	// Bounds returns the bounds of every frame, with its origin at (0, 0).
+	Bounds() image.Rectangle
+	// Frame returns the current frame, and its sequence number. The frame
+	// must not be modified once returned.
+	Frame() (frame *image.RGBA, seq uint64)
+	// Damaged returns the region changed since frame seq, or the whole
+	// bounds if it isn't known.
+	Damaged(seq uint64) image.Rectangle
+	// Changed returns a channel which is closed once the frame following
+	// frame seq is available.
+	Changed(seq uint64) <-chan struct{}
+}
+
+// damageHistory is the number of frames for which an ImageSource knows the
+// damaged region. Clients which fall further behind are sent the whole
+// framebuffer.
+const damageHistory = 64
+
+// ImageSource is a FramebufferSource serving a mutable image, into which the
+// application draws, e.g. a Go-rendered UI. After drawing, the application
+// calls Damage with the region drawn, which makes a new frame holding it and
+// sends it to the clients waiting for an update.
+//
+// The image must not be drawn into while Damage is called, which is simplest
+// if Damage is called by the goroutine drawing.
+type ImageSource struct {
+	img image.Image
+
+	mu      sync.Mutex
+	frame   *image.RGBA
+	seq     uint64
+	damage  []image.Rectangle // The damage of the latest frames, oldest first.
+	changed chan struct{}     // Closed by the next Damage.
+}
+
+// Verify that interfaces are honored.
+var _ FramebufferSource = (*ImageSource)(nil)
+
+// NewImageSource returns a source serving img, starting with its current
+// contents.
+func NewImageSource(img image.Image) *ImageSource {
+	b := img.Bounds()
+	frame := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(frame, frame.Bounds(), img, b.Min, draw.Src)
+	return &ImageSource{img: img, frame: frame, changed: make(chan struct{})}
+}
+
+// Damage makes a new frame with the region r of the image, in the
+// coordinates of the image, redrawn from it.
+func (s *ImageSource) Damage(r image.Rectangle) {
+	b := s.img.Bounds()
+	r = r.Intersect(b).Sub(b.Min)
+	if r.Empty() {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Frames already returned are never modified, so a new frame is made.
+	frame := &image.RGBA{
+		Pix:    append([]uint8(nil), s.frame.Pix...),
+		Stride: s.frame.Stride,
+		Rect:   s.frame.Rect,
+	}
+	draw.Draw(frame, r, s.img, r.Min.Add(b.Min), draw.Src)
+	s.frame = frame
+	s.seq++
+	s.damage = append(s.damage, r)
+	if len(s.damage) > damageHistory {
+		s.damage = s.damage[len(s.damage)-damageHistory:]
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Bounds implements the FramebufferSource interface.
+func (s *ImageSource) Bounds() image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frame.Bounds()
+}
+
+// Frame implements the FramebufferSource interface.
+func (s *ImageSource) Frame() (*image.RGBA, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.frame, s.seq
+}
+
+// Damaged implements the FramebufferSource interface.
+func (s *ImageSource) Damaged(seq uint64) image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq > s.seq || s.seq-seq > uint64(len(s.damage)) {
+		return s.frame.Bounds()
+	}
+	var r image.Rectangle
+	for _, d := range s.damage[len(s.damage)-int(s.seq-seq):] {
+		r = r.Union(d)
+	}
+	return r
+}
+
+// Changed implements the FramebufferSource interface.
+func (s *ImageSource) Changed(seq uint64) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if seq < s.seq {
+		return closed
+	}
+	return s.changed
+}
+
+// closed is a closed channel.
+var closed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestImageSource(t *testing.T) {
+	img := image.NewRGBA(image.Rect(10, 10, 20, 15)) // The frames have their origin at (0, 0).
+	img.Set(10, 10, color.RGBA{0xff, 0, 0, 0xff})
+	src := NewImageSource(img)
+	if got, want := src.Bounds(), image.Rect(0, 0, 10, 5); got != want {
+		t.Errorf("incorrect bounds; got = %v, want = %v", got, want)
+	}
+	f0, seq := src.Frame()
+	if got, want := f0.RGBAAt(0, 0), (color.RGBA{0xff, 0, 0, 0xff}); got != want {
+		t.Errorf("incorrect pixel; got = %v, want = %v", got, want)
+	}
+	changed := src.Changed(seq)
+	select {
+	case <-changed:
+		t.Fatal("changed before damage")
+	default:
+	}
+
+	img.Set(11, 12, color.RGBA{0, 0xff, 0, 0xff})
+	src.Damage(image.Rect(11, 12, 12, 13))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not changed after damage")
+	}
+	f1, seq1 := src.Frame()
+	if got, want := seq1, seq+1; got != want {
+		t.Errorf("incorrect sequence number; got = %v, want = %v", got, want)
+	}
+	if got, want := f1.RGBAAt(1, 2), (color.RGBA{0, 0xff, 0, 0xff}); got != want {
+		t.Errorf("incorrect damaged pixel; got = %v, want = %v", got, want)
+	}
+	if got, want := f0.RGBAAt(1, 2), (color.RGBA{}); got != want {
+		t.Errorf("earlier frame modified; got = %v, want = %v", got, want)
+	}
+
+	// Damage outside the image is ignored.
+	src.Damage(image.Rect(0, 0, 5, 5))
+	if _, got := src.Frame(); got != seq1 {
+		t.Errorf("incorrect sequence number; got = %v, want = %v", got, seq1)
+	}
+}
+
+func TestImageSource_Damaged(t *testing.T) {
+	src := NewImageSource(image.NewRGBA(image.Rect(0, 0, 100, 100)))
+	src.Damage(image.Rect(0, 0, 1, 1))
+	src.Damage(image.Rect(5, 5, 10, 10))
+
+	for _, tt := range []struct {
+		desc string
+		seq  uint64
+		want image.Rectangle
+	}{
+		{"current", 2, image.Rectangle{}},
+		{"one frame", 1, image.Rect(5, 5, 10, 10)},
+		{"two frames", 0, image.Rect(0, 0, 10, 10)},
+		{"future", 3, image.Rect(0, 0, 100, 100)},
+	} {
+		if got := src.Damaged(tt.seq); got != tt.want {
+			t.Errorf("%s: Damaged(%d) = %v, want %v", tt.desc, tt.seq, got, tt.want)
+		}
+	}
+
+	// Beyond the history, the whole framebuffer is damaged.
+	for i := 0; i < damageHistory; i++ {
+		src.Damage(image.Rect(0, 0, 1, 1))
+	}
+	if got, want := src.Damaged(1), image.Rect(0, 0, 100, 100); got != want {
+		t.Errorf("Damaged(1) = %v, want %v", got, want)
+	}
+	if got, want := src.Damaged(3), image.Rect(0, 0, 1, 1); got != want {
+		t.Errorf("Damaged(3) = %v, want %v", got, want)
+	}
+}
@@ -61,6 +61,7 @@ func TestFramebufferUpdate(t *testing.T) {
 	// Use empty PixelFormat so that the BPP is zero, and rects won't be read.
 	// TODO(kward): give some real rectangles so this hack isn't necessary.
 	conn.pixelFormat = PixelFormat{}
+	conn.fbWidth, conn.fbHeight = 640, 480
 
 	for _, tt := range []struct {
 		desc  string
@@ -222,3 +223,191 @@ func TestSetColorMapEntries(t *testing.T) {}
 func TestBell(t *testing.T) {}
 
 func TestServerCutText(t *testing.T) {}
+
+func TestFramebufferUpdate_RectFunc(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat{} // No pixel data.
+	conn.fbWidth, conn.fbHeight = 640, 480
+
+	rects := []Rectangle{
+		{1, 2, 3, 4, &RawEncoding{}, conn.Encodable},
+		{5, 6, 7, 8, &RawEncoding{}, conn.Encodable},
+	}
+	var got []Rectangle
+	conn.config.RectFunc = func(rect *Rectangle, i, n int) error {
+		if i != len(got) {
+			t.Errorf("incorrect rectangle index; got = %v, want = %v", i, len(got))
+		}
+		if n != len(rects) {
+			t.Errorf("incorrect number of rectangles; got = %v, want = %v", n, len(rects))
+		}
+		got = append(got, *rect)
+		return nil
+	}
+
+	bytes, err := newFramebufferUpdate(rects).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(bytes[1:]); err != nil { // Strip message-type.
+		t.Fatal(err)
+	}
+	msg, err := (&FramebufferUpdate{}).Read(conn)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	fu := msg.(*FramebufferUpdate)
+	if got, want := fu.NumRect, uint16(len(rects)); got != want {
+		t.Errorf("incorrect number-of-rectangles; got = %v, want = %v", got, want)
+	}
+	if fu.Rects != nil {
+		t.Errorf("rectangles unexpectedly buffered")
+	}
+	if len(got) != len(rects) {
+		t.Fatalf("incorrect number of rectangles streamed; got = %v, want = %v", len(got), len(rects))
+	}
+	for i := range rects {
+		if got[i].X != rects[i].X || got[i].Height != rects[i].Height {
+			t.Errorf("incorrect rectangle [%d]; got = %v, want = %v", i, &got[i], &rects[i])
+		}
+	}
+}
+
+func TestMessageLimits(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 100, 100
+
+	for _, tt := range []struct {
+		desc   string
+		limits Limits
+		msg    ServerMessage
+		data   []interface{}
+		ok     bool
+	}{
+		{"rects within default limit", Limits{}, &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(0)}, true},
+		{"rects exceed limit", Limits{MaxRects: 2}, &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(3)}, false},
+		{"rect larger than framebuffer", Limits{}, &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(1), rectangleMessage{0, 0, 101, 1, encodings.Raw}}, false},
+		{"cut text within limit", Limits{MaxCutTextLength: 3}, &ServerCutText{},
+			[]interface{}{[3]byte{}, uint32(3), []byte("abc")}, true},
+		{"cut text exceeds default limit", Limits{}, &ServerCutText{},
+			[]interface{}{[3]byte{}, uint32(DefaultMaxCutTextLength + 1)}, false},
+		{"color map entries exceed limit", Limits{MaxColorMapEntries: 16}, &SetColorMapEntries{},
+			[]interface{}{[1]byte{}, uint16(0), uint16(17)}, false},
+	} {
+		mockConn.Reset()
+		conn.config.Limits = tt.limits
+		for _, d := range tt.data {
+			if err := conn.send(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := tt.msg.Read(conn)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+	}
+}
+
+func TestRectangle_Bounds(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}
+	conn.pixelFormat = PixelFormat{} // No pixel data.
+	conn.fbWidth, conn.fbHeight = 100, 50
+
+	for _, tt := range []struct {
+		desc string
+		msg  rectangleMessage
+		ok   bool
+	}{
+		{"full framebuffer", rectangleMessage{0, 0, 100, 50, encodings.Raw}, true},
+		{"bottom right pixel", rectangleMessage{99, 49, 1, 1, encodings.Raw}, true},
+		{"empty rect at edge", rectangleMessage{100, 50, 0, 0, encodings.Raw}, true},
+		{"empty rect beyond edge", rectangleMessage{200, 0, 0, 10, encodings.Raw}, true},
+		{"too wide", rectangleMessage{0, 0, 101, 50, encodings.Raw}, false},
+		{"too tall", rectangleMessage{0, 0, 100, 51, encodings.Raw}, false},
+		{"x overflow", rectangleMessage{99, 0, 2, 1, encodings.Raw}, false},
+		{"y overflow", rectangleMessage{0, 65535, 1, 1, encodings.Raw}, false},
+		{"pseudo-encoding", rectangleMessage{0, 0, 640, 480, encodings.DesktopSizePseudo}, true},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.msg); err != nil {
+			t.Fatal(err)
+		}
+
+		rect := NewRectangle(conn.Encodable)
+		err := rect.Read(conn)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			} else if _, ok := err.(*ProtocolError); !ok {
+				t.Errorf("%s: unexpected %T error: %s", tt.desc, err, err)
+			}
+		}
+		conn.fbWidth, conn.fbHeight = 100, 50 // Undo any DesktopSize change.
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 100, 100
+
+	for _, tt := range []struct {
+		desc       string
+		msg        ServerMessage
+		data       []interface{}
+		strict, ok bool
+	}{
+		{"update padding", &FramebufferUpdate{},
+			[]interface{}{uint8(1), uint16(1), rectangleMessage{0, 0, 0, 0, encodings.Raw}}, false, true},
+		{"update padding", &FramebufferUpdate{},
+			[]interface{}{uint8(1), uint16(1), rectangleMessage{0, 0, 0, 0, encodings.Raw}}, true, false},
+		{"zero rects", &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(0)}, false, true},
+		{"zero rects", &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(0)}, true, false},
+		{"color map overflow", &SetColorMapEntries{},
+			[]interface{}{[1]byte{}, uint16(255), uint16(2), [12]byte{}}, false, true},
+		{"color map overflow", &SetColorMapEntries{},
+			[]interface{}{[1]byte{}, uint16(255), uint16(2), [12]byte{}}, true, false},
+		{"cut text padding", &ServerCutText{},
+			[]interface{}{[3]byte{0, 0, 1}, uint32(0)}, false, true},
+		{"cut text padding", &ServerCutText{},
+			[]interface{}{[3]byte{0, 0, 1}, uint32(0)}, true, false},
+		{"rect out of bounds", &FramebufferUpdate{},
+			[]interface{}{uint8(0), uint16(1), rectangleMessage{0, 0, 101, 1, encodings.Raw}}, false, false},
+	} {
+		mockConn.Reset()
+		conn.config.Strict = tt.strict
+		for _, d := range tt.data {
+			if err := conn.send(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, err := tt.msg.Read(conn)
+		if err == nil && !tt.ok {
+			t.Errorf("%s (strict=%t): expected error", tt.desc, tt.strict)
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s (strict=%t): unexpected error: %s", tt.desc, tt.strict, err)
+			} else if _, ok := err.(*ProtocolError); !ok {
+				t.Errorf("%s (strict=%t): unexpected %T error: %s", tt.desc, tt.strict, err, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Expect-style automation sessions, built on Screen and the input helpers.
+
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/keys"
+	"github.com/kward/go-vnc/logging"
+	"golang.org/x/net/context"
+)
+
+// DefaultStepTimeout is the default timeout of each step of a Session.
+const DefaultStepTimeout = 30 * time.Second
+
+// Session runs a script of automation steps against a connection: input,
+// waits for the screen, and assertions. The steps are chained, e.g.
+//
+//	err := vnc.NewSession(ctx, conn, screen).
+//		Timeout(5*time.Second).
+//		ClickImage(buttons.Left, loginButton).
+//		Type("user\n").
+//		WaitForImage(welcome).
+//		Err()
+//
+// The first step which fails stops the session; the remaining steps do
+// nothing, and Err returns a *StepError describing the failure, with a
+// screenshot of the screen at the time.
+type Session struct {
+	ctx    context.Context
+	c      *ClientConn
+	screen *Screen
+
+	timeout time.Duration
+	dir     string // Where to save screenshots of failures, if set.
+	step    int
+	err     error
+}
+
+// NewSession returns a session for the connection. The screen must be kept
+// up to date with the server messages of the connection (see Screen.Listen).
+// The session is stopped when the context is done.
+func NewSession(ctx context.Context, c *ClientConn, screen *Screen) *Session {
+	return &Session{
+		ctx:     ctx,
+		c:       c,
+		screen:  screen,
+		timeout: DefaultStepTimeout,
+	}
+}
+
+// StepError describes the step of a Session which failed.
+type StepError struct {
+	Step       int         // The number of the step, from 1.
+	Desc       string      // A description of the step.
+	Err        error       // Why it failed.
+	Screenshot *image.RGBA // The screen when it failed.
+	Path       string      // Where the screenshot was saved, if it was.
+}
+
+// Error implements the error interface.
+func (e *StepError) Error() string {
+	msg := fmt.Sprintf("step %d (%s): %s", e.Step, e.Desc, e.Err)
+	if e.Path != "" {
+		msg += fmt.Sprintf(" (screenshot %s)", e.Path)
+	}
+	return msg
+}
+
+// Unwrap returns why the step failed.
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Err returns the error of the step which failed, if any.
+func (s *Session) Err() error {
+	return s.err
+}
+
+// Timeout sets the timeout of the following steps.
+func (s *Session) Timeout(d time.Duration) *Session {
+	s.timeout = d
+	return s
+}
+
+// ScreenshotDir sets the directory where the screenshot of a failed step is
+// saved, as a PNG image named after the step.
+func (s *Session) ScreenshotDir(dir string) *Session {
+	s.dir = dir
+	return s
+}
+
+// Do runs fn as a step, with a context which times out after the step
+// timeout.
+func (s *Session) Do(desc string, fn func(ctx context.Context) error) *Session {
+	if s.err != nil {
+		return s
+	}
+	s.step++
+	if logging.V(logging.FlowLevel) {
+		glog.Infof("Session step %d: %s", s.step, desc)
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	err := fn(ctx)
+	if err == nil {
+		return s
+	}
+	if err == context.DeadlineExceeded && s.ctx.Err() == nil {
+		err = wrapErrorf(ErrTimeout, "timed out after %s", s.timeout)
+	}
+	s.fail(desc, err)
+	return s
+}
+
+// fail stops the session, taking a screenshot.
+func (s *Session) fail(desc string, err error) {
+	e := &StepError{Step: s.step, Desc: desc, Err: err, Screenshot: s.screen.Image()}
+	if s.dir != "" {
+		path := filepath.Join(s.dir, fmt.Sprintf("step-%03d.png", s.step))
+		if werr := writePNG(path, e.Screenshot); werr != nil {
+			glog.Errorf("saving screenshot: %s", werr)
+		} else {
+			e.Path = path
+		}
+	}
+	s.err = e
+}
+
+// writePNG writes the image as a PNG image.
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//-----------------------------------------------------------------------------
+// Input
+
+// Type types the text.
+func (s *Session) Type(text string) *Session {
+	return s.Do(fmt.Sprintf("type %q", text), func(context.Context) error {
+		return s.c.Type(text)
+	})
+}
+
+// Key presses the keys together, e.g. Key(keys.ControlLeft, keys.C).
+func (s *Session) Key(ks ...keys.Key) *Session {
+	return s.Do(fmt.Sprintf("key %v", ks), func(context.Context) error {
+		return s.c.KeyCombo(ks...)
+	})
+}
+
+// Move moves the pointer to (x, y).
+func (s *Session) Move(x, y uint16) *Session {
+	return s.Do(fmt.Sprintf("move %d,%d", x, y), func(context.Context) error {
+		return s.c.PointerEvent(buttons.None, x, y)
+	})
+}
+
+// Click clicks the button at (x, y).
+func (s *Session) Click(button buttons.Button, x, y uint16) *Session {
+	return s.Do(fmt.Sprintf("click %v at %d,%d", button, x, y), func(context.Context) error {
+		return s.c.Click(button, x, y)
+	})
+}
+
+// ClickImage waits for needle to appear on the screen, and then clicks the
+// button at its center.
+func (s *Session) ClickImage(button buttons.Button, needle image.Image) *Session {
+	return s.Do(fmt.Sprintf("click %v on image", button), func(ctx context.Context) error {
+		pt, err := s.waitForImage(ctx, needle)
+		if err != nil {
+			return err
+		}
+		size := needle.Bounds().Size()
+		return s.c.Click(button, uint16(pt.X+size.X/2), uint16(pt.Y+size.Y/2))
+	})
+}
+
+// Pause waits for the duration.
+func (s *Session) Pause(d time.Duration) *Session {
+	return s.Do(fmt.Sprintf("pause %s", d), func(ctx context.Context) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+//-----------------------------------------------------------------------------
+// Waits
+
+// WaitForChange waits for the region of the screen to change.
+func (s *Session) WaitForChange(region image.Rectangle) *Session {
+	return s.Do(fmt.Sprintf("wait for change of %v", region), func(ctx context.Context) error {
+		return s.screen.WaitForChange(ctx, region)
+	})
+}
+
+// WaitForMatch waits for the region of the screen to match img, with each
+// color channel differing by at most tolerance.
+func (s *Session) WaitForMatch(region image.Rectangle, img image.Image, tolerance int) *Session {
+	return s.Do(fmt.Sprintf("wait for match of %v", region), func(ctx context.Context) error {
+		return s.screen.WaitForMatch(ctx, region, img, tolerance)
+	})
+}
+
+// WaitForImage waits for needle to appear anywhere on the screen.
+func (s *Session) WaitForImage(needle image.Image) *Session {
+	return s.Do("wait for image", func(ctx context.Context) error {
+		_, err := s.waitForImage(ctx, needle)
+		return err
+	})
+}
+
+// waitForImage refreshes the screen, and then waits for needle to appear on
+// it, returning its position.
+func (s *Session) waitForImage(ctx context.Context, needle image.Image) (image.Point, error) {
+	if err := s.screen.Refresh(ctx); err != nil {
+		return image.Point{}, err
+	}
+	for {
+		if pt, ok := s.screen.FindImage(needle); ok {
+			return pt, nil
+		}
+		if err := s.screen.WaitForChange(ctx, s.screen.Bounds()); err != nil {
+			return image.Point{}, err
+		}
+	}
+}
+
+//-----------------------------------------------------------------------------
+// Assertions
+
+// Assert refreshes the screen, and checks that ok returns true for it.
+func (s *Session) Assert(desc string, ok func(img *image.RGBA) bool) *Session {
+	return s.Do("assert "+desc, func(ctx context.Context) error {
+		if err := s.screen.Refresh(ctx); err != nil {
+			return err
+		}
+		if !ok(s.screen.Image()) {
+			return Errorf("assertion failed")
+		}
+		return nil
+	})
+}
+
+// AssertImage refreshes the screen, and checks that needle is on it.
+func (s *Session) AssertImage(needle image.Image) *Session {
+	return s.Do("assert image", func(ctx context.Context) error {
+		if err := s.screen.Refresh(ctx); err != nil {
+			return err
+		}
+		if _, ok := s.screen.FindImage(needle); !ok {
+			return Errorf("image not found")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,86 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kward/go-vnc/buttons"
+	"github.com/kward/go-vnc/messages"
+	"golang.org/x/net/context"
+)
+
+func TestSession(t *testing.T) {
+	SetSettle(0)
+	s, sc := newTestScreen(
+		rawUpdate(2, 1, Color{R: 0xffff}), // For ClickImage.
+		rawUpdate(0, 0, Color{}),          // For AssertImage.
+	)
+	needle := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	needle.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+
+	err := NewSession(context.Background(), s.c, s).
+		Timeout(5*time.Second).
+		ClickImage(buttons.Left, needle).
+		AssertImage(needle).
+		Err()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for i, want := range []PointerEventMessage{
+		{messages.PointerEvent, uint8(buttons.Left), 2, 1},
+		{messages.PointerEvent, uint8(buttons.None), 2, 1},
+	} {
+		var got PointerEventMessage
+		if err := s.c.receive(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("event %d = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestSession_Failure(t *testing.T) {
+	SetSettle(0)
+	s, _ := newTestScreen()
+	dir, err := os.MkdirTemp("", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ran bool
+	sess := NewSession(context.Background(), s.c, s).
+		Timeout(10*time.Millisecond).
+		ScreenshotDir(dir).
+		Move(1, 1).
+		WaitForChange(image.Rect(0, 0, 2, 2)).
+		Do("never", func(context.Context) error { ran = true; return nil })
+
+	var serr *StepError
+	if !errors.As(sess.Err(), &serr) {
+		t.Fatalf("error = %v, want a StepError", sess.Err())
+	}
+	if got, want := serr.Step, 2; got != want {
+		t.Errorf("Step = %d, want %d", got, want)
+	}
+	if !errors.Is(serr, ErrTimeout) {
+		t.Errorf("error = %v, want %v", serr, ErrTimeout)
+	}
+	if ran {
+		t.Error("step after the failure was run")
+	}
+	if got, want := serr.Screenshot.Bounds(), image.Rect(0, 0, 4, 3); got != want {
+		t.Errorf("screenshot bounds = %v, want %v", got, want)
+	}
+	if _, err := os.Stat(serr.Path); err != nil {
+		t.Errorf("screenshot not saved: %s", err)
+	}
+}
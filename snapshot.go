@@ -0,0 +1,169 @@
+// Snapshots of the state of connections, for resuming them in another process.
+
+package vnc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// State is a snapshot of the essential state of a connection, and optionally
+// of its framebuffer. A monitoring process can checkpoint a connection with
+// it, and hand off the file descriptor of the network connection to a new
+// process, which resumes the connection with Resume, without a handshake.
+type State struct {
+	ProtocolVersion ProtocolVersion // The negotiated protocol version.
+	ServerVersion   ProtocolVersion
+	Profile         Profile
+	DesktopName     string
+	Width, Height   uint16
+	PixelFormat     PixelFormat
+	Encodings       []encodings.Encoding
+	ColorMap        [][3]uint16 `json:",omitempty"` // Only with color map pixel formats.
+
+	// Framebuffer holds the red, green and blue values of each pixel, in the
+	// pixel format, as big-endian uint16s, in row-major order. It is empty if
+	// the framebuffer wasn't saved.
+	Framebuffer []byte `json:",omitempty"`
+}
+
+// State returns a snapshot of the state of the connection, including the
+// framebuffer fb, if non-nil, and the MemoryBudget of the connection has room
+// for it. It must not be called while messages are read from the server.
+func (c *ClientConn) State(fb *Framebuffer) *State {
+	st := &State{
+		ProtocolVersion: c.ProtocolVersion(),
+		ServerVersion:   c.serverVersion,
+		Profile:         c.profile,
+		DesktopName:     c.DesktopName(),
+		Width:           c.FramebufferWidth(),
+		Height:          c.FramebufferHeight(),
+		PixelFormat:     c.PixelFormat(),
+	}
+	for _, e := range c.Encodings() {
+		st.Encodings = append(st.Encodings, e.Type())
+	}
+	if !rfbflags.IsTrueColor(st.PixelFormat.TrueColor) {
+		st.ColorMap = make([][3]uint16, len(c.colorMap))
+		for i, color := range c.colorMap {
+			st.ColorMap[i] = [3]uint16{color.R, color.G, color.B}
+		}
+	}
+	if fb != nil && fb.Width == int(st.Width) && fb.Height == int(st.Height) {
+		// The snapshot is the caller's once returned, so only the room for
+		// it is checked.
+		n := 6 * int64(len(fb.Pixels))
+		if !c.budget.reserve(n, false) {
+			c.logger().Print("Leaving the framebuffer out of the snapshot, over the memory budget.")
+			return st
+		}
+		defer c.budget.release(n)
+		st.Framebuffer = make([]byte, 6*len(fb.Pixels))
+		for i, p := range fb.Pixels {
+			binary.BigEndian.PutUint16(st.Framebuffer[6*i:], p.R)
+			binary.BigEndian.PutUint16(st.Framebuffer[6*i+2:], p.G)
+			binary.BigEndian.PutUint16(st.Framebuffer[6*i+4:], p.B)
+		}
+	}
+	return st
+}
+
+// State returns a snapshot of the state of the connection of the screen,
+// including its framebuffer. See ClientConn.State.
+func (s *Screen) State() *State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c.State(s.fb)
+}
+
+// ReadState reads a state written by State.Write.
+func ReadState(r io.Reader) (*State, error) {
+	st := &State{}
+	if err := json.NewDecoder(r).Decode(st); err != nil {
+		return nil, wrapErrorf(err, "reading state: %s", err)
+	}
+	if n := 6 * int(st.Width) * int(st.Height); len(st.Framebuffer) != 0 && len(st.Framebuffer) != n {
+		return nil, Errorf("reading state: framebuffer length %d, want %d", len(st.Framebuffer), n)
+	}
+	if len(st.ColorMap) > len(ColorMap{}) {
+		return nil, Errorf("reading state: color map has %d entries", len(st.ColorMap))
+	}
+	return st, nil
+}
+
+// Write writes the state as JSON.
+func (st *State) Write(w io.Writer) error {
+	return json.NewEncoder(w).Encode(st)
+}
+
+// Resume returns a connection over c, in the state st, without a handshake.
+// The encodings of the state are those of cfg.Encodings, or the built-in
+// encodings, of the same type; others are only advertised.
+func Resume(c io.ReadWriteCloser, cfg *ClientConfig, st *State) (*ClientConn, error) {
+	conn := NewClientConn(c, cfg)
+	switch st.ProtocolVersion {
+	case ProtocolVersion33:
+		conn.protocolVersion = PROTO_VERS_3_3
+	case ProtocolVersion38:
+		conn.protocolVersion = PROTO_VERS_3_8
+	default:
+		return nil, wrapErrorf(ErrUnsupportedVersion, "unable to resume protocol version %v", st.ProtocolVersion)
+	}
+	conn.serverVersion = st.ServerVersion
+	conn.profile = st.Profile
+	conn.desktopName = st.DesktopName
+	conn.fbWidth, conn.fbHeight = st.Width, st.Height
+	conn.pixelFormat = st.PixelFormat
+	for i, rgb := range st.ColorMap {
+		conn.colorMap[i] = Color{R: rgb[0], G: rgb[1], B: rgb[2]}
+	}
+
+	known := append(append(Encodings{}, cfg.Encodings...), builtinEncodings()...)
+	conn.encodings = nil
+FindEncoding:
+	for _, t := range st.Encodings {
+		for _, e := range known {
+			if e.Type() == t {
+				conn.encodings = append(conn.encodings, e)
+				continue FindEncoding
+			}
+		}
+		conn.encodings = append(conn.encodings, &advertisedEncoding{t})
+	}
+	return conn, nil
+}
+
+// NewFramebufferFromState returns the framebuffer of the state, for the
+// connection resumed from it, or nil if the framebuffer wasn't saved.
+func NewFramebufferFromState(c *ClientConn, st *State) *Framebuffer {
+	if len(st.Framebuffer) == 0 {
+		return nil
+	}
+	fb := NewFramebuffer(int(st.Width), int(st.Height))
+	pf := c.readPixelFormat()
+	for i := range fb.Pixels {
+		fb.Pixels[i] = Color{
+			pf: pf,
+			cm: &c.colorMap,
+			R:  binary.BigEndian.Uint16(st.Framebuffer[6*i:]),
+			G:  binary.BigEndian.Uint16(st.Framebuffer[6*i+2:]),
+			B:  binary.BigEndian.Uint16(st.Framebuffer[6*i+4:]),
+		}
+	}
+	return fb
+}
+
+// ResumeScreen returns a Screen for the connection resumed from the state,
+// holding the framebuffer of the state, if saved.
+func ResumeScreen(c *ClientConn, st *State) *Screen {
+	s := NewScreen(c)
+	if fb := NewFramebufferFromState(c, st); fb != nil {
+		s.fb = fb
+		s.reserve()
+	}
+	return s
+}
@@ -0,0 +1,105 @@
+package vnc
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+func TestState(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.protocolVersion = PROTO_VERS_3_8
+	conn.serverVersion = ProtocolVersionApple
+	conn.profile = ProfileApple
+	conn.desktopName = "desktop"
+	conn.fbWidth, conn.fbHeight = 2, 1
+	conn.pixelFormat = PixelFormatApple
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}, &FineQualityPseudoEncoding{80}}
+	fb := NewFramebuffer(2, 1)
+	fb.Pixels[0] = Color{pf: &conn.pixelFormat, R: 0xff}
+	fb.Pixels[1] = Color{pf: &conn.pixelFormat, G: 0x80, B: 0x40}
+
+	var buf bytes.Buffer
+	if err := conn.State(fb).Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	st, err := ReadState(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := Resume(&MockConn{}, &ClientConfig{}, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := resumed.ProtocolVersion(), ProtocolVersion38; got != want {
+		t.Errorf("ProtocolVersion() = %v, want = %v", got, want)
+	}
+	if got, want := resumed.ServerProtocolVersion(), ProtocolVersionApple; got != want {
+		t.Errorf("ServerProtocolVersion() = %v, want = %v", got, want)
+	}
+	if got, want := resumed.Profile(), ProfileApple; got != want {
+		t.Errorf("Profile() = %v, want = %v", got, want)
+	}
+	if got, want := resumed.DesktopName(), "desktop"; got != want {
+		t.Errorf("DesktopName() = %q, want = %q", got, want)
+	}
+	if w, h := resumed.FramebufferWidth(), resumed.FramebufferHeight(); w != 2 || h != 1 {
+		t.Errorf("framebuffer size = %dx%d, want 2x1", w, h)
+	}
+	if got, want := resumed.PixelFormat(), PixelFormatApple; got != want {
+		t.Errorf("PixelFormat() = %v, want = %v", got, want)
+	}
+	var encs []encodings.Encoding
+	for _, e := range resumed.Encodings() {
+		encs = append(encs, e.Type())
+	}
+	if want := []encodings.Encoding{encodings.Raw, encodings.DesktopSizePseudo, -432}; !reflect.DeepEqual(encs, want) {
+		t.Errorf("Encodings() = %v, want = %v", encs, want)
+	}
+	if _, ok := resumed.Encodings()[1].(*DesktopSizePseudoEncoding); !ok {
+		t.Errorf("encoding = %T, want *DesktopSizePseudoEncoding", resumed.Encodings()[1])
+	}
+
+	screen := ResumeScreen(resumed, st)
+	if got, want := screen.Image(), fb.Image(); !reflect.DeepEqual(got, want) {
+		t.Errorf("screen image = %v, want = %v", got.Pix, want.Pix)
+	}
+}
+
+func TestState_ColorMap(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.protocolVersion = PROTO_VERS_3_3
+	conn.pixelFormat = NewPixelFormat(8)
+	conn.colorMap[7] = Color{R: 1, G: 2, B: 3}
+
+	resumed, err := Resume(&MockConn{}, &ClientConfig{}, conn.State(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := resumed.colorMap[7], (Color{R: 1, G: 2, B: 3}); got != want {
+		t.Errorf("color map entry = %v, want = %v", got, want)
+	}
+}
+
+func TestReadState_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		data string
+	}{
+		{"invalid JSON", `{`},
+		{"framebuffer length", `{"Width":1,"Height":1,"Framebuffer":"AAAA"}`},
+	} {
+		if _, err := ReadState(bytes.NewBufferString(tt.data)); err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+	}
+
+	_, err := Resume(&MockConn{}, &ClientConfig{}, &State{})
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("Resume() error = %v, want %v", err, ErrUnsupportedVersion)
+	}
+}
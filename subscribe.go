@@ -0,0 +1,80 @@
+// Typed subscriptions to the messages from the server.
+
+package vnc
+
+import "sync"
+
+// subscriptionBuffer is the number of messages buffered by each subscription.
+const subscriptionBuffer = 16
+
+// Subscribe returns a channel receiving the messages of type T read by
+// ListenAndHandle from now on, e.g. Subscribe[*ServerCutText](conn), without
+// a type switch over the messages of the ServerMessageCh, which still
+// receives every message. The channel is closed when ListenAndHandle returns.
+//
+// Like the ServerMessageCh, if the channel blocks, then the goroutine reading
+// from the server blocks, so it must be read until closed.
+func Subscribe[T ServerMessage](c *ClientConn) <-chan T {
+	ch := make(chan T, subscriptionBuffer)
+	c.subs.add(&subscription{
+		send: func(msg ServerMessage) bool {
+			m, ok := msg.(T)
+			if ok {
+				ch <- m
+			}
+			return ok
+		},
+		close: func() { close(ch) },
+	})
+	return ch
+}
+
+// subscription delivers the messages of a type to a subscriber.
+type subscription struct {
+	send  func(msg ServerMessage) bool // Sends msg, if of the type, returning whether it was.
+	close func()
+}
+
+// subscriptions are the subscriptions to the messages of a connection.
+type subscriptions struct {
+	mu     sync.Mutex
+	subs   []*subscription
+	closed bool
+}
+
+// add adds the subscription, which is closed at once if the messages are no
+// longer read.
+func (ss *subscriptions) add(s *subscription) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if ss.closed {
+		s.close()
+		return
+	}
+	ss.subs = append(ss.subs, s)
+}
+
+// send sends the message to the subscriptions of its type, returning whether
+// there were any.
+func (ss *subscriptions) send(msg ServerMessage) bool {
+	ss.mu.Lock()
+	subs := ss.subs
+	ss.mu.Unlock()
+	sent := false
+	for _, s := range subs {
+		if s.send(msg) {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// close closes the subscriptions, once the messages are no longer read.
+func (ss *subscriptions) close() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for _, s := range ss.subs {
+		s.close()
+	}
+	ss.subs, ss.closed = nil, true
+}
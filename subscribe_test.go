@@ -0,0 +1,46 @@
+package vnc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/kward/go-vnc/messages"
+)
+
+func TestSubscribe(t *testing.T) {
+	stream := []byte{
+		byte(messages.Bell),
+		byte(messages.ServerCutText), 0, 0, 0, 0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o',
+		byte(messages.Bell),
+	}
+	cfg := NewClientConfig("")
+	cfg.Logger = log.New(ioutil.Discard, "", 0)
+	conn := NewClientConn(NewStreamConn(bytes.NewReader(stream), ioutil.Discard), cfg)
+	cuts := Subscribe[*ServerCutText](conn)
+	bells := Subscribe[*Bell](conn)
+	if err := conn.ListenAndHandle(); err != nil {
+		t.Fatal(err)
+	}
+
+	var texts []string
+	for cut := range cuts {
+		texts = append(texts, cut.Text)
+	}
+	if len(texts) != 1 || texts[0] != "hello" {
+		t.Errorf("cut texts = %q, want [hello]", texts)
+	}
+	n := 0
+	for range bells {
+		n++
+	}
+	if got, want := n, 2; got != want {
+		t.Errorf("bells = %d, want %d", got, want)
+	}
+
+	// Subscriptions made once the messages are no longer read are closed.
+	if _, ok := <-Subscribe[*Bell](conn); ok {
+		t.Error("subscription open after ListenAndHandle returned")
+	}
+}
@@ -0,0 +1,125 @@
+//go:build ignore
+// +build ignore
+
+// gen generates the update stream corpora used by the decoder benchmarks.
+//
+// Usage:
+//
+//	$ cd testdata/corpus && go run gen.go
+//
+// Each corpus file holds a ServerInit message (without the leading
+// message-type), followed by a stream of server messages exactly as they
+// would appear on the wire. The pixel data is a deterministic pseudo-random
+// pattern mixed with flat areas, to roughly resemble a desktop.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"math/rand"
+)
+
+type pixelFormat struct {
+	BPP                             uint8
+	Depth                           uint8
+	BigEndian                       uint8
+	TrueColor                       uint8
+	RedMax, GreenMax, BlueMax       uint16
+	RedShift, GreenShift, BlueShift uint8
+	_                               [3]byte
+}
+
+type rect struct {
+	X, Y, W, H uint16
+	Enc        int32
+}
+
+const (
+	encRaw         = 0
+	encDesktopSize = -223
+)
+
+type corpus struct {
+	name  string
+	w, h  uint16
+	pf    pixelFormat
+	rects [][]rect // Rectangles per FramebufferUpdate.
+}
+
+var corpora = []corpus{
+	{
+		name: "raw-32bpp.rfb",
+		w:    128, h: 128,
+		pf: pixelFormat{32, 24, 0, 1, 255, 255, 255, 16, 8, 0, [3]byte{}},
+		rects: [][]rect{
+			{{0, 0, 128, 128, encRaw}},
+			{{10, 10, 32, 16, encRaw}, {64, 100, 48, 8, encRaw}},
+			{{0, 0, 128, 16, encRaw}, {0, 112, 128, 16, encRaw}},
+			{{0, 0, 128, 128, encDesktopSize}, {0, 0, 128, 128, encRaw}},
+		},
+	},
+	{
+		name: "raw-16bpp.rfb",
+		w:    128, h: 128,
+		pf: pixelFormat{16, 16, 0, 1, 31, 63, 31, 11, 5, 0, [3]byte{}},
+		rects: [][]rect{
+			{{0, 0, 128, 128, encRaw}},
+			{{10, 10, 32, 16, encRaw}, {64, 100, 48, 8, encRaw}},
+			{{0, 0, 128, 16, encRaw}, {0, 112, 128, 16, encRaw}},
+			{{0, 0, 128, 128, encDesktopSize}, {0, 0, 128, 128, encRaw}},
+		},
+	},
+}
+
+func main() {
+	rnd := rand.New(rand.NewSource(6143))
+	for _, c := range corpora {
+		var buf bytes.Buffer
+		name := []byte("corpus " + c.name)
+		w := func(v interface{}) {
+			if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// ServerInit.
+		w(c.w)
+		w(c.h)
+		w(c.pf)
+		w(uint32(len(name)))
+		w(name)
+
+		bpp := int(c.pf.BPP / 8)
+		for _, rects := range c.rects {
+			w(uint8(0)) // message-type: FramebufferUpdate
+			w(uint8(0)) // padding
+			w(uint16(len(rects)))
+			for _, r := range rects {
+				w(r)
+				if r.Enc != encRaw {
+					continue
+				}
+				for y := 0; y < int(r.H); y++ {
+					for x := 0; x < int(r.W); x++ {
+						var p uint32
+						if (x/16+y/16)%3 != 0 {
+							p = rnd.Uint32()
+						} else {
+							p = uint32(x*y) & 0x00ffffff
+						}
+						px := make([]byte, 4)
+						binary.LittleEndian.PutUint32(px, p)
+						buf.Write(px[:bpp])
+					}
+				}
+			}
+		}
+
+		if err := ioutil.WriteFile(c.name, buf.Bytes(), 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("wrote %s (%d bytes)", c.name, buf.Len())
+	}
+}
@@ -0,0 +1,225 @@
+// The capabilities of the Tight security type, of TightVNC servers.
+
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+)
+
+const secTypeTight = uint8(16)
+
+// Vendors of Tight capabilities.
+const (
+	TightVendorStandard = "STDV" // Defined by the RFB protocol.
+	TightVendorTight    = "TGHT" // Defined by TightVNC.
+)
+
+// Tight capability codes, of the tunneling and authentication types.
+const (
+	tightNoTunneling = int32(0)
+	tightAuthNone    = int32(1)
+	tightAuthVNC     = int32(2)
+)
+
+// DefaultMaxTightCapabilities is the default limit on the number of
+// capabilities of each list sent by a server.
+const DefaultMaxTightCapabilities = 1024
+
+// tightCapabilityLen is the length of a capability on the wire: its code,
+// vendor and name.
+const tightCapabilityLen = 16
+
+// TightCapability is a capability advertised by a server with the Tight
+// security type: a tunneling or authentication type, a message, or an
+// encoding. Its code is the message or encoding type, and is only unique
+// together with its vendor and name.
+type TightCapability struct {
+	Code   int32
+	Vendor string // 4 characters, e.g. TGHT.
+	Name   string // 8 characters, e.g. FTC_LSRQ.
+}
+
+// String implements the fmt.Stringer interface.
+func (c TightCapability) String() string {
+	return fmt.Sprintf("%d %s/%s", c.Code, c.Vendor, c.Name)
+}
+
+// TightCapabilityList is a list of capabilities.
+type TightCapabilityList []TightCapability
+
+// Has returns whether the list holds the capability of vendor and name.
+func (l TightCapabilityList) Has(vendor, name string) bool {
+	_, ok := l.Lookup(vendor, name)
+	return ok
+}
+
+// Lookup returns the capability of vendor and name, e.g. to learn the type of
+// a message.
+func (l TightCapabilityList) Lookup(vendor, name string) (TightCapability, bool) {
+	for _, c := range l {
+		if c.Vendor == vendor && c.Name == name {
+			return c, true
+		}
+	}
+	return TightCapability{}, false
+}
+
+// TightCapabilities are the capabilities advertised by a server with the Tight
+// security type.
+type TightCapabilities struct {
+	Tunnels TightCapabilityList // The tunneling types.
+	Auth    TightCapabilityList // The authentication types.
+
+	// The messages, and encodings, which the server supports beyond those of
+	// the RFB protocol. They are sent after the ServerInit message.
+	ServerMessages TightCapabilityList
+	ClientMessages TightCapabilityList
+	Encodings      TightCapabilityList
+}
+
+// FileTransfer returns whether the server supports the file transfer
+// extension of TightVNC.
+func (c *TightCapabilities) FileTransfer() bool {
+	return c.ClientMessages.Has(TightVendorTight, "FTC_LSRQ") &&
+		c.ServerMessages.Has(TightVendorTight, "FTS_LSDT")
+}
+
+// TightCapabilities returns the capabilities advertised by the server, or nil
+// unless the Tight security type was negotiated. The message and encoding
+// capabilities are only known once the connection is initialized.
+func (c *ClientConn) TightCapabilities() *TightCapabilities {
+	return c.tight
+}
+
+//-----------------------------------------------------------------------------
+
+// ClientAuthTight is the Tight security type of TightVNC servers, which
+// advertises their capabilities (see ClientConn.TightCapabilities). No
+// tunneling is used, and the authentication is either None or VNC, the latter
+// if Password is set.
+type ClientAuthTight struct {
+	Password string
+}
+
+// Verify that interfaces are honored.
+var _ ClientAuth = (*ClientAuthTight)(nil)
+
+func (*ClientAuthTight) SecurityType() uint8 {
+	return secTypeTight
+}
+
+func (auth *ClientAuthTight) Handshake(conn *ClientConn) error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("ClientAuthTight." + logging.FnName())
+	}
+
+	caps := &TightCapabilities{}
+	conn.tight = caps
+
+	var err error
+	if caps.Tunnels, err = conn.readTightCapabilities32(); err != nil {
+		return err
+	}
+	if len(caps.Tunnels) > 0 {
+		if !caps.Tunnels.Has(TightVendorTight, "NOTUNNEL") {
+			return wrapErrorf(ErrUnsupportedSecurity, "Tight security handshake failed; no supported tunneling types: %v", caps.Tunnels)
+		}
+		if err := conn.send(tightNoTunneling); err != nil {
+			return err
+		}
+	}
+
+	if caps.Auth, err = conn.readTightCapabilities32(); err != nil {
+		return err
+	}
+	if len(caps.Auth) == 0 {
+		return nil // No authentication.
+	}
+	for _, c := range caps.Auth {
+		var a ClientAuth
+		switch {
+		case c.Code == tightAuthNone && c.Vendor == TightVendorStandard:
+			a = &ClientAuthNone{}
+		case c.Code == tightAuthVNC && c.Vendor == TightVendorStandard && auth.Password != "":
+			a = &ClientAuthVNC{auth.Password}
+		default:
+			continue
+		}
+		if err := conn.send(c.Code); err != nil {
+			return err
+		}
+		return a.Handshake(conn)
+	}
+	return wrapErrorf(ErrUnsupportedSecurity, "Tight security handshake failed; no suitable auth types found; server supports: %v", caps.Auth)
+}
+
+// readTightCapabilities32 reads a list of capabilities preceded by its
+// uint32 length, as sent during the security handshake.
+func (c *ClientConn) readTightCapabilities32() (TightCapabilityList, error) {
+	hdr, err := c.readHeader(4)
+	if err != nil {
+		return nil, err
+	}
+	return c.readTightCapabilities(binary.BigEndian.Uint32(hdr))
+}
+
+// readTightCapabilities reads a list of n capabilities.
+func (c *ClientConn) readTightCapabilities(n uint32) (TightCapabilityList, error) {
+	if n > DefaultMaxTightCapabilities {
+		return nil, wrapErrorf(ErrLimitExceeded, "%d Tight capabilities exceed limit of %d", n, DefaultMaxTightCapabilities)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, n*tightCapabilityLen)
+	if err := c.readFull(buf); err != nil {
+		return nil, err
+	}
+	l := make(TightCapabilityList, n)
+	for i := range l {
+		b := buf[i*tightCapabilityLen:]
+		l[i] = TightCapability{
+			Code:   int32(binary.BigEndian.Uint32(b)),
+			Vendor: string(b[4:8]),
+			Name:   string(b[8:16]),
+		}
+	}
+	return l, nil
+}
+
+// tightInteractionCapabilities reads the message and encoding capabilities
+// which follow the ServerInit message once the Tight security type was
+// negotiated.
+func (c *ClientConn) tightInteractionCapabilities() error {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info(logging.FnName())
+	}
+
+	// number-of-server-messages, number-of-client-messages,
+	// number-of-encodings, padding
+	hdr, err := c.readHeader(8)
+	if err != nil {
+		return err
+	}
+	// Copy the counts out of the scratch space before reading further.
+	numServerMessages := uint32(binary.BigEndian.Uint16(hdr[0:]))
+	numClientMessages := uint32(binary.BigEndian.Uint16(hdr[2:]))
+	numEncodings := uint32(binary.BigEndian.Uint16(hdr[4:]))
+	if c.tight.ServerMessages, err = c.readTightCapabilities(numServerMessages); err != nil {
+		return err
+	}
+	if c.tight.ClientMessages, err = c.readTightCapabilities(numClientMessages); err != nil {
+		return err
+	}
+	if c.tight.Encodings, err = c.readTightCapabilities(numEncodings); err != nil {
+		return err
+	}
+	if logging.V(logging.ResultLevel) {
+		glog.Infof("Tight capabilities: %+v", *c.tight)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// writeTightCapabilities writes a list of capabilities, without its length.
+func writeTightCapabilities(w io.Writer, l TightCapabilityList) error {
+	for _, c := range l {
+		if err := binary.Write(w, binary.BigEndian, c.Code); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, c.Vendor+c.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	tightNoTunnel = TightCapability{0, "TGHT", "NOTUNNEL"}
+	tightNone     = TightCapability{1, "STDV", "NOAUTH__"}
+	tightVNC      = TightCapability{2, "STDV", "VNCAUTH_"}
+	tightUnixAuth = TightCapability{129, "TGHT", "ULGNAUTH"}
+)
+
+func TestClientAuthTight(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		password string
+		tunnels  TightCapabilityList
+		auth     TightCapabilityList
+		ok       bool
+		tunnel   bool  // Whether a tunneling type is chosen.
+		chosen   int32 // The authentication type chosen, if any.
+	}{
+		{"no tunnels or auth", "", nil, nil, true, false, 0},
+		{"none", "", TightCapabilityList{tightNoTunnel}, TightCapabilityList{tightNone}, true, true, 1},
+		{"vnc", "secret", nil, TightCapabilityList{tightUnixAuth, tightVNC}, true, false, 2},
+		{"vnc without password", "", nil, TightCapabilityList{tightVNC}, false, false, 0},
+		{"unsupported tunnel", "", TightCapabilityList{{1, "TGHT", "SSL_____"}}, nil, false, false, 0},
+	} {
+		mockConn := &MockConn{}
+		conn := NewClientConn(mockConn, &ClientConfig{})
+		binary.Write(mockConn, binary.BigEndian, uint32(len(tt.tunnels)))
+		writeTightCapabilities(mockConn, tt.tunnels)
+		if tt.ok || len(tt.tunnels) == 0 {
+			binary.Write(mockConn, binary.BigEndian, uint32(len(tt.auth)))
+			writeTightCapabilities(mockConn, tt.auth)
+		}
+		if tt.chosen == tightAuthVNC {
+			writeVNCAuthChallenge(mockConn)
+		}
+
+		err := (&ClientAuthTight{tt.password}).Handshake(conn)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error %v", tt.desc, err)
+			} else if !errors.Is(err, ErrUnsupportedSecurity) {
+				t.Errorf("%s: error %v, want ErrUnsupportedSecurity", tt.desc, err)
+			}
+			continue
+		}
+
+		caps := conn.TightCapabilities()
+		if got, want := len(caps.Tunnels), len(tt.tunnels); got != want {
+			t.Errorf("%s: len(Tunnels) = %d, want %d", tt.desc, got, want)
+		}
+		if got, want := len(caps.Auth), len(tt.auth); got != want {
+			t.Errorf("%s: len(Auth) = %d, want %d", tt.desc, got, want)
+		}
+		if tt.tunnel {
+			var code int32
+			if err := conn.receive(&code); err != nil || code != tightNoTunneling {
+				t.Errorf("%s: tunneling type = %d, %v; want %d", tt.desc, code, err, tightNoTunneling)
+			}
+		}
+		if tt.chosen != 0 {
+			var code int32
+			if err := conn.receive(&code); err != nil || code != tt.chosen {
+				t.Errorf("%s: auth type = %d, %v; want %d", tt.desc, code, err, tt.chosen)
+			}
+		}
+		if tt.chosen == tightAuthVNC {
+			if err := readVNCAuthResponse(mockConn); err != nil {
+				t.Errorf("%s: reading VNCAuth response: %v", tt.desc, err)
+			}
+		}
+	}
+}
+
+func TestTightInteractionCapabilities(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.config.secType = secTypeTight
+	conn.tight = &TightCapabilities{}
+
+	server := TightCapabilityList{{130, "TGHT", "FTS_LSDT"}}
+	client := TightCapabilityList{{130, "TGHT", "FTC_LSRQ"}, {131, "TGHT", "FTC_DNRQ"}}
+	encs := TightCapabilityList{{7, "TGHT", "TIGHT___"}}
+	binary.Write(mockConn, binary.BigEndian, []uint16{uint16(len(server)), uint16(len(client)), uint16(len(encs)), 0})
+	for _, l := range []TightCapabilityList{server, client, encs} {
+		writeTightCapabilities(mockConn, l)
+	}
+	if err := conn.tightInteractionCapabilities(); err != nil {
+		t.Fatal(err)
+	}
+
+	caps := conn.TightCapabilities()
+	if !caps.FileTransfer() {
+		t.Error("FileTransfer() = false, want true")
+	}
+	if got, ok := caps.ClientMessages.Lookup(TightVendorTight, "FTC_DNRQ"); !ok || got.Code != 131 {
+		t.Errorf("Lookup(FTC_DNRQ) = %v, %v; want code 131", got, ok)
+	}
+	if !caps.Encodings.Has(TightVendorTight, "TIGHT___") {
+		t.Error("Encodings lacks Tight")
+	}
+	if caps.Encodings.Has(TightVendorStandard, "TIGHT___") {
+		t.Error("Encodings has Tight of the wrong vendor")
+	}
+}
+
+func TestTightCapabilities_Limit(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	binary.Write(mockConn, binary.BigEndian, uint32(DefaultMaxTightCapabilities+1))
+	if _, err := conn.readTightCapabilities32(); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("readTightCapabilities32() = %v, want ErrLimitExceeded", err)
+	}
+}
+
+func TestTightCapabilities_NotNegotiated(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	if caps := conn.TightCapabilities(); caps != nil {
+		t.Errorf("TightCapabilities() = %v, want nil", caps)
+	}
+}
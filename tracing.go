@@ -0,0 +1,89 @@
+// Hooks for tracing connections, e.g. with OpenTelemetry.
+
+package vnc
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Tracer starts the spans in which the work of connections is traced.
+// Implement it to trace with a tracing system; for OpenTelemetry, Start would
+// call trace.Tracer.Start, and wrap the span.
+//
+// The spans traced are:
+//
+//	vnc.Connect          the whole of Connect
+//	vnc.Handshake        each stage of the handshake (attribute vnc.stage)
+//	vnc.ReadMessage      reading each server message (vnc.message_type)
+//	vnc.ReadRectangle    reading each rectangle of a FramebufferUpdate
+//	                     (vnc.encoding, vnc.x, vnc.y, vnc.width, vnc.height)
+//
+// Messages are traced as children of the context given to Connect, and
+// rectangles as children of their message.
+type Tracer interface {
+	// Start starts a span, as a child of the span of ctx, if any, returning
+	// a context holding the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	// SetAttribute sets an attribute of the span. The value is a string,
+	// int, or bool.
+	SetAttribute(key string, value interface{})
+	// End ends the span, recording the error the operation failed with, if
+	// not nil.
+	End(err error)
+}
+
+// noopSpan is the span of connections without a Tracer.
+type noopSpan struct{}
+
+// Verify that interfaces are honored.
+var _ Span = noopSpan{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) End(error)                        {}
+
+// startSpan starts a span with the Tracer of the connection, if it has one.
+func (c *ClientConn) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if c.config.Tracer == nil {
+		return ctx, noopSpan{}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return c.config.Tracer.Start(ctx, name)
+}
+
+// readRect reads a rectangle, recording its statistics and span.
+func (c *ClientConn) readRect(rect *Rectangle) error {
+	start, waited, received := time.Now(), c.readWait, c.metrics["bytes-received"].Value()
+	_, span := c.startSpan(c.msgCtx, "vnc.ReadRectangle")
+	err := rect.Read(c)
+	if err == nil {
+		c.stats.rect(start)
+		decode := time.Since(start) - (c.readWait - waited)
+		c.encStats.record(rect, c.metrics["bytes-received"].Value()-received, decode)
+		if rect.Enc != nil {
+			span.SetAttribute("vnc.encoding", rect.Enc.Type().String())
+		}
+		span.SetAttribute("vnc.x", int(rect.X))
+		span.SetAttribute("vnc.y", int(rect.Y))
+		span.SetAttribute("vnc.width", int(rect.Width))
+		span.SetAttribute("vnc.height", int(rect.Height))
+	}
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	if err := c.filterRect(rect); err != nil {
+		return err
+	}
+	if rect.changesScreen() {
+		c.recordActivity(time.Now())
+	}
+	return nil
+}
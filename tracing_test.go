@@ -0,0 +1,100 @@
+package vnc
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeTracer records spans in memory.
+type fakeTracer struct {
+	spans []*fakeSpan
+}
+
+type fakeSpan struct {
+	name   string
+	parent *fakeSpan
+	attrs  map[string]interface{}
+	ended  bool
+	err    error
+}
+
+type spanKey struct{}
+
+func (t *fakeTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	parent, _ := ctx.Value(spanKey{}).(*fakeSpan)
+	s := &fakeSpan{name: name, parent: parent, attrs: map[string]interface{}{}}
+	t.spans = append(t.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *fakeSpan) SetAttribute(key string, value interface{}) { s.attrs[key] = value }
+func (s *fakeSpan) End(err error)                              { s.ended, s.err = true, err }
+
+func TestTracing_Rectangles(t *testing.T) {
+	tracer := &fakeTracer{}
+	conn := NewClientConn(&MockConn{}, &ClientConfig{Tracer: tracer})
+	conn.pixelFormat = PixelFormat{} // No pixel data.
+	conn.fbWidth, conn.fbHeight = 640, 480
+
+	rects := []Rectangle{
+		{1, 2, 3, 4, &RawEncoding{}, conn.Encodable},
+		{5, 6, 7, 8, &RawEncoding{}, conn.Encodable},
+	}
+	bytes, err := newFramebufferUpdate(rects).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(bytes[1:]); err != nil { // Strip message-type.
+		t.Fatal(err)
+	}
+	if _, err := (&FramebufferUpdate{}).Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got, want := len(tracer.spans), len(rects); got != want {
+		t.Fatalf("got %d spans, want %d", got, want)
+	}
+	for i, s := range tracer.spans {
+		if got, want := s.name, "vnc.ReadRectangle"; got != want {
+			t.Errorf("span %d name = %q, want %q", i, got, want)
+		}
+		if !s.ended || s.err != nil {
+			t.Errorf("span %d ended = %v, err = %v", i, s.ended, s.err)
+		}
+		if got, want := s.attrs["vnc.x"], int(rects[i].X); got != want {
+			t.Errorf("span %d vnc.x = %v, want %v", i, got, want)
+		}
+		if got, want := s.attrs["vnc.encoding"], "Raw"; got != want {
+			t.Errorf("span %d vnc.encoding = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestTracing_Connect(t *testing.T) {
+	tracer := &fakeTracer{}
+	if _, err := Connect(context.Background(), &MockConn{}, &ClientConfig{Tracer: tracer}); err == nil {
+		t.Fatal("expected error")
+	}
+	if got, want := len(tracer.spans), 2; got != want {
+		t.Fatalf("got %d spans, want %d", got, want)
+	}
+	connect, stage := tracer.spans[0], tracer.spans[1]
+	if got, want := connect.name, "vnc.Connect"; got != want {
+		t.Errorf("span name = %q, want %q", got, want)
+	}
+	if got, want := stage.name, "vnc.Handshake"; got != want {
+		t.Errorf("span name = %q, want %q", got, want)
+	}
+	if got, want := stage.attrs["vnc.stage"], "ProtocolVersion"; got != want {
+		t.Errorf("vnc.stage = %v, want %v", got, want)
+	}
+	if stage.parent != connect {
+		t.Error("handshake span isn't a child of the connect span")
+	}
+	for _, s := range tracer.spans {
+		if !s.ended || s.err == nil {
+			t.Errorf("%s: ended = %v, err = %v; want ended with error", s.name, s.ended, s.err)
+		}
+	}
+}
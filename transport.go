@@ -0,0 +1,102 @@
+// Transports of the protocol other than network connections.
+
+package vnc
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// deadliner is implemented by connections with deadlines, e.g. a net.Conn.
+type deadliner interface {
+	SetDeadline(t time.Time) error
+}
+
+// streamDeadline emulates the deadline of a connection without deadlines,
+// e.g. a pipe, by closing it once the deadline passes.
+type streamDeadline struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired bool
+}
+
+// set sets the deadline t, or clears it if t is zero, calling close once it
+// passes.
+func (d *streamDeadline) set(t time.Time, close func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if t.IsZero() {
+		return
+	}
+	d.timer = time.AfterFunc(time.Until(t), func() {
+		d.mu.Lock()
+		d.expired = true
+		d.mu.Unlock()
+		close()
+	})
+}
+
+// isExpired returns whether a deadline has passed, closing the connection.
+func (d *streamDeadline) isExpired() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.expired
+}
+
+// setDeadline sets the deadline of the reads and writes of the connection.
+// A connection without deadlines is closed once the deadline passes, failing
+// them, and the connection with them.
+func (c *ClientConn) setDeadline(t time.Time) error {
+	if c.deadliner != nil {
+		return c.deadliner.SetDeadline(t)
+	}
+	c.streamDeadline.set(t, func() { c.c.Close() })
+	return nil
+}
+
+// deadlineExpired returns whether a deadline of a connection without
+// deadlines has passed, which closed it.
+func (c *ClientConn) deadlineExpired() bool {
+	return c.deadliner == nil && c.streamDeadline.isExpired()
+}
+
+//-----------------------------------------------------------------------------
+
+// streamConn joins a reader and a writer into a connection.
+type streamConn struct {
+	io.Reader
+	io.Writer
+}
+
+// Verify that interfaces are honored.
+var _ io.ReadWriteCloser = (*streamConn)(nil)
+
+// NewStreamConn returns a connection reading from r, and writing to w, e.g.
+// the os.Stdin and os.Stdout of a process started by inetd, or the stdout and
+// stdin of an SSH session. Closing it closes w, and then r, if they are
+// io.Closers.
+//
+// It has no deadlines, so the handshake timeouts, and the cancellation of
+// Connect, close it instead.
+func NewStreamConn(r io.Reader, w io.Writer) io.ReadWriteCloser {
+	return &streamConn{r, w}
+}
+
+// Close implements the io.Closer interface.
+func (s *streamConn) Close() error {
+	var err error
+	if c, ok := s.Writer.(io.Closer); ok {
+		err = c.Close()
+	}
+	if c, ok := s.Reader.(io.Closer); ok {
+		if rerr := c.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
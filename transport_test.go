@@ -0,0 +1,139 @@
+package vnc
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// closeRecorder records whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	io.Writer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+// closeNotifier closes a channel when closed.
+type closeNotifier struct {
+	io.Reader
+	io.Writer
+	closed chan struct{}
+}
+
+func (c *closeNotifier) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestStreamConn(t *testing.T) {
+	r := &closeRecorder{Reader: strings.NewReader("RFB")}
+	w := &closeRecorder{Writer: ioutil.Discard}
+	c := NewStreamConn(r, w)
+
+	b := make([]byte, 3)
+	if _, err := io.ReadFull(c, b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := string(b), "RFB"; got != want {
+		t.Errorf("incorrect read; got = %q, want = %q", got, want)
+	}
+	if _, err := c.Write(b); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !r.closed || !w.closed {
+		t.Errorf("not closed; reader = %v, writer = %v", r.closed, w.closed)
+	}
+
+	// Readers and writers which aren't closers are left alone.
+	if err := NewStreamConn(strings.NewReader(""), ioutil.Discard).Close(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+// newSilentStream returns a connection without deadlines to a server which
+// sends its protocol version, and then goes silent.
+func newSilentStream() io.ReadWriteCloser {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	go func() {
+		sw.Write([]byte("RFB 003.008\n"))
+		io.Copy(ioutil.Discard, sr) // Until the client closes.
+		sw.Close()
+	}()
+	return NewStreamConn(cr, cw)
+}
+
+func TestHandshakeTimeouts_Stream(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		timeouts HandshakeTimeouts
+		ctx      time.Duration
+	}{
+		{"security timeout", HandshakeTimeouts{Security: 50 * time.Millisecond}, 0},
+		{"context deadline", HandshakeTimeouts{}, 50 * time.Millisecond},
+	} {
+		ctx := context.Background()
+		if tt.ctx > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tt.ctx)
+			defer cancel()
+		}
+
+		start := time.Now()
+		_, err := Connect(ctx, newSilentStream(), &ClientConfig{Timeouts: tt.timeouts})
+		if err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if got, max := time.Since(start), 5*time.Second; got > max {
+			t.Errorf("%s: Connect() took %v, want < %v", tt.desc, got, max)
+		}
+		if got, want := err.Error(), "Security handshake"; !strings.Contains(got, want) {
+			t.Errorf("%s: error = %q, want to contain %q", tt.desc, got, want)
+		}
+		if tt.ctx == 0 && !errors.Is(err, ErrTimeout) {
+			t.Errorf("%s: error = %v, want errors.Is(ErrTimeout)", tt.desc, err)
+		}
+	}
+}
+
+func TestWatchContext_Stream(t *testing.T) {
+	// Once done, the context expires the deadline, which closes the stream.
+	closed := make(chan struct{})
+	conn := NewClientConn(&closeNotifier{strings.NewReader(""), ioutil.Discard, closed}, &ClientConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	unwatch := conn.watchContext(ctx)
+	defer unwatch()
+	cancel()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream not closed")
+	}
+
+	// Once unwatched, the context is ignored.
+	rc := &closeRecorder{Reader: strings.NewReader(""), Writer: ioutil.Discard}
+	conn = NewClientConn(rc, &ClientConfig{})
+	ctx, cancel = context.WithCancel(context.Background())
+	unwatch = conn.watchContext(ctx)
+	unwatch()
+	unwatch()
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if conn.deadlineExpired() || rc.closed {
+		t.Errorf("context done after unwatching; deadline expired = %v, closed = %v", conn.deadlineExpired(), rc.closed)
+	}
+}
@@ -0,0 +1,183 @@
+// UltraVNC Server-to-Client messages, read with ProfileUltraVNC.
+
+package vnc
+
+import (
+	"encoding/binary"
+
+	"github.com/golang/glog"
+	"github.com/kward/go-vnc/logging"
+	"github.com/kward/go-vnc/messages"
+)
+
+// ultraServerMessages are the messages read with ProfileUltraVNC.
+var ultraServerMessages = []ServerMessage{
+	&UltraResizeFrameBuffer{},
+	&UltraFileTransfer{},
+	&UltraTextChat{},
+	&UltraKeepAlive{},
+	&UltraServerState{},
+}
+
+// Verify that interfaces are honored.
+var _ ServerMessage = (*UltraResizeFrameBuffer)(nil)
+var _ ServerMessage = (*UltraFileTransfer)(nil)
+var _ ServerMessage = (*UltraTextChat)(nil)
+var _ ServerMessage = (*UltraKeepAlive)(nil)
+var _ ServerMessage = (*UltraServerState)(nil)
+
+//-----------------------------------------------------------------------------
+
+// UltraResizeFrameBuffer indicates that the server resized the framebuffer.
+type UltraResizeFrameBuffer struct {
+	Width, Height uint16
+}
+
+// Type implements the ServerMessage interface.
+func (*UltraResizeFrameBuffer) Type() messages.ServerMessage {
+	return messages.UltraResizeFrameBuffer
+}
+
+// Read implements the ServerMessage interface.
+func (*UltraResizeFrameBuffer) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("UltraResizeFrameBuffer." + logging.FnName())
+	}
+	hdr, err := c.readHeader(5) // padding, width, height
+	if err != nil {
+		return nil, err
+	}
+	m := &UltraResizeFrameBuffer{
+		Width:  binary.BigEndian.Uint16(hdr[1:]),
+		Height: binary.BigEndian.Uint16(hdr[3:]),
+	}
+	if err := c.config.Limits.checkFramebuffer(m.Width, m.Height); err != nil {
+		return nil, err
+	}
+	c.setFramebufferSize(m.Width, m.Height)
+	c.publish(Event{Kind: EventResized, Width: m.Width, Height: m.Height})
+	return m, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// UltraFileTransfer holds a message of the UltraVNC file transfer protocol.
+// File transfers aren't supported; the message is read so that it can be
+// ignored.
+type UltraFileTransfer struct {
+	ContentType  uint8
+	ContentParam uint8
+	Size         uint32
+	Data         []byte
+}
+
+// Type implements the ServerMessage interface.
+func (*UltraFileTransfer) Type() messages.ServerMessage { return messages.UltraFileTransfer }
+
+// Read implements the ServerMessage interface.
+func (*UltraFileTransfer) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("UltraFileTransfer." + logging.FnName())
+	}
+	hdr, err := c.readHeader(11) // content-type, content-param, padding, size, length
+	if err != nil {
+		return nil, err
+	}
+	m := &UltraFileTransfer{
+		ContentType:  hdr[0],
+		ContentParam: hdr[1],
+		Size:         binary.BigEndian.Uint32(hdr[3:]),
+	}
+	length := binary.BigEndian.Uint32(hdr[7:])
+	if max := c.config.Limits.maxCutTextLength(); length > max {
+		return nil, wrapErrorf(ErrLimitExceeded, "file transfer length %d exceeds limit of %d", length, max)
+	}
+	m.Data = make([]byte, length)
+	if err := c.readFull(m.Data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// Control values of UltraTextChat messages, sent in place of the text length.
+const (
+	UltraTextChatOpen     uint32 = 0xffffffff
+	UltraTextChatClose    uint32 = 0xfffffffe
+	UltraTextChatFinished uint32 = 0xfffffffd
+)
+
+// UltraTextChat holds a message of the UltraVNC text chat.
+type UltraTextChat struct {
+	Control uint32 // One of the UltraTextChat values, or zero for text.
+	Text    string
+}
+
+// Type implements the ServerMessage interface.
+func (*UltraTextChat) Type() messages.ServerMessage { return messages.UltraTextChat }
+
+// Read implements the ServerMessage interface.
+func (*UltraTextChat) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("UltraTextChat." + logging.FnName())
+	}
+	hdr, err := c.readHeader(7) // padding, length
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(hdr[3:])
+	if length >= UltraTextChatFinished {
+		return &UltraTextChat{Control: length}, nil
+	}
+	if max := c.config.Limits.maxCutTextLength(); length > max {
+		return nil, wrapErrorf(ErrLimitExceeded, "text chat length %d exceeds limit of %d", length, max)
+	}
+	text := make([]byte, length)
+	if err := c.readFull(text); err != nil {
+		return nil, err
+	}
+	return &UltraTextChat{Text: string(text)}, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// UltraKeepAlive is sent by the server to keep idle connections alive.
+type UltraKeepAlive struct{}
+
+// Type implements the ServerMessage interface.
+func (*UltraKeepAlive) Type() messages.ServerMessage { return messages.UltraKeepAlive }
+
+// Read implements the ServerMessage interface.
+func (*UltraKeepAlive) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("UltraKeepAlive." + logging.FnName())
+	}
+	return &UltraKeepAlive{}, nil
+}
+
+//-----------------------------------------------------------------------------
+
+// UltraServerState reports a change of the state of the server, e.g. whether
+// the remote input is blocked.
+type UltraServerState struct {
+	State, Value uint32
+}
+
+// Type implements the ServerMessage interface.
+func (*UltraServerState) Type() messages.ServerMessage { return messages.UltraServerState }
+
+// Read implements the ServerMessage interface.
+func (*UltraServerState) Read(c *ClientConn) (ServerMessage, error) {
+	if logging.V(logging.FnDeclLevel) {
+		glog.Info("UltraServerState." + logging.FnName())
+	}
+	hdr, err := c.readHeader(11) // padding, state, value
+	if err != nil {
+		return nil, err
+	}
+	return &UltraServerState{
+		State: binary.BigEndian.Uint32(hdr[3:]),
+		Value: binary.BigEndian.Uint32(hdr[7:]),
+	}, nil
+}
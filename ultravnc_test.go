@@ -0,0 +1,100 @@
+package vnc
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+func TestUltraServerMessages(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		data []byte
+		want ServerMessage
+	}{
+		{"resize frame buffer",
+			[]byte{4, 0, 0x03, 0x20, 0x02, 0x58},
+			&UltraResizeFrameBuffer{Width: 800, Height: 600}},
+		{"file transfer",
+			[]byte{7, 1, 2, 0, 0, 0, 0, 9, 0, 0, 0, 2, 'a', 'b'},
+			&UltraFileTransfer{ContentType: 1, ContentParam: 2, Size: 9, Data: []byte("ab")}},
+		{"text chat",
+			[]byte{11, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'},
+			&UltraTextChat{Text: "hi"}},
+		{"text chat open",
+			[]byte{11, 0, 0, 0, 0xff, 0xff, 0xff, 0xff},
+			&UltraTextChat{Control: UltraTextChatOpen}},
+		{"keep alive",
+			[]byte{13},
+			&UltraKeepAlive{}},
+		{"server state",
+			[]byte{173, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2},
+			&UltraServerState{State: 1, Value: 2}},
+	} {
+		conn := NewClientConn(&MockConn{}, &ClientConfig{})
+		if _, err := conn.UnmarshalServerMessage(tt.data); err == nil {
+			t.Errorf("%s: expected error without ProfileUltraVNC", tt.desc)
+		}
+
+		conn.profile = ProfileUltraVNC
+		msg, err := conn.UnmarshalServerMessage(tt.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+			continue
+		}
+		if got, want := msg, tt.want; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got = %#v, want = %#v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestUltraResizeFrameBuffer(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.profile = ProfileUltraVNC
+	if _, err := conn.UnmarshalServerMessage([]byte{4, 0, 0x03, 0x20, 0x02, 0x58}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := conn.FramebufferWidth(), uint16(800); got != want {
+		t.Errorf("FramebufferWidth() = %v, want = %v", got, want)
+	}
+	if got, want := conn.FramebufferHeight(), uint16(600); got != want {
+		t.Errorf("FramebufferHeight() = %v, want = %v", got, want)
+	}
+}
+
+func TestUltraTextChat_Limit(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{Limits: Limits{MaxCutTextLength: 1}})
+	conn.profile = ProfileUltraVNC
+	_, err := conn.UnmarshalServerMessage([]byte{11, 0, 0, 0, 0, 0, 0, 2, 'h', 'i'})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("error = %v, want %v", err, ErrLimitExceeded)
+	}
+}
+
+func TestSetEncodings_UltraVNC(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.profile = ProfileUltraVNC
+
+	if err := conn.SetEncodings(Encodings{&RawEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	req := SetEncodingsMessage{}
+	if err := conn.receive(&req); err != nil {
+		t.Fatal(err)
+	}
+	var encs []int32
+	if err := conn.receiveN(&encs, int(req.NumEncs)); err != nil {
+		t.Fatal(err)
+	}
+	want := []int32{
+		int32(encodings.Raw),
+		int32(encodings.UltraServerStatePseudo),
+		int32(encodings.UltraEnableKeepAlivePseudo),
+	}
+	if !reflect.DeepEqual(encs, want) {
+		t.Errorf("encodings = %v, want = %v", encs, want)
+	}
+}
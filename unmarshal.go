@@ -0,0 +1,149 @@
+// Deterministic decoding of server messages from byte slices.
+
+package vnc
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"time"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/messages"
+)
+
+// byteConn implements the net.Conn interface on top of a byte slice. Reads
+// consume the slice, and writes are discarded.
+type byteConn struct {
+	r *bytes.Reader
+}
+
+// Verify that interfaces are honored.
+var _ net.Conn = (*byteConn)(nil)
+
+func newByteConn(data []byte) *byteConn {
+	return &byteConn{r: bytes.NewReader(data)}
+}
+
+func (b *byteConn) Read(p []byte) (int, error)       { return b.r.Read(p) }
+func (b *byteConn) Write(p []byte) (int, error)      { return len(p), nil }
+func (b *byteConn) Close() error                     { return nil }
+func (b *byteConn) LocalAddr() net.Addr              { return nil }
+func (b *byteConn) RemoteAddr() net.Addr             { return nil }
+func (b *byteConn) SetDeadline(time.Time) error      { return nil }
+func (b *byteConn) SetReadDeadline(time.Time) error  { return nil }
+func (b *byteConn) SetWriteDeadline(time.Time) error { return nil }
+func (b *byteConn) remaining() int                   { return b.r.Len() }
+
+// checkPayload returns an error if the connection reads from a byte slice
+// holding fewer than n bytes, so that payloads, whose lengths are untrusted,
+// are never allocated beyond the data being unmarshaled.
+func (c *ClientConn) checkPayload(n int) error {
+	if bc, ok := c.raw.(*byteConn); ok && n > bc.remaining() {
+		return wrapErrorf(io.ErrUnexpectedEOF, "payload of %d bytes exceeds the %d bytes of data left", n, bc.remaining())
+	}
+	return nil
+}
+
+// withData calls fn with a copy of the connection which reads from data
+// instead of the network. Any connection state changed by fn (e.g. the color
+// map, or framebuffer size) is copied back once fn returns. An error is
+// returned if fn doesn't consume all of data.
+func (c *ClientConn) withData(data []byte, fn func(*ClientConn) error) error {
+	bc := newByteConn(data)
+	shadow := newClientConn(bc, c.config)
+	shadow.protocolVersion = c.protocolVersion
+	shadow.serverVersion = c.serverVersion
+	shadow.colorMap = c.colorMap
+	c.metaMu.RLock()
+	shadow.desktopName = c.desktopName
+	shadow.encodings, shadow.retired = c.encodings, c.retired
+	shadow.fbWidth, shadow.fbHeight = c.fbWidth, c.fbHeight
+	shadow.monitors = c.monitors
+	shadow.pixelFormat = c.pixelFormat
+	c.metaMu.RUnlock()
+	shadow.profile = c.profile
+	shadow.eventConn = c
+	// The shadow is never closed, so it returns its memory, e.g. the buffer
+	// pixel data was decoded from, to the budget once done.
+	defer shadow.budget.close()
+
+	if err := fn(shadow); err != nil {
+		return err
+	}
+	if n := bc.remaining(); n > 0 {
+		return Errorf("%d bytes of trailing data", n)
+	}
+
+	c.colorMap = shadow.colorMap
+	c.setDesktopName(shadow.desktopName)
+	c.setMonitors(shadow.monitors)
+	c.setFramebufferSize(shadow.fbWidth, shadow.fbHeight)
+	return nil
+}
+
+// UnmarshalServerMessage decodes a single server message, including the
+// message-type, from data. The message is decoded using the current state of
+// the connection (e.g. pixel format, encodings, and framebuffer size), which
+// is updated by the message as though it was received from the server. No
+// network I/O is performed.
+//
+// The messages that can be decoded are those supported by the ClientConfig,
+// in addition to the RFC-required messages.
+func (c *ClientConn) UnmarshalServerMessage(data []byte) (ServerMessage, error) {
+	serverMessages := map[messages.ServerMessage]ServerMessage{
+		messages.FramebufferUpdate:  &FramebufferUpdate{},
+		messages.SetColorMapEntries: &SetColorMapEntries{},
+		messages.Bell:               &Bell{},
+		messages.ServerCutText:      &ServerCutText{},
+	}
+	for _, m := range c.profileServerMessages() {
+		serverMessages[m.Type()] = m
+	}
+	for _, m := range c.config.ServerMessages {
+		serverMessages[m.Type()] = m
+	}
+
+	var msg ServerMessage
+	err := c.withData(data, func(c *ClientConn) error {
+		hdr, err := c.readHeader(1)
+		if err != nil {
+			return err
+		}
+		messageType := messages.ServerMessage(hdr[0])
+		m, ok := serverMessages[messageType]
+		if !ok {
+			return wrapErrorf(ErrUnsupportedMessage, "unsupported message-type: %v", messageType)
+		}
+		msg, err = m.Read(c)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// UnmarshalEncoding decodes the payload of a rectangle with the given
+// encoding from data. The rectangle position and dimensions are taken from
+// rect. As with UnmarshalServerMessage, the current state of the connection is
+// used, and no network I/O is performed.
+func (c *ClientConn) UnmarshalEncoding(enc encodings.Encoding, rect *Rectangle, data []byte) (Encoding, error) {
+	var result Encoding
+	err := c.withData(data, func(c *ClientConn) error {
+		if enc >= 0 {
+			if err := rect.validateBounds(c.fbWidth, c.fbHeight); err != nil {
+				return err
+			}
+		}
+		r := *rect
+		r.encFn = c.Encodable
+		var err error
+		result, err = r.readEncoding(c, enc)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package vnc
+
+import (
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/messages"
+)
+
+func TestUnmarshalServerMessage(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		data []byte
+		ok   bool
+	}{
+		{"bell", []byte{2}, true},
+		{"server cut text", []byte{3, 0, 0, 0, 0, 0, 0, 3, 'a', 'b', 'c'}, true},
+		{"set color map entries", []byte{1, 0, 0, 7, 0, 1, 0, 1, 0, 2, 0, 3}, true},
+		{"empty", []byte{}, false},
+		{"truncated", []byte{3, 0, 0, 0, 0, 0, 0, 3, 'a'}, false},
+		{"trailing data", []byte{2, 2}, false},
+		{"unsupported message-type", []byte{200}, false},
+	} {
+		conn := NewClientConn(&MockConn{}, &ClientConfig{})
+		msg, err := conn.UnmarshalServerMessage(tt.data)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if !tt.ok {
+			continue
+		}
+		if got, want := msg.Type(), messages.ServerMessage(tt.data[0]); got != want {
+			t.Errorf("%s: incorrect message-type; got = %v, want = %v", tt.desc, got, want)
+		}
+		switch m := msg.(type) {
+		case *ServerCutText:
+			if got, want := m.Text, "abc"; got != want {
+				t.Errorf("%s: incorrect text; got = %q, want = %q", tt.desc, got, want)
+			}
+		case *SetColorMapEntries:
+			// The color map of the connection must be updated.
+			if got, want := conn.colorMap[7].B, uint16(3); got != want {
+				t.Errorf("%s: incorrect color map blue value; got = %v, want = %v", tt.desc, got, want)
+			}
+		}
+	}
+}
+
+func TestUnmarshalEncoding(t *testing.T) {
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.encodings = Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}
+	conn.pixelFormat = PixelFormat16bit
+	conn.fbWidth, conn.fbHeight = 10, 10
+
+	enc, err := conn.UnmarshalEncoding(encodings.Raw, &Rectangle{Width: 2, Height: 1}, []byte{0, 127, 127, 255})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := len(enc.(*RawEncoding).Colors), 2; got != want {
+		t.Errorf("incorrect number of colors; got = %v, want = %v", got, want)
+	}
+
+	if _, err := conn.UnmarshalEncoding(encodings.Raw, &Rectangle{X: 9, Width: 2, Height: 1}, []byte{0, 127, 127, 255}); err == nil {
+		t.Error("expected error for out of bounds rectangle")
+	}
+
+	if _, err := conn.UnmarshalEncoding(encodings.DesktopSizePseudo, &Rectangle{Width: 20, Height: 30}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got, want := conn.FramebufferWidth(), uint16(20); got != want {
+		t.Errorf("incorrect framebuffer width; got = %v, want = %v", got, want)
+	}
+}
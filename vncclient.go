@@ -5,62 +5,164 @@ package vnc
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net"
+	"math"
 	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/kward/go-vnc/go/metrics"
+	"github.com/kward/go-vnc/keys"
 	"github.com/kward/go-vnc/logging"
 	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
 	"golang.org/x/net/context"
 )
 
-// Connect negotiates a connection to a VNC server.
-func Connect(ctx context.Context, c net.Conn, cfg *ClientConfig) (*ClientConn, error) {
-	conn := NewClientConn(c, cfg)
+// Connect negotiates a connection to a VNC server, configured by the options,
+// e.g. a *ClientConfig, or WithAuth and WithEncodings. The connection is
+// usually a net.Conn, but may be any io.ReadWriteCloser, e.g. a pipe made with
+// NewStreamConn.
+func Connect(ctx context.Context, c io.ReadWriteCloser, opts ...Option) (_ *ClientConn, err error) {
+	conn := NewClientConn(c, newConfig(opts))
+	defer func() { conn.stats.connected(err) }()
+	conn.traceCtx = ctx
+	ctx, span := conn.startSpan(ctx, "vnc.Connect")
+	defer func() { span.End(err) }()
 
 	if err := conn.processContext(ctx); err != nil {
-		log.Fatalf("invalid context; %s", err)
+		conn.logger().Fatalf("invalid context; %s", err)
 	}
 
-	if err := conn.protocolVersionHandshake(ctx); err != nil {
+	// Unblock the handshake if the context is done.
+	unwatch := conn.watchContext(ctx)
+	defer unwatch()
+
+	timeouts := conn.config.Timeouts
+	if err := conn.handshakeStage(ctx, "ProtocolVersion", timeouts.version(), func() error {
+		return conn.protocolVersionHandshake(ctx)
+	}); err != nil {
 		conn.Close()
 		return nil, err
 	}
-	if err := conn.securityHandshake(); err != nil {
+	if err := conn.handshakeStage(ctx, "Security", timeouts.security(), func() error {
+		if err := conn.securityHandshake(); err != nil {
+			return err
+		}
+		return conn.securityResultHandshake()
+	}); err != nil {
 		conn.Close()
 		return nil, err
 	}
-	if err := conn.securityResultHandshake(); err != nil {
+	conn.publish(Event{Kind: EventAuthenticated})
+	if err := conn.handshakeStage(ctx, "Initialization", timeouts.serverInit(), func() error {
+		if err := conn.clientInit(); err != nil {
+			return err
+		}
+		return conn.serverInit()
+	}); err != nil {
 		conn.Close()
 		return nil, err
 	}
-	if err := conn.clientInit(); err != nil {
+	// The context no longer applies once the handshake is done, so it must
+	// not expire the deadline after it is cleared.
+	unwatch()
+	if err := ctx.Err(); err != nil {
 		conn.Close()
-		return nil, err
+		return nil, wrapErrorf(err, "connection aborted; %s", err)
 	}
-	if err := conn.serverInit(); err != nil {
+	if err := conn.setDeadline(time.Time{}); err != nil {
 		conn.Close()
 		return nil, err
 	}
+	if d := conn.config.CoalesceWrites; d > 0 {
+		conn.coalescer = newWriteCoalescer(conn.c, d)
+	}
+	if conn.config.PrioritySend {
+		conn.sendQ = newSendQueue(conn.writer())
+	}
 
 	// Send client-to-server messages.
 	encs := conn.encodings
+	if cfg := conn.config.Encodings; cfg != nil {
+		encs = cfg
+	}
 	if err := conn.SetEncodings(encs); err != nil {
 		conn.Close()
 		return nil, Errorf("failure calling SetEncodings; %s", err)
 	}
-	pf := conn.pixelFormat
+	pf := conn.initialPixelFormat()
 	if err := conn.SetPixelFormat(pf); err != nil {
 		conn.Close()
 		return nil, Errorf("failure calling SetPixelFormat; %s", err)
 	}
 
+	conn.publish(Event{Kind: EventConnected, Width: conn.fbWidth, Height: conn.fbHeight})
 	return conn, nil
 }
 
+// watchContext expires the deadline of the connection once ctx is done, which
+// aborts the reads and writes of the handshake. The returned function stops
+// watching, and returns once the deadline will no longer be expired. It may
+// be called more than once.
+func (c *ClientConn) watchContext(ctx context.Context) func() {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.setDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+// handshakeStage runs a stage of the handshake, with a deadline of timeout, or
+// the deadline of the context if that is sooner.
+func (c *ClientConn) handshakeStage(ctx context.Context, stage string, timeout time.Duration, fn func() error) (err error) {
+	_, span := c.startSpan(ctx, "vnc.Handshake")
+	span.SetAttribute("vnc.stage", stage)
+	defer func() { span.End(err) }()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if err := c.setDeadline(deadline); err != nil {
+		return err
+	}
+
+	err = fn()
+	if err == nil {
+		return nil
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return wrapErrorf(ctxErr, "%s handshake aborted; %s", stage, ctxErr)
+	}
+	if errors.Is(err, ErrTimeout) {
+		return wrapErrorf(err, "%s handshake timed out; %s", stage, err)
+	}
+	if c.deadlineExpired() {
+		return wrapErrorf(ErrTimeout, "%s handshake timed out; %s", stage, err)
+	}
+	return err
+}
+
 // A ClientConfig structure is used to configure a ClientConn. After
 // one has been passed to initialize a connection, it must not be modified.
 type ClientConfig struct {
@@ -78,6 +180,18 @@ type ClientConfig struct {
 	// disconnected when a connection is established to the VNC server.
 	Exclusive bool
 
+	// ViewOnly suppresses all input sent to the server, i.e. KeyEvent,
+	// PointerEvent, and ClientCutText messages, which fail with ErrViewOnly,
+	// and forces the connection to be shared, so that monitoring clients
+	// never disturb the remote session. See ClientConn.SetViewOnly.
+	ViewOnly bool
+
+	// ClipboardHistory is the number of cut texts received from the server
+	// retained, with the time they were received, e.g. so automation can
+	// check what was copied earlier in the session. See
+	// ClientConn.ClipboardHistory.
+	ClipboardHistory int
+
 	// The channel that all messages received from the server will be
 	// sent on. If the channel blocks, then the goroutine reading data
 	// from the VNC server may block indefinitely. It is up to the user
@@ -85,10 +199,270 @@ type ClientConfig struct {
 	// If this is not set, then all messages will be discarded.
 	ServerMessageCh chan ServerMessage
 
+	// LazyPayloads determines whether rectangle payloads are decoded. If true,
+	// each Rectangle holds a LazyEncoding with the payload exactly as received
+	// from the server. This is useful for proxies and recorders, which don't
+	// need the decoded pixel data.
+	LazyPayloads bool
+
+	// TolerateUnknownEncodings determines whether a rectangle with an
+	// unsupported encoding aborts the FramebufferUpdate. If true, and the
+	// framing of the encoding payload is known (e.g. pseudo-encodings without
+	// payload), the rectangle is instead delivered holding an UnknownEncoding.
+	TolerateUnknownEncodings bool
+
+	// RectFunc, if set, is called with each rectangle of a FramebufferUpdate
+	// as soon as it has been read, rather than buffering the rectangles of the
+	// whole update. The FramebufferUpdate sent on the ServerMessageCh then
+	// holds the number of rectangles, but not the rectangles themselves.
+	RectFunc RectFunc
+
+	// RectFilters, if set, are run in turn on each rectangle of a
+	// FramebufferUpdate once decoded, before it is delivered, e.g. to redact
+	// regions of the screen. See RectFilter.
+	RectFilters []RectFilter
+
+	// RectCache, if set, caches decoded rectangles by the hash of their
+	// content, so repeated rectangles skip decoding. See RectCache.
+	RectCache *RectCache
+
+	// Palette, if set, holds the colors of the pixels of color map pixel
+	// formats, e.g. PaletteGrayscale, until the server sends
+	// SetColorMapEntries, which many servers never do. If nil,
+	// PaletteBGR233 is used.
+	Palette *ColorMap
+
+	// PointerTransform, if set, maps the coordinates of the pointer events
+	// sent onto the framebuffer, until SetPointerTransform is called.
+	PointerTransform *PointerTransform
+
+	// PixelFormatFallback, if set, switches the pixel format to fewer
+	// bits-per-pixel while the bandwidth of the link is low.
+	PixelFormatFallback *PixelFormatFallback
+
 	// A slice of supported messages that can be read from the server.
 	// This only needs to contain NEW server messages, and doesn't
 	// need to explicitly contain the RFC-required messages.
 	ServerMessages []ServerMessage
+
+	// CoalesceWrites, if non-zero, holds back the messages sent once
+	// connected for up to this delay, so those sent close together (e.g. the
+	// key and pointer events of bursts of input) are written to the network
+	// at once. This reduces the syscalls and packets sent, at the cost of the
+	// delay. See ClientConn.Flush.
+	CoalesceWrites time.Duration
+
+	// PrioritySend determines whether the messages sent once connected wait
+	// in a queue ordered by SendPriority, so that input isn't delayed behind
+	// large messages, e.g. ClientCutText, on a slow link. Messages sent
+	// concurrently may then be reordered; those sent by one goroutine never
+	// are, as each send waits for its message to be written.
+	PrioritySend bool
+
+	// Limits constrains the size of messages read from the server.
+	Limits Limits
+
+	// MemoryBudget, if set, caps the memory used by the connection, and any
+	// others sharing the budget. See MemoryBudget.
+	MemoryBudget *MemoryBudget
+
+	// Timeouts for the stages of the handshake.
+	Timeouts HandshakeTimeouts
+
+	// Metrics, if set, receives the statistics of the connection.
+	Metrics MetricsRegistry
+
+	// Events, if set, receives the lifecycle events of the connection.
+	Events *EventBus
+
+	// IdleTimeout, if set, is the time without the remote screen changing
+	// after which EventIdle is published, and EventActive once it changes
+	// again. See ClientConn.LastActivity.
+	IdleTimeout time.Duration
+
+	// Tracer, if set, traces the work of the connection.
+	Tracer Tracer
+
+	// WireTrace, if set, receives a hex dump of every read from, and write
+	// to, the network, marked with the direction and time. WireTraceLimit, if
+	// non-zero, caps the bytes dumped of each.
+	WireTrace      io.Writer
+	WireTraceLimit int
+
+	// DesktopNameFunc, SizeFunc and PixelFormatFunc, if set, are called when
+	// the desktop name, framebuffer size, or pixel format changes, including
+	// when first set by the ServerInit message. EncodingsFunc, if set, is
+	// called when the encodings are set with SetEncodings. They are called
+	// from the goroutine which made the change, so shouldn't block.
+	DesktopNameFunc func(name string)
+	SizeFunc        func(width, height uint16)
+	PixelFormatFunc func(pf PixelFormat)
+	EncodingsFunc   func(encs Encodings)
+
+	// Encodings, if set, are advertised with SetEncodings once connected,
+	// rather than only the Raw encoding.
+	Encodings Encodings
+
+	// Logger, if set, receives the log messages of the connection, rather
+	// than the standard logger.
+	Logger *log.Logger
+
+	// KeyboardLayout, if set, is the keyboard layout of the server, used by
+	// Type to type each rune with the keys of the layout. It is needed by
+	// servers which map keysyms to the keys of a US keyboard, rather than to
+	// the characters of their own layout.
+	KeyboardLayout *keys.Layout
+
+	// Profile selects the workarounds used for the quirks of the server
+	// implementation. By default, none are used.
+	Profile Profile
+
+	// Strict determines how violations of the RFB protocol by the server are
+	// handled. If true, any violation aborts the message being read. If false,
+	// violations which are known quirks of real-world servers (e.g. non-zero
+	// padding, empty FramebufferUpdates, or color map entries beyond the end
+	// of the color map) are logged and tolerated. Violations that would
+	// compromise the client, such as rectangles outside the framebuffer, are
+	// never tolerated.
+	Strict bool
+}
+
+// DefaultHandshakeTimeout is the default timeout of each handshake stage.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// HandshakeTimeouts holds the timeouts for the stages of the handshake. A zero
+// value for any field selects the DefaultHandshakeTimeout, and a negative value
+// disables the timeout. If the context passed to Connect has a deadline, it
+// further constrains every stage.
+type HandshakeTimeouts struct {
+	// Version is the timeout of the ProtocolVersion handshake (§7.1.1).
+	Version time.Duration
+
+	// Security is the timeout of the Security and SecurityResult handshakes
+	// (§7.1.2, §7.1.3), including authentication.
+	Security time.Duration
+
+	// ServerInit is the timeout of the ClientInit and ServerInit messages
+	// (§7.3).
+	ServerInit time.Duration
+}
+
+func handshakeTimeout(d time.Duration) time.Duration {
+	if d == 0 {
+		return DefaultHandshakeTimeout
+	}
+	return d
+}
+
+func (t HandshakeTimeouts) version() time.Duration    { return handshakeTimeout(t.Version) }
+func (t HandshakeTimeouts) security() time.Duration   { return handshakeTimeout(t.Security) }
+func (t HandshakeTimeouts) serverInit() time.Duration { return handshakeTimeout(t.ServerInit) }
+
+// Default message size limits.
+const (
+	DefaultMaxRects           = 65535   // LastRect requires the maximum.
+	DefaultMaxCutTextLength   = 1 << 20 // 1 MiB
+	DefaultMaxColorMapEntries = 256
+	DefaultMaxStringLength    = 1 << 16 // 64 KiB
+	DefaultMaxCursorArea      = 256 * 256
+	DefaultMaxFramebufferArea = 8192 * 8192
+)
+
+// Limits constrains the size of messages read from the server, preventing a
+// misbehaving server from making the client allocate arbitrary amounts of
+// memory from a single length field. A zero value for any field selects the
+// default. Regardless of the limits, rectangles of pixel data are never
+// permitted to extend beyond the framebuffer. The rectangles of the cursor
+// pseudo-encodings, which aren't placed on the framebuffer, are limited by
+// MaxCursorArea instead, and the framebuffer itself by MaxFramebufferArea.
+type Limits struct {
+	// MaxRects is the maximum number-of-rectangles of a FramebufferUpdate.
+	MaxRects uint16
+
+	// MaxCutTextLength is the maximum length of a ServerCutText.
+	MaxCutTextLength uint32
+
+	// MaxColorMapEntries is the maximum number-of-colors of a
+	// SetColorMapEntries message.
+	MaxColorMapEntries uint16
+
+	// MaxStringLength is the maximum length of the desktop name, and of any
+	// failure reason, sent by the server.
+	MaxStringLength uint32
+
+	// MaxCursorArea is the maximum area, in pixels, of the rectangles of the
+	// Cursor and XCursor pseudo-encodings.
+	MaxCursorArea uint32
+
+	// MaxFramebufferArea is the maximum area, in pixels, of the framebuffer
+	// sized by the ServerInit message, or resized by the server.
+	MaxFramebufferArea uint32
+}
+
+// maxRects returns the effective maximum number-of-rectangles.
+func (l Limits) maxRects() uint16 {
+	if l.MaxRects == 0 {
+		return DefaultMaxRects
+	}
+	return l.MaxRects
+}
+
+// maxCutTextLength returns the effective maximum ServerCutText length.
+func (l Limits) maxCutTextLength() uint32 {
+	if l.MaxCutTextLength == 0 {
+		return DefaultMaxCutTextLength
+	}
+	return l.MaxCutTextLength
+}
+
+// maxColorMapEntries returns the effective maximum number-of-colors.
+func (l Limits) maxColorMapEntries() uint16 {
+	if l.MaxColorMapEntries == 0 {
+		return DefaultMaxColorMapEntries
+	}
+	return l.MaxColorMapEntries
+}
+
+// maxStringLength returns the effective maximum string length.
+func (l Limits) maxStringLength() uint32 {
+	if l.MaxStringLength == 0 {
+		return DefaultMaxStringLength
+	}
+	return l.MaxStringLength
+}
+
+// maxCursorArea returns the effective maximum cursor area.
+func (l Limits) maxCursorArea() uint32 {
+	if l.MaxCursorArea == 0 {
+		return DefaultMaxCursorArea
+	}
+	return l.MaxCursorArea
+}
+
+// checkCursor returns an error if the area of the cursor rectangle exceeds
+// the limit.
+func (l Limits) checkCursor(rect *Rectangle) error {
+	if max := l.maxCursorArea(); uint64(rect.Area()) > uint64(max) {
+		return wrapErrorf(ErrLimitExceeded, "cursor area %dx%d exceeds limit of %d pixels", rect.Width, rect.Height, max)
+	}
+	return nil
+}
+
+// maxFramebufferArea returns the effective maximum framebuffer area.
+func (l Limits) maxFramebufferArea() uint32 {
+	if l.MaxFramebufferArea == 0 {
+		return DefaultMaxFramebufferArea
+	}
+	return l.MaxFramebufferArea
+}
+
+// checkFramebuffer returns an error if the area of a framebuffer of the size
+// exceeds the limit.
+func (l Limits) checkFramebuffer(width, height uint16) error {
+	if max := l.maxFramebufferArea(); uint64(width)*uint64(height) > uint64(max) {
+		return wrapErrorf(ErrLimitExceeded, "framebuffer size %dx%d exceeds limit of %d pixels", width, height, max)
+	}
+	return nil
 }
 
 // NewClientConfig returns a populated ClientConfig.
@@ -110,9 +484,10 @@ func NewClientConfig(p string) *ClientConfig {
 
 // The ClientConn type holds client connection information.
 type ClientConn struct {
-	c               net.Conn
+	c               io.ReadWriteCloser
 	config          *ClientConfig
 	protocolVersion string
+	serverVersion   ProtocolVersion
 
 	// If the pixel format uses a color map, then this is the color
 	// map that is used. This should not be modified directly, since
@@ -120,6 +495,17 @@ type ClientConn struct {
 	// Definition in §5 - Representation of Pixel Data.
 	colorMap ColorMap
 
+	// The palette set with SetPalette, if any, overriding ClientConfig.Palette.
+	pal *ColorMap
+
+	// Guards the desktop name, encodings, framebuffer size, monitors, and
+	// pixel format, which are read by the accessors from any goroutine. The
+	// encodings and pixel format are also set from any goroutine, so the
+	// goroutine reading from the server takes copies of them under the lock
+	// (see encodable and readPixelFormat). It is the only writer of the
+	// others, so reads them without locking.
+	metaMu sync.RWMutex
+
 	// Name associated with the desktop, sent from the server.
 	desktopName string
 
@@ -127,23 +513,128 @@ type ClientConn struct {
 	// directly. Instead, SetEncodings() should be used.
 	encodings Encodings
 
+	// Encodings previously supported by the client, which remain decodable
+	// as the server may use them until it has processed SetEncodings, and the
+	// number of FramebufferUpdateRequests sent before it. See pruneRetired.
+	retired   Encodings
+	retiredAt uint64
+
+	// The FramebufferUpdateRequests sent, and those answered by the updates
+	// read, assuming each update answers one request, in order.
+	requested, answered uint64
+
 	// Height of the frame buffer in pixels, sent from the server.
 	fbHeight uint16
 
 	// Width of the frame buffer in pixels, sent from the server.
 	fbWidth uint16
 
+	// Layout of the screens of the desktop, sent from the server with the
+	// ExtendedDesktopSize pseudo-encoding.
+	monitors []Monitor
+
 	// The pixel format associated with the connection. This shouldn't
 	// be modified. If you wish to set a new pixel format, use the
 	// SetPixelFormat method.
 	pixelFormat PixelFormat
 
+	// The copy of pixelFormat the pixel data read is decoded with. Only the
+	// goroutine reading from the server uses it. See readPixelFormat.
+	readPF *PixelFormat
+
 	// Track metrics on system performance.
 	metrics map[string]metrics.Metric
+
+	// The profile in use, detected during the ProtocolVersion handshake.
+	profile Profile
+
+	// The capabilities of the server, if the Tight security type was
+	// negotiated. Set during the handshake.
+	tight *TightCapabilities
+
+	// Orders the messages sent, if ClientConfig.PrioritySend is set.
+	sendQ *sendQueue
+
+	// Statistics of the rectangles received, by encoding, and the time spent
+	// waiting for data from the network, which isn't decoding. Only the
+	// goroutine reading from the server uses readWait.
+	encStats encodingStats
+	readWait time.Duration
+
+	// Holds back the messages sent, if ClientConfig.CoalesceWrites is set.
+	coalescer *writeCoalescer
+
+	// Statistics exported to the configured MetricsRegistry, if any.
+	stats *connMetrics
+
+	// The estimated bandwidth of the link, guarded by metaMu, and the state
+	// of the PixelFormatFallback, only used by the goroutine reading from the
+	// server. See observeUpdate.
+	bandwidth       float64
+	fallback        int         // The number of fallbacks stepped down.
+	fullPixelFormat PixelFormat // The pixel format restored.
+	lastFallback    time.Time   // When the pixel format was last switched.
+
+	// The parent context of the spans of messages, and the context of the
+	// span of the message being read. Only used with a Tracer.
+	traceCtx context.Context
+	msgCtx   context.Context
+
+	// Whether input is suppressed. See ClientConfig.ViewOnly.
+	viewOnly atomic.Bool
+
+	// The transform of the coordinates of pointer events, if any. See
+	// SetPointerTransform.
+	pointerTransform atomic.Pointer[PointerTransform]
+
+	closeOnce sync.Once   // Publishes EventClosed.
+	eventConn *ClientConn // The connection events are published for, if not this one.
+
+	// The latest cut text received. See ClientConfig.ClipboardHistory.
+	clipHistory clipboardHistory
+
+	// The typed subscriptions to the messages from the server. See Subscribe.
+	subs subscriptions
+
+	// Records the input events sent, if set. See RecordMacro.
+	macroMu sync.Mutex
+	macro   *MacroRecorder
+
+	// The connection, without the wrapping of ClientConfig.WireTrace.
+	raw io.ReadWriteCloser
+
+	// The deadlines of the connection, if it has them, and the emulation of
+	// them otherwise. See setDeadline.
+	deadliner      deadliner
+	streamDeadline streamDeadline
+
+	// The memory used by the connection. See ClientConfig.MemoryBudget.
+	budget connBudget
+
+	// The activity of the remote screen. See ClientConfig.IdleTimeout.
+	idle idleDetector
+
+	// Scratch space for reading message headers, and pixel data, without
+	// allocating. Only the goroutine reading from the server may use these.
+	hdrBuf [16]byte
+	pixBuf []byte
 }
 
-func NewClientConn(c net.Conn, cfg *ClientConfig) *ClientConn {
-	return &ClientConn{
+func NewClientConn(c io.ReadWriteCloser, cfg *ClientConfig) *ClientConn {
+	conn := newClientConn(c, cfg)
+	conn.idle.start(time.Now())
+	return conn
+}
+
+// newClientConn returns a connection without starting its idle detector, for
+// the copies of connections used to unmarshal messages.
+func newClientConn(c io.ReadWriteCloser, cfg *ClientConfig) *ClientConn {
+	raw := c
+	d, _ := c.(deadliner)
+	if cfg.WireTrace != nil {
+		c = newWireTraceConn(c, cfg.WireTrace, cfg.WireTraceLimit)
+	}
+	conn := &ClientConn{
 		c:           c,
 		config:      cfg,
 		encodings:   Encodings{&RawEncoding{}},
@@ -152,17 +643,65 @@ func NewClientConn(c net.Conn, cfg *ClientConfig) *ClientConn {
 			"bytes-received": &metrics.Gauge{},
 			"bytes-sent":     &metrics.Gauge{},
 		},
+		stats:     newConnMetrics(cfg.Metrics),
+		raw:       raw,
+		deadliner: d,
 	}
+	conn.viewOnly.Store(cfg.ViewOnly)
+	conn.pointerTransform.Store(cfg.PointerTransform)
+	conn.clipHistory.setMax(cfg.ClipboardHistory)
+	conn.budget.budget = cfg.MemoryBudget
+	conn.colorMap = *conn.palette()
+	conn.idle.window, conn.idle.publish = cfg.IdleTimeout, conn.publish
+	return conn
+}
+
+// logger returns the logger of the connection.
+func (c *ClientConn) logger() *log.Logger {
+	if c.config.Logger != nil {
+		return c.config.Logger
+	}
+	return log.Default()
+}
+
+// ViewOnly returns whether input sent to the server is suppressed.
+func (c *ClientConn) ViewOnly() bool {
+	return c.viewOnly.Load()
+}
+
+// SetViewOnly sets whether input sent to the server is suppressed, overriding
+// ClientConfig.ViewOnly, e.g. to let an operator take control of a session
+// being monitored. The connection stays shared.
+func (c *ClientConn) SetViewOnly(viewOnly bool) {
+	c.viewOnly.Store(viewOnly)
+}
+
+// checkInput returns an error if input is suppressed.
+func (c *ClientConn) checkInput(msg messages.ClientMessage) error {
+	if c.ViewOnly() {
+		return wrapErrorf(ErrViewOnly, "%v message suppressed", msg)
+	}
+	return nil
 }
 
 // Close a connection to a VNC server.
 func (c *ClientConn) Close() error {
-	log.Print("VNC Client connection closed.")
-	return c.c.Close()
+	c.logger().Print("VNC Client connection closed.")
+	flushErr := c.Flush()
+	err := c.c.Close()
+	if err == nil {
+		err = flushErr
+	}
+	c.budget.close()
+	c.idle.stop()
+	c.closeOnce.Do(func() { c.publish(Event{Kind: EventClosed}) })
+	return err
 }
 
 // DesktopName returns the server provided desktop name.
 func (c *ClientConn) DesktopName() string {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
 	return c.desktopName
 }
 
@@ -171,38 +710,179 @@ func (c *ClientConn) setDesktopName(name string) {
 	if logging.V(logging.ResultLevel) {
 		glog.Infof("desktopName: %s", name)
 	}
+	c.metaMu.Lock()
+	changed := c.desktopName != name
 	c.desktopName = name
+	c.metaMu.Unlock()
+	if fn := c.config.DesktopNameFunc; fn != nil && changed && c.notifies() {
+		fn(name)
+	}
 }
 
-// Encodings returns the server provided encodings.
+// Encodings returns the encodings supported by the client.
 func (c *ClientConn) Encodings() Encodings {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
 	return c.encodings
 }
 
-// FramebufferHeight returns the server provided framebuffer height.
-func (c *ClientConn) FramebufferHeight() uint16 {
-	return c.fbHeight
+// setEncodings stores the encodings supported by the client. Encodings no
+// longer supported are retired.
+func (c *ClientConn) setEncodings(encs Encodings) {
+	c.metaMu.Lock()
+	var retired Encodings
+	for _, e := range append(append(Encodings{}, c.retired...), c.encodings...) {
+		if _, ok := encs.find(e.Type()); ok {
+			continue
+		}
+		if _, ok := retired.find(e.Type()); !ok {
+			retired = append(retired, e)
+		}
+	}
+	c.encodings, c.retired = encs, retired
+	c.retiredAt = math.MaxUint64 // Until SetEncodings is sent.
+	c.metaMu.Unlock()
+	if fn := c.config.EncodingsFunc; fn != nil && c.notifies() {
+		fn(encs)
+	}
 }
 
-// setFramebufferHeight stores the server provided framebuffer height.
-func (c *ClientConn) setFramebufferHeight(height uint16) {
-	if logging.V(logging.ResultLevel) {
-		glog.Infof("height: %d", height)
+// sentEncodings records that SetEncodings was sent, after the requests sent
+// so far.
+func (c *ClientConn) sentEncodings() {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.retiredAt = c.requested
+}
+
+// countRequest counts a FramebufferUpdateRequest, before it is sent.
+func (c *ClientConn) countRequest() {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	c.requested++
+}
+
+// outstanding returns true if a FramebufferUpdateRequest sent hasn't been
+// answered yet.
+func (c *ClientConn) outstanding() bool {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.answered < c.requested
+}
+
+// answerRequest counts the request answered by an update read, and prunes the
+// retired encodings once the update answers a request sent after
+// SetEncodings, by which time the server no longer uses them. Updates beyond
+// the requests sent, e.g. unsolicited, answer none.
+func (c *ClientConn) answerRequest() {
+	c.metaMu.Lock()
+	defer c.metaMu.Unlock()
+	if c.answered < c.requested {
+		c.answered++
+	}
+	if c.retired != nil && c.answered > c.retiredAt {
+		c.retired = nil
 	}
-	c.fbHeight = height
+}
+
+// FramebufferHeight returns the server provided framebuffer height.
+func (c *ClientConn) FramebufferHeight() uint16 {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.fbHeight
 }
 
 // FramebufferWidth returns the server provided framebuffer width.
 func (c *ClientConn) FramebufferWidth() uint16 {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
 	return c.fbWidth
 }
 
-// setFramebufferWidth stores the server provided framebuffer width.
-func (c *ClientConn) setFramebufferWidth(width uint16) {
+// setFramebufferSize stores the server provided framebuffer size.
+func (c *ClientConn) setFramebufferSize(width, height uint16) {
 	if logging.V(logging.ResultLevel) {
-		glog.Infof("width: %d", width)
+		glog.Infof("width: %d height: %d", width, height)
 	}
-	c.fbWidth = width
+	c.metaMu.Lock()
+	changed := c.fbWidth != width || c.fbHeight != height
+	c.fbWidth, c.fbHeight = width, height
+	c.metaMu.Unlock()
+	if fn := c.config.SizeFunc; fn != nil && changed && c.notifies() {
+		fn(width, height)
+	}
+}
+
+// Monitors returns the layout of the screens of the desktop, as last sent by
+// the server with the ExtendedDesktopSize pseudo-encoding. It is empty if the
+// server hasn't sent the layout.
+func (c *ClientConn) Monitors() []Monitor {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return append([]Monitor(nil), c.monitors...)
+}
+
+// Monitor returns the screen of the desktop with the id.
+func (c *ClientConn) Monitor(id uint32) (Monitor, bool) {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	for _, m := range c.monitors {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Monitor{}, false
+}
+
+// setMonitors stores the server provided layout of the screens.
+func (c *ClientConn) setMonitors(monitors []Monitor) {
+	c.metaMu.Lock()
+	c.monitors = monitors
+	c.metaMu.Unlock()
+}
+
+// PixelFormat returns the pixel format of the pixel data sent by the server.
+func (c *ClientConn) PixelFormat() PixelFormat {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	return c.pixelFormat
+}
+
+// setPixelFormat stores the pixel format of the pixel data sent by the server.
+func (c *ClientConn) setPixelFormat(pf PixelFormat) {
+	c.metaMu.Lock()
+	changed := c.pixelFormat != pf
+	c.pixelFormat = pf
+	c.metaMu.Unlock()
+	if fn := c.config.PixelFormatFunc; fn != nil && changed && c.notifies() {
+		fn(pf)
+	}
+}
+
+// readPixelFormat returns the pixel format of the pixel data read from the
+// server. A new copy of the pixel format is taken, under the lock, whenever it
+// changes, and the colors decoded point to the copy, so that they keep their
+// pixel format once it changes. The color map is invalidated by a change to a
+// color map pixel format, until the server sends its colors. Only the
+// goroutine reading from the server may call it, which it does before reading
+// each message.
+func (c *ClientConn) readPixelFormat() *PixelFormat {
+	c.metaMu.RLock()
+	defer c.metaMu.RUnlock()
+	if c.readPF == nil || *c.readPF != c.pixelFormat {
+		pf := c.pixelFormat
+		if c.readPF != nil && !rfbflags.IsTrueColor(pf.TrueColor) {
+			c.colorMap = *c.palette()
+		}
+		c.readPF = &pf
+	}
+	return c.readPF
+}
+
+// notifies returns false for the copies of the connection used to unmarshal
+// messages, whose changes are notified once copied back to the connection.
+func (c *ClientConn) notifies() bool {
+	return c.eventConn == nil
 }
 
 // ListenAndHandle listens to a VNC server and handles server messages.
@@ -211,21 +891,26 @@ func (c *ClientConn) ListenAndHandle() error {
 		glog.Info(logging.FnName())
 	}
 	defer c.Close()
+	defer c.subs.close()
 
 	if c.config.ServerMessages == nil {
 		return NewVNCError("Client config error: ServerMessages undefined")
 	}
 	serverMessages := make(map[messages.ServerMessage]ServerMessage)
+	for _, m := range c.profileServerMessages() {
+		serverMessages[m.Type()] = m
+	}
 	for _, m := range c.config.ServerMessages {
 		serverMessages[m.Type()] = m
 	}
 
 	for {
-		var messageType messages.ServerMessage
-		if err := c.receive(&messageType); err != nil {
-			log.Print("error: reading from server")
+		hdr, err := c.readHeader(1)
+		if err != nil {
+			c.logger().Print("error: reading from server")
 			break
 		}
+		messageType := messages.ServerMessage(hdr[0])
 		if logging.V(logging.ResultLevel) {
 			glog.Infof("message-type: %s", messageType)
 		}
@@ -233,18 +918,26 @@ func (c *ClientConn) ListenAndHandle() error {
 		msg, ok := serverMessages[messageType]
 		if !ok {
 			// Unsupported message type! Bad!
-			log.Printf("error unsupported message-type: %v", messageType)
+			c.logger().Printf("error unsupported message-type: %v", messageType)
 			break
 		}
 
+		var span Span
+		c.msgCtx, span = c.startSpan(c.traceCtx, "vnc.ReadMessage")
+		span.SetAttribute("vnc.message_type", messageType.String())
+		c.readPixelFormat()
 		parsedMsg, err := msg.Read(c)
+		span.End(err)
 		if err != nil {
-			log.Printf("error parsing message; %v", err)
+			c.logger().Printf("error parsing message; %v", err)
 			break
 		}
 
+		subscribed := c.subs.send(parsedMsg)
 		if c.config.ServerMessageCh == nil {
-			log.Print("ignoring message; no server message channel")
+			if !subscribed {
+				c.logger().Print("ignoring message; no server message channel")
+			}
 			continue
 		}
 
@@ -254,15 +947,76 @@ func (c *ClientConn) ListenAndHandle() error {
 	return nil
 }
 
+// protocolViolation reports a violation of the RFB protocol by the server. In
+// strict mode a ProtocolError is returned, otherwise the violation is logged
+// and nil is returned.
+func (c *ClientConn) protocolViolation(format string, a ...interface{}) error {
+	err := protocolErrorf(format, a...)
+	if c.config.Strict {
+		return err
+	}
+	if logging.V(logging.FlowLevel) {
+		glog.Infof("ignoring %s", err)
+	}
+	return nil
+}
+
 // receive a packet from the network.
 func (c *ClientConn) receive(data interface{}) error {
-	if err := binary.Read(c.c, binary.BigEndian, data); err != nil {
-		return err
+	start := time.Now()
+	err := binary.Read(c.c, binary.BigEndian, data)
+	c.readWait += time.Since(start)
+	if err != nil {
+		return connError(err)
 	}
 	c.metrics["bytes-received"].Adjust(int64(binary.Size(data)))
+	c.stats.received(binary.Size(data))
 	return nil
 }
 
+// readFull reads exactly len(b) bytes from the network.
+func (c *ClientConn) readFull(b []byte) error {
+	start := time.Now()
+	_, err := io.ReadFull(c.c, b)
+	c.readWait += time.Since(start)
+	if err != nil {
+		return connError(err)
+	}
+	c.metrics["bytes-received"].Adjust(int64(len(b)))
+	c.stats.received(len(b))
+	return nil
+}
+
+// readHeader reads an n byte message header from the network into scratch
+// space. The returned slice is only valid until the next read.
+func (c *ClientConn) readHeader(n int) ([]byte, error) {
+	b := c.hdrBuf[:n]
+	if err := c.readFull(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readPixels reads n bytes of pixel data from the network into scratch space.
+// The returned slice is only valid until the next call to readPixels. The
+// scratch space is only kept for the next call if the MemoryBudget has room.
+func (c *ClientConn) readPixels(n int) ([]byte, error) {
+	b := c.pixBuf
+	if cap(b) < n {
+		c.budget.release(int64(cap(c.pixBuf)))
+		c.pixBuf = nil
+		b = make([]byte, n)
+		if c.budget.reserve(int64(n), false) {
+			c.pixBuf = b
+		}
+	}
+	b = b[:n]
+	if err := c.readFull(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 // receiveN receives N packets from the network.
 func (c *ClientConn) receiveN(data interface{}, n int) error {
 	if logging.V(logging.FnDeclLevel) {
@@ -304,6 +1058,7 @@ func (c *ClientConn) receiveN(data interface{}, n int) error {
 		return NewVNCError(fmt.Sprintf("unrecognized data type %v", reflect.TypeOf(data)))
 	}
 	c.metrics["bytes-received"].Adjust(int64(binary.Size(data)))
+	c.stats.received(binary.Size(data))
 	return nil
 }
 
@@ -312,13 +1067,39 @@ func (c *ClientConn) send(data interface{}) error {
 	if logging.V(logging.SpamLevel) {
 		glog.Infof("ClientConn.%s", logging.FnNameWithArgs("%v", data))
 	}
-	if err := binary.Write(c.c, binary.BigEndian, data); err != nil {
-		return err
+	if err := c.write(data); err != nil {
+		return connError(err)
 	}
 	c.metrics["bytes-sent"].Adjust(int64(binary.Size(data)))
+	c.stats.sent(binary.Size(data))
 	return nil
 }
 
+// write writes data to the network, through the send queue if there is one.
+func (c *ClientConn) write(data interface{}) error {
+	if c.sendQ == nil {
+		return binary.Write(c.writer(), binary.BigEndian, data)
+	}
+	b, ok := data.([]byte)
+	if !ok {
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.BigEndian, data); err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+	return c.sendQ.send(messagePriority(b), b)
+}
+
+// sendMessage marshals a message, and sends it to the network.
+func (c *ClientConn) sendMessage(m Marshaler) error {
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return c.send(b)
+}
+
 // sendN sends N packets to the network.
 // func (c *ClientConn) sendN(data interface{}, n int) error {
 // 	var buf bytes.Buffer
@@ -347,7 +1128,7 @@ func (c *ClientConn) send(data interface{}) error {
 
 func (c *ClientConn) processContext(ctx context.Context) error {
 	if mpv := ctx.Value("vnc_max_proto_version"); mpv != nil && mpv != "" {
-		log.Printf("vnc_max_proto_version: %v", mpv)
+		c.logger().Printf("vnc_max_proto_version: %v", mpv)
 		vers := []string{"3.3", "3.8"}
 		valid := false
 		for _, v := range vers {
@@ -365,8 +1146,8 @@ func (c *ClientConn) processContext(ctx context.Context) error {
 }
 
 func (c *ClientConn) DebugMetrics() {
-	log.Println("Metrics:")
+	c.logger().Println("Metrics:")
 	for name, metric := range c.metrics {
-		log.Printf("  %v: %v", name, metric.Value())
+		c.logger().Printf("  %v: %v", name, metric.Value())
 	}
 }
@@ -3,10 +3,14 @@ package vnc
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/net/context"
 )
@@ -143,3 +147,178 @@ func TestReceiveN(t *testing.T) {
 		}
 	}
 }
+
+// newSilentServer returns the address of a server which sends its protocol
+// version, and then goes silent until the returned func is called.
+func newSilentServer(t *testing.T) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer ln.Close()
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		c.Write([]byte("RFB 003.008\n"))
+		<-done
+	}()
+
+	return ln.Addr().String(), func() { close(done) }
+}
+
+func TestHandshakeTimeouts(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		timeouts HandshakeTimeouts
+		ctx      time.Duration
+		stage    string
+	}{
+		{"security timeout", HandshakeTimeouts{Security: 50 * time.Millisecond}, 0, "Security"},
+		{"context deadline", HandshakeTimeouts{}, 50 * time.Millisecond, "Security"},
+		{"context deadline before timeout", HandshakeTimeouts{Security: time.Minute}, 50 * time.Millisecond, "Security"},
+	} {
+		addr, stop := newSilentServer(t)
+		nc, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("error connecting to mock server: %s", err)
+		}
+
+		ctx := context.Background()
+		if tt.ctx > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, tt.ctx)
+			defer cancel()
+		}
+
+		start := time.Now()
+		_, err = Connect(ctx, nc, &ClientConfig{Timeouts: tt.timeouts})
+		stop()
+		if err == nil {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if got, max := time.Since(start), 5*time.Second; got > max {
+			t.Errorf("%s: Connect() took %v, want < %v", tt.desc, got, max)
+		}
+		if got, want := err.Error(), tt.stage+" handshake"; !strings.Contains(got, want) {
+			t.Errorf("%s: error = %q, want to contain %q", tt.desc, got, want)
+		}
+		if tt.ctx == 0 && !errors.Is(err, ErrTimeout) {
+			t.Errorf("%s: error = %v, want errors.Is(ErrTimeout)", tt.desc, err)
+		}
+	}
+}
+
+func TestHandshakeTimeouts_Defaults(t *testing.T) {
+	var ht HandshakeTimeouts
+	if got, want := ht.version(), DefaultHandshakeTimeout; got != want {
+		t.Errorf("version() = %v, want = %v", got, want)
+	}
+	ht = HandshakeTimeouts{Version: -1, Security: time.Second}
+	if got := ht.version(); got > 0 {
+		t.Errorf("version() = %v, want disabled", got)
+	}
+	if got, want := ht.security(), time.Second; got != want {
+		t.Errorf("security() = %v, want = %v", got, want)
+	}
+}
+
+func TestClientConn_SetPixelFormatWhileReading(t *testing.T) {
+	// 1x1 raw rectangles of the same 32bpp pixel.
+	const n = 100
+	var data bytes.Buffer
+	for i := 0; i < n; i++ {
+		data.Write([]byte{0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, 0x80, 0x80, 0x80, 0})
+	}
+	conn := NewClientConn(&closeRecorder{Reader: &data, Writer: ioutil.Discard}, &ClientConfig{})
+	conn.fbWidth, conn.fbHeight = 1, 1
+
+	// The pixel format is set while the rectangles are read.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			pf := PixelFormat32bit
+			if i%2 == 0 {
+				pf = PixelFormatApple
+			}
+			if err := conn.SetPixelFormat(pf); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	var (
+		colors []Color
+		rgb    [][3]uint32
+	)
+	for i := 0; i < n; i++ {
+		r := NewRectangle(conn.encodable())
+		if err := conn.readRect(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c := r.Enc.(*RawEncoding).Colors[0]
+		r1, g1, b1, _ := c.RGBA()
+		colors, rgb = append(colors, c), append(rgb, [3]uint32{r1, g1, b1})
+	}
+	<-done
+
+	// The colors keep the pixel format they were decoded with.
+	if err := conn.SetPixelFormat(PixelFormat16bit); err != nil {
+		t.Fatal(err)
+	}
+	conn.readPixelFormat()
+	for i, c := range colors {
+		if r, g, b, _ := c.RGBA(); [3]uint32{r, g, b} != rgb[i] {
+			t.Errorf("color %d = %#x, want %#x", i, [3]uint32{r, g, b}, rgb[i])
+		}
+	}
+}
+
+func TestClientConn_MetadataFuncs(t *testing.T) {
+	var got []string
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{
+		DesktopNameFunc: func(name string) { got = append(got, "name "+name) },
+		SizeFunc:        func(w, h uint16) { got = append(got, fmt.Sprintf("size %dx%d", w, h)) },
+		PixelFormatFunc: func(pf PixelFormat) { got = append(got, fmt.Sprintf("bpp %d", pf.BPP)) },
+		EncodingsFunc:   func(encs Encodings) { got = append(got, fmt.Sprintf("%d encodings", len(encs))) },
+	})
+
+	conn.setDesktopName("desktop")
+	conn.setDesktopName("desktop") // Unchanged.
+	conn.setFramebufferSize(800, 600)
+	if err := conn.SetPixelFormat(PixelFormat16bit); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetEncodings(Encodings{&RawEncoding{}, &DesktopSizePseudoEncoding{}}); err != nil {
+		t.Fatal(err)
+	}
+	// Notified once, by the connection rather than its unmarshaling copy.
+	if _, err := conn.UnmarshalServerMessage([]byte{
+		0, 0, 0, 1, // FramebufferUpdate with 1 rectangle.
+		0, 0, 0, 0, 0x04, 0, 0x03, 0, 0xff, 0xff, 0xff, 0x21, // DesktopSize 1024x768.
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"name desktop", "size 800x600", "bpp 16", "2 encodings", "size 1024x768"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("notifications = %q, want = %q", got, want)
+	}
+	if got, want := conn.DesktopName(), "desktop"; got != want {
+		t.Errorf("DesktopName() = %q, want = %q", got, want)
+	}
+	if got, want := conn.PixelFormat(), PixelFormat16bit; got != want {
+		t.Errorf("PixelFormat() = %v, want = %v", got, want)
+	}
+	if w, h := conn.FramebufferWidth(), conn.FramebufferHeight(); w != 1024 || h != 768 {
+		t.Errorf("framebuffer size = %dx%d, want 1024x768", w, h)
+	}
+}
@@ -0,0 +1,222 @@
+// Saved connections, of the .vnc files of VNC viewers.
+
+package vnc
+
+import (
+	"bufio"
+	"crypto/des"
+	"encoding/hex"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// ConnectionFile is a saved connection, read from the .vnc file of a RealVNC,
+// TightVNC or UltraVNC viewer.
+type ConnectionFile struct {
+	Addr      string               // The host:port of the server.
+	Password  string               // The password, if saved.
+	Encodings []encodings.Encoding // The preferred encodings, best first.
+	ViewOnly  bool
+	Shared    bool // True, unless the file says otherwise.
+}
+
+// vncFileKey is the fixed DES key obfuscating the passwords saved by VNC
+// viewers, bit-reversed, as for VNC authentication (see ClientAuthVNC).
+var vncFileKey = []byte{0xe8, 0x4a, 0xd6, 0x60, 0xc4, 0x72, 0x1a, 0xe0}
+
+// encodingNames are the encodings named by .vnc files.
+var encodingNames = map[string]encodings.Encoding{
+	"raw":      encodings.Raw,
+	"copyrect": encodings.CopyRect,
+	"rre":      encodings.RRE,
+	"corre":    4,
+	"hextile":  encodings.Hextile,
+	"zlib":     6,
+	"tight":    7,
+	"trle":     encodings.TRLE,
+	"zrle":     encodings.ZRLE,
+}
+
+// ReadConnectionFile reads a .vnc file. The keys of its sections, e.g.
+// [Connection] and [Options], are read regardless of the section, and case:
+//
+//   - Host: the host, and optionally the display, e.g. host:1 for port 5901,
+//     or port, e.g. host::5901.
+//   - Port: the port, if not given by Host.
+//   - Password: the password, obfuscated, as 16 hex digits.
+//   - PreferredEncoding, or preferred_encoding: the name of the preferred
+//     encoding, e.g. ZRLE, or its number.
+//   - ViewOnly, and Shared: 1 if true.
+func ReadConnectionFile(r io.Reader) (*ConnectionFile, error) {
+	f := &ConnectionFile{Shared: true}
+	var host, port string
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+			continue
+		}
+		i := strings.IndexByte(line, '=')
+		if i < 0 {
+			return nil, Errorf("reading .vnc file: line %d: missing =", n)
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+		var err error
+		switch strings.Replace(key, "_", "", -1) {
+		case "host":
+			host = value
+		case "port":
+			port = value
+		case "password":
+			f.Password, err = decryptVNCPassword(value)
+		case "preferredencoding":
+			var e encodings.Encoding
+			if e, err = parseEncodingName(value); err == nil {
+				f.Encodings = append(f.Encodings, e)
+			}
+		case "viewonly":
+			f.ViewOnly = value == "1"
+		case "shared":
+			f.Shared = value == "1"
+		}
+		if err != nil {
+			return nil, wrapErrorf(err, "reading .vnc file: line %d: %s", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, wrapErrorf(err, "reading .vnc file: %s", err)
+	}
+	if host == "" {
+		return nil, Errorf("reading .vnc file: no host")
+	}
+	addr, err := vncFileAddr(host, port)
+	if err != nil {
+		return nil, wrapErrorf(err, "reading .vnc file: %s", err)
+	}
+	f.Addr = addr
+	return f, nil
+}
+
+// vncFileAddr returns the host:port of the host of a .vnc file, which may
+// hold a display, e.g. host:1, or port, e.g. host::5901, and its port, if any.
+// IPv6 addresses followed by either are enclosed in brackets.
+func vncFileAddr(host, port string) (string, error) {
+	var suffix string
+	if strings.HasPrefix(host, "[") {
+		i := strings.IndexByte(host, ']')
+		if i < 0 {
+			return "", Errorf("invalid host %q", host)
+		}
+		host, suffix = host[1:i], host[i+1:]
+	} else if n := strings.Count(host, ":"); n == 1 || n == 2 && strings.Contains(host, "::") {
+		i := strings.IndexByte(host, ':')
+		host, suffix = host[:i], host[i:]
+	}
+	switch {
+	case strings.HasPrefix(suffix, "::"):
+		port = suffix[2:]
+	case strings.HasPrefix(suffix, ":"):
+		display, err := strconv.Atoi(suffix[1:])
+		if err != nil {
+			return "", Errorf("invalid display %q", suffix[1:])
+		}
+		if display < 100 {
+			display += 5900
+		}
+		port = strconv.Itoa(display)
+	case suffix != "":
+		return "", Errorf("invalid host %q", host+suffix)
+	}
+	if port == "" {
+		port = "5900"
+	}
+	if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 0xffff {
+		return "", Errorf("invalid port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// decryptVNCPassword returns the password obfuscated as hex digits by VNC
+// viewers.
+func decryptVNCPassword(s string) (string, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) == 0 || len(b)%des.BlockSize != 0 {
+		return "", Errorf("invalid password %q", s)
+	}
+	cipher, err := des.NewCipher(vncFileKey)
+	if err != nil {
+		return "", err
+	}
+	for i := 0; i < len(b); i += des.BlockSize {
+		cipher.Decrypt(b[i:i+des.BlockSize], b[i:i+des.BlockSize])
+	}
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b), nil
+}
+
+// parseEncodingName returns the encoding of a name, e.g. ZRLE, or number.
+func parseEncodingName(s string) (encodings.Encoding, error) {
+	if e, ok := encodingNames[strings.ToLower(s)]; ok {
+		return e, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return 0, Errorf("unknown encoding %q", s)
+	}
+	return encodings.Encoding(n), nil
+}
+
+// Config returns the configuration of the connection. VNC authentication is
+// only offered if the password was saved. The preferred encodings which this
+// package decodes are advertised, best first, before Raw; others are ignored.
+func (f *ConnectionFile) Config() *ClientConfig {
+	cfg := NewClientConfig(f.Password)
+	if f.Password == "" {
+		cfg.Auth = []ClientAuth{&ClientAuthNone{}}
+	}
+	cfg.Exclusive = !f.Shared
+	cfg.ViewOnly = f.ViewOnly
+	cfg.Encodings = f.encodings()
+	return cfg
+}
+
+// encodings returns the preferred encodings decoded by this package, best
+// first, and then Raw, or nil if there are none.
+func (f *ConnectionFile) encodings() Encodings {
+	var encs Encodings
+	for _, t := range f.Encodings {
+		if t == encodings.Raw || t < 0 {
+			continue
+		}
+		for _, e := range builtinEncodings() {
+			if e.Type() == t {
+				encs = append(encs, e)
+			}
+		}
+	}
+	if len(encs) == 0 {
+		return nil
+	}
+	return append(encs, &RawEncoding{})
+}
+
+// ApplyTo applies the saved connection to cfg, for a connection configured
+// otherwise: the password, if saved and cfg has none, the preferred
+// encodings, if cfg has none, and view-only.
+func (f *ConnectionFile) ApplyTo(cfg *ClientConfig) {
+	if f.Password != "" && cfg.Password == "" {
+		cfg.Password = f.Password
+		cfg.Auth = withVNCPassword(cfg.Auth, f.Password)
+	}
+	if cfg.Encodings == nil {
+		cfg.Encodings = f.encodings()
+	}
+	cfg.ViewOnly = cfg.ViewOnly || f.ViewOnly
+}
@@ -0,0 +1,140 @@
+package vnc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+func TestReadConnectionFile(t *testing.T) {
+	for _, tt := range []struct {
+		desc string
+		file string
+		want ConnectionFile
+		ok   bool
+	}{
+		{"display",
+			"[Connection]\nHost=example.com:1\nPassword=dbd83cfd727a1458\n",
+			ConnectionFile{Addr: "example.com:5901", Password: "password", Shared: true}, true},
+		{"port",
+			"[Connection]\nHost=example.com::5999\n",
+			ConnectionFile{Addr: "example.com:5999", Shared: true}, true},
+		{"separate port",
+			"[connection]\nhost=10.0.0.1\nport=5902\n[options]\nviewonly=1\nshared=0\npreferred_encoding=16\n",
+			ConnectionFile{Addr: "10.0.0.1:5902", Encodings: []encodings.Encoding{encodings.ZRLE}, ViewOnly: true}, true},
+		{"RealVNC",
+			"ConnMethod=tcp\nHost=example.com\nPreferredEncoding=ZRLE\n",
+			ConnectionFile{Addr: "example.com:5900", Encodings: []encodings.Encoding{encodings.ZRLE}, Shared: true}, true},
+		{"IPv6 port",
+			"Host=[::1]::5901\n",
+			ConnectionFile{Addr: "[::1]:5901", Shared: true}, true},
+		{"IPv6 display",
+			"Host=[fe80::1]:2\n",
+			ConnectionFile{Addr: "[fe80::1]:5902", Shared: true}, true},
+		{"IPv6",
+			"Host=fe80::1:2\n",
+			ConnectionFile{Addr: "[fe80::1:2]:5900", Shared: true}, true},
+		{"no host", "[Connection]\nPort=5900\n", ConnectionFile{}, false},
+		{"invalid password", "Host=example.com\nPassword=xyz\n", ConnectionFile{}, false},
+		{"invalid port", "Host=example.com::70000\n", ConnectionFile{}, false},
+		{"unknown encoding", "Host=example.com\nPreferredEncoding=foo\n", ConnectionFile{}, false},
+		{"missing =", "Host example.com\n", ConnectionFile{}, false},
+	} {
+		f, err := ReadConnectionFile(strings.NewReader(tt.file))
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+			continue
+		}
+		if err != nil {
+			if tt.ok {
+				t.Errorf("%s: unexpected error %v", tt.desc, err)
+			}
+			continue
+		}
+		if got, want := f.Addr, tt.want.Addr; got != want {
+			t.Errorf("%s: Addr = %q, want %q", tt.desc, got, want)
+		}
+		if got, want := f.Password, tt.want.Password; got != want {
+			t.Errorf("%s: Password = %q, want %q", tt.desc, got, want)
+		}
+		if got, want := len(f.Encodings), len(tt.want.Encodings); got != want {
+			t.Errorf("%s: len(Encodings) = %d, want %d", tt.desc, got, want)
+		} else {
+			for i := range f.Encodings {
+				if got, want := f.Encodings[i], tt.want.Encodings[i]; got != want {
+					t.Errorf("%s: Encodings[%d] = %v, want %v", tt.desc, i, got, want)
+				}
+			}
+		}
+		if got, want := f.ViewOnly, tt.want.ViewOnly; got != want {
+			t.Errorf("%s: ViewOnly = %v, want %v", tt.desc, got, want)
+		}
+		if got, want := f.Shared, tt.want.Shared; got != want {
+			t.Errorf("%s: Shared = %v, want %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestConnectionFile_Config(t *testing.T) {
+	f := &ConnectionFile{
+		Addr:      "example.com:5900",
+		Encodings: []encodings.Encoding{encodings.Raw, encodings.ZRLE, encodings.CopyRect},
+		ViewOnly:  true,
+	}
+	cfg := f.Config()
+	if got, want := len(cfg.Auth), 1; got != want {
+		t.Errorf("without password, len(Auth) = %d, want %d", got, want)
+	}
+	if !cfg.Exclusive {
+		t.Error("Exclusive = false, want true")
+	}
+	if !cfg.ViewOnly {
+		t.Error("ViewOnly = false, want true")
+	}
+	// ZRLE isn't decoded, and Raw is last.
+	want := []encodings.Encoding{encodings.CopyRect, encodings.Raw}
+	if got := len(cfg.Encodings); got != len(want) {
+		t.Fatalf("len(Encodings) = %d, want %d", got, len(want))
+	}
+	for i, e := range cfg.Encodings {
+		if got := e.Type(); got != want[i] {
+			t.Errorf("Encodings[%d] = %v, want %v", i, got, want[i])
+		}
+	}
+
+	f.Password, f.Shared = "secret", true
+	cfg = f.Config()
+	if got, want := len(cfg.Auth), 2; got != want {
+		t.Errorf("with password, len(Auth) = %d, want %d", got, want)
+	}
+	if cfg.Exclusive {
+		t.Error("Exclusive = true, want false")
+	}
+}
+
+func TestConnectionFile_ApplyTo(t *testing.T) {
+	f := &ConnectionFile{Password: "saved", ViewOnly: true}
+	cfg := NewClientConfig("")
+	auth := cfg.Auth
+	f.ApplyTo(cfg)
+	if a := auth[1].(*ClientAuthVNC); a.Password != "" {
+		t.Errorf("the Auth slice given was modified; password = %q", a.Password)
+	}
+	if got, want := cfg.Password, "saved"; got != want {
+		t.Errorf("Password = %q, want %q", got, want)
+	}
+	if got, want := len(cfg.Auth), 2; got != want {
+		t.Errorf("len(Auth) = %d, want %d", got, want)
+	}
+	if !cfg.ViewOnly {
+		t.Error("ViewOnly = false, want true")
+	}
+
+	// A password given otherwise wins.
+	cfg = NewClientConfig("given")
+	f.ApplyTo(cfg)
+	if got, want := cfg.Password, "given"; got != want {
+		t.Errorf("Password = %q, want %q", got, want)
+	}
+}
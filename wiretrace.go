@@ -0,0 +1,58 @@
+// Hex dumps of the bytes exchanged with the server, for protocol debugging.
+
+package vnc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// wireTraceConn dumps the bytes read from, and written to, a connection.
+type wireTraceConn struct {
+	io.ReadWriteCloser
+	limit int // Maximum bytes dumped per read or write; 0 for no limit.
+
+	mu sync.Mutex // Serializes dumps of concurrent reads and writes.
+	w  io.Writer
+}
+
+// Verify that interfaces are honored.
+var _ io.ReadWriteCloser = (*wireTraceConn)(nil)
+
+// newWireTraceConn returns c, dumping its traffic to w.
+func newWireTraceConn(c io.ReadWriteCloser, w io.Writer, limit int) *wireTraceConn {
+	return &wireTraceConn{ReadWriteCloser: c, limit: limit, w: w}
+}
+
+func (c *wireTraceConn) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	if n > 0 {
+		c.dump("<<<", b[:n])
+	}
+	return n, err
+}
+
+func (c *wireTraceConn) Write(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(b)
+	if n > 0 {
+		c.dump(">>>", b[:n])
+	}
+	return n, err
+}
+
+// dump writes a header line, with the direction (<<< for received, >>> for
+// sent), time and length, followed by a hex dump of the bytes.
+func (c *wireTraceConn) dump(dir string, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.w, "%s %s %d bytes\n", time.Now().Format("15:04:05.000000"), dir, len(b))
+	if c.limit > 0 && len(b) > c.limit {
+		io.WriteString(c.w, hex.Dump(b[:c.limit]))
+		fmt.Fprintf(c.w, "... %d bytes not shown\n", len(b)-c.limit)
+		return
+	}
+	io.WriteString(c.w, hex.Dump(b))
+}
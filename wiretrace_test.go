@@ -0,0 +1,38 @@
+package vnc
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWireTrace(t *testing.T) {
+	var trace bytes.Buffer
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{WireTrace: &trace, WireTraceLimit: 4})
+
+	if err := conn.send([]byte("RFB 003.008\n")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 3)
+	if err := conn.readFull(b); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(trace.String(), "\n")
+	for i, want := range []string{
+		`^\d\d:\d\d:\d\d\.\d{6} >>> 12 bytes$`,
+		`^00000000  52 46 42 20 +\|RFB \|$`,
+		`^\.\.\. 8 bytes not shown$`,
+		`^\d\d:\d\d:\d\d\.\d{6} <<< 3 bytes$`,
+		`^00000000  52 46 42 +\|RFB\|$`,
+	} {
+		if i >= len(lines) {
+			t.Fatalf("trace has %d lines, want more:\n%s", len(lines), trace.String())
+		}
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("line %d = %q, want match of %q", i, lines[i], want)
+		}
+	}
+}
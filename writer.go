@@ -0,0 +1,187 @@
+// Writing of Server-to-Client messages, e.g. by proxies.
+
+package vnc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/kward/go-vnc/messages"
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+// MarshalServerMessage returns the wire format of a server message, as read
+// from a server, so that a proxy can forward it, or a rewritten copy of it,
+// without keeping the bytes received. If pf is non-nil, the pixel data of the
+// rectangles is re-encoded in the pixel format pf (e.g. that requested by the
+// client of the proxy); otherwise it is encoded in the pixel format it was
+// read with.
+//
+// The number-of-rectangles of a FramebufferUpdate is that of its Rects, so
+// rectangles can be added or removed. Updates read with a RectFunc, which
+// don't hold their rectangles, can't be marshaled. Rectangles holding a
+// LazyEncoding or UnknownEncoding are written exactly as received.
+func MarshalServerMessage(msg ServerMessage, pf *PixelFormat) ([]byte, error) {
+	switch m := msg.(type) {
+	case *FramebufferUpdate:
+		return marshalFramebufferUpdate(m, pf)
+	case encoding.BinaryMarshaler:
+		return m.MarshalBinary()
+	}
+	return nil, Errorf("unable to marshal %v message", msg.Type())
+}
+
+func marshalFramebufferUpdate(m *FramebufferUpdate, pf *PixelFormat) ([]byte, error) {
+	bufs, err := framebufferUpdateBuffers(m, pf)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Join(bufs, nil), nil
+}
+
+// framebufferUpdateBuffers returns the wire format of a FramebufferUpdate as
+// the buffers of its header, and of the header and payload of each rectangle.
+func framebufferUpdateBuffers(m *FramebufferUpdate, pf *PixelFormat) (net.Buffers, error) {
+	if len(m.Rects) == 0 && m.NumRect > 0 {
+		return nil, Errorf("FramebufferUpdate doesn't hold its %d rectangles", m.NumRect)
+	}
+	b := make([]byte, 4)
+	b[0] = uint8(messages.FramebufferUpdate) // message-type
+	binary.BigEndian.PutUint16(b[2:], uint16(len(m.Rects)))
+	bufs := make(net.Buffers, 1, 1+2*len(m.Rects))
+	bufs[0] = b
+	for i := range m.Rects {
+		hdr, payload, err := marshalRectangle(&m.Rects[i], pf)
+		if err != nil {
+			return nil, err
+		}
+		bufs = append(bufs, hdr, payload)
+	}
+	return bufs, nil
+}
+
+// marshalRectangle returns the header and payload of the wire format of a
+// rectangle.
+func marshalRectangle(r *Rectangle, pf *PixelFormat) (hdr, payload []byte, err error) {
+	if r.Enc == nil {
+		return nil, nil, Errorf("rectangle %v has no encoding", r)
+	}
+	switch e := r.Enc.(type) {
+	case *RawEncoding:
+		payload, err = encodeColors(e.Colors, pf)
+	case *CursorPseudoEncoding:
+		payload, err = encodeColors(e.Colors, pf)
+		payload = append(payload, e.Bitmask...)
+	default:
+		payload, err = r.Enc.Marshal()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	msg := rectangleMessage{r.X, r.Y, r.Width, r.Height, r.Enc.Type()}
+	hdr = make([]byte, rectangleMessageLen)
+	msg.put(hdr)
+	return hdr, payload, nil
+}
+
+// serverMessageBuffers returns the wire format of a server message as the
+// buffers of its parts, so that large payloads, e.g. of cut text or pixel
+// data, are written without first being copied after their headers.
+func serverMessageBuffers(msg ServerMessage, pf *PixelFormat) (net.Buffers, error) {
+	switch m := msg.(type) {
+	case *FramebufferUpdate:
+		return framebufferUpdateBuffers(m, pf)
+	case *ServerCutText:
+		text, err := latin1Bytes(m.Text)
+		if err != nil {
+			return nil, err
+		}
+		b := serverMessageHeader(m, 8)
+		binary.BigEndian.PutUint32(b[4:], uint32(len(text)))
+		return net.Buffers{b, text}, nil
+	}
+	b, err := MarshalServerMessage(msg, pf)
+	if err != nil {
+		return nil, err
+	}
+	return net.Buffers{b}, nil
+}
+
+// encodeColors encodes the colors in the pixel format pf, or if nil, in the
+// pixel format each was read with.
+func encodeColors(colors []Color, pf *PixelFormat) ([]byte, error) {
+	var b []byte
+	for i := range colors {
+		c := colors[i]
+		if pf != nil {
+			var err error
+			if c, err = convertColor(c, pf); err != nil {
+				return nil, err
+			}
+		} else if c.pf == nil {
+			return nil, Errorf("color %d has no pixel format", i)
+		}
+		p, err := c.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, p...)
+	}
+	return b, nil
+}
+
+// convertColor returns the color c in the pixel format pf.
+func convertColor(c Color, pf *PixelFormat) (Color, error) {
+	if !rfbflags.IsTrueColor(pf.TrueColor) {
+		// Color map indices can only be kept, as the color map is the server's.
+		if c.pf == nil || rfbflags.IsTrueColor(c.pf.TrueColor) {
+			return Color{}, Errorf("unable to convert true color to color map pixel format")
+		}
+		return Color{pf: pf, cmIndex: c.cmIndex, R: c.R, G: c.G, B: c.B}, nil
+	}
+	r, g, b, _ := c.RGBA()
+	return Color{
+		pf: pf,
+		R:  uint16(r * uint32(pf.RedMax) / 0xffff),
+		G:  uint16(g * uint32(pf.GreenMax) / 0xffff),
+		B:  uint16(b * uint32(pf.BlueMax) / 0xffff),
+	}, nil
+}
+
+// ServerMessageWriter writes server messages, e.g. to the client of a proxy.
+type ServerMessageWriter struct {
+	w  io.Writer
+	pf *PixelFormat
+}
+
+// NewServerMessageWriter returns a ServerMessageWriter writing to w. The pixel
+// data of the messages is written in the pixel format it was read with, until
+// SetPixelFormat is called.
+func NewServerMessageWriter(w io.Writer) *ServerMessageWriter {
+	return &ServerMessageWriter{w: w}
+}
+
+// SetPixelFormat sets the pixel format of the pixel data written, e.g. as
+// requested by the client with a SetPixelFormat message.
+func (w *ServerMessageWriter) SetPixelFormat(pf PixelFormat) {
+	w.pf = &pf
+}
+
+// WriteMessage writes a server message. See MarshalServerMessage. The parts of
+// the message are written with a single vectored write, where w supports
+// them, e.g. TCP connections, and otherwise one after the other, so messages
+// must not be written concurrently to w by other writers.
+func (w *ServerMessageWriter) WriteMessage(msg ServerMessage) error {
+	bufs, err := serverMessageBuffers(msg, w.pf)
+	if err != nil {
+		return err
+	}
+	if _, err := bufs.WriteTo(w.w); err != nil {
+		return wrapErrorf(err, "unable to write %v message: %s", msg.Type(), err)
+	}
+	return nil
+}
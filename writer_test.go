@@ -0,0 +1,93 @@
+package vnc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kward/go-vnc/rfbflags"
+)
+
+func TestMarshalServerMessage(t *testing.T) {
+	update := []byte{
+		0, 0, 0, 1, // FramebufferUpdate with 1 rectangle.
+		0, 0, 0, 0, 0, 1, 0, 1, 0, 0, 0, 0, // Raw 1x1 at (0,0).
+		0x33, 0x22, 0x11, 0, // Red 0x11, green 0x22, blue 0x33.
+	}
+	conn := NewClientConn(&MockConn{}, &ClientConfig{})
+	conn.pixelFormat = PixelFormatApple
+	conn.fbWidth, conn.fbHeight = 10, 10
+	msg, err := conn.UnmarshalServerMessage(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Re-encoded in the pixel format read.
+	got, err := MarshalServerMessage(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := update; !bytes.Equal(got, want) {
+		t.Errorf("MarshalServerMessage(nil) = %v, want = %v", got, want)
+	}
+
+	// Re-encoded in a 16-bit RGB565 pixel format.
+	rgb565 := PixelFormat{
+		BPP: 16, Depth: 16, BigEndian: rfbflags.RFBTrue, TrueColor: rfbflags.RFBTrue,
+		RedMax: 31, GreenMax: 63, BlueMax: 31, RedShift: 11, GreenShift: 5, BlueShift: 0,
+	}
+	got, err = MarshalServerMessage(msg, &rgb565)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte{}, update[:16]...), 0x11, 0x06) // 2<<11 | 8<<5 | 6
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalServerMessage(rgb565) = %v, want = %v", got, want)
+	}
+
+	// Rectangles can be removed.
+	msg.(*FramebufferUpdate).Rects = nil
+	msg.(*FramebufferUpdate).NumRect = 0
+	if got, err = MarshalServerMessage(msg, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0, 0, 0, 0}; !bytes.Equal(got, want) {
+		t.Errorf("MarshalServerMessage() = %v, want = %v", got, want)
+	}
+
+	// Updates without their rectangles can't be marshaled.
+	if _, err := MarshalServerMessage(&FramebufferUpdate{NumRect: 1}, nil); err == nil {
+		t.Error("expected error for FramebufferUpdate without rectangles")
+	}
+}
+
+func TestServerMessageWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewServerMessageWriter(&buf)
+	for _, msg := range []ServerMessage{
+		&Bell{},
+		&ServerCutText{Text: "hi"},
+		&FramebufferUpdate{},
+	} {
+		if err := w.WriteMessage(msg); err != nil {
+			t.Fatalf("WriteMessage(%v) unexpected error: %s", msg.Type(), err)
+		}
+	}
+	want := []byte{
+		2,
+		3, 0, 0, 0, 0, 0, 0, 2, 'h', 'i',
+		0, 0, 0, 0,
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("written = %v, want = %v", got, want)
+	}
+
+	w.SetPixelFormat(PixelFormatApple)
+	buf.Reset()
+	rect := Rectangle{Width: 1, Height: 1, Enc: &RawEncoding{Colors: []Color{{R: 0xffff}}}}
+	if err := w.WriteMessage(&FramebufferUpdate{Rects: []Rectangle{rect}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Bytes()[16:], []byte{0, 0, 0xff, 0}; !bytes.Equal(got, want) {
+		t.Errorf("pixel = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Experimental ZSTD encoding.
+
+The ZSTD encoding is a private encoding of this package, and isn't
+implemented by other clients or servers. It is raw pixel data compressed with
+ZSTD, which compresses well at a CPU cost low enough for high-throughput
+streaming on a LAN.
+*/
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/kward/go-vnc/encodings"
+)
+
+// ZstdDecoder decompresses ZSTD frames. It is implemented by the Decoder of
+// github.com/klauspost/compress/zstd, as this package has no ZSTD
+// implementation of its own.
+type ZstdDecoder interface {
+	// DecodeAll decompresses all the frames of input, appending them to dst.
+	DecodeAll(input, dst []byte) ([]byte, error)
+}
+
+// ZstdEncoder compresses data into a ZSTD frame. It is implemented by the
+// Encoder of github.com/klauspost/compress/zstd.
+type ZstdEncoder interface {
+	// EncodeAll compresses src, appending the frame to dst.
+	EncodeAll(src, dst []byte) []byte
+}
+
+// ZstdEncoding holds the pixel data of a rectangle with the ZSTD encoding.
+//
+// The payload of each rectangle is a U32 length, followed by that many bytes
+// of a ZSTD frame decompressing to the pixel data of the raw encoding. Each
+// rectangle is compressed independently, so rectangles can be dropped or
+// forwarded without disturbing the others.
+//
+// The encoding is only used when added to the encodings of both the client
+// and the server, e.g.
+//
+//	dec, _ := zstd.NewReader(nil)
+//	c.SetEncodings(Encodings{&ZstdEncoding{Decoder: dec}, &RawEncoding{}})
+type ZstdEncoding struct {
+	Colors []Color
+
+	Decoder ZstdDecoder // Decompresses the rectangles read.
+	Encoder ZstdEncoder // Compresses the rectangles marshaled.
+}
+
+// Verify that interfaces are honored.
+var (
+	_ Encoding      = (*ZstdEncoding)(nil)
+	_ PayloadReader = (*ZstdEncoding)(nil)
+)
+
+// Marshal implements the Marshaler interface.
+func (e *ZstdEncoding) Marshal() ([]byte, error) {
+	if e.Encoder == nil {
+		return nil, Errorf("unable to marshal ZstdEncoding without an encoder")
+	}
+	raw, err := (&RawEncoding{e.Colors}).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	b := e.Encoder.EncodeAll(raw, make([]byte, 4, 4+zstdBound(len(raw))))
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	return b, nil
+}
+
+// Read implements the Encoding interface.
+func (e *ZstdEncoding) Read(c *ClientConn, rect *Rectangle) (Encoding, error) {
+	if e.Decoder == nil {
+		return nil, wrapErrorf(ErrUnsupportedEncoding, "unable to read rectangle with zstd encoding without a decoder")
+	}
+	data, err := e.ReadPayload(c, rect)
+	if err != nil {
+		return nil, err
+	}
+	pf := c.readPixelFormat()
+	n := rect.Area() * int(pf.BPP/8)
+	raw, err := e.Decoder.DecodeAll(data[4:], make([]byte, 0, n))
+	if err != nil {
+		return nil, protocolErrorf("unable to decompress rectangle with zstd encoding: %s", err)
+	}
+	if len(raw) != n {
+		return nil, protocolErrorf("zstd encoding decompressed to %d bytes, want %d", len(raw), n)
+	}
+
+	colors := make([]Color, rect.Area())
+	if err := pf.decodePixels(&c.colorMap, raw, colors); err != nil {
+		return nil, err
+	}
+	return &ZstdEncoding{Colors: colors, Decoder: e.Decoder, Encoder: e.Encoder}, nil
+}
+
+// ReadPayload implements the PayloadReader interface.
+func (*ZstdEncoding) ReadPayload(c *ClientConn, rect *Rectangle) ([]byte, error) {
+	return readZstdPayload(c, rect)
+}
+
+// String implements the fmt.Stringer interface.
+func (e *ZstdEncoding) String() string {
+	return fmt.Sprintf("ZstdEncoding{ colors: %d }", len(e.Colors))
+}
+
+// Type implements the Encoding interface.
+func (*ZstdEncoding) Type() encodings.Encoding { return encodings.ZstdPrivate }
+
+// readZstdPayload reads the length and compressed data of a rectangle with
+// the ZSTD encoding. The length may not exceed that of the pixel data after
+// the worst case expansion of ZSTD.
+func readZstdPayload(c *ClientConn, rect *Rectangle) ([]byte, error) {
+	hdr, err := c.readHeader(4)
+	if err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with zstd encoding: %s", err)
+	}
+	l := binary.BigEndian.Uint32(hdr)
+	if max := zstdBound(rect.Area() * int(c.readPixelFormat().BPP/8)); int64(l) > int64(max) {
+		return nil, protocolErrorf("zstd encoding length %d exceeds limit of %d", l, max)
+	}
+	if err := c.checkPayload(int(l)); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with zstd encoding: %s", err)
+	}
+	data := make([]byte, 4+l)
+	copy(data, hdr)
+	if err := c.readFull(data[4:]); err != nil {
+		return nil, wrapErrorf(err, "unable to read rectangle with zstd encoding: %s", err)
+	}
+	return data, nil
+}
+
+// zstdBound returns the maximum length of n bytes compressed with ZSTD, as
+// ZSTD_compressBound of the reference implementation.
+func zstdBound(n int) int {
+	bound := n + n>>8
+	if n < 128<<10 {
+		bound += (128<<10 - n) >> 11
+	}
+	return bound
+}
@@ -0,0 +1,145 @@
+package vnc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kward/go-vnc/encodings"
+	"github.com/kward/go-vnc/go/operators"
+)
+
+// fakeZstd is a ZstdEncoder and ZstdDecoder which "compresses" by prefixing
+// the data with a magic number.
+type fakeZstd struct{}
+
+var fakeZstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func (fakeZstd) EncodeAll(src, dst []byte) []byte {
+	return append(append(dst, fakeZstdMagic...), src...)
+}
+
+func (fakeZstd) DecodeAll(input, dst []byte) ([]byte, error) {
+	if !bytes.HasPrefix(input, fakeZstdMagic) {
+		return nil, Errorf("invalid magic number")
+	}
+	return append(dst, input[len(fakeZstdMagic):]...), nil
+}
+
+func TestZstdEncoding_Type(t *testing.T) {
+	if got, want := (&ZstdEncoding{}).Type(), encodings.ZstdPrivate; got != want {
+		t.Errorf("incorrect encoding; got = %v, want = %v", got, want)
+	}
+}
+
+func TestZstdEncoding_Marshal(t *testing.T) {
+	if _, err := (&ZstdEncoding{}).Marshal(); err == nil {
+		t.Error("expected error without an encoder")
+	}
+
+	e := &ZstdEncoding{
+		Colors: []Color{
+			Color{&PixelFormat16bit, &ColorMap{}, 0, 127, 7, 0},
+			Color{&PixelFormat16bit, &ColorMap{}, 0, 32767, 2047, 127}},
+		Encoder: fakeZstd{},
+	}
+	got, err := e.Marshal()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := []byte{0, 0, 0, 8, 0x28, 0xb5, 0x2f, 0xfd, 0, 127, 127, 255}
+	if !operators.EqualSlicesOfByte(got, want) {
+		t.Errorf("incorrect result; got = %v, want = %v", got, want)
+	}
+}
+
+func TestZstdEncoding_Read(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{})
+	conn.pixelFormat = PixelFormat16bit
+
+	for _, tt := range []struct {
+		desc string
+		dec  ZstdDecoder
+		data []byte
+		ok   bool
+	}{
+		{"valid",
+			fakeZstd{}, []byte{0, 0, 0, 8, 0x28, 0xb5, 0x2f, 0xfd, 0, 127, 127, 255}, true},
+		{"no decoder",
+			nil, []byte{0, 0, 0, 8, 0x28, 0xb5, 0x2f, 0xfd, 0, 127, 127, 255}, false},
+		{"invalid frame",
+			fakeZstd{}, []byte{0, 0, 0, 8, 0, 0, 0, 0, 0, 127, 127, 255}, false},
+		{"wrong length",
+			fakeZstd{}, []byte{0, 0, 0, 6, 0x28, 0xb5, 0x2f, 0xfd, 0, 127}, false},
+		{"length exceeds limit",
+			fakeZstd{}, []byte{0, 1, 0, 0}, false},
+	} {
+		mockConn.Reset()
+		if err := conn.send(tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		rect := &Rectangle{Width: 2, Height: 1}
+		enc, err := (&ZstdEncoding{Decoder: tt.dec}).Read(conn, rect)
+		if err == nil && !tt.ok {
+			t.Errorf("%s: expected error", tt.desc)
+		}
+		if err != nil && tt.ok {
+			t.Errorf("%s: unexpected error: %s", tt.desc, err)
+		}
+		if !tt.ok {
+			continue
+		}
+		colors := enc.(*ZstdEncoding).Colors
+		if got, want := len(colors), rect.Area(); got != want {
+			t.Errorf("%s: incorrect number of colors; got = %v, want = %v", tt.desc, got, want)
+			continue
+		}
+		if got, want := colors[1].R, uint16(32767); got != want {
+			t.Errorf("%s: incorrect R value; got = %v, want = %v", tt.desc, got, want)
+		}
+		if got, want := mockConn.b.Len(), 0; got != want {
+			t.Errorf("%s: unread data remaining; got = %v, want = %v", tt.desc, got, want)
+		}
+	}
+}
+
+func TestZstdEncoding_Unknown(t *testing.T) {
+	mockConn := &MockConn{}
+	conn := NewClientConn(mockConn, &ClientConfig{TolerateUnknownEncodings: true})
+	conn.pixelFormat = PixelFormat16bit
+	conn.fbWidth, conn.fbHeight = 320, 240
+
+	data := []byte{0, 0, 0, 8, 0x28, 0xb5, 0x2f, 0xfd, 0, 127, 127, 255}
+	if err := conn.send(rectangleMessage{0, 0, 2, 1, encodings.ZstdPrivate}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.send(data); err != nil {
+		t.Fatal(err)
+	}
+	rect := NewRectangle(conn.Encodable)
+	if err := rect.Read(conn); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	unknown, ok := rect.Enc.(*UnknownEncoding)
+	if !ok {
+		t.Fatalf("expected UnknownEncoding; got %T", rect.Enc)
+	}
+	if got, want := unknown.Data, data; !operators.EqualSlicesOfByte(got, want) {
+		t.Errorf("incorrect payload; got = %v, want = %v", got, want)
+	}
+}
+
+func TestZstdBound(t *testing.T) {
+	for _, tt := range []struct {
+		n, want int
+	}{
+		{0, 64},
+		{1024, 1024 + 4 + 63},
+		{128 << 10, 128<<10 + 512},
+	} {
+		if got := zstdBound(tt.n); got != tt.want {
+			t.Errorf("zstdBound(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}